@@ -6,10 +6,120 @@ const (
 	TaskActionCopyIndex TaskAction = "copy_index"
 	TaskActionSync      TaskAction = "sync"
 	TaskActionSyncDiff  TaskAction = "sync_diff"
+	TaskActionRepair    TaskAction = "repair"
 	TaskActionCompare   TaskAction = "compare"
 	TaskActionImport    TaskAction = "import"
 	TaskActionExport    TaskAction = "export"
 	TaskActionTemplate  TaskAction = "create_template"
+	// TaskActionPlan logs the steps of sync_all without running them, sync_all creates the templates, the target
+	// indices, syncs and swaps the aliases
+	TaskActionPlan    TaskAction = "plan"
+	TaskActionSyncAll TaskAction = "sync_all"
+)
+
+type ConflictPolicy string
+
+const (
+	ConflictPolicyOverwrite    ConflictPolicy = "overwrite"
+	ConflictPolicySkipExisting ConflictPolicy = "skip-existing"
+	ConflictPolicyNewerWins    ConflictPolicy = "newer-wins"
+)
+
+// MultiTypePolicy decides how a multi-type 5.x index is created on a version allowing one mapping type
+type MultiTypePolicy string
+
+const (
+	MultiTypePolicyError MultiTypePolicy = "error"
+	MultiTypePolicyFirst MultiTypePolicy = "first"
+)
+
+// ClosedIndexPolicy decides how a closed source index is migrated, it can't be searched
+type ClosedIndexPolicy string
+
+const (
+	ClosedIndexPolicyError ClosedIndexPolicy = "error"
+	ClosedIndexPolicySkip  ClosedIndexPolicy = "skip"
+	// ClosedIndexPolicyOpen opens the source index for the migration and closes it again after
+	ClosedIndexPolicyOpen ClosedIndexPolicy = "open"
+)
+
+// TemplateConflictPolicy decides what happens to an index template which already exists on the target
+type TemplateConflictPolicy string
+
+const (
+	TemplateConflictPolicyOverwrite TemplateConflictPolicy = "overwrite"
+	TemplateConflictPolicySkip      TemplateConflictPolicy = "skip"
+	// TemplateConflictPolicyMerge lays the migrated template over the existing one, the migrated values win and the
+	// keys only the existing one has are kept
+	TemplateConflictPolicyMerge TemplateConflictPolicy = "merge"
+)
+
+// BulkRefreshPolicy is the refresh param of the bulk requests of a sync
+type BulkRefreshPolicy string
+
+const (
+	// BulkRefreshPolicyNone leaves the refresh to the refresh_interval of the target, the fastest for a bulk load
+	BulkRefreshPolicyNone BulkRefreshPolicy = "none"
+	// BulkRefreshPolicyWaitFor answers a bulk once its docs are visible to search, every bulk waits up to a
+	// refresh_interval of the target, which bounds the throughput of a bulk worker
+	BulkRefreshPolicyWaitFor BulkRefreshPolicy = "wait_for"
+	// BulkRefreshPolicyTrue refreshes the written shards after every bulk, it makes many small segments to merge and
+	// is the slowest, meant for small syncs read right away
+	BulkRefreshPolicyTrue BulkRefreshPolicy = "true"
+)
+
+// IndexRetryCleanup decides what a retry of a failed index does with the docs the failed attempt wrote
+type IndexRetryCleanup string
+
+const (
+	// IndexRetryCleanupKeep keeps the target, the retry writes the docs again over the same ids
+	IndexRetryCleanupKeep IndexRetryCleanup = "keep"
+	// IndexRetryCleanupRecreate deletes and recreates the target before the retry, needed with auto generated ids
+	IndexRetryCleanupRecreate IndexRetryCleanup = "recreate"
+)
+
+// RollupInterval is the time bucket the docs of a rollup are aggregated into
+type RollupInterval string
+
+const (
+	RollupIntervalHour  RollupInterval = "hour"
+	RollupIntervalDay   RollupInterval = "day"
+	RollupIntervalMonth RollupInterval = "month"
+	RollupIntervalYear  RollupInterval = "year"
+)
+
+// RollupMetric aggregates a numeric field of the docs of a rollup bucket
+type RollupMetric string
+
+const (
+	RollupMetricSum RollupMetric = "sum"
+	RollupMetricMin RollupMetric = "min"
+	RollupMetricMax RollupMetric = "max"
+	RollupMetricAvg RollupMetric = "avg"
+)
+
+// RollupCfg writes a doc per time bucket and group of the source docs instead of the docs themselves. The
+// aggregation runs in the migrator, not with the rollup api, so any source and target version can be rolled up.
+type RollupCfg struct {
+	// TimeField is the date of the docs, an epoch millis number or a date string
+	TimeField string         `mapstructure:"time_field"`
+	Interval  RollupInterval `mapstructure:"interval"`
+	// GroupBy fields split the buckets further, e.g. a doc per month and host
+	GroupBy []string `mapstructure:"group_by"`
+	// Metrics are the aggregations of every numeric field, e.g. {"bytes": ["sum", "max"]} writes bytes_sum and
+	// bytes_max. Every bucket doc has the doc_count of its source docs.
+	Metrics map[string][]RollupMetric `mapstructure:"metrics"`
+}
+
+type WriteMode string
+
+const (
+	// WriteModeAsync fires the slave write and only logs its failure
+	WriteModeAsync WriteMode = "async"
+	// WriteModeBestEffortQueue fires the slave write and records its failure for compensation
+	WriteModeBestEffortQueue WriteMode = "best-effort-queue"
+	// WriteModeStrict waits for the slave write and fails the client request when it fails
+	WriteModeStrict WriteMode = "strict"
 )
 
 type TaskCfg struct {
@@ -31,11 +141,104 @@ type TaskCfg struct {
 	Ids               []string         `mapstructure:"ids"`
 	IndexFilePairs    []*IndexFilePair `mapstructure:"index_file_pairs"`
 	IndexFileRoot     string           `mapstructure:"index_file_root"`
+	MaxDocs           uint             `mapstructure:"max_docs"`
+	AutoSlice         bool             `mapstructure:"auto_slice"`
+	BulkDedup         bool             `mapstructure:"bulk_dedup"`
+	ConflictPolicy    ConflictPolicy   `mapstructure:"conflict_policy"`
+	TimestampField    string           `mapstructure:"timestamp_field"`
+	// ForceMergeSegments force merges the target indices after sync, it is expensive so run the task off-peak
+	ForceMergeSegments uint `mapstructure:"force_merge_segments"`
+	// TargetType overrides the _type written to a typed (before 7.x) target
+	TargetType string `mapstructure:"target_type"`
+	// StrictCompatibility fails the task on an unsupported version jump instead of warning
+	StrictCompatibility bool `mapstructure:"strict_compatibility"`
+	// IndexTimeout bounds in seconds the run of every index, 0 means no limit
+	IndexTimeout uint `mapstructure:"index_timeout"`
+	// ProgressLogInterval throttles in seconds the task progress log, 0 logs every finished index
+	ProgressLogInterval uint `mapstructure:"progress_log_interval"`
+	// AutoGenerateIds lets the target assign new ids, a re-run then duplicates the docs
+	AutoGenerateIds bool `mapstructure:"auto_generate_ids"`
+	// UnorderedArrayFields are the dotted path fields whose arrays compare regardless of order, `*` for all
+	UnorderedArrayFields []string `mapstructure:"unordered_array_fields"`
+	// CompareIgnoreFields are the dotted path fields stripped from both sides before they are compared
+	CompareIgnoreFields []string `mapstructure:"compare_ignore_fields"`
+	// CompareCheckpointFile records the index pairs a compare finished, a restarted compare resumes from it
+	CompareCheckpointFile string `mapstructure:"compare_checkpoint_file"`
+	// IndexListFile lists an index or a source=target pair per line, added to IndexPairs
+	IndexListFile string `mapstructure:"index_list_file"`
+	// ProgressTotalDocs counts the source docs of all the index pairs before a sync so the total progress is right
+	// from the start, otherwise its total grows as the index pairs start
+	ProgressTotalDocs bool `mapstructure:"progress_total_docs"`
+	// MinIndexBytes and MaxIndexBytes bound the store size of the source indices to migrate, 0 is unbounded
+	MinIndexBytes uint64 `mapstructure:"min_index_bytes"`
+	MaxIndexBytes uint64 `mapstructure:"max_index_bytes"`
+	// SizeOrdering starts the indices by their store size, asc for the smallest first and desc for the biggest
+	SizeOrdering string `mapstructure:"size_ordering"`
+	// StartStagger is the max random delay in milliseconds between the starts of the parallel indices, 0 is off
+	StartStagger uint `mapstructure:"start_stagger"`
+	// ReadParallel bounds the slices of an index scrolled at once, 0 scrolls all of them at once
+	ReadParallel uint `mapstructure:"read_parallel"`
+	// MaxOpenScrolls bounds the scrolls open on the source across all the indices, under the
+	// search.max_open_scroll_context of the cluster. 0 is unbounded.
+	MaxOpenScrolls uint `mapstructure:"max_open_scrolls"`
+	// MaxInflightBulk bounds the bulk requests of an index sent at once, it protects the bulk thread pool of the
+	// target from es_rejected_execution_exception. 0 is one per action parallelism.
+	MaxInflightBulk uint `mapstructure:"max_inflight_bulk"`
+	// StreamBulk streams the bulk bodies to the target instead of buffering every batch, a failed request is not
+	// retried by the client
+	StreamBulk bool `mapstructure:"stream_bulk"`
+	// SortFields sorts the source scrolls, e.g. ["timestamp:asc", "_id"]
+	SortFields []string `mapstructure:"sort_fields"`
+	// MultiTypePolicy creates the target of a multi-type source with its first type when "first", the default
+	// "error" fails the index pair
+	MultiTypePolicy MultiTypePolicy `mapstructure:"multi_type_policy"`
+	// WaitForActiveShards is the number of active shard copies the writes wait for, e.g. "2" or "all", higher values
+	// trade throughput for durability
+	WaitForActiveShards string `mapstructure:"wait_for_active_shards"`
+	// RequireExistingTarget never creates the target indices, a missing target fails its index pair
+	RequireExistingTarget bool `mapstructure:"require_existing_target"`
+	// DenyIndexes are index names and wildcards never migrated, even when an index pair names them
+	DenyIndexes []string `mapstructure:"deny_indexes"`
+	// ClosedIndexPolicy skips the closed source indices with a warning when "skip" and opens them for the migration
+	// when "open", the default "error" fails them
+	ClosedIndexPolicy ClosedIndexPolicy `mapstructure:"closed_index_policy"`
+	// TrackTotalHits counts the exact total of the source scrolls on es 7 and later, unset means true
+	TrackTotalHits *bool `mapstructure:"track_total_hits"`
+	// VerifyCount fails the index pairs whose target counts fewer docs than the source after the sync, the last
+	// bulks then wait for a refresh of the target
+	VerifyCount bool `mapstructure:"verify_count"`
+	// BulkRefreshPolicy is the refresh of the bulk requests, "wait_for" or "true" make the docs visible to search
+	// once their bulk answered at the cost of throughput, the default "none" leaves it to the target
+	BulkRefreshPolicy BulkRefreshPolicy `mapstructure:"bulk_refresh_policy"`
+	// IndexRetry syncs a failed index pair again from a fresh scroll up to this many times, IndexRetryCleanup
+	// "recreate" deletes and recreates its target before every retry, the default "keep" writes over it
+	IndexRetry        uint              `mapstructure:"index_retry"`
+	IndexRetryCleanup IndexRetryCleanup `mapstructure:"index_retry_cleanup"`
+	// Rollup aggregates the source docs of every index pair into a doc per time bucket, e.g. daily indices into
+	// monthly ones
+	Rollup *RollupCfg `mapstructure:"rollup"`
+	// CompareSettings diffs the index settings and mappings of every index pair too when comparing
+	CompareSettings bool `mapstructure:"compare_settings"`
+	// AllowSelf lets an index pair read and rewrite the same index of the same cluster, e.g. to reindex in place
+	AllowSelf bool `mapstructure:"allow_self"`
+	// TemplateConflictPolicy keeps an index template existing on the target when "skip" and merges into it when
+	// "merge", the default "overwrite" replaces it
+	TemplateConflictPolicy TemplateConflictPolicy `mapstructure:"template_conflict_policy"`
+	// CancelOnSignal cancels the task on SIGINT or SIGTERM and clears its open scrolls before it exits, without it
+	// the scrolls of an interrupted process stay open on the source until they expire
+	CancelOnSignal bool `mapstructure:"cancel_on_signal"`
 }
 
 type IndexPair struct {
+	// SourceIndex may be a comma separated list or a wildcard, TargetIndex may then use `{index}` for every
+	// expanded source index
 	SourceIndex string `mapstructure:"source_index"`
 	TargetIndex string `mapstructure:"target_index"`
+	// Merge allows a multi index SourceIndex to write into a single TargetIndex
+	Merge bool `mapstructure:"merge"`
+	// SwapAlias is moved onto TargetIndex once its sync succeeded, only the sync_all action swaps it. It may use
+	// `{index}` like TargetIndex.
+	SwapAlias string `mapstructure:"swap_alias"`
 }
 
 type IndexFilePair struct {
@@ -50,9 +253,22 @@ type IndexTemplate struct {
 }
 
 type ESConfig struct {
+	// Name labels the cluster in the logs and the gateway metrics, e.g. "prod-eu"
+	Name      string   `mapstructure:"name"`
 	Addresses []string `mapstructure:"addresses"`
 	User      string   `mapstructure:"user"`
-	Password  string   `mapstructure:"password"`
+	// Password is either literal or a `${ENV:NAME}` / `${FILE:/path}` reference resolved when the client is built
+	Password string `mapstructure:"password"`
+	// PasswordFile keeps the password out of the config file, it can not be set together with Password
+	PasswordFile string `mapstructure:"password_file"`
+	// Headers are attached to every request sent to the cluster, including the ones the gateway proxies
+	Headers map[string]string `mapstructure:"headers"`
+	// SensitiveHeaders are masked when the headers are logged, Authorization and Cookie always are
+	SensitiveHeaders []string `mapstructure:"sensitive_headers"`
+	// UserAgent is sent with every request to the cluster, empty means ela-lib/<version>
+	UserAgent string `mapstructure:"user_agent"`
+	// ScrollDecodeConcurrency decodes the hits of a scroll page on that many goroutines, 0 or 1 decodes serially
+	ScrollDecodeConcurrency int `mapstructure:"scroll_decode_concurrency"`
 }
 
 type Config struct {
@@ -63,12 +279,80 @@ type Config struct {
 	GatewayCfg        *GatewayCfg          `mapstructure:"gateway"`
 }
 
+type AuthScheme string
+
+const (
+	AuthSchemeBasic  AuthScheme = "basic"
+	AuthSchemeApiKey AuthScheme = "apikey"
+	AuthSchemeBearer AuthScheme = "bearer"
+)
+
 type GatewayCfg struct {
 	Address  string `mapstructure:"address"`
 	User     string `mapstructure:"user"`
 	Password string `mapstructure:"password"`
 
+	// AuthSchemes are the accepted inbound auth schemes, empty means basic only. The password, api keys and
+	// bearer tokens may be `${ENV:NAME}` / `${FILE:/path}` references.
+	AuthSchemes  []AuthScheme `mapstructure:"auth_schemes"`
+	ApiKeys      []string     `mapstructure:"api_keys"`
+	BearerTokens []string     `mapstructure:"bearer_tokens"`
+
 	SourceES string `mapstructure:"source_es"`
 	TargetES string `mapstructure:"target_es"`
 	Master   string `mapstructure:"master"`
+
+	// MaxBodySize limits in MB the request bodies the gateway has to buffer, 0 means 100MB
+	MaxBodySize uint `mapstructure:"max_body_size"`
+
+	// WriteMode decides how slave write failures are handled, empty means async
+	WriteMode WriteMode `mapstructure:"write_mode"`
+	// CompensationFile keeps the failed slave writes as json lines, required by best-effort-queue
+	CompensationFile string `mapstructure:"compensation_file"`
+
+	// CORS is disabled when it is not configured
+	CORS *CORSCfg `mapstructure:"cors"`
+
+	// ReadWeights split the stateless reads between the master and the slave
+	ReadWeights ReadWeights `mapstructure:"read_weights"`
+	// ShadowCompare replays the reads served by the master on the slave and reports the mismatches
+	ShadowCompare bool `mapstructure:"shadow_compare"`
+	// ShadowRetries re-reads the slave on a mismatch before reporting it, the slave may not have refreshed a
+	// recent write yet. ShadowRetryDelay waits in milliseconds before each re-read, 0 means 1000.
+	ShadowRetries    uint `mapstructure:"shadow_retries"`
+	ShadowRetryDelay uint `mapstructure:"shadow_retry_delay"`
+
+	// TLSCertFile and TLSKeyFile serve https when both are set
+	TLSCertFile string `mapstructure:"tls_cert_file"`
+	TLSKeyFile  string `mapstructure:"tls_key_file"`
+	// TLSMinVersion is one of 1.0, 1.1, 1.2 and 1.3, empty means 1.2
+	TLSMinVersion string `mapstructure:"tls_min_version"`
+
+	// IndexRoutes proxy the requests whose index starts with a prefix to the elastic of that name alone, e.g.
+	// {"tenant_a-*": "cluster_a"}. The longest prefix wins, the other requests go to the master and the slave.
+	IndexRoutes map[string]string `mapstructure:"index_routes"`
+
+	// UpstreamTimeout bounds in milliseconds every request to an upstream, the client is answered 504 once it
+	// passes. 0 means no limit.
+	UpstreamTimeout uint `mapstructure:"upstream_timeout"`
+	// UpstreamReadRetries retries a stateless read which failed to reach its upstream or was answered 502, 503 or
+	// 504, writes are never retried as the upstream may have applied them
+	UpstreamReadRetries uint `mapstructure:"upstream_read_retries"`
+}
+
+// ReadWeights are relative, e.g. master 9 and slave 1 send a tenth of the reads to the slave. Both 0 reads
+// the master only.
+type ReadWeights struct {
+	Master uint `mapstructure:"master" json:"master"`
+	Slave  uint `mapstructure:"slave" json:"slave"`
+}
+
+type CORSCfg struct {
+	// AllowedOrigins accepts "*" for any origin
+	AllowedOrigins   []string `mapstructure:"allowed_origins"`
+	AllowedMethods   []string `mapstructure:"allowed_methods"`
+	AllowedHeaders   []string `mapstructure:"allowed_headers"`
+	AllowCredentials bool     `mapstructure:"allow_credentials"`
+	// MaxAge is the seconds a preflight result can be cached, 0 omits it
+	MaxAge uint `mapstructure:"max_age"`
 }
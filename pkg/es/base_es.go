@@ -9,6 +9,7 @@ import (
 	"github.com/jinzhu/copier"
 	"github.com/pkg/errors"
 	_ "github.com/pkg/errors"
+	"github.com/samber/lo"
 	"github.com/spf13/cast"
 	"io"
 	"math/rand"
@@ -19,14 +20,27 @@ import (
 
 type BaseES struct {
 	ClusterVersion string
-	Addresses      []string
-	User           string
-	Password       string
+	// ClusterName is the configured label of the cluster, empty when none is set
+	ClusterName string
+	Addresses   []string
+	User        string
+	Password    string
 
 	ActionRuleMap map[RequestActionType]*UriParserRule
 	MethodRuleMap map[MethodType][]*MatchRule
 
 	Settings IESSettings
+
+	Sessions *SessionAffinity
+
+	// Transport is the transport of the version client, kept to release its connections on Close
+	Transport *http.Transport
+
+	// Headers are set on every proxied request over the ones of the client
+	Headers map[string]string
+
+	// ScrollDecodeConcurrency decodes the hits of a scroll page concurrently when over 1, see decodeScrollResponse
+	ScrollDecodeConcurrency int
 }
 
 func NewBaseES(clusterVersion string, addresses []string, user string, password string) *BaseES {
@@ -35,6 +49,7 @@ func NewBaseES(clusterVersion string, addresses []string, user string, password
 		Addresses:      addresses,
 		User:           user,
 		Password:       password,
+		Sessions:       NewSessionAffinity(defaultSessionAffinityTTL),
 	}
 
 	baseES.GetActionRuleMap()
@@ -42,6 +57,15 @@ func NewBaseES(clusterVersion string, addresses []string, user string, password
 	return baseES
 }
 
+// Close releases the idle connections and clears the cached sessions, a later request dials again.
+func (es *BaseES) Close() error {
+	if es.Transport != nil {
+		es.Transport.CloseIdleConnections()
+	}
+	es.Sessions.Clear()
+	return nil
+}
+
 func (es *BaseES) ClusterVersionGte7() bool {
 	segments := strings.Split(es.ClusterVersion, ".")
 	return cast.ToInt(segments[0]) >= 7
@@ -108,6 +132,10 @@ func (es *BaseES) GetAddresses() []string {
 	return es.Addresses
 }
 
+func (es *BaseES) GetClusterName() string {
+	return es.ClusterName
+}
+
 func (es *BaseES) GetUser() string {
 	return es.User
 }
@@ -116,6 +144,17 @@ func (es *BaseES) GetPassword() string {
 	return es.Password
 }
 
+// matchSegment binds a `${variable}` pattern segment, a literal segment must equal the uri segment.
+func matchSegment(variableMap map[string]string, patternSegment string, uriSegment string) bool {
+	if !strings.HasPrefix(patternSegment, "${") {
+		return patternSegment == uriSegment
+	}
+
+	variable := strings.Trim(patternSegment, "${}")
+	variableMap[variable] = uriSegment
+	return true
+}
+
 func (es *BaseES) matchRule(uri string, uriPattern string) (map[string]string, bool) {
 	uri = strings.Trim(uri, "/")
 	uriPattern = strings.Trim(uriPattern, "/")
@@ -155,8 +194,9 @@ func (es *BaseES) matchRule(uri string, uriPattern string) (map[string]string, b
 	for uriBeginIdx <= uriEndIdx && patternBeginIdx <= patternEndIdx && hasChange {
 		hasChange = false
 		if !strings.HasSuffix(removeActionPatternSegments[patternBeginIdx], "?") {
-			variable := strings.Trim(removeActionPatternSegments[patternBeginIdx], "${}")
-			variableMap[variable] = removeActionUriSegments[uriBeginIdx]
+			if !matchSegment(variableMap, removeActionPatternSegments[patternBeginIdx], removeActionUriSegments[uriBeginIdx]) {
+				return nil, false
+			}
 			uriBeginIdx++
 			patternBeginIdx++
 			hasChange = true
@@ -164,8 +204,9 @@ func (es *BaseES) matchRule(uri string, uriPattern string) (map[string]string, b
 
 		if uriBeginIdx <= uriEndIdx && patternBeginIdx <= patternEndIdx {
 			if !strings.HasSuffix(removeActionPatternSegments[patternEndIdx], "?") {
-				variable := strings.Trim(removeActionPatternSegments[patternEndIdx], "${}")
-				variableMap[variable] = removeActionUriSegments[uriEndIdx]
+				if !matchSegment(variableMap, removeActionPatternSegments[patternEndIdx], removeActionUriSegments[uriEndIdx]) {
+					return nil, false
+				}
 				uriEndIdx--
 				patternEndIdx--
 				hasChange = true
@@ -296,15 +337,34 @@ func (es *BaseES) IsWrite(requestActionType RequestActionType) bool {
 	return actionRule.IsWrite
 }
 
-func (es *BaseES) Request(c *gin.Context, bodyBytes []byte, parserUriResult *UriPathParserResult) (map[string]interface{}, int, error) {
+// Request sends body to the upstream as a stream, only the small bodies of scroll and search actions are
+// read ahead to find their session ids.
+func (es *BaseES) Request(c *gin.Context, body io.Reader, parserUriResult *UriPathParserResult) (map[string]interface{}, int, error) {
 	makeUriResult, err := es.MakeUri(parserUriResult)
 	if err != nil {
 		return nil, http.StatusInternalServerError, errors.WithStack(err)
 	}
 
+	var sessionIds []string
+	if lo.Contains(sessionActions, parserUriResult.RequestAction) {
+		bodyBytes, err := io.ReadAll(body)
+		if err != nil {
+			return nil, http.StatusInternalServerError, errors.WithStack(err)
+		}
+		sessionIds = getSessionIds(parserUriResult, bodyBytes)
+		body = bytes.NewReader(bodyBytes)
+	}
+	for _, sessionId := range sessionIds {
+		if address, ok := es.Sessions.Get(sessionId); ok {
+			makeUriResult.Address = address
+			break
+		}
+	}
+
 	targetUrl := fmt.Sprintf("%s%s", makeUriResult.Address, makeUriResult.Uri)
 
-	req, err := http.NewRequest(string(makeUriResult.Method), targetUrl, io.NopCloser(bytes.NewBuffer(bodyBytes)))
+	// the context of the client request bounds the upstream request
+	req, err := http.NewRequestWithContext(c.Request.Context(), string(makeUriResult.Method), targetUrl, body)
 	if err != nil {
 		return nil, http.StatusInternalServerError, errors.WithStack(err)
 	}
@@ -318,35 +378,90 @@ func (es *BaseES) Request(c *gin.Context, bodyBytes []byte, parserUriResult *Uri
 		req.Header.Set(k, v[0])
 	}
 
+	setHeaders(req, es.Headers)
 	req.Header.Set("Content-Type", "application/json")
 
-	reqQuery := req.URL.Query()
-
-	queryParams := c.Request.URL.Query()
-	for key, values := range queryParams {
-		for _, value := range values {
-			reqQuery.Add(key, value)
-		}
-	}
+	// keep the raw query so flags like `pretty`, the routing and the param order reach the upstream untouched
+	req.URL.RawQuery = c.Request.URL.RawQuery
 
 	client := &http.Client{}
 	resp, err := client.Do(req)
 	if err != nil {
 		return nil, http.StatusInternalServerError, errors.WithStack(err)
 	}
-	defer func() {
-		_ = resp.Body.Close()
-	}()
+	defer utils.DrainAndClose(resp.Body)
 
 	if resp.StatusCode > 299 {
 		return es.formatResponse(resp)
 	}
 
 	bodyMap, statusCode, _ := es.formatResponse(resp)
+	es.trackSession(parserUriResult, makeUriResult.Address, sessionIds, bodyMap)
 
 	return bodyMap, statusCode, nil
 }
 
+var sessionActions = []RequestActionType{
+	RequestActionTypeSearchDocument,
+	RequestActionTypeSearchDocumentWithLimit,
+	RequestActionTypeSearchScroll,
+	RequestActionTypeSearchScrollWithBody,
+	RequestActionTypeClearScroll,
+	RequestActionTypeClosePointInTime,
+}
+
+// getSessionIds collects the scroll and point in time ids a request refers to, from the uri or the body.
+func getSessionIds(parserUriResult *UriPathParserResult, bodyBytes []byte) []string {
+	var sessionIds []string
+	if scrollId, ok := parserUriResult.VariableMap["scrollId"]; ok {
+		sessionIds = append(sessionIds, strings.Split(scrollId, ",")...)
+	}
+
+	bodyMap := make(map[string]interface{})
+	if err := json.Unmarshal(bodyBytes, &bodyMap); err != nil {
+		return sessionIds
+	}
+
+	switch scrollId := bodyMap["scroll_id"].(type) {
+	case string:
+		sessionIds = append(sessionIds, scrollId)
+	case []interface{}:
+		sessionIds = append(sessionIds, cast.ToStringSlice(scrollId)...)
+	}
+
+	if pitId, ok := utils.GetValueFromMapByPath(bodyMap, "pit.id"); ok {
+		sessionIds = append(sessionIds, cast.ToString(pitId))
+	}
+
+	if parserUriResult.RequestAction == RequestActionTypeClosePointInTime {
+		sessionIds = append(sessionIds, cast.ToString(bodyMap["id"]))
+	}
+	return lo.Compact(sessionIds)
+}
+
+// trackSession pins the scroll or point in time ids of a response to the address that served it.
+func (es *BaseES) trackSession(parserUriResult *UriPathParserResult, address string,
+	sessionIds []string, bodyMap map[string]interface{}) {
+	switch parserUriResult.RequestAction {
+	case RequestActionTypeClearScroll, RequestActionTypeClosePointInTime:
+		for _, sessionId := range sessionIds {
+			es.Sessions.Delete(sessionId)
+		}
+		return
+	case RequestActionTypeOpenPointInTime:
+		if pitId := cast.ToString(bodyMap["id"]); pitId != "" {
+			es.Sessions.Set(pitId, address)
+		}
+		return
+	}
+
+	for _, key := range []string{"_scroll_id", "pit_id"} {
+		if sessionId := cast.ToString(bodyMap[key]); sessionId != "" {
+			es.Sessions.Set(sessionId, address)
+		}
+	}
+}
+
 func (es *BaseES) formatResponse(resp *http.Response) (map[string]interface{}, int, error) {
 	bodyBytes, _ := io.ReadAll(resp.Body)
 	bodyMap := make(map[string]interface{})
@@ -1,10 +1,45 @@
 package es
 
 import (
+	"fmt"
+	"github.com/gin-gonic/gin"
 	_ "github.com/pkg/errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
+// proxyRequest sends method uri through BaseES.Request to a mock upstream and returns the upstream request url.
+func proxyRequest(t *testing.T, method string, uri string, body string) *url.URL {
+	var upstreamURL *url.URL
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamURL = r.URL
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+
+	baseES := NewBaseES("7.10.2", []string{server.URL}, "", "")
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(method, uri, nil)
+
+	parseUriResult := baseES.MatchRule(c)
+	if parseUriResult == nil {
+		t.Fatalf("%s %s not matched", method, uri)
+	}
+
+	if _, statusCode, err := baseES.Request(c, strings.NewReader(body), parseUriResult); err != nil || statusCode != http.StatusOK {
+		t.Fatalf("request %d %+v", statusCode, err)
+	}
+	return upstreamURL
+}
+
 func TestMatchRule(t *testing.T) {
 	baseES := BaseES{}
 
@@ -52,4 +87,184 @@ func TestMatchRule(t *testing.T) {
 		t.Errorf("match failed")
 		return
 	}
+	variableMap, ok = baseES.matchRule("/a/_doc/1", "/${index}/_source/${docId}")
+	if ok {
+		t.Errorf("literal segment should not match: %+v", variableMap)
+		return
+	}
+
+	variableMap, ok = baseES.matchRule("/a/_source/1", "/${index}/_source/${docId}")
+	if !(ok && len(variableMap) == 2 && variableMap["index"] == "a" && variableMap["docId"] == "1") {
+		t.Errorf("variableMap: %+v", variableMap)
+	}
+}
+
+func TestRequestKeepQueryParams(t *testing.T) {
+	upstreamURL := proxyRequest(t, http.MethodGet, "/a/_doc/1?routing=x&_source=false", "")
+	if upstreamURL.Path != "/a/_doc/1" {
+		t.Errorf("path: %s", upstreamURL.Path)
+	}
+
+	query := upstreamURL.Query()
+	if query.Get("routing") != "x" || query.Get("_source") != "false" {
+		t.Errorf("query: %s", upstreamURL.RawQuery)
+	}
+}
+
+func TestRequestKeepRawQuery(t *testing.T) {
+	upstreamURL := proxyRequest(t, http.MethodGet, "/a/_search?q=user:kimchy&size=5&pretty", "")
+	if upstreamURL.RawQuery != "q=user:kimchy&size=5&pretty" {
+		t.Errorf("search query: %s", upstreamURL.RawQuery)
+	}
+
+	upstreamURL = proxyRequest(t, http.MethodPost, "/a/_doc?refresh=wait_for", `{"user":"kimchy"}`)
+	if upstreamURL.Path != "/a/_doc" || upstreamURL.RawQuery != "refresh=wait_for" {
+		t.Errorf("write url: %s", upstreamURL.String())
+	}
+}
+
+func TestMatchScrollRule(t *testing.T) {
+	cases := []struct {
+		method   string
+		uri      string
+		action   RequestActionType
+		scrollId string
+	}{
+		{http.MethodGet, "/_search/scroll", RequestActionTypeSearchScroll, ""},
+		{http.MethodGet, "/_search/scroll/abc", RequestActionTypeSearchScroll, "abc"},
+		{http.MethodPost, "/_search/scroll", RequestActionTypeSearchScrollWithBody, ""},
+		{http.MethodDelete, "/_search/scroll", RequestActionTypeClearScroll, ""},
+		{http.MethodDelete, "/_search/scroll/abc", RequestActionTypeClearScroll, "abc"},
+	}
+
+	for _, version := range []string{"5.6.16", "6.8.23", "7.10.2", "8.12.2"} {
+		baseES := NewBaseES(version, []string{"http://127.0.0.1:9200"}, "", "")
+		for _, item := range cases {
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest(item.method, item.uri, nil)
+
+			parseUriResult := baseES.MatchRule(c)
+			if parseUriResult == nil || parseUriResult.RequestAction != item.action ||
+				parseUriResult.VariableMap["scrollId"] != item.scrollId {
+				t.Errorf("%s %s %s: %+v", version, item.method, item.uri, parseUriResult)
+				continue
+			}
+
+			makeUriResult, err := baseES.MakeUri(parseUriResult)
+			if err != nil || makeUriResult.Uri != item.uri || string(makeUriResult.Method) != item.method {
+				t.Errorf("%s make uri %+v %+v", version, makeUriResult, err)
+			}
+		}
+	}
+}
+
+func TestMatchCountRule(t *testing.T) {
+	for _, version := range []string{"5.6.16", "6.8.23", "7.10.2", "8.12.2"} {
+		baseES := NewBaseES(version, []string{"http://127.0.0.1:9200"}, "", "")
+		for _, method := range []string{http.MethodGet, http.MethodPost} {
+			for uri, index := range map[string]string{"/_count": "", "/a/_count": "a", "/a,b*/_count": "a,b*"} {
+				c, _ := gin.CreateTestContext(httptest.NewRecorder())
+				c.Request = httptest.NewRequest(method, uri, nil)
+
+				parseUriResult := baseES.MatchRule(c)
+				if parseUriResult == nil || parseUriResult.RequestAction != RequestActionTypeCountDocument ||
+					parseUriResult.VariableMap["index"] != index {
+					t.Errorf("%s %s %s: %+v", version, method, uri, parseUriResult)
+					continue
+				}
+
+				makeUriResult, err := baseES.MakeUri(parseUriResult)
+				if err != nil || makeUriResult.Uri != uri {
+					t.Errorf("%s make uri %+v %+v", version, makeUriResult, err)
+				}
+			}
+		}
+	}
+}
+
+func TestRequestScrollStickToAddress(t *testing.T) {
+	var (
+		addresses []string
+		hits      [2]atomic.Int32
+	)
+	for i := 0; i < 2; i++ {
+		idx := i
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			hits[idx].Add(1)
+			body, _ := io.ReadAll(r.Body)
+			scrollId := fmt.Sprintf("scroll-%d", idx)
+			if strings.HasPrefix(r.URL.Path, "/_search/scroll") && !strings.Contains(string(body), scrollId) {
+				w.WriteHeader(http.StatusNotFound)
+				_, _ = w.Write([]byte(`{"error":"search_context_missing_exception"}`))
+				return
+			}
+			_, _ = fmt.Fprintf(w, `{"_scroll_id":"%s","hits":{"total":{"value":2},"hits":[]}}`, scrollId)
+		}))
+		defer server.Close()
+		addresses = append(addresses, server.URL)
+	}
+
+	baseES := NewBaseES("7.10.2", addresses, "", "")
+	request := func(method string, uri string, body string) map[string]interface{} {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(method, uri, strings.NewReader(body))
+		resp, statusCode, err := baseES.Request(c, strings.NewReader(body), baseES.MatchRule(c))
+		if err != nil || statusCode != http.StatusOK {
+			t.Fatalf("%s %s: %d %+v %+v", method, uri, statusCode, resp, err)
+		}
+		return resp
+	}
+
+	resp := request(http.MethodPost, "/a/_search?scroll=1m", `{"size":1}`)
+	scrollId := resp["_scroll_id"].(string)
+	for page := 0; page < 5; page++ {
+		request(http.MethodPost, "/_search/scroll", fmt.Sprintf(`{"scroll":"1m","scroll_id":"%s"}`, scrollId))
+	}
+
+	if hits[0].Load()+hits[1].Load() != 6 || (hits[0].Load() != 6 && hits[1].Load() != 6) {
+		t.Errorf("scroll should stick to one address, hits: %d %d", hits[0].Load(), hits[1].Load())
+	}
+
+	request(http.MethodDelete, "/_search/scroll", fmt.Sprintf(`{"scroll_id":["%s"]}`, scrollId))
+	if baseES.Sessions.Len() != 0 {
+		t.Errorf("clear scroll should release the session")
+	}
+}
+
+func TestSessionAffinityExpire(t *testing.T) {
+	sessions := NewSessionAffinity(time.Millisecond)
+	sessions.Set("a", "http://127.0.0.1:9200")
+	if address, ok := sessions.Get("a"); !ok || address != "http://127.0.0.1:9200" {
+		t.Fatalf("expected session a")
+	}
+
+	time.Sleep(5 * time.Millisecond)
+	if _, ok := sessions.Get("a"); ok {
+		t.Errorf("expected session a expired")
+	}
+}
+
+func TestRequestHeaders(t *testing.T) {
+	var upstreamHeader http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamHeader = r.Header.Clone()
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+
+	baseES := NewBaseES("7.10.2", []string{server.URL}, "", "")
+	baseES.Headers = map[string]string{"X-Tenant": "tenant-a"}
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodGet, "/a/_search", nil)
+	c.Request.Header.Set("X-Tenant", "tenant-b")
+	c.Request.Header.Set("Traceparent", "00-trace-span-01")
+
+	if _, _, err := baseES.Request(c, strings.NewReader(""), baseES.MatchRule(c)); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if upstreamHeader.Get("X-Tenant") != "tenant-a" || upstreamHeader.Get("Traceparent") != "00-trace-span-01" {
+		t.Errorf("unexpected upstream headers %v", upstreamHeader)
+	}
 }
@@ -6,10 +6,18 @@ import (
 	"crypto/tls"
 	"encoding/json"
 	"github.com/CharellKing/ela-lib/config"
+	"github.com/CharellKing/ela-lib/utils"
 	"github.com/gin-gonic/gin"
+	"github.com/samber/lo"
+	"github.com/spf13/cast"
 	"io"
+	"net"
 	"net/http"
+	"net/url"
+	"slices"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/pkg/errors"
 )
@@ -20,6 +28,8 @@ const (
 	OperationCreate Operation = iota
 	OperationUpdate
 	OperationDelete
+	// OperationCreateOnly writes with op_type=create, leaving an existing doc untouched
+	OperationCreateOnly
 )
 
 type MethodType string
@@ -38,11 +48,17 @@ type ScrollResult struct {
 }
 
 type Doc struct {
-	Type   string                 `mapstructure:"_type" json:"_type"`
-	ID     string                 `mapstructure:"_id" json:"_id"`
-	Source map[string]interface{} `mapstructure:"_source" json:"_source"`
-	Hash   uint64                 `mapstructure:"_hash" json:"_hash"`
-	Op     Operation              `mapstructure:"_op" json:"_op"`
+	Type string `mapstructure:"_type" json:"_type"`
+	ID   string `mapstructure:"_id" json:"_id"`
+	// Routing is the custom routing of the doc, the bulk writes it so the doc lands on the same shard
+	Routing string                 `mapstructure:"_routing" json:"_routing,omitempty"`
+	Source  map[string]interface{} `mapstructure:"_source" json:"_source"`
+	Hash    uint64                 `mapstructure:"_hash" json:"_hash"`
+	Op      Operation              `mapstructure:"_op" json:"_op"`
+	// Script makes an update a scripted one, e.g. {"source": "ctx._source.n += params.n", "params": {"n": 1}}, the
+	// Upsert is indexed instead when the doc does not exist yet
+	Script map[string]interface{} `mapstructure:"_script" json:"_script,omitempty"`
+	Upsert map[string]interface{} `mapstructure:"_upsert" json:"_upsert,omitempty"`
 }
 
 func (d *Doc) DumpFileBytes() []byte {
@@ -54,6 +70,72 @@ func (d *Doc) DumpFileBytes() []byte {
 	return buf
 }
 
+// scriptedUpdateBody is the body of the update action of a doc with a script
+func scriptedUpdateBody(doc *Doc) map[string]interface{} {
+	body := map[string]interface{}{"script": doc.Script}
+	if doc.Upsert != nil {
+		body["upsert"] = doc.Upsert
+	}
+	return body
+}
+
+// WriteOption holds the params of the bulk and create index requests, nil leaves them to the cluster
+type WriteOption struct {
+	// WaitForActiveShards is the number of active shard copies a write waits for, e.g. "2" or "all"
+	WaitForActiveShards string
+	// Refresh is the refresh param of a bulk request, "wait_for" answers once its docs are visible to search
+	Refresh string
+}
+
+func (option *WriteOption) waitForActiveShards() string {
+	if option == nil {
+		return ""
+	}
+	return option.WaitForActiveShards
+}
+
+func (option *WriteOption) refresh() string {
+	if option == nil {
+		return ""
+	}
+	return option.Refresh
+}
+
+// AliasAction is an action of an `_aliases` request, it adds the alias to the index or removes it when Remove
+type AliasAction struct {
+	Alias  string
+	Index  string
+	Remove bool
+}
+
+// ReindexOption holds the params of a `_reindex` request
+type ReindexOption struct {
+	// Query is the search body selecting the source docs like the one of ScrollOption, nil reindexes all of them
+	Query map[string]interface{}
+	// Slices splits the reindex into parallel slices, 0 reindexes with one
+	Slices uint
+	// RequestsPerSecond throttles the reindex, 0 leaves it unthrottled
+	RequestsPerSecond uint
+	// PollInterval is the wait between two polls of the reindex task, a second by default
+	PollInterval time.Duration
+}
+
+func (option *ReindexOption) pollInterval() time.Duration {
+	if option == nil || option.PollInterval <= 0 {
+		return time.Second
+	}
+	return option.PollInterval
+}
+
+// ReindexResult is the outcome of a finished reindex task
+type ReindexResult struct {
+	Total   uint64
+	Created uint64
+	Updated uint64
+	// Failures are the docs the reindex failed to write
+	Failures []interface{}
+}
+
 type ScrollOption struct {
 	Query      map[string]interface{}
 	SortFields []string
@@ -61,28 +143,70 @@ type ScrollOption struct {
 	ScrollTime uint
 	SliceId    *uint
 	SliceSize  *uint
+	// TrackTotalHits counts the exact total of the hits, es 7 and later stop counting at 10000 by default
+	TrackTotalHits bool
 }
 
 type ES interface {
 	GetClusterVersion() string
+	// GetClusterName returns the configured name of the cluster, empty when none is set
+	GetClusterName() string
 	IndexExisted(index string) (bool, error)
+	// IndexClosed tells whether the index is closed, a closed index can't be searched
+	IndexClosed(ctx context.Context, index string) (bool, error)
+	// OpenIndex opens the index and waits until it can be searched, CloseIndex closes it. An open or close which
+	// the cluster settings or a block forbid fails with their reason.
+	OpenIndex(ctx context.Context, index string) error
+	CloseIndex(ctx context.Context, index string) error
+	// GetAliasIndexes returns the indices the alias points to, none when the alias does not exist
+	GetAliasIndexes(ctx context.Context, alias string) ([]string, error)
+	// UpdateAliases applies the actions in one `_aliases` request, es applies them all or none
+	UpdateAliases(ctx context.Context, actions []AliasAction) error
 	GetIndexes() ([]string, error)
 
+	// GetIndexSizes returns the store size in bytes of every index
+	GetIndexSizes() (map[string]uint64, error)
+	// GetHiddenIndexes returns the indices whose `index.hidden` setting is true, read for all of them at once
+	GetHiddenIndexes() (map[string]bool, error)
+	// GetIndexStats returns the `_stats` of the index, summed over the indices a wildcard or alias matches
+	GetIndexStats(ctx context.Context, index string) (*IndexStats, error)
+
 	NewScroll(ctx context.Context, index string, option *ScrollOption) (*ScrollResult, error)
 	NextScroll(ctx context.Context, scrollId string, scrollTime uint) (*ScrollResult, error)
 	ClearScroll(scrollId string) error
 
 	BulkBody(index string, buf *bytes.Buffer, doc *Doc) error
-	Bulk(buf *bytes.Buffer) error
+	Bulk(buf *bytes.Buffer, option *WriteOption) error
+	// BulkStream sends a bulk request whose body is read while it is sent, a failed request is not retried
+	BulkStream(body io.Reader, option *WriteOption) error
 
 	GetIndexMappingAndSetting(index string) (IESSettings, error)
+	GetIndexMapping(index string) (map[string]interface{}, error)
+	GetIndexSettings(index string) (map[string]interface{}, error)
 
-	CreateIndex(esSetting IESSettings) error
+	CreateIndex(esSetting IESSettings, option *WriteOption) error
 	DeleteIndex(index string) error
+	// PutMapping adds the field properties to the mapping of an existing index
+	PutMapping(index string, properties map[string]interface{}) error
 
 	Count(ctx context.Context, index string) (uint64, error)
+	// SearchByQuery runs a single search request on the index and returns its answer as is
+	SearchByQuery(ctx context.Context, index string, query map[string]interface{}) (map[string]interface{}, error)
+	CountByQuery(ctx context.Context, index string, query map[string]interface{}) (uint64, error)
+
+	// Refresh accepts comma separated indices and wildcards
+	Refresh(ctx context.Context, index string) error
+	// ForceMerge merges the segments of the indices, 0 maxNumSegments lets es decide. It is expensive on io and
+	// cpu, run it off-peak once the writes are done.
+	ForceMerge(ctx context.Context, index string, maxNumSegments uint) error
 
 	CreateTemplate(ctx context.Context, name string, body map[string]interface{}) error
+	// Reindex copies the source index into the dest index of the same cluster with `_reindex`, it runs as a task
+	// which is polled until it finished
+	Reindex(ctx context.Context, source, dest string, option *ReindexOption) (*ReindexResult, error)
+
+	// GetTemplate returns the body of the legacy index template name, nil when it does not exist
+	GetTemplate(ctx context.Context, name string) (map[string]interface{}, error)
 
 	ClusterHealth(ctx context.Context) (map[string]interface{}, error)
 
@@ -90,6 +214,9 @@ type ES interface {
 
 	GetAddresses() []string
 
+	// Close releases the idle connections of the client
+	Close() error
+
 	GetUser() string
 
 	GetPassword() string
@@ -106,7 +233,7 @@ type ES interface {
 
 	IsWrite(requestActionType RequestActionType) bool
 
-	Request(c *gin.Context, bodyBytes []byte, parserUriResult *UriPathParserResult) (map[string]interface{}, int, error)
+	Request(c *gin.Context, body io.Reader, parserUriResult *UriPathParserResult) (map[string]interface{}, int, error)
 
 	ClusterVersionGte7() bool
 }
@@ -131,22 +258,51 @@ func NewESV0(config *config.ESConfig) *V0 {
 }
 
 func (es *V0) GetES() (ES, error) {
-	clusterVersion, err := es.GetVersion()
+	esConfig, err := resolveSecrets(es.Config)
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
+	resolvedES := NewESV0(esConfig)
 
-	if strings.HasPrefix(clusterVersion.Version.Number, "8.") {
-		return NewESV8(es.Config, clusterVersion.Version.Number)
-	} else if strings.HasPrefix(clusterVersion.Version.Number, "7.") {
-		return NewESV7(es.Config, clusterVersion.Version.Number)
-	} else if strings.HasPrefix(clusterVersion.Version.Number, "6.") {
-		return NewESV6(es.Config, clusterVersion.Version.Number)
-	} else if strings.HasPrefix(clusterVersion.Version.Number, "5.") {
-		return NewESV5(es.Config, clusterVersion.Version.Number)
+	clusterVersion, err := resolvedES.GetVersion()
+	if err != nil {
+		return nil, errors.WithStack(err)
 	}
 
-	return nil, errors.Errorf("unsupported version: %s", clusterVersion.Version.Number)
+	return resolvedES.newES(clusterVersion.Version.Number)
+}
+
+// resolveSecrets returns a copy of the config holding the password its reference or file points to
+func resolveSecrets(esConfig *config.ESConfig) (*config.ESConfig, error) {
+	if esConfig.Password != "" && esConfig.PasswordFile != "" {
+		return nil, utils.NewCustomError(utils.InvalidParams, "password and password_file can not be set together")
+	}
+
+	resolvedConfig := *esConfig
+	var err error
+	if esConfig.PasswordFile != "" {
+		resolvedConfig.Password, err = utils.ReadSecretFile(esConfig.PasswordFile)
+	} else {
+		resolvedConfig.Password, err = utils.ResolveSecret(esConfig.Password)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &resolvedConfig, nil
+}
+
+func (es *V0) newES(version string) (ES, error) {
+	if strings.HasPrefix(version, "8.") {
+		return NewESV8(es.Config, version)
+	} else if strings.HasPrefix(version, "7.") {
+		return NewESV7(es.Config, version)
+	} else if strings.HasPrefix(version, "6.") {
+		return NewESV6(es.Config, version)
+	} else if strings.HasPrefix(version, "5.") {
+		return NewESV5(es.Config, version)
+	}
+
+	return nil, errors.Errorf("unsupported version: %s", version)
 }
 
 func (es *V0) GetVersion() (*ClusterVersion, error) {
@@ -178,15 +334,22 @@ func (es *V0) Get(url string) ([]byte, error) {
 	if es.Config.User != "" && es.Config.Password != "" {
 		req.SetBasicAuth(es.Config.User, es.Config.Password)
 	}
+	setHeaders(req, requestHeaders(es.Config))
 
 	client := &http.Client{Transport: transport}
 	resp, err := client.Do(req)
 	if err != nil {
-		return nil, errors.WithStack(err)
+		var dnsErr *net.DNSError
+		if errors.As(err, &dnsErr) {
+			return nil, utils.NewCustomError(utils.ConnectionFailed, "resolve %s: %s", url, dnsErr.Error())
+		}
+		return nil, utils.NewCustomError(utils.ConnectionFailed, "connect %s: %s", url, err.Error())
+	}
+	defer utils.DrainAndClose(resp.Body)
+
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		return nil, utils.NewCustomError(utils.AuthFailed, "auth %s as user %q, status code: %d", url, es.Config.User, resp.StatusCode)
 	}
-	defer func() {
-		_ = resp.Body.Close()
-	}()
 
 	if resp.StatusCode != http.StatusOK {
 		return nil, errors.Errorf("failed to get %s, status code: %d", url, resp.StatusCode)
@@ -206,7 +369,378 @@ type IResponse interface {
 }
 
 func formatError(res IResponse) error {
-	statusStr := res.Status()
-	bodyStr := res.String()
-	return errors.Errorf("status: %s, body: %s", statusStr, bodyStr)
+	return errors.WithStack(newESError(res))
+}
+
+// indexReadyTimeout bounds the wait of OpenIndex for the primaries of the opened index
+const indexReadyTimeout = 30 * time.Second
+
+// formatIndexStateError tells apart an open or close forbidden by the cluster, e.g. by
+// `cluster.indices.close.enable: false` or a read only block, the *ESError is kept as the cause
+func formatIndexStateError(res IResponse, action string, index string) error {
+	esError := newESError(res)
+	if esError.Type == "cluster_block_exception" || esError.StatusCode == http.StatusForbidden ||
+		strings.Contains(esError.Reason, "cluster.indices.close.enable") {
+		return errors.Wrapf(esError, "%s index %s is blocked by the cluster: %s", action, index, esError.Reason)
+	}
+	return errors.Wrapf(esError, "%s index %s", action, index)
+}
+
+// newBulkStreamRequest builds the bulk request of BulkStream. GetBody fails, so the transport neither buffers the
+// body to retry the request nor resends a body it already consumed.
+func newBulkStreamRequest(body io.Reader, option *WriteOption) (*http.Request, error) {
+	req, err := http.NewRequest(http.MethodPost, "/_bulk", body)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	params := url.Values{}
+	if waitForActiveShards := option.waitForActiveShards(); waitForActiveShards != "" {
+		params.Set("wait_for_active_shards", waitForActiveShards)
+	}
+	if refresh := option.refresh(); refresh != "" {
+		params.Set("refresh", refresh)
+	}
+	req.URL.RawQuery = params.Encode()
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	req.GetBody = func() (io.ReadCloser, error) {
+		return nil, errors.New("a streamed bulk body can not be resent")
+	}
+	return req, nil
+}
+
+type catIndex struct {
+	Index     string `json:"index"`
+	StoreSize string `json:"store.size,omitempty"`
+	Status    string `json:"status,omitempty"`
+}
+
+// IndexStats are the docs, store and segments stats of an index. The docs and segments count the primaries only,
+// StoreBytes includes the replicas.
+type IndexStats struct {
+	DocsCount         uint64
+	DocsDeleted       uint64
+	PrimaryStoreBytes uint64
+	StoreBytes        uint64
+	SegmentsCount     uint64
+}
+
+type indexStatsSection struct {
+	Docs struct {
+		Count   uint64 `json:"count"`
+		Deleted uint64 `json:"deleted"`
+	} `json:"docs"`
+	Store struct {
+		SizeInBytes uint64 `json:"size_in_bytes"`
+	} `json:"store"`
+	Segments struct {
+		Count uint64 `json:"count"`
+	} `json:"segments"`
+}
+
+// parseIndexStats parses the `_all` section of the body of `<index>/_stats/docs,store,segments`
+func parseIndexStats(body io.Reader) (*IndexStats, error) {
+	var statsResult struct {
+		All struct {
+			Primaries indexStatsSection `json:"primaries"`
+			Total     indexStatsSection `json:"total"`
+		} `json:"_all"`
+	}
+	if err := json.NewDecoder(body).Decode(&statsResult); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	primaries, total := statsResult.All.Primaries, statsResult.All.Total
+	return &IndexStats{
+		DocsCount:         primaries.Docs.Count,
+		DocsDeleted:       primaries.Docs.Deleted,
+		PrimaryStoreBytes: primaries.Store.SizeInBytes,
+		StoreBytes:        total.Store.SizeInBytes,
+		SegmentsCount:     primaries.Segments.Count,
+	}, nil
+}
+
+// splitIndexes splits comma separated indices, the wildcards are expanded by es
+func splitIndexes(index string) []string {
+	return lo.FilterMap(strings.Split(index, ","), func(item string, _ int) (string, bool) {
+		item = strings.TrimSpace(item)
+		return item, item != ""
+	})
+}
+
+// decodeResponse decodes the body with the json codec of the build, see unmarshalJSON
+func decodeResponse(body io.Reader, v interface{}) error {
+	bodyBytes, err := io.ReadAll(body)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(unmarshalJSON(bodyBytes, v))
+}
+
+// rawScrollResult is a scroll answer of any version with the hits left undecoded, total is a number before 7.x
+type rawScrollResult struct {
+	ScrollId string `json:"_scroll_id"`
+	Hits     struct {
+		Total json.RawMessage   `json:"total"`
+		Hits  []json.RawMessage `json:"hits"`
+	} `json:"hits"`
+}
+
+// decodeScrollResponse decodes a scroll answer like the version types do, but only splits the hits in the first
+// pass and decodes them into docs on concurrency goroutines, which spreads a page of large docs over the cores
+func decodeScrollResponse(body io.Reader, concurrency int) (*ScrollResult, error) {
+	var scrollResult rawScrollResult
+	if err := decodeResponse(body, &scrollResult); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	total, err := parseHitsTotal(scrollResult.Hits.Total)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	docs, err := decodeHits(scrollResult.Hits.Hits, concurrency)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return &ScrollResult{
+		Total:    total,
+		Docs:     docs,
+		ScrollId: scrollResult.ScrollId,
+	}, nil
+}
+
+// parseHitsTotal reads the hits total of 7.x and later, `{"value": n}`, and the plain number of the earlier ones
+func parseHitsTotal(raw json.RawMessage) (uint64, error) {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 || bytes.Equal(raw, []byte("null")) {
+		return 0, nil
+	}
+
+	if raw[0] == '{' {
+		var total struct {
+			Value uint64 `json:"value"`
+		}
+		err := unmarshalJSON(raw, &total)
+		return total.Value, errors.WithStack(err)
+	}
+
+	var total uint64
+	err := unmarshalJSON(raw, &total)
+	return total, errors.WithStack(err)
+}
+
+// decodeHits decodes the hits in contiguous chunks, one per goroutine, so the docs keep the order of the page
+func decodeHits(hits []json.RawMessage, concurrency int) ([]*Doc, error) {
+	docs := make([]*Doc, len(hits))
+	if len(hits) == 0 {
+		return docs, nil
+	}
+
+	concurrency = min(max(concurrency, 1), len(hits))
+	chunkSize := (len(hits) + concurrency - 1) / concurrency
+	errs := make([]error, concurrency)
+
+	var wg sync.WaitGroup
+	for worker := 0; worker < concurrency; worker++ {
+		start, end := worker*chunkSize, min((worker+1)*chunkSize, len(hits))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := start; i < end; i++ {
+				var doc Doc
+				if err := unmarshalJSON(hits[i], &doc); err != nil {
+					errs[worker] = errors.WithStack(err)
+					return
+				}
+				docs[i] = &doc
+			}
+		}()
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return docs, nil
+}
+
+// parseCatIndexSizes parses the body of `_cat/indices?h=index,store.size&bytes=b&format=json`, a closed index
+// has no store size and is 0.
+func parseCatIndexSizes(body io.Reader) (map[string]uint64, error) {
+	var catIndices []catIndex
+	if err := json.NewDecoder(body).Decode(&catIndices); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	sizes := make(map[string]uint64, len(catIndices))
+	for _, item := range catIndices {
+		if item.Index == "" {
+			continue
+		}
+		sizes[item.Index] = cast.ToUint64(item.StoreSize)
+	}
+	return sizes, nil
+}
+
+// parseHiddenIndexes parses the body of `_settings/index.hidden?expand_wildcards=all`, the indices without the
+// setting are not hidden
+func parseHiddenIndexes(body io.Reader) (map[string]bool, error) {
+	var settings map[string]interface{}
+	if err := json.NewDecoder(body).Decode(&settings); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	hidden := make(map[string]bool)
+	for index := range settings {
+		if cast.ToBool(GetIndexSettingValue(settings, index, "hidden")) {
+			hidden[index] = true
+		}
+	}
+	return hidden, nil
+}
+
+// parseCatIndexClosed parses the body of `_cat/indices/<index>?h=index,status&format=json`, an alias or wildcard is
+// closed when one of its indices is
+func parseCatIndexClosed(body io.Reader) (bool, error) {
+	var catIndices []catIndex
+	if err := json.NewDecoder(body).Decode(&catIndices); err != nil {
+		return false, errors.WithStack(err)
+	}
+
+	return lo.SomeBy(catIndices, func(item catIndex) bool {
+		return item.Status == "close"
+	}), nil
+}
+
+// aliasActionsBody encodes the body of `_aliases`, the actions are applied in their order
+func aliasActionsBody(actions []AliasAction) (*bytes.Buffer, error) {
+	bodyActions := lo.Map(actions, func(action AliasAction, _ int) map[string]interface{} {
+		return map[string]interface{}{
+			lo.Ternary(action.Remove, "remove", "add"): map[string]interface{}{
+				"index": action.Index,
+				"alias": action.Alias,
+			},
+		}
+	})
+
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(map[string]interface{}{"actions": bodyActions}); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return &buf, nil
+}
+
+// scrollIdBody carries the scroll id of a scroll request in its body, the id of a scroll over many shards runs to
+// kilobytes and overflows the url limit of es
+func scrollIdBody(scrollId string) io.Reader {
+	body, _ := json.Marshal(map[string]interface{}{"scroll_id": scrollId})
+	return bytes.NewReader(body)
+}
+
+// clearScrollBody carries the scroll id of a clear scroll request in its body, see scrollIdBody
+func clearScrollBody(scrollId string) io.Reader {
+	body, _ := json.Marshal(map[string]interface{}{"scroll_id": []string{scrollId}})
+	return bytes.NewReader(body)
+}
+
+// parseAliasIndexes parses the body of `_alias/<alias>`, which is keyed by the indices of the alias
+func parseAliasIndexes(body io.Reader) ([]string, error) {
+	var aliases map[string]interface{}
+	if err := json.NewDecoder(body).Decode(&aliases); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	indexes := lo.Keys(aliases)
+	slices.Sort(indexes)
+	return indexes, nil
+}
+
+// parseTemplate parses the body of `_template/<name>`, which is keyed by the template names
+func parseTemplate(body io.Reader, name string) (map[string]interface{}, error) {
+	var templates map[string]interface{}
+	if err := json.NewDecoder(body).Decode(&templates); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	template, _ := templates[name].(map[string]interface{})
+	return template, nil
+}
+
+func reindexBody(source, dest string, option *ReindexOption) map[string]interface{} {
+	sourceBody := map[string]interface{}{"index": source}
+	if option != nil {
+		for k, v := range option.Query {
+			sourceBody[k] = v
+		}
+	}
+	return map[string]interface{}{
+		"source": sourceBody,
+		"dest":   map[string]interface{}{"index": dest},
+	}
+}
+
+// parseReindexTask parses the answer of a `_reindex` request not waiting for completion
+func parseReindexTask(body io.Reader) (string, error) {
+	var answer struct {
+		Task string `json:"task"`
+	}
+	if err := json.NewDecoder(body).Decode(&answer); err != nil {
+		return "", errors.WithStack(err)
+	}
+	if answer.Task == "" {
+		return "", errors.New("the reindex answered no task")
+	}
+	return answer.Task, nil
+}
+
+// waitForReindexTask polls the task with getTask until it completed, getTask returns the body of `_tasks/<id>`
+func waitForReindexTask(ctx context.Context, taskId string, option *ReindexOption,
+	getTask func(ctx context.Context, taskId string) (map[string]interface{}, error)) (*ReindexResult, error) {
+	ticker := time.NewTicker(option.pollInterval())
+	defer ticker.Stop()
+
+	for {
+		task, err := getTask(ctx, taskId)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		if cast.ToBool(task["completed"]) {
+			if taskErr, ok := task["error"]; ok {
+				return nil, errors.Errorf("reindex task %s failed: %v", taskId, taskErr)
+			}
+			response := cast.ToStringMap(task["response"])
+			result := &ReindexResult{
+				Total:    cast.ToUint64(response["total"]),
+				Created:  cast.ToUint64(response["created"]),
+				Updated:  cast.ToUint64(response["updated"]),
+				Failures: cast.ToSlice(response["failures"]),
+			}
+			if len(result.Failures) > 0 {
+				return result, errors.Errorf("reindex task %s failed %d docs, the first %v", taskId,
+					len(result.Failures), result.Failures[0])
+			}
+			return result, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, errors.WithStack(ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// parseCatIndices parses the body of `_cat/indices?h=index&format=json`.
+func parseCatIndices(body io.Reader) ([]string, error) {
+	var catIndices []catIndex
+	if err := json.NewDecoder(body).Decode(&catIndices); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return lo.FilterMap(catIndices, func(item catIndex, _ int) (string, bool) {
+		return item.Index, item.Index != ""
+	}), nil
 }
@@ -1,13 +1,55 @@
 package es
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
 	"github.com/CharellKing/ela-lib/config"
+	"github.com/CharellKing/ela-lib/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"github.com/samber/lo"
+	"github.com/spf13/cast"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
 	"regexp"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
+func newMockES(t *testing.T, version string, handler http.HandlerFunc) ES {
+	return newMockESWithConfig(t, version, &config.ESConfig{}, handler)
+}
+
+func newMockESWithConfig(t *testing.T, version string, esConfig *config.ESConfig, handler http.HandlerFunc) ES {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Elastic-Product", "Elasticsearch")
+		if r.URL.Path == "/" {
+			_, _ = fmt.Fprintf(w, `{"version":{"number":"%s"}}`, version)
+			return
+		}
+		handler(w, r)
+	}))
+	t.Cleanup(server.Close)
+
+	esConfig.Addresses = []string{server.URL}
+	es, err := NewESV0(esConfig).GetES()
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	return es
+}
+
 func TestGetVersion(t *testing.T) {
 	es0 := NewESV0(&config.ESConfig{
 		Addresses: []string{
@@ -77,3 +119,977 @@ func TestPattern(t *testing.T) {
 	}
 
 }
+
+func TestGetIndexes(t *testing.T) {
+	for _, version := range []string{"5.6.16", "6.8.23", "7.17.9", "8.12.2"} {
+		es := newMockES(t, version, func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/_cat/indices" || r.URL.Query().Get("h") != "index" || r.URL.Query().Get("format") != "json" {
+				t.Errorf("%s unexpected request %s", version, r.URL.String())
+			}
+			_, _ = w.Write([]byte(`[{"index":"logs-2024.01.01"},{"index":".kibana_1"},{"index":"remote:orders"}]`))
+		})
+
+		indexes, err := es.GetIndexes()
+		if err != nil {
+			t.Fatalf("%s %+v", version, err)
+		}
+		if len(indexes) != 3 || indexes[0] != "logs-2024.01.01" || indexes[1] != ".kibana_1" || indexes[2] != "remote:orders" {
+			t.Errorf("%s indexes: %+v", version, indexes)
+		}
+	}
+}
+
+func TestIndexClosed(t *testing.T) {
+	for _, version := range []string{"5.6.16", "6.8.23", "7.17.9", "8.12.2"} {
+		es := newMockES(t, version, func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Query().Get("h") != "index,status" {
+				t.Errorf("%s unexpected request %s", version, r.URL.String())
+			}
+			switch r.URL.Path {
+			case "/_cat/indices/open":
+				_, _ = w.Write([]byte(`[{"index":"open","status":"open"}]`))
+			case "/_cat/indices/logs-*":
+				_, _ = w.Write([]byte(`[{"index":"logs-1","status":"open"},{"index":"logs-2","status":"close"}]`))
+			default:
+				t.Errorf("%s unexpected request %s", version, r.URL.String())
+			}
+		})
+
+		for index, expected := range map[string]bool{"open": false, "logs-*": true} {
+			closed, err := es.IndexClosed(context.Background(), index)
+			if err != nil {
+				t.Fatalf("%s %+v", version, err)
+			}
+			if closed != expected {
+				t.Errorf("%s expect %s closed %v, got %v", version, index, expected, closed)
+			}
+		}
+	}
+}
+
+func TestOpenCloseIndex(t *testing.T) {
+	for _, version := range []string{"5.6.16", "6.8.23", "7.17.9", "8.12.2"} {
+		var requests []string
+		es := newMockES(t, version, func(w http.ResponseWriter, r *http.Request) {
+			requests = append(requests, r.Method+" "+r.URL.Path)
+			switch r.URL.Path {
+			case "/a/_open", "/a/_close":
+				_, _ = w.Write([]byte(`{"acknowledged":true}`))
+			case "/_cluster/health/a":
+				if r.URL.Query().Get("wait_for_status") != "yellow" || r.URL.Query().Get("timeout") == "" {
+					t.Errorf("%s unexpected health request %s", version, r.URL.String())
+				}
+				_, _ = w.Write([]byte(`{"status":"yellow","timed_out":false}`))
+			case "/b/_close":
+				w.WriteHeader(http.StatusBadRequest)
+				_, _ = w.Write([]byte(`{"error":{"type":"illegal_state_exception","reason":"closing indices is disabled - set [cluster.indices.close.enable: true] to enable it. NOTE: closed indices still consume a significant amount of diskspace"},"status":400}`))
+			case "/_cluster/health/b":
+				w.WriteHeader(http.StatusRequestTimeout)
+				_, _ = w.Write([]byte(`{"status":"red","timed_out":true}`))
+			default:
+				_, _ = w.Write([]byte(`{"acknowledged":true}`))
+			}
+		})
+
+		if err := es.OpenIndex(context.Background(), "a"); err != nil {
+			t.Fatalf("%s %+v", version, err)
+		}
+		if err := es.CloseIndex(context.Background(), "a"); err != nil {
+			t.Fatalf("%s %+v", version, err)
+		}
+		expected := []string{"POST /a/_open", "GET /_cluster/health/a", "POST /a/_close"}
+		if !reflect.DeepEqual(requests, expected) {
+			t.Errorf("%s expect the requests %v, got %v", version, expected, requests)
+		}
+
+		if err := es.CloseIndex(context.Background(), "b"); err == nil || !strings.Contains(err.Error(), "close index b is blocked by the cluster") {
+			t.Errorf("%s expect a blocked close error, got %v", version, err)
+		}
+		if err := es.OpenIndex(context.Background(), "b"); err == nil || !strings.Contains(err.Error(), "index b is not ready") {
+			t.Errorf("%s expect a not ready error, got %v", version, err)
+		}
+	}
+}
+
+func TestSwapAliasActions(t *testing.T) {
+	for _, version := range []string{"5.6.16", "6.8.23", "7.17.9", "8.12.2"} {
+		var body string
+		es := newMockES(t, version, func(w http.ResponseWriter, r *http.Request) {
+			switch r.Method + " " + r.URL.Path {
+			case "GET /_alias/writer":
+				_, _ = w.Write([]byte(`{"old-2":{"aliases":{"writer":{}}},"old-1":{"aliases":{"writer":{}}}}`))
+			case "GET /_alias/missing":
+				w.WriteHeader(http.StatusNotFound)
+				_, _ = w.Write([]byte(`{"error":"alias [missing] missing","status":404}`))
+			case "POST /_aliases":
+				data, _ := io.ReadAll(r.Body)
+				body = string(data)
+				_, _ = w.Write([]byte(`{"acknowledged":true}`))
+			default:
+				t.Errorf("%s unexpected request %s %s", version, r.Method, r.URL.String())
+			}
+		})
+
+		indexes, err := es.GetAliasIndexes(context.Background(), "writer")
+		if err != nil {
+			t.Fatalf("%s %+v", version, err)
+		}
+		if !reflect.DeepEqual(indexes, []string{"old-1", "old-2"}) {
+			t.Errorf("%s unexpected alias indexes %v", version, indexes)
+		}
+		if indexes, err := es.GetAliasIndexes(context.Background(), "missing"); err != nil || len(indexes) != 0 {
+			t.Errorf("%s expect no index for a missing alias, got %v %+v", version, indexes, err)
+		}
+
+		if err := es.UpdateAliases(context.Background(), []AliasAction{
+			{Alias: "writer", Index: "old-1", Remove: true},
+			{Alias: "writer", Index: "new"},
+		}); err != nil {
+			t.Fatalf("%s %+v", version, err)
+		}
+		expected := `{"actions":[{"remove":{"alias":"writer","index":"old-1"}},{"add":{"alias":"writer","index":"new"}}]}`
+		if strings.TrimSpace(body) != expected {
+			t.Errorf("%s expect the aliases body %s, got %s", version, expected, body)
+		}
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	for _, version := range []string{"5.6.16", "6.8.23", "7.17.9", "8.12.2"} {
+		var paths []string
+		es := newMockES(t, version, func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodPost {
+				t.Errorf("%s unexpected method %s", version, r.Method)
+			}
+			paths = append(paths, r.URL.Path)
+			_, _ = w.Write([]byte(`{"_shards":{"total":1,"successful":1,"failed":0}}`))
+		})
+
+		for _, index := range []string{"a", "a, b", "logs-*"} {
+			if err := es.Refresh(context.Background(), index); err != nil {
+				t.Fatalf("%s %+v", version, err)
+			}
+		}
+		expected := []string{"/a/_refresh", "/a,b/_refresh", "/logs-*/_refresh"}
+		if strings.Join(paths, " ") != strings.Join(expected, " ") {
+			t.Errorf("%s paths %v", version, paths)
+		}
+	}
+}
+
+func TestForceMerge(t *testing.T) {
+	taskPollInterval = 10 * time.Millisecond
+
+	for _, version := range []string{"5.6.16", "6.8.23", "7.17.9", "8.12.2"} {
+		var (
+			requests  []string
+			taskPolls int
+		)
+		es := newMockES(t, version, func(w http.ResponseWriter, r *http.Request) {
+			requests = append(requests, r.Method+" "+r.URL.Path+"?"+r.URL.RawQuery)
+			switch r.URL.Path {
+			case "/a,b/_forcemerge":
+				if r.URL.Query().Get("wait_for_completion") == "false" {
+					_, _ = w.Write([]byte(`{"task":"node:1"}`))
+					return
+				}
+				_, _ = w.Write([]byte(`{"_shards":{"total":1,"successful":1,"failed":0}}`))
+			case "/_tasks/node:1":
+				taskPolls++
+				_, _ = fmt.Fprintf(w, `{"completed":%t}`, taskPolls > 1)
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		})
+
+		if err := es.ForceMerge(context.Background(), "a,b", 1); err != nil {
+			t.Fatalf("%s %+v", version, err)
+		}
+		if len(requests) == 0 || !strings.HasPrefix(requests[0], "POST /a,b/_forcemerge?") ||
+			!strings.Contains(requests[0], "max_num_segments=1") {
+			t.Errorf("%s requests %v", version, requests)
+		}
+		expectedPolls := lo.Ternary(version == "8.12.2", 2, 0)
+		if taskPolls != expectedPolls {
+			t.Errorf("%s task polls %d, expected %d", version, taskPolls, expectedPolls)
+		}
+	}
+}
+
+func TestCloseIdleConnections(t *testing.T) {
+	var closed atomic.Int32
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Elastic-Product", "Elasticsearch")
+		_, _ = w.Write([]byte(`{"count":1}`))
+	}))
+	server.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateClosed {
+			closed.Add(1)
+		}
+	}
+	server.Start()
+	defer server.Close()
+
+	for _, version := range []string{"5.6.16", "6.8.23", "7.17.9", "8.12.2"} {
+		closed.Store(0)
+		es, err := NewESV0(&config.ESConfig{Addresses: []string{server.URL}}).newES(version)
+		if err != nil {
+			t.Fatalf("%s %+v", version, err)
+		}
+		if _, err := es.Count(context.Background(), "a"); err != nil {
+			t.Fatalf("%s %+v", version, err)
+		}
+
+		if err := es.Close(); err != nil {
+			t.Fatalf("%s %+v", version, err)
+		}
+		for i := 0; i < 50 && closed.Load() == 0; i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if closed.Load() == 0 {
+			t.Errorf("%s idle connection is not closed", version)
+		}
+	}
+}
+
+func TestESError(t *testing.T) {
+	cases := []struct {
+		status         int
+		body           string
+		expectedType   string
+		expectedReason string
+		predicate      func(err error) bool
+	}{
+		{http.StatusNotFound, `{"error":{"type":"index_not_found_exception","reason":"no such index [a]"},"status":404}`,
+			"index_not_found_exception", "no such index [a]", IsNotFound},
+		{http.StatusTooManyRequests, `{"error":{"type":"es_rejected_execution_exception","reason":"rejected"},"status":429}`,
+			"es_rejected_execution_exception", "rejected", IsTooManyRequests},
+		{http.StatusNotFound, `{"error":"IndexMissingException[[a] missing]","status":404}`,
+			"", "IndexMissingException[[a] missing]", IsNotFound},
+	}
+
+	for _, version := range []string{"5.6.16", "6.8.23", "7.17.9", "8.12.2"} {
+		for _, item := range cases {
+			es := newMockES(t, version, func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(item.status)
+				_, _ = w.Write([]byte(item.body))
+			})
+
+			err := es.Refresh(context.Background(), "a")
+			var esError *ESError
+			if !errors.As(err, &esError) {
+				t.Fatalf("%s expected an ESError, got %+v", version, err)
+			}
+			if esError.StatusCode != item.status || esError.Type != item.expectedType ||
+				esError.Reason != item.expectedReason || esError.Body != item.body {
+				t.Errorf("%s unexpected error %+v", version, esError)
+			}
+			if !item.predicate(err) {
+				t.Errorf("%s predicate does not match %d", version, item.status)
+			}
+		}
+	}
+
+	if IsNotFound(errors.New("not found")) {
+		t.Errorf("a plain error is not an ESError")
+	}
+}
+
+func TestHeaders(t *testing.T) {
+	headers := map[string]string{"X-Tenant": "tenant-a", "traceparent": "00-trace-span-01"}
+	for _, version := range []string{"5.6.16", "6.8.23", "7.17.9", "8.12.2"} {
+		requestHeaders := make(map[string]http.Header)
+		es := newMockESWithConfig(t, version, &config.ESConfig{Headers: headers}, func(w http.ResponseWriter, r *http.Request) {
+			requestHeaders[r.URL.Path] = r.Header.Clone()
+			if strings.HasSuffix(r.URL.Path, "_bulk") {
+				_, _ = w.Write([]byte(`{"took":1,"errors":false,"items":[]}`))
+				return
+			}
+			_, _ = w.Write([]byte(`{"_scroll_id":"scroll","hits":{"hits":[]}}`))
+		})
+
+		if _, err := es.NewScroll(context.Background(), "a", &ScrollOption{ScrollSize: 10, ScrollTime: 1}); err != nil {
+			t.Fatalf("%s %+v", version, err)
+		}
+		if err := es.Bulk(bytes.NewBufferString("{\"index\":{\"_index\":\"a\",\"_id\":\"1\"}}\n{}\n"), nil); err != nil {
+			t.Fatalf("%s %+v", version, err)
+		}
+
+		for _, path := range []string{"/a/_search", "/_bulk"} {
+			header, ok := requestHeaders[path]
+			if !ok {
+				t.Fatalf("%s no request on %s, got %v", version, path, lo.Keys(requestHeaders))
+			}
+			for key, value := range headers {
+				if header.Get(key) != value {
+					t.Errorf("%s %s header %s is %q", version, path, key, header.Get(key))
+				}
+			}
+		}
+	}
+
+	redacted := RedactedHeaders(&config.ESConfig{
+		Headers:          map[string]string{"X-Tenant": "tenant-a", "authorization": "Bearer x", "X-Api-Token": "secret"},
+		SensitiveHeaders: []string{"x-api-token"},
+	})
+	expected := map[string]string{"X-Tenant": "tenant-a", "authorization": redactedHeaderValue, "X-Api-Token": redactedHeaderValue}
+	for key, value := range expected {
+		if redacted[key] != value {
+			t.Errorf("redacted header %s is %q", key, redacted[key])
+		}
+	}
+}
+
+func TestBulkBodyWithoutId(t *testing.T) {
+	for _, version := range []string{"5.6.16", "6.8.23", "7.17.9", "8.12.2"} {
+		es, err := NewESV0(&config.ESConfig{}).newES(version)
+		if err != nil {
+			t.Fatalf("%s %+v", version, err)
+		}
+
+		for _, id := range []string{"", "1"} {
+			var buf bytes.Buffer
+			doc := &Doc{ID: id, Type: "doc", Op: OperationCreate, Source: map[string]interface{}{"a": 1}}
+			if err := es.BulkBody("idx", &buf, doc); err != nil {
+				t.Fatalf("%s %+v", version, err)
+			}
+
+			var meta map[string]map[string]interface{}
+			if err := json.Unmarshal(bytes.SplitN(buf.Bytes(), []byte("\n"), 2)[0], &meta); err != nil {
+				t.Fatalf("%s %+v", version, err)
+			}
+			_, hasId := meta["index"]["_id"]
+			if hasId != (id != "") {
+				t.Errorf("%s id %q, metadata %v", version, id, meta)
+			}
+		}
+	}
+}
+
+func TestBulkBodyScriptedUpdate(t *testing.T) {
+	for _, version := range []string{"5.6.16", "6.8.23", "7.17.9", "8.12.2"} {
+		es, err := NewESV0(&config.ESConfig{}).newES(version)
+		if err != nil {
+			t.Fatalf("%s %+v", version, err)
+		}
+
+		var buf bytes.Buffer
+		doc := &Doc{
+			ID:     "1",
+			Type:   "doc",
+			Op:     OperationUpdate,
+			Script: map[string]interface{}{"source": "ctx._source.n += params.n", "params": map[string]interface{}{"n": 1}},
+			Upsert: map[string]interface{}{"n": 1},
+		}
+		if err := es.BulkBody("idx", &buf, doc); err != nil {
+			t.Fatalf("%s %+v", version, err)
+		}
+
+		lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+		if len(lines) != 2 || !strings.HasPrefix(lines[0], `{"update":`) {
+			t.Fatalf("%s unexpected bulk body %s", version, buf.String())
+		}
+		expected := `{"script":{"params":{"n":1},"source":"ctx._source.n += params.n"},"upsert":{"n":1}}`
+		if lines[1] != expected {
+			t.Errorf("%s expect the update body %s, got %s", version, expected, lines[1])
+		}
+	}
+}
+
+func TestSecretPassword(t *testing.T) {
+	t.Setenv("ELA_TEST_ES_PASSWORD", "env-password")
+	passwordFile := filepath.Join(t.TempDir(), "password")
+	if err := os.WriteFile(passwordFile, []byte("file-password\n"), 0600); err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	for _, testCase := range []struct {
+		esConfig *config.ESConfig
+		expected string
+	}{
+		{&config.ESConfig{User: "elastic", Password: "literal-password"}, "literal-password"},
+		{&config.ESConfig{User: "elastic", Password: "${ENV:ELA_TEST_ES_PASSWORD}"}, "env-password"},
+		{&config.ESConfig{User: "elastic", Password: "${FILE:" + passwordFile + "}"}, "file-password"},
+		{&config.ESConfig{User: "elastic", PasswordFile: passwordFile}, "file-password"},
+	} {
+		var rootPassword string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, rootPassword, _ = r.BasicAuth()
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("X-Elastic-Product", "Elasticsearch")
+			_, _ = w.Write([]byte(`{"version":{"number":"7.17.9"}}`))
+		}))
+		testCase.esConfig.Addresses = []string{server.URL}
+		es, err := NewESV0(testCase.esConfig).GetES()
+		server.Close()
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+		if es.GetPassword() != testCase.expected || rootPassword != testCase.expected {
+			t.Errorf("expect password %q, got client %q, request %q", testCase.expected, es.GetPassword(), rootPassword)
+		}
+	}
+
+	for _, esConfig := range []*config.ESConfig{
+		{Password: "${ENV:ELA_TEST_ES_PASSWORD_UNSET}"},
+		{Password: "literal-password", PasswordFile: passwordFile},
+		{PasswordFile: filepath.Join(t.TempDir(), "missing")},
+	} {
+		if _, err := resolveSecrets(esConfig); err == nil {
+			t.Errorf("expect an error resolving %+v", esConfig)
+		}
+	}
+}
+
+func TestGetIndexSizes(t *testing.T) {
+	for _, version := range []string{"5.6.16", "6.8.23", "7.17.9", "8.12.2"} {
+		es := newMockES(t, version, func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/_cat/indices" || r.URL.Query().Get("bytes") != "b" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			_, _ = w.Write([]byte(`[{"index":"a","store.size":"1024"},{"index":"b","store.size":"10"},{"index":"closed"}]`))
+		})
+
+		sizes, err := es.GetIndexSizes()
+		if err != nil {
+			t.Fatalf("%s %+v", version, err)
+		}
+		expected := map[string]uint64{"a": 1024, "b": 10, "closed": 0}
+		if !reflect.DeepEqual(sizes, expected) {
+			t.Errorf("%s expect sizes %v, got %v", version, expected, sizes)
+		}
+	}
+}
+
+func TestGetHiddenIndexes(t *testing.T) {
+	for _, version := range []string{"5.6.16", "6.8.23", "7.17.9", "8.12.2"} {
+		es := newMockES(t, version, func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/_settings/index.hidden" || r.URL.Query().Get("expand_wildcards") != "all" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			_, _ = w.Write([]byte(`{"a":{"settings":{"index":{"hidden":"true"}}},"b":{"settings":{}},` +
+				`"c":{"settings":{"index":{"hidden":"false"}}}}`))
+		})
+
+		hidden, err := es.GetHiddenIndexes()
+		if err != nil {
+			t.Fatalf("%s %+v", version, err)
+		}
+		if expected := map[string]bool{"a": true}; !reflect.DeepEqual(hidden, expected) {
+			t.Errorf("%s expect hidden %v, got %v", version, expected, hidden)
+		}
+	}
+}
+
+func TestValidateIndexName(t *testing.T) {
+	for _, name := range []string{"logs-2024.01", "a", ".kibana", strings.Repeat("a", 255)} {
+		if err := ValidateIndexName(name); err != nil {
+			t.Errorf("expect %q valid, got %+v", name, err)
+		}
+	}
+
+	for _, name := range []string{"", ".", "..", "Logs", "logs/2024", "logs*", "a b", "a,b", "a#b", "a|b", "a:b", "-logs",
+		"_logs", "+logs", strings.Repeat("a", 256)} {
+		err := ValidateIndexName(name)
+		if !utils.IsCustomError(err, utils.InvalidParams) {
+			t.Errorf("expect %q invalid, got %v", name, err)
+		}
+	}
+}
+
+func TestUserAgent(t *testing.T) {
+	if !strings.HasPrefix(DefaultUserAgent, "ela-lib/") {
+		t.Fatalf("unexpected default user agent %s", DefaultUserAgent)
+	}
+
+	for _, testCase := range []struct {
+		esConfig *config.ESConfig
+		expected string
+	}{
+		{&config.ESConfig{}, DefaultUserAgent},
+		{&config.ESConfig{UserAgent: "reindexer/1.0"}, "reindexer/1.0"},
+		{&config.ESConfig{UserAgent: "reindexer/1.0", Headers: map[string]string{"user-agent": "custom"}}, "custom"},
+	} {
+		for _, version := range []string{"5.6.16", "6.8.23", "7.17.9", "8.12.2"} {
+			var userAgent string
+			es := newMockESWithConfig(t, version, testCase.esConfig, func(w http.ResponseWriter, r *http.Request) {
+				userAgent = r.Header.Get("User-Agent")
+				_, _ = w.Write([]byte(`{"_shards":{"total":1,"successful":1,"failed":0}}`))
+			})
+			if err := es.Refresh(context.Background(), "a"); err != nil {
+				t.Fatalf("%s %+v", version, err)
+			}
+			if userAgent != testCase.expected {
+				t.Errorf("%s expect user agent %q, got %q", version, testCase.expected, userAgent)
+			}
+
+			// the gateway proxies with BaseES.Request, the client user agent is replaced
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest(http.MethodGet, "/a/_search", nil)
+			c.Request.Header.Set("User-Agent", "curl/8.0")
+			if _, _, err := es.Request(c, strings.NewReader(""), es.MatchRule(c)); err != nil {
+				t.Fatalf("%s %+v", version, err)
+			}
+			if userAgent != testCase.expected {
+				t.Errorf("%s expect proxied user agent %q, got %q", version, testCase.expected, userAgent)
+			}
+		}
+	}
+}
+
+func TestPutMapping(t *testing.T) {
+	for version, expectPath := range map[string]string{
+		"5.6.16": "/a/_mapping/logs",
+		"6.8.23": "/a/_mapping/logs",
+		"7.17.9": "/a/_mapping",
+		"8.12.2": "/a/_mapping",
+	} {
+		var putPath, putBody string
+		es := newMockES(t, version, func(w http.ResponseWriter, r *http.Request) {
+			switch r.Method {
+			case http.MethodGet:
+				_, _ = w.Write([]byte(`{"a":{"mappings":{"logs":{"properties":{"name":{"type":"keyword"}}}}}}`))
+			case http.MethodPut:
+				putPath = r.URL.Path
+				body, _ := io.ReadAll(r.Body)
+				putBody = string(body)
+				_, _ = w.Write([]byte(`{"acknowledged":true}`))
+			}
+		})
+
+		err := es.PutMapping("a", map[string]interface{}{"age": map[string]interface{}{"type": "long"}})
+		if err != nil {
+			t.Fatalf("%s %+v", version, err)
+		}
+		if putPath != expectPath || putBody != `{"properties":{"age":{"type":"long"}}}` {
+			t.Errorf("%s unexpected put mapping %s %s", version, putPath, putBody)
+		}
+	}
+}
+
+func TestCreateIndexKeepsSortSettings(t *testing.T) {
+	mappings := map[string]string{
+		"6.8.23": `{"a":{"mappings":{"_doc":{"properties":{"ts":{"type":"date"}}}}}}`,
+		"7.17.9": `{"a":{"mappings":{"properties":{"ts":{"type":"date"}}}}}`,
+		"8.12.2": `{"a":{"mappings":{"properties":{"ts":{"type":"date"}}}}}`,
+	}
+	for version, mapping := range mappings {
+		var createBody map[string]interface{}
+		es := newMockES(t, version, func(w http.ResponseWriter, r *http.Request) {
+			switch {
+			case r.Method == http.MethodHead:
+			case r.URL.Path == "/a/_settings":
+				_, _ = w.Write([]byte(`{"a":{"settings":{"index":{"sort":{"field":"ts","order":"desc"},` +
+					`"number_of_shards":"1","uuid":"u","provided_name":"a","creation_date":"1","version":{"created":"1"}}}}}`))
+			case r.URL.Path == "/a/_mapping":
+				_, _ = w.Write([]byte(mapping))
+			case r.URL.Path == "/a/_alias":
+				_, _ = w.Write([]byte(`{"a":{"aliases":{}}}`))
+			case r.Method == http.MethodPut && r.URL.Path == "/b":
+				_ = json.NewDecoder(r.Body).Decode(&createBody)
+				_, _ = w.Write([]byte(`{"acknowledged":true}`))
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		})
+
+		sourceSetting, err := es.GetIndexMappingAndSetting("a")
+		if err != nil {
+			t.Fatalf("%s %+v", version, err)
+		}
+		targetSetting := map[string]IESSettings{
+			"6.8.23": sourceSetting.ToTargetV6Settings("b"),
+			"7.17.9": sourceSetting.ToTargetV7Settings("b"),
+			"8.12.2": sourceSetting.ToTargetV8Settings("b"),
+		}[version]
+		if err := es.CreateIndex(targetSetting, nil); err != nil {
+			t.Fatalf("%s %+v", version, err)
+		}
+
+		settings := cast.ToStringMap(createBody["settings"])
+		if indexSettings, ok := settings["index"]; ok {
+			settings = cast.ToStringMap(indexSettings)
+		}
+		expectSort := map[string]interface{}{"field": "ts", "order": "desc"}
+		if !reflect.DeepEqual(settings["sort"], expectSort) {
+			t.Errorf("%s expect the sort settings %v, got %v", version, expectSort, createBody["settings"])
+		}
+		if _, ok := settings["uuid"]; ok {
+			t.Errorf("%s expect the uuid stripped, got %v", version, createBody["settings"])
+		}
+		if !strings.Contains(fmt.Sprint(createBody["mappings"]), "ts:map[type:date]") {
+			t.Errorf("%s expect the sort field mapped, got %v", version, createBody["mappings"])
+		}
+	}
+}
+
+func TestBulkItemErrors(t *testing.T) {
+	for _, version := range []string{"5.6.16", "6.8.23", "7.17.9", "8.12.2"} {
+		es := newMockES(t, version, func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(`{"took":1,"errors":true,"items":[` +
+				`{"index":{"_index":"a","_id":"1","status":201}},` +
+				`{"index":{"_index":"a","_id":"2","status":400,"error":{"type":"mapper_parsing_exception","reason":"failed to parse"}}}]}`))
+		})
+
+		err := es.Bulk(bytes.NewBufferString("{\"index\":{\"_index\":\"a\",\"_id\":\"1\"}}\n{}\n{\"index\":{\"_index\":\"a\",\"_id\":\"2\"}}\n{}\n"), nil)
+		var bulkError *BulkError
+		if !errors.As(err, &bulkError) {
+			t.Fatalf("%s expect a bulk error, got %v", version, err)
+		}
+		expected := []*BulkItemError{{Position: 1, Index: "a", ID: "2", Status: 400, Type: "mapper_parsing_exception", Reason: "failed to parse"}}
+		if !reflect.DeepEqual(bulkError.Items, expected) {
+			t.Errorf("%s unexpected bulk items %v", version, bulkError.Items)
+		}
+	}
+}
+
+func TestBulkStream(t *testing.T) {
+	body := "{\"index\":{\"_index\":\"a\",\"_id\":\"1\"}}\n{}\n{\"index\":{\"_index\":\"a\",\"_id\":\"2\"}}\n{}\n"
+	for _, version := range []string{"5.6.16", "6.8.23", "7.17.9", "8.12.2"} {
+		var requests int
+		es := newMockES(t, version, func(w http.ResponseWriter, r *http.Request) {
+			requests++
+			received, _ := io.ReadAll(r.Body)
+			if r.URL.Path != "/_bulk" || r.ContentLength != -1 || string(received) != body {
+				t.Errorf("%s expect the body streamed to /_bulk, got %s %d %q", version, r.URL.Path, r.ContentLength, received)
+			}
+			if requests > 1 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			_, _ = w.Write([]byte(`{"took":1,"errors":true,"items":[` +
+				`{"index":{"_index":"a","_id":"1","status":201}},` +
+				`{"index":{"_index":"a","_id":"2","status":409,"error":{"type":"version_conflict_engine_exception","reason":"exists"}}}]}`))
+		})
+
+		err := es.BulkStream(io.MultiReader(strings.NewReader(body)), nil)
+		var bulkError *BulkError
+		if !errors.As(err, &bulkError) || len(bulkError.Items) != 1 || bulkError.Items[0].ID != "2" {
+			t.Fatalf("%s expect the rejected doc 2, got %v", version, err)
+		}
+
+		// a streamed body is consumed, so a failed request is not retried
+		if err := es.BulkStream(io.MultiReader(strings.NewReader(body)), nil); err == nil || requests != 2 {
+			t.Errorf("%s expect a single failed request, got %d with %v", version, requests, err)
+		}
+	}
+}
+
+func TestGetIndexStats(t *testing.T) {
+	statsBody := `{
+  "_shards": {"total": 4, "successful": 4, "failed": 0},
+  "_all": {
+    "primaries": {
+      "docs": {"count": 1200, "deleted": 30},
+      "store": {"size_in_bytes": 524288, "reserved_in_bytes": 0},
+      "segments": {"count": 6, "memory_in_bytes": 0, "terms_memory_in_bytes": 0}
+    },
+    "total": {
+      "docs": {"count": 2400, "deleted": 60},
+      "store": {"size_in_bytes": 1048576, "reserved_in_bytes": 0},
+      "segments": {"count": 12, "memory_in_bytes": 0, "terms_memory_in_bytes": 0}
+    }
+  },
+  "indices": {
+    "a": {
+      "uuid": "kgv1AzMuRZ2bSBNTLyuIBw",
+      "primaries": {"docs": {"count": 1200, "deleted": 30}, "store": {"size_in_bytes": 524288}, "segments": {"count": 6}},
+      "total": {"docs": {"count": 2400, "deleted": 60}, "store": {"size_in_bytes": 1048576}, "segments": {"count": 12}}
+    }
+  }
+}`
+	for _, version := range []string{"5.6.16", "6.8.23", "7.17.9", "8.12.2"} {
+		es := newMockES(t, version, func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/a/_stats/docs,store,segments" {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			_, _ = w.Write([]byte(statsBody))
+		})
+
+		stats, err := es.GetIndexStats(context.Background(), "a")
+		if err != nil {
+			t.Fatalf("%s %+v", version, err)
+		}
+		expected := &IndexStats{DocsCount: 1200, DocsDeleted: 30, PrimaryStoreBytes: 524288, StoreBytes: 1048576, SegmentsCount: 6}
+		if !reflect.DeepEqual(stats, expected) {
+			t.Errorf("%s expect stats %+v, got %+v", version, expected, stats)
+		}
+	}
+}
+
+func TestScrollDecodeHits(t *testing.T) {
+	for _, version := range []string{"5.6.16", "6.8.23", "7.17.9", "8.12.2"} {
+		total := lo.Ternary(strings.HasPrefix(version, "7.") || strings.HasPrefix(version, "8."), `{"value":1}`, `1`)
+		es := newMockES(t, version, func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(`{"_scroll_id":"scroll","hits":{"total":` + total + `,"hits":[` +
+				`{"_index":"a","_type":"doc","_id":"1","_routing":"user1","_score":1,"_source":{"name":"a","n":2}}]}}`))
+		})
+
+		scrollResult, err := es.NewScroll(context.Background(), "a", &ScrollOption{ScrollSize: 10, ScrollTime: 1})
+		if err != nil {
+			t.Fatalf("%s %+v", version, err)
+		}
+		expected := []*Doc{{
+			Type:    "doc",
+			ID:      "1",
+			Routing: "user1",
+			Source:  map[string]interface{}{"name": "a", "n": float64(2)},
+		}}
+		if scrollResult.Total != 1 || !reflect.DeepEqual(scrollResult.Docs, expected) {
+			t.Errorf("%s unexpected scroll result %d %+v", version, scrollResult.Total, scrollResult.Docs[0])
+		}
+
+		var buf bytes.Buffer
+		if err := es.BulkBody("b", &buf, &Doc{ID: "1", Routing: "user1", Op: OperationCreate}); err != nil {
+			t.Fatalf("%s %+v", version, err)
+		}
+		if !strings.Contains(buf.String(), `routing":"user1"`) {
+			t.Errorf("%s expect the routing in the bulk body, got %s", version, buf.String())
+		}
+	}
+}
+
+func TestScrollSortFields(t *testing.T) {
+	for _, version := range []string{"5.6.16", "6.8.23", "7.17.9", "8.12.2"} {
+		var sort string
+		es := newMockES(t, version, func(w http.ResponseWriter, r *http.Request) {
+			sort = r.URL.Query().Get("sort")
+			_, _ = w.Write([]byte(`{"_scroll_id":"scroll","hits":{"hits":[]}}`))
+		})
+
+		option := &ScrollOption{ScrollSize: 10, ScrollTime: 1, SortFields: []string{"timestamp:asc", "_id"}}
+		if _, err := es.NewScroll(context.Background(), "a", option); err != nil {
+			t.Fatalf("%s %+v", version, err)
+		}
+		if sort != "timestamp:asc,_id" {
+			t.Errorf("%s expect the sort sent with the scroll, got %q", version, sort)
+		}
+	}
+}
+
+func TestScrollTrackTotalHits(t *testing.T) {
+	for _, version := range []string{"5.6.16", "6.8.23", "7.17.9", "8.12.2"} {
+		for _, trackTotalHits := range []bool{true, false} {
+			var body map[string]interface{}
+			es := newMockES(t, version, func(w http.ResponseWriter, r *http.Request) {
+				body = nil
+				_ = json.NewDecoder(r.Body).Decode(&body)
+				_, _ = w.Write([]byte(`{"_scroll_id":"scroll","hits":{"hits":[]}}`))
+			})
+
+			option := &ScrollOption{ScrollSize: 10, ScrollTime: 1, TrackTotalHits: trackTotalHits}
+			if _, err := es.NewScroll(context.Background(), "a", option); err != nil {
+				t.Fatalf("%s %+v", version, err)
+			}
+			// es 5 and 6 always count the exact total and reject the flag
+			expected := trackTotalHits && (strings.HasPrefix(version, "7.") || strings.HasPrefix(version, "8."))
+			if _, ok := body["track_total_hits"]; ok != expected {
+				t.Errorf("%s track total hits %t: expect the flag sent %t, got body %+v", version, trackTotalHits,
+					expected, body)
+			}
+		}
+	}
+}
+
+func TestWaitForActiveShards(t *testing.T) {
+	for _, version := range []string{"5.6.16", "6.8.23", "7.17.9", "8.12.2"} {
+		params := make(map[string]string)
+		es := newMockES(t, version, func(w http.ResponseWriter, r *http.Request) {
+			_, _ = io.Copy(io.Discard, r.Body)
+			params[r.Method+" "+r.URL.Path] = r.URL.Query().Get("wait_for_active_shards")
+			if r.URL.Path == "/_bulk" {
+				_, _ = w.Write([]byte(`{"took":1,"errors":false,"items":[]}`))
+				return
+			}
+			_, _ = w.Write([]byte(`{"acknowledged":true}`))
+		})
+
+		option := &WriteOption{WaitForActiveShards: "all"}
+		body := "{\"index\":{\"_index\":\"a\",\"_id\":\"1\"}}\n{}\n"
+		if err := es.Bulk(bytes.NewBufferString(body), option); err != nil {
+			t.Fatalf("%s %+v", version, err)
+		}
+		if params["POST /_bulk"] != "all" {
+			t.Errorf("%s expect wait_for_active_shards on the bulk, got %v", version, params)
+		}
+
+		delete(params, "POST /_bulk")
+		if err := es.BulkStream(strings.NewReader(body), &WriteOption{WaitForActiveShards: "2"}); err != nil {
+			t.Fatalf("%s %+v", version, err)
+		}
+		if params["POST /_bulk"] != "2" {
+			t.Errorf("%s expect wait_for_active_shards on the streamed bulk, got %v", version, params)
+		}
+
+		if err := es.CreateIndex(NewV7Settings(nil, nil, nil, "b"), option); err != nil {
+			t.Fatalf("%s %+v", version, err)
+		}
+		if params["PUT /b"] != "all" {
+			t.Errorf("%s expect wait_for_active_shards on the create index, got %v", version, params)
+		}
+
+		// nil leaves it to the cluster
+		if err := es.Bulk(bytes.NewBufferString(body), nil); err != nil || params["POST /_bulk"] != "" {
+			t.Errorf("%s expect no wait_for_active_shards, got %v %v", version, params, err)
+		}
+	}
+}
+
+func TestBulkRefresh(t *testing.T) {
+	for _, version := range []string{"5.6.16", "6.8.23", "7.17.9", "8.12.2"} {
+		var refreshes []string
+		es := newMockES(t, version, func(w http.ResponseWriter, r *http.Request) {
+			_, _ = io.Copy(io.Discard, r.Body)
+			refreshes = append(refreshes, r.URL.Query().Get("refresh"))
+			_, _ = w.Write([]byte(`{"took":1,"errors":false,"items":[]}`))
+		})
+
+		body := "{\"index\":{\"_index\":\"a\",\"_id\":\"1\"}}\n{}\n"
+		if err := es.Bulk(bytes.NewBufferString(body), nil); err != nil {
+			t.Fatalf("%s %+v", version, err)
+		}
+		if err := es.Bulk(bytes.NewBufferString(body), &WriteOption{Refresh: "wait_for"}); err != nil {
+			t.Fatalf("%s %+v", version, err)
+		}
+		if err := es.BulkStream(strings.NewReader(body), &WriteOption{Refresh: "wait_for"}); err != nil {
+			t.Fatalf("%s %+v", version, err)
+		}
+		if expected := []string{"", "wait_for", "wait_for"}; !reflect.DeepEqual(refreshes, expected) {
+			t.Errorf("%s expect the refresh params %q, got %q", version, expected, refreshes)
+		}
+	}
+}
+
+func TestSearchByQuery(t *testing.T) {
+	for _, version := range []string{"5.6.16", "6.8.23", "7.17.9", "8.12.2"} {
+		var path, body string
+		es := newMockES(t, version, func(w http.ResponseWriter, r *http.Request) {
+			bodyBytes, _ := io.ReadAll(r.Body)
+			path, body = r.URL.Path, strings.TrimSpace(string(bodyBytes))
+			_, _ = w.Write([]byte(`{"took":1,"hits":{"total":2,"hits":[]},"aggregations":{"tags":{"buckets":[{"key":"go","doc_count":2}]}}}`))
+		})
+
+		result, err := es.SearchByQuery(context.Background(), "a", map[string]interface{}{"size": 0})
+		if err != nil {
+			t.Fatalf("%s %+v", version, err)
+		}
+		if path != "/a/_search" || body != `{"size":0}` {
+			t.Errorf("%s unexpected search %s %s", version, path, body)
+		}
+		if buckets := cast.ToSlice(cast.ToStringMap(cast.ToStringMap(result["aggregations"])["tags"])["buckets"]); len(buckets) != 1 {
+			t.Errorf("%s unexpected answer %v", version, result)
+		}
+	}
+}
+
+func TestConnectionReusedAfterClientError(t *testing.T) {
+	for _, version := range []string{"5.6.16", "6.8.23", "7.17.9", "8.12.2"} {
+		// every new connection comes from another client port, the answer is larger than what the transport reads
+		// itself on close, so an unread rest drops the connection
+		remoteAddrs := make(map[string]bool)
+		es := newMockES(t, version, func(w http.ResponseWriter, r *http.Request) {
+			remoteAddrs[r.RemoteAddr] = true
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = fmt.Fprintf(w, `{"error":"alias [missing] missing","status":404,"padding":"%s"}`,
+				strings.Repeat("x", 512*1024))
+		})
+
+		for i := 0; i < 3; i++ {
+			indexes, err := es.GetAliasIndexes(context.Background(), "missing")
+			if err != nil || len(indexes) != 0 {
+				t.Fatalf("%s expect no index of a missing alias, got %v %+v", version, indexes, err)
+			}
+		}
+		if len(remoteAddrs) != 1 {
+			t.Errorf("%s expect the requests to reuse one connection, got %d", version, len(remoteAddrs))
+		}
+	}
+}
+
+func TestReindex(t *testing.T) {
+	for _, version := range []string{"5.6.16", "6.8.23", "7.17.9", "8.12.2"} {
+		var (
+			reindexBody map[string]interface{}
+			params      url.Values
+			polls       int
+		)
+		es := newMockES(t, version, func(w http.ResponseWriter, r *http.Request) {
+			switch {
+			case r.Method == http.MethodPost && r.URL.Path == "/_reindex":
+				params = r.URL.Query()
+				_ = json.NewDecoder(r.Body).Decode(&reindexBody)
+				_, _ = w.Write([]byte(`{"task":"node-1:42"}`))
+			case r.Method == http.MethodGet && r.URL.Path == "/_tasks/node-1:42":
+				polls++
+				if polls < 3 {
+					_, _ = w.Write([]byte(`{"completed":false,"task":{"status":{"total":5,"created":2}}}`))
+					return
+				}
+				_, _ = w.Write([]byte(`{"completed":true,"response":{"total":5,"created":4,"updated":1,"failures":[]}}`))
+			default:
+				w.WriteHeader(http.StatusBadRequest)
+			}
+		})
+
+		result, err := es.Reindex(context.Background(), "a", "a-v2", &ReindexOption{
+			Query:        map[string]interface{}{"query": map[string]interface{}{"term": map[string]interface{}{"k": "v"}}},
+			Slices:       4,
+			PollInterval: time.Millisecond,
+		})
+		if err != nil {
+			t.Fatalf("%s %+v", version, err)
+		}
+
+		expectedBody := map[string]interface{}{
+			"source": map[string]interface{}{
+				"index": "a",
+				"query": map[string]interface{}{"term": map[string]interface{}{"k": "v"}},
+			},
+			"dest": map[string]interface{}{"index": "a-v2"},
+		}
+		if !reflect.DeepEqual(reindexBody, expectedBody) {
+			t.Errorf("%s expect the reindex body %v, got %v", version, expectedBody, reindexBody)
+		}
+		if params.Get("wait_for_completion") != "false" || params.Get("slices") != "4" {
+			t.Errorf("%s expect a sliced reindex task, got the params %v", version, params)
+		}
+		if polls != 3 || result.Total != 5 || result.Created != 4 || result.Updated != 1 || len(result.Failures) != 0 {
+			t.Errorf("%s expect the result after 3 polls, got %+v after %d", version, result, polls)
+		}
+	}
+}
+
+func TestLongScrollId(t *testing.T) {
+	scrollId := strings.Repeat("DXF1ZXJ5QW5kRmV0Y2gBAAAAAAAAAD4WYm9laVYtZndUQlNsdDcwakFMNjU1QQ", 200)
+	for _, version := range []string{"5.6.16", "6.8.23", "7.17.9", "8.12.2"} {
+		var requests []string
+		es := newMockES(t, version, func(w http.ResponseWriter, r *http.Request) {
+			var body struct {
+				ScrollId interface{} `json:"scroll_id"`
+			}
+			_ = json.NewDecoder(r.Body).Decode(&body)
+			if r.URL.Path != "/_search/scroll" || r.URL.Query().Has("scroll_id") ||
+				!reflect.DeepEqual(body.ScrollId, lo.Ternary[interface{}](r.Method == http.MethodDelete, []interface{}{scrollId}, scrollId)) {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			requests = append(requests, r.Method)
+			total := lo.Ternary(version < "7.", `0`, `{"value":0,"relation":"eq"}`)
+			_, _ = fmt.Fprintf(w, `{"_scroll_id":"next","hits":{"total":%s,"hits":[]},"succeeded":true}`, total)
+		})
+
+		if _, err := es.NextScroll(context.Background(), scrollId, 1); err != nil {
+			t.Fatalf("%s %+v", version, err)
+		}
+		if err := es.ClearScroll(scrollId); err != nil {
+			t.Fatalf("%s %+v", version, err)
+		}
+		if len(requests) != 2 {
+			t.Errorf("%s expect the scroll id in the bodies, got the requests %v", version, requests)
+		}
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"github.com/mitchellh/mapstructure"
 	"github.com/pkg/errors"
+	"github.com/spf13/cast"
 	"strings"
 )
 
@@ -64,3 +65,22 @@ func GetESSettings(esVersion string, settings map[string]interface{}) (IESSettin
 	}
 	return nil, fmt.Errorf("unsupported version: %s", esVersion)
 }
+
+// GetIndexSettingValue reads key from the `index` section of a get-settings response, keys are
+// looked up in both the nested and the flattened form, e.g. `number_of_shards` or `routing.allocation`.
+func GetIndexSettingValue(settings map[string]interface{}, index string, key string) interface{} {
+	indexSettings := cast.ToStringMap(cast.ToStringMap(cast.ToStringMap(settings[index])["settings"])["index"])
+	if value, ok := indexSettings[key]; ok {
+		return value
+	}
+
+	var value interface{} = indexSettings
+	for _, segment := range strings.Split(key, ".") {
+		valueMap, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = valueMap[segment]
+	}
+	return value
+}
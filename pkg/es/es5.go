@@ -1,7 +1,6 @@
 package es
 
 import (
-	"bufio"
 	"bytes"
 	"context"
 	"crypto/tls"
@@ -10,11 +9,8 @@ import (
 	"github.com/CharellKing/ela-lib/config"
 	"github.com/CharellKing/ela-lib/utils"
 	"github.com/gin-gonic/gin"
-	"github.com/mitchellh/mapstructure"
-	lop "github.com/samber/lo/parallel"
 	"github.com/spf13/cast"
 	"io"
-	"log"
 	"net/http"
 	"strings"
 	"time"
@@ -41,15 +37,20 @@ func NewESV5(esConfig *config.ESConfig, clusterVersion string) (*V5, error) {
 		Addresses: esConfig.Addresses,
 		Username:  esConfig.User,
 		Password:  esConfig.Password,
-		Transport: transport,
+		Transport: newRoundTripper(transport, requestHeaders(esConfig)),
 	})
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
 
+	baseES := NewBaseES(clusterVersion, esConfig.Addresses, esConfig.User, esConfig.Password)
+	baseES.Transport = transport
+	baseES.Headers = requestHeaders(esConfig)
+	baseES.ClusterName = esConfig.Name
+	baseES.ScrollDecodeConcurrency = esConfig.ScrollDecodeConcurrency
 	return &V5{
 		Client: client,
-		BaseES: NewBaseES(clusterVersion, esConfig.Addresses, esConfig.User, esConfig.Password),
+		BaseES: baseES,
 	}, nil
 }
 
@@ -62,9 +63,9 @@ type ScrollResultV5 struct {
 	ScrollId string `json:"_scroll_id,omitempty"`
 	TimedOut bool   `json:"timed_out,omitempty"`
 	Hits     struct {
-		MaxScore float32       `json:"max_score,omitempty"`
-		Total    int           `json:"total,omitempty"`
-		Docs     []interface{} `json:"hits,omitempty"`
+		MaxScore float32 `json:"max_score,omitempty"`
+		Total    int     `json:"total,omitempty"`
+		Docs     []*Doc  `json:"hits,omitempty"`
 	} `json:"hits"`
 	Shards struct {
 		Total      int `json:"total,omitempty"`
@@ -105,6 +106,7 @@ func (es *V5) fixDatetimeFormatDate(ctx context.Context, doc *Doc) *Doc {
 
 func (es *V5) NewScroll(ctx context.Context, index string, option *ScrollOption) (*ScrollResult, error) {
 	scrollSearchOptions := []func(*esapi.SearchRequest){
+		es.Search.WithContext(ctx),
 		es.Search.WithIndex(index),
 		es.Search.WithSize(cast.ToInt(option.ScrollSize)),
 		es.Search.WithScroll(cast.ToDuration(option.ScrollTime) * time.Minute),
@@ -138,78 +140,68 @@ func (es *V5) NewScroll(ctx context.Context, index string, option *ScrollOption)
 		return nil, errors.WithStack(err)
 	}
 
+	defer utils.DrainAndClose(res.Body)
+
 	if res.IsError() {
 		return nil, formatError(res)
 	}
 
-	defer func() {
-		_ = res.Body.Close()
-	}()
+	if es.ScrollDecodeConcurrency > 1 {
+		return decodeScrollResponse(res.Body, es.ScrollDecodeConcurrency)
+	}
 
 	var scrollResult ScrollResultV5
-	if err := json.NewDecoder(res.Body).Decode(&scrollResult); err != nil {
+	if err := decodeResponse(res.Body, &scrollResult); err != nil {
 		return nil, errors.WithStack(err)
 	}
 
-	hitDocs := lop.Map(scrollResult.Hits.Docs, func(hit interface{}, _ int) *Doc {
-		var hitDoc Doc
-		_ = mapstructure.Decode(hit, &hitDoc)
-		return &hitDoc
-	})
-
 	return &ScrollResult{
 		Total:    uint64(scrollResult.Hits.Total),
-		Docs:     hitDocs,
+		Docs:     scrollResult.Hits.Docs,
 		ScrollId: scrollResult.ScrollId,
 	}, nil
 }
 
 func (es *V5) NextScroll(ctx context.Context, scrollId string, scrollTime uint) (*ScrollResult, error) {
-	res, err := es.Client.Scroll(es.Client.Scroll.WithScrollID(scrollId), es.Client.Scroll.WithScroll(time.Duration(scrollTime)*time.Minute))
+	res, err := es.Client.Scroll(es.Client.Scroll.WithContext(ctx), es.Client.Scroll.WithBody(scrollIdBody(scrollId)), es.Client.Scroll.WithScroll(time.Duration(scrollTime)*time.Minute))
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
 
+	defer utils.DrainAndClose(res.Body)
+
 	if res.IsError() {
 		return nil, formatError(res)
 	}
 
-	defer func() {
-		_ = res.Body.Close()
-	}()
+	if es.ScrollDecodeConcurrency > 1 {
+		return decodeScrollResponse(res.Body, es.ScrollDecodeConcurrency)
+	}
 
 	var scrollResult ScrollResultV5
-	if err := json.NewDecoder(res.Body).Decode(&scrollResult); err != nil {
+	if err := decodeResponse(res.Body, &scrollResult); err != nil {
 		return nil, errors.WithStack(err)
 	}
 
-	hitDocs := lop.Map(scrollResult.Hits.Docs, func(hit interface{}, _ int) *Doc {
-		var hitDoc Doc
-		_ = mapstructure.Decode(hit, &hitDoc)
-		return &hitDoc
-	})
-
 	return &ScrollResult{
 		Total:    uint64(scrollResult.Hits.Total),
-		Docs:     hitDocs,
+		Docs:     scrollResult.Hits.Docs,
 		ScrollId: scrollResult.ScrollId,
 	}, nil
 }
 
 func (es *V5) ClearScroll(scrollId string) error {
-	res, err := es.Client.ClearScroll(es.Client.ClearScroll.WithScrollID(scrollId))
+	res, err := es.Client.ClearScroll(es.Client.ClearScroll.WithBody(clearScrollBody(scrollId)))
 	if err != nil {
 		return errors.WithStack(err)
 	}
 
+	defer utils.DrainAndClose(res.Body)
+
 	if res.IsError() {
 		return formatError(res)
 	}
 
-	defer func() {
-		_ = res.Body.Close()
-	}()
-
 	return nil
 }
 
@@ -248,14 +240,12 @@ func (es *V5) GetIndexAliases(index string) (map[string]interface{}, error) {
 		return nil, errors.WithStack(err)
 	}
 
+	defer utils.DrainAndClose(res.Body)
+
 	if res.IsError() {
 		return nil, formatError(res)
 	}
 
-	defer func() {
-		_ = res.Body.Close()
-	}()
-
 	bodyBytes, err := io.ReadAll(res.Body)
 	if err != nil {
 		return nil, errors.WithStack(err)
@@ -275,14 +265,12 @@ func (es *V5) GetIndexMapping(index string) (map[string]interface{}, error) {
 		return nil, errors.WithStack(err)
 	}
 
+	defer utils.DrainAndClose(res.Body)
+
 	if res.IsError() {
 		return nil, formatError(res)
 	}
 
-	defer func() {
-		_ = res.Body.Close()
-	}()
-
 	bodyBytes, err := io.ReadAll(res.Body)
 	if err != nil {
 		return nil, errors.WithStack(err)
@@ -302,14 +290,12 @@ func (es *V5) GetIndexSettings(index string) (map[string]interface{}, error) {
 		return nil, errors.WithStack(err)
 	}
 
+	defer utils.DrainAndClose(res.Body)
+
 	if res.IsError() {
 		return nil, formatError(res)
 	}
 
-	defer func() {
-		_ = res.Body.Close()
-	}()
-
 	var indexSetting map[string]interface{}
 	if err := json.NewDecoder(res.Body).Decode(&indexSetting); err != nil {
 		return nil, errors.WithStack(err)
@@ -318,7 +304,7 @@ func (es *V5) GetIndexSettings(index string) (map[string]interface{}, error) {
 	return indexSetting, nil
 }
 
-func (es *V5) CreateIndex(esSetting IESSettings) error {
+func (es *V5) CreateIndex(esSetting IESSettings, option *WriteOption) error {
 	indexBodyMap := lo.Assign(
 		esSetting.GetSettings(),
 		esSetting.GetMappings(),
@@ -328,8 +314,9 @@ func (es *V5) CreateIndex(esSetting IESSettings) error {
 	indexSettingsBytes, _ := json.Marshal(indexBodyMap)
 
 	req := esapi.IndicesCreateRequest{
-		Index: esSetting.GetIndex(),
-		Body:  bytes.NewBuffer(indexSettingsBytes),
+		Index:               esSetting.GetIndex(),
+		Body:                bytes.NewBuffer(indexSettingsBytes),
+		WaitForActiveShards: option.waitForActiveShards(),
 	}
 
 	res, err := req.Do(context.Background(), es)
@@ -337,14 +324,12 @@ func (es *V5) CreateIndex(esSetting IESSettings) error {
 		return errors.WithStack(err)
 	}
 
+	defer utils.DrainAndClose(res.Body)
+
 	if res.IsError() {
 		return formatError(res)
 	}
 
-	defer func() {
-		_ = res.Body.Close()
-	}()
-
 	return nil
 }
 
@@ -354,6 +339,8 @@ func (es *V5) IndexExisted(indexName string) (bool, error) {
 		return false, errors.WithStack(err)
 	}
 
+	defer utils.DrainAndClose(res.Body)
+
 	if res.StatusCode == 404 {
 		return false, nil
 	}
@@ -362,10 +349,6 @@ func (es *V5) IndexExisted(indexName string) (bool, error) {
 		return false, formatError(res)
 	}
 
-	defer func() {
-		_ = res.Body.Close()
-	}()
-
 	return res.StatusCode == 200, nil
 }
 
@@ -375,16 +358,57 @@ func (es *V5) DeleteIndex(index string) error {
 		return errors.WithStack(err)
 	}
 
+	defer utils.DrainAndClose(res.Body)
+
 	if res.IsError() {
 		return formatError(res)
 	}
 
-	defer func() {
-		_ = res.Body.Close()
-	}()
 	return nil
 }
 
+func (es *V5) PutMapping(index string, properties map[string]interface{}) error {
+	docType, err := es.getMappingType(index)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	bodyBytes, _ := json.Marshal(map[string]interface{}{"properties": properties})
+
+	req := esapi.IndicesPutMappingRequest{
+		Index:        []string{index},
+		DocumentType: docType,
+		Body:         bytes.NewBuffer(bodyBytes),
+	}
+
+	res, err := req.Do(context.Background(), es)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	defer utils.DrainAndClose(res.Body)
+
+	if res.IsError() {
+		return formatError(res)
+	}
+
+	return nil
+}
+
+// getMappingType returns the mapping type of the index, new mappings go to v5MappingType when it has none
+func (es *V5) getMappingType(index string) (string, error) {
+	indexMapping, err := es.GetIndexMapping(index)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	mappings := cast.ToStringMap(cast.ToStringMap(indexMapping[index])["mappings"])
+	for docType := range mappings {
+		return docType, nil
+	}
+	return v5MappingType, nil
+}
+
 func (es *V5) BulkBody(index string, buf *bytes.Buffer, doc *Doc) error {
 	action := ""
 	var body map[string]interface{}
@@ -393,8 +417,15 @@ func (es *V5) BulkBody(index string, buf *bytes.Buffer, doc *Doc) error {
 	case OperationCreate:
 		action = "index"
 		body = doc.Source
+	case OperationCreateOnly:
+		action = "create"
+		body = doc.Source
 	case OperationUpdate:
 		action = "update"
+		if doc.Script != nil {
+			body = scriptedUpdateBody(doc)
+			break
+		}
 		body = map[string]interface{}{
 			doc.Type: doc.Source,
 		}
@@ -404,12 +435,19 @@ func (es *V5) BulkBody(index string, buf *bytes.Buffer, doc *Doc) error {
 		return fmt.Errorf("unknow action %+v", doc.Op)
 	}
 
+	metadata := map[string]interface{}{
+		"_index": index,
+		"_type":  doc.Type,
+	}
+	// without _id es generates one
+	if doc.ID != "" {
+		metadata["_id"] = doc.ID
+	}
+	if doc.Routing != "" {
+		metadata["_routing"] = doc.Routing
+	}
 	meta := map[string]interface{}{
-		action: map[string]interface{}{
-			"_index": index,
-			"_id":    doc.ID,
-			"_type":  doc.Type,
-		},
+		action: metadata,
 	}
 
 	metaBytes, _ := json.Marshal(meta)
@@ -425,18 +463,75 @@ func (es *V5) BulkBody(index string, buf *bytes.Buffer, doc *Doc) error {
 }
 
 func (es *V5) Count(ctx context.Context, index string) (uint64, error) {
-	res, err := es.Client.Count(es.Client.Count.WithIndex(index))
+	res, err := es.Client.Count(es.Client.Count.WithContext(ctx), es.Client.Count.WithIndex(index))
 	if err != nil {
 		return 0, errors.WithStack(err)
 	}
 
+	defer utils.DrainAndClose(res.Body)
+
 	if res.IsError() {
 		return 0, formatError(res)
 	}
 
-	defer func() {
-		_ = res.Body.Close()
-	}()
+	var countResult map[string]interface{}
+	if err := json.NewDecoder(res.Body).Decode(&countResult); err != nil {
+		return 0, errors.WithStack(err)
+	}
+
+	return cast.ToUint64(countResult["count"]), nil
+}
+
+func (es *V5) SearchByQuery(ctx context.Context, index string, query map[string]interface{}) (map[string]interface{}, error) {
+	var buf bytes.Buffer
+	_ = json.NewEncoder(&buf).Encode(query)
+
+	res, err := es.Client.Search(
+		es.Client.Search.WithContext(ctx),
+		es.Client.Search.WithIndex(index),
+		es.Client.Search.WithBody(&buf),
+	)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	defer utils.DrainAndClose(res.Body)
+
+	if res.IsError() {
+		return nil, formatError(res)
+	}
+
+	var searchResult map[string]interface{}
+	if err := decodeResponse(res.Body, &searchResult); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return searchResult, nil
+}
+
+func (es *V5) CountByQuery(ctx context.Context, index string, query map[string]interface{}) (uint64, error) {
+	countOptions := []func(*esapi.CountRequest){
+		es.Client.Count.WithContext(ctx),
+		es.Client.Count.WithIndex(index),
+	}
+
+	if queryBody, ok := query["query"]; ok {
+		var buf bytes.Buffer
+		_ = json.NewEncoder(&buf).Encode(map[string]interface{}{
+			"query": queryBody,
+		})
+		countOptions = append(countOptions, es.Client.Count.WithBody(&buf))
+	}
+
+	res, err := es.Client.Count(countOptions...)
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+
+	defer utils.DrainAndClose(res.Body)
+
+	if res.IsError() {
+		return 0, formatError(res)
+	}
 
 	var countResult map[string]interface{}
 	if err := json.NewDecoder(res.Body).Decode(&countResult); err != nil {
@@ -446,52 +541,268 @@ func (es *V5) Count(ctx context.Context, index string) (uint64, error) {
 	return cast.ToUint64(countResult["count"]), nil
 }
 
-func (es *V5) Bulk(buf *bytes.Buffer) error {
+func (es *V5) Refresh(ctx context.Context, index string) error {
+	res, err := es.Client.Indices.Refresh(
+		es.Client.Indices.Refresh.WithContext(ctx),
+		es.Client.Indices.Refresh.WithIndex(splitIndexes(index)...),
+	)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	defer utils.DrainAndClose(res.Body)
+
+	if res.IsError() {
+		return formatError(res)
+	}
+
+	return nil
+}
+
+func (es *V5) ForceMerge(ctx context.Context, index string, maxNumSegments uint) error {
+	options := []func(*esapi.IndicesForcemergeRequest){
+		es.Client.Indices.Forcemerge.WithContext(ctx),
+		es.Client.Indices.Forcemerge.WithIndex(splitIndexes(index)...),
+	}
+	if maxNumSegments > 0 {
+		options = append(options, es.Client.Indices.Forcemerge.WithMaxNumSegments(cast.ToInt(maxNumSegments)))
+	}
+
+	res, err := es.Client.Indices.Forcemerge(options...)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	defer utils.DrainAndClose(res.Body)
+
+	if res.IsError() {
+		return formatError(res)
+	}
+
+	return nil
+}
+
+func (es *V5) Bulk(buf *bytes.Buffer, option *WriteOption) error {
 	// Execute the bulk request
-	res, err := es.Client.Bulk(bytes.NewReader(buf.Bytes()))
+	res, err := es.Client.Bulk(bytes.NewReader(buf.Bytes()),
+		es.Client.Bulk.WithWaitForActiveShards(option.waitForActiveShards()),
+		es.Client.Bulk.WithRefresh(option.refresh()))
 	if err != nil {
 		return errors.WithStack(err)
 	}
 
+	defer utils.DrainAndClose(res.Body)
+
 	if res.IsError() {
 		return formatError(res)
 	}
 
-	defer func() {
-		_ = res.Body.Close()
-	}()
+	return checkBulkResponse(res.Body)
+}
+
+func (es *V5) BulkStream(body io.Reader, option *WriteOption) error {
+	req, err := newBulkStreamRequest(body, option)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	httpRes, err := es.Client.Perform(req)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	res := &esapi.Response{StatusCode: httpRes.StatusCode, Header: httpRes.Header, Body: httpRes.Body}
+	defer utils.DrainAndClose(res.Body)
+
+	if res.IsError() {
+		return formatError(res)
+	}
+	return checkBulkResponse(res.Body)
+}
+
+// OpenIndex opens the index and waits until its primaries are active
+func (es *V5) OpenIndex(ctx context.Context, index string) error {
+	res, err := es.Client.Indices.Open([]string{index}, es.Client.Indices.Open.WithContext(ctx))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if res.IsError() {
+		return formatIndexStateError(res, "open", index)
+	}
+	utils.DrainAndClose(res.Body)
+
+	return es.waitIndexReady(ctx, index)
+}
+
+func (es *V5) waitIndexReady(ctx context.Context, index string) error {
+	res, err := es.Client.Cluster.Health(
+		es.Client.Cluster.Health.WithContext(ctx),
+		es.Client.Cluster.Health.WithIndex(index),
+		es.Client.Cluster.Health.WithWaitForStatus("yellow"),
+		es.Client.Cluster.Health.WithTimeout(indexReadyTimeout),
+	)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	defer utils.DrainAndClose(res.Body)
+
+	if res.IsError() {
+		return errors.Wrapf(formatError(res), "index %s is not ready after %s", index, indexReadyTimeout)
+	}
 
 	return nil
 }
 
-func (es *V5) GetIndexes() ([]string, error) {
-	res, err := es.Client.Cat.Indices()
+func (es *V5) CloseIndex(ctx context.Context, index string) error {
+	res, err := es.Client.Indices.Close([]string{index}, es.Client.Indices.Close.WithContext(ctx))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	defer utils.DrainAndClose(res.Body)
+
+	if res.IsError() {
+		return formatIndexStateError(res, "close", index)
+	}
+
+	return nil
+}
+
+func (es *V5) GetAliasIndexes(ctx context.Context, alias string) ([]string, error) {
+	res, err := es.Client.Indices.GetAlias(
+		es.Client.Indices.GetAlias.WithContext(ctx),
+		es.Client.Indices.GetAlias.WithName(alias),
+	)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	defer utils.DrainAndClose(res.Body)
+
+	if res.StatusCode == 404 {
+		return nil, nil
+	}
+
+	if res.IsError() {
+		return nil, formatError(res)
+	}
+
+	return parseAliasIndexes(res.Body)
+}
+
+func (es *V5) UpdateAliases(ctx context.Context, actions []AliasAction) error {
+	body, err := aliasActionsBody(actions)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	res, err := es.Client.Indices.UpdateAliases(body, es.Client.Indices.UpdateAliases.WithContext(ctx))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	defer utils.DrainAndClose(res.Body)
+
+	if res.IsError() {
+		return formatError(res)
+	}
+
+	return nil
+}
+
+func (es *V5) IndexClosed(ctx context.Context, index string) (bool, error) {
+	res, err := es.Client.Cat.Indices(
+		es.Client.Cat.Indices.WithContext(ctx),
+		es.Client.Cat.Indices.WithIndex(index),
+		es.Client.Cat.Indices.WithH("index", "status"),
+		es.Client.Cat.Indices.WithFormat("json"),
+	)
 	if err != nil {
-		log.Fatalf("Error getting indices: %s", err)
-		return nil, err
+		return false, errors.WithStack(err)
 	}
 
+	defer utils.DrainAndClose(res.Body)
+
+	if res.IsError() {
+		return false, formatError(res)
+	}
+
+	return parseCatIndexClosed(res.Body)
+}
+
+func (es *V5) GetIndexSizes() (map[string]uint64, error) {
+	res, err := es.Client.Cat.Indices(
+		es.Client.Cat.Indices.WithH("index", "store.size"),
+		es.Client.Cat.Indices.WithBytes("b"),
+		es.Client.Cat.Indices.WithFormat("json"),
+	)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	defer utils.DrainAndClose(res.Body)
+
+	if res.IsError() {
+		return nil, formatError(res)
+	}
+
+	return parseCatIndexSizes(res.Body)
+}
+
+func (es *V5) GetHiddenIndexes() (map[string]bool, error) {
+	res, err := es.Client.Indices.GetSettings(
+		es.Client.Indices.GetSettings.WithName("index.hidden"),
+		es.Client.Indices.GetSettings.WithExpandWildcards("all"),
+	)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	defer utils.DrainAndClose(res.Body)
+
 	if res.IsError() {
 		return nil, formatError(res)
 	}
 
-	defer func() {
-		_ = res.Body.Close()
-	}()
+	return parseHiddenIndexes(res.Body)
+}
+
+func (es *V5) GetIndexStats(ctx context.Context, index string) (*IndexStats, error) {
+	res, err := es.Client.Indices.Stats(
+		es.Client.Indices.Stats.WithContext(ctx),
+		es.Client.Indices.Stats.WithIndex(splitIndexes(index)...),
+		es.Client.Indices.Stats.WithMetric("docs", "store", "segments"),
+	)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	defer utils.DrainAndClose(res.Body)
 
-	var indices []string
-	scanner := bufio.NewScanner(res.Body)
-	for scanner.Scan() {
-		value := scanner.Text()
-		segments := strings.Fields(value)
-		indices = append(indices, segments[2])
+	if res.IsError() {
+		return nil, formatError(res)
 	}
 
-	if err := scanner.Err(); err != nil {
+	return parseIndexStats(res.Body)
+}
+
+func (es *V5) GetIndexes() ([]string, error) {
+	res, err := es.Client.Cat.Indices(
+		es.Client.Cat.Indices.WithH("index"),
+		es.Client.Cat.Indices.WithFormat("json"),
+	)
+	if err != nil {
 		return nil, errors.WithStack(err)
 	}
 
-	return indices, nil
+	defer utils.DrainAndClose(res.Body)
+
+	if res.IsError() {
+		return nil, formatError(res)
+	}
+
+	return parseCatIndices(res.Body)
 }
 
 func (es *V5) CreateTemplate(ctx context.Context, name string, body map[string]interface{}) error {
@@ -501,13 +812,12 @@ func (es *V5) CreateTemplate(ctx context.Context, name string, body map[string]i
 		return errors.WithStack(err)
 	}
 
+	defer utils.DrainAndClose(res.Body)
+
 	if res.IsError() {
 		return formatError(res)
 	}
 
-	defer func() {
-		_ = res.Body.Close()
-	}()
 	return nil
 }
 
@@ -529,6 +839,82 @@ type ClusterHealthRespV5 struct {
 	UnassignedShards            int     `json:"unassigned_shards"`
 }
 
+func (es *V5) Reindex(ctx context.Context, source, dest string, option *ReindexOption) (*ReindexResult, error) {
+	bodyBytes, err := json.Marshal(reindexBody(source, dest, option))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	options := []func(*esapi.ReindexRequest){
+		es.Client.Reindex.WithContext(ctx),
+		es.Client.Reindex.WithWaitForCompletion(false),
+	}
+	if option != nil && option.Slices > 0 {
+		options = append(options, es.Client.Reindex.WithSlices(cast.ToInt(option.Slices)))
+	}
+	if option != nil && option.RequestsPerSecond > 0 {
+		options = append(options, es.Client.Reindex.WithRequestsPerSecond(cast.ToInt(option.RequestsPerSecond)))
+	}
+
+	res, err := es.Client.Reindex(bytes.NewReader(bodyBytes), options...)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	defer utils.DrainAndClose(res.Body)
+
+	if res.IsError() {
+		return nil, formatError(res)
+	}
+
+	taskId, err := parseReindexTask(res.Body)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return waitForReindexTask(ctx, taskId, option, es.getTask)
+}
+
+func (es *V5) getTask(ctx context.Context, taskId string) (map[string]interface{}, error) {
+	res, err := es.Client.Tasks.Get(es.Client.Tasks.Get.WithContext(ctx), es.Client.Tasks.Get.WithTaskID(taskId))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	defer utils.DrainAndClose(res.Body)
+
+	if res.IsError() {
+		return nil, formatError(res)
+	}
+
+	var task map[string]interface{}
+	if err := json.NewDecoder(res.Body).Decode(&task); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return task, nil
+}
+
+func (es *V5) GetTemplate(ctx context.Context, name string) (map[string]interface{}, error) {
+	res, err := es.Client.Indices.GetTemplate(
+		es.Client.Indices.GetTemplate.WithContext(ctx),
+		es.Client.Indices.GetTemplate.WithName(name),
+	)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	defer utils.DrainAndClose(res.Body)
+
+	if res.StatusCode == 404 {
+		return nil, nil
+	}
+
+	if res.IsError() {
+		return nil, formatError(res)
+	}
+
+	return parseTemplate(res.Body, name)
+}
+
 func (es *V5) ClusterHealth(ctx context.Context) (map[string]interface{}, error) {
 	// Get Cluster Health
 	res, err := es.Client.Cluster.Health()
@@ -536,14 +922,12 @@ func (es *V5) ClusterHealth(ctx context.Context) (map[string]interface{}, error)
 		return nil, errors.WithStack(err)
 	}
 
+	defer utils.DrainAndClose(res.Body)
+
 	if res.IsError() {
 		return nil, formatError(res)
 	}
 
-	defer func() {
-		_ = res.Body.Close()
-	}()
-
 	var clusterHealthResp map[string]interface{}
 	if err := json.NewDecoder(res.Body).Decode(&clusterHealthResp); err != nil {
 		return nil, errors.WithStack(err)
@@ -559,14 +943,12 @@ func (es *V5) GetInfo(ctx context.Context) (map[string]interface{}, error) {
 		return nil, errors.WithStack(err)
 	}
 
+	defer utils.DrainAndClose(res.Body)
+
 	if res.IsError() {
 		return nil, formatError(res)
 	}
 
-	defer func() {
-		_ = res.Body.Close()
-	}()
-
 	var clusterHealthResp map[string]interface{}
 	if err := json.NewDecoder(res.Body).Decode(&clusterHealthResp); err != nil {
 		return nil, errors.WithStack(err)
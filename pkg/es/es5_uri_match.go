@@ -80,5 +80,30 @@ func getUriParserRuleMapWithRequestActionForV5() map[RequestActionType]*UriParse
 			},
 			false,
 		},
+		RequestActionTypeCountDocument: {
+			[]*MatchRule{
+				newMatchRule(MethodGet, "/${index}?/${docType}?/_count", 1),
+				newMatchRule(MethodPost, "/${index}?/${docType}?/_count", 1),
+			},
+			false,
+		},
+		RequestActionTypeSearchScroll: {
+			[]*MatchRule{
+				newMatchRule(MethodGet, "/_search/scroll/${scrollId}?", 1),
+			},
+			false,
+		},
+		RequestActionTypeSearchScrollWithBody: {
+			[]*MatchRule{
+				newMatchRule(MethodPost, "/_search/scroll/${scrollId}?", 1),
+			},
+			false,
+		},
+		RequestActionTypeClearScroll: {
+			[]*MatchRule{
+				newMatchRule(MethodDelete, "/_search/scroll/${scrollId}?", 1),
+			},
+			false,
+		},
 	}
 }
@@ -6,7 +6,6 @@ import (
 	"github.com/samber/lo"
 	path "github.com/segment-boneyard/go-map-path"
 	"github.com/spf13/cast"
-	"sort"
 )
 
 type V5Settings struct {
@@ -69,42 +68,10 @@ func (v5 *V5Settings) getUnwrappedMappings() map[string]interface{} {
 	return unwrappedMappings
 }
 
-func (v5 *V5Settings) mergeUnWrappedMapping(unwrappedMappings map[string]interface{}) map[string]interface{} {
-	var typePropertiesMapArray []map[string]interface{}
-	for _, typeProperties := range unwrappedMappings {
-		typePropertiesMap := cast.ToStringMap(typeProperties)
-		if _, ok := typePropertiesMap["properties"]; !ok {
-			continue
-		}
-
-		enabled := path.Path(typePropertiesMap, "_source.enabled")
-		if enabled != nil && cast.ToBool(enabled) == false {
-			continue
-		}
-
-		typePropertiesMapArray = append(typePropertiesMapArray, cast.ToStringMap(typePropertiesMap["properties"]))
-	}
-
-	sort.Slice(typePropertiesMapArray, func(i, j int) bool {
-		return len(typePropertiesMapArray[i]) > len(typePropertiesMapArray[j])
-	})
-
-	mergedProperties := make(map[string]interface{})
-	for _, typePropertiesMap := range typePropertiesMapArray {
-		for key, value := range typePropertiesMap {
-			mergedProperties[key] = value
-		}
-	}
-
-	return map[string]interface{}{
-		"properties": mergedProperties,
-	}
-}
-
 func (v5 *V5Settings) ToESV5Mapping() map[string]interface{} {
 	unwrappedMappings := v5.getUnwrappedMappings()
 	return map[string]interface{}{
-		"mappings": unwrappedMappings,
+		"mappings": wrapMappingType(unwrappedMappings, v5MappingType),
 	}
 }
 
@@ -113,7 +80,10 @@ func (v5 *V5Settings) ToAlias() map[string]interface{} {
 }
 
 func (v5 *V5Settings) ToESV6Mapping() map[string]interface{} {
-	return v5.ToESV5Mapping()
+	unwrappedMappings := v5.getUnwrappedMappings()
+	return map[string]interface{}{
+		"mappings": wrapMappingType(unwrappedMappings, v6MappingType),
+	}
 }
 
 func (v5 *V5Settings) ToESV7Mapping() map[string]interface{} {
@@ -139,14 +109,14 @@ func (v5 *V5Settings) DateFieldSupportTimestamp(properties map[string]interface{
 		fieldMap[field] = fieldAttrMap
 	}
 
-	return map[string]interface{}{
+	return lo.Assign(properties, map[string]interface{}{
 		"properties": fieldMap,
-	}
+	})
 }
 
 func (v5 *V5Settings) ToESV8Mapping() map[string]interface{} {
 	unwrappedMappings := v5.getUnwrappedMappings()
-	mergedProperties := v5.mergeUnWrappedMapping(unwrappedMappings)
+	mergedProperties := flattenMappingTypes(unwrappedMappings)
 	mergedProperties = v5.DateFieldSupportTimestamp(mergedProperties)
 	return map[string]interface{}{
 		"mappings": mergedProperties,
@@ -204,7 +174,7 @@ func (v5 *V5Settings) GetAliases() map[string]interface{} {
 }
 
 func (v5 *V5Settings) GetProperties() map[string]interface{} {
-	return v5.mergeUnWrappedMapping(v5.getUnwrappedMappings())
+	return flattenMappingTypes(v5.getUnwrappedMappings())
 }
 
 func (v5 *V5Settings) GetFieldMap() map[string]interface{} {
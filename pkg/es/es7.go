@@ -1,20 +1,16 @@
 package es
 
 import (
-	"bufio"
 	"bytes"
 	"context"
 	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"github.com/CharellKing/ela-lib/config"
-	"github.com/mitchellh/mapstructure"
-	lop "github.com/samber/lo/parallel"
+	"github.com/CharellKing/ela-lib/utils"
 	"github.com/spf13/cast"
 	"io"
-	"log"
 	"net/http"
-	"strings"
 	"time"
 
 	elasticsearch7 "github.com/elastic/go-elasticsearch/v7"
@@ -39,16 +35,21 @@ func NewESV7(esConfig *config.ESConfig, clusterVersion string) (*V7, error) {
 		Addresses: esConfig.Addresses,
 		Username:  esConfig.User,
 		Password:  esConfig.Password,
-		Transport: transport,
+		Transport: newRoundTripper(transport, requestHeaders(esConfig)),
 	})
 
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
 
+	baseES := NewBaseES(clusterVersion, esConfig.Addresses, esConfig.User, esConfig.Password)
+	baseES.Transport = transport
+	baseES.Headers = requestHeaders(esConfig)
+	baseES.ClusterName = esConfig.Name
+	baseES.ScrollDecodeConcurrency = esConfig.ScrollDecodeConcurrency
 	return &V7{
 		Client: client,
-		BaseES: NewBaseES(clusterVersion, esConfig.Addresses, esConfig.User, esConfig.Password),
+		BaseES: baseES,
 	}, nil
 }
 
@@ -66,7 +67,7 @@ type ScrollResultV7 struct {
 			Value    int    `json:"value,omitempty"`
 			Relation string `json:"relation,omitempty"`
 		} `json:"total,omitempty"`
-		Docs []interface{} `json:"hits,omitempty"`
+		Docs []*Doc `json:"hits,omitempty"`
 	} `json:"hits"`
 	Shards struct {
 		Successful int `json:"successful,omitempty"`
@@ -83,6 +84,7 @@ type ScrollResultV7 struct {
 
 func (es *V7) NewScroll(ctx context.Context, index string, option *ScrollOption) (*ScrollResult, error) {
 	scrollSearchOptions := []func(*esapi.SearchRequest){
+		es.Search.WithContext(ctx),
 		es.Search.WithIndex(index),
 		es.Search.WithSize(cast.ToInt(option.ScrollSize)),
 		es.Search.WithScroll(cast.ToDuration(option.ScrollTime) * time.Minute),
@@ -101,6 +103,10 @@ func (es *V7) NewScroll(ctx context.Context, index string, option *ScrollOption)
 		}
 	}
 
+	if option.TrackTotalHits {
+		query["track_total_hits"] = true
+	}
+
 	if len(query) > 0 {
 		var buf bytes.Buffer
 		_ = json.NewEncoder(&buf).Encode(query)
@@ -116,77 +122,68 @@ func (es *V7) NewScroll(ctx context.Context, index string, option *ScrollOption)
 		return nil, errors.WithStack(err)
 	}
 
+	defer utils.DrainAndClose(res.Body)
+
 	if res.IsError() {
 		return nil, formatError(res)
 	}
 
-	defer func() {
-		_ = res.Body.Close()
-	}()
+	if es.ScrollDecodeConcurrency > 1 {
+		return decodeScrollResponse(res.Body, es.ScrollDecodeConcurrency)
+	}
+
 	var scrollResult ScrollResultV7
-	if err := json.NewDecoder(res.Body).Decode(&scrollResult); err != nil {
+	if err := decodeResponse(res.Body, &scrollResult); err != nil {
 		return nil, errors.WithStack(err)
 	}
 
-	hitDocs := lop.Map(scrollResult.Hits.Docs, func(hit interface{}, _ int) *Doc {
-		var hitDoc Doc
-		_ = mapstructure.Decode(hit, &hitDoc)
-		return &hitDoc
-	})
-
 	return &ScrollResult{
 		Total:    uint64(scrollResult.Hits.Total.Value),
-		Docs:     hitDocs,
+		Docs:     scrollResult.Hits.Docs,
 		ScrollId: scrollResult.ScrollId,
 	}, nil
 }
 
 func (es *V7) NextScroll(ctx context.Context, scrollId string, scrollTime uint) (*ScrollResult, error) {
-	res, err := es.Client.Scroll(es.Client.Scroll.WithScrollID(scrollId), es.Client.Scroll.WithScroll(time.Duration(scrollTime)*time.Minute))
+	res, err := es.Client.Scroll(es.Client.Scroll.WithContext(ctx), es.Client.Scroll.WithBody(scrollIdBody(scrollId)), es.Client.Scroll.WithScroll(time.Duration(scrollTime)*time.Minute))
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
 
+	defer utils.DrainAndClose(res.Body)
+
 	if res.IsError() {
 		return nil, formatError(res)
 	}
 
-	defer func() {
-		_ = res.Body.Close()
-	}()
+	if es.ScrollDecodeConcurrency > 1 {
+		return decodeScrollResponse(res.Body, es.ScrollDecodeConcurrency)
+	}
 
 	var scrollResult ScrollResultV7
-	if err := json.NewDecoder(res.Body).Decode(&scrollResult); err != nil {
+	if err := decodeResponse(res.Body, &scrollResult); err != nil {
 		return nil, errors.WithStack(err)
 	}
 
-	hitDocs := lop.Map(scrollResult.Hits.Docs, func(hit interface{}, _ int) *Doc {
-		var hitDoc Doc
-		_ = mapstructure.Decode(hit, &hitDoc)
-		return &hitDoc
-	})
-
 	return &ScrollResult{
 		Total:    uint64(scrollResult.Hits.Total.Value),
-		Docs:     hitDocs,
+		Docs:     scrollResult.Hits.Docs,
 		ScrollId: scrollResult.ScrollId,
 	}, nil
 }
 
 func (es *V7) ClearScroll(scrollId string) error {
-	res, err := es.Client.ClearScroll(es.Client.ClearScroll.WithScrollID(scrollId))
+	res, err := es.Client.ClearScroll(es.Client.ClearScroll.WithBody(clearScrollBody(scrollId)))
 	if err != nil {
 		return errors.WithStack(err)
 	}
 
+	defer utils.DrainAndClose(res.Body)
+
 	if res.IsError() {
 		return formatError(res)
 	}
 
-	defer func() {
-		_ = res.Body.Close()
-	}()
-
 	return nil
 }
 
@@ -224,14 +221,12 @@ func (es *V7) GetIndexAliases(index string) (map[string]interface{}, error) {
 		return nil, errors.WithStack(err)
 	}
 
+	defer utils.DrainAndClose(res.Body)
+
 	if res.IsError() {
 		return nil, formatError(res)
 	}
 
-	defer func() {
-		_ = res.Body.Close()
-	}()
-
 	bodyBytes, err := io.ReadAll(res.Body)
 	if err != nil {
 		return nil, errors.WithStack(err)
@@ -251,14 +246,12 @@ func (es *V7) GetIndexMapping(index string) (map[string]interface{}, error) {
 		return nil, errors.WithStack(err)
 	}
 
+	defer utils.DrainAndClose(res.Body)
+
 	if res.IsError() {
 		return nil, formatError(res)
 	}
 
-	defer func() {
-		_ = res.Body.Close()
-	}()
-
 	bodyBytes, err := io.ReadAll(res.Body)
 	if err != nil {
 		return nil, errors.WithStack(err)
@@ -278,14 +271,12 @@ func (es *V7) GetIndexSettings(index string) (map[string]interface{}, error) {
 		return nil, errors.WithStack(err)
 	}
 
+	defer utils.DrainAndClose(res.Body)
+
 	if res.IsError() {
 		return nil, formatError(res)
 	}
 
-	defer func() {
-		_ = res.Body.Close()
-	}()
-
 	var indexSetting map[string]interface{}
 	if err := json.NewDecoder(res.Body).Decode(&indexSetting); err != nil {
 		return nil, errors.WithStack(err)
@@ -302,8 +293,15 @@ func (es *V7) BulkBody(index string, buf *bytes.Buffer, doc *Doc) error {
 	case OperationCreate:
 		action = "index"
 		body = doc.Source
+	case OperationCreateOnly:
+		action = "create"
+		body = doc.Source
 	case OperationUpdate:
 		action = "update"
+		if doc.Script != nil {
+			body = scriptedUpdateBody(doc)
+			break
+		}
 		body = map[string]interface{}{
 			doc.Type: doc.Source,
 		}
@@ -313,11 +311,18 @@ func (es *V7) BulkBody(index string, buf *bytes.Buffer, doc *Doc) error {
 		return fmt.Errorf("unknow action %+v", doc.Op)
 	}
 
+	metadata := map[string]interface{}{
+		"_index": index,
+	}
+	// without _id es generates one
+	if doc.ID != "" {
+		metadata["_id"] = doc.ID
+	}
+	if doc.Routing != "" {
+		metadata["routing"] = doc.Routing
+	}
 	meta := map[string]interface{}{
-		action: map[string]interface{}{
-			"_index": index,
-			"_id":    doc.ID,
-		},
+		action: metadata,
 	}
 
 	metaBytes, _ := json.Marshal(meta)
@@ -332,25 +337,66 @@ func (es *V7) BulkBody(index string, buf *bytes.Buffer, doc *Doc) error {
 	return nil
 }
 
-func (es *V7) Bulk(buf *bytes.Buffer) error {
-	// Execute the bulk request
-	res, err := es.Client.Bulk(bytes.NewReader(buf.Bytes()))
+func (es *V7) Refresh(ctx context.Context, index string) error {
+	res, err := es.Client.Indices.Refresh(
+		es.Client.Indices.Refresh.WithContext(ctx),
+		es.Client.Indices.Refresh.WithIndex(splitIndexes(index)...),
+	)
 	if err != nil {
 		return errors.WithStack(err)
 	}
 
+	defer utils.DrainAndClose(res.Body)
+
 	if res.IsError() {
 		return formatError(res)
 	}
 
-	defer func() {
-		_ = res.Body.Close()
-	}()
+	return nil
+}
+
+func (es *V7) ForceMerge(ctx context.Context, index string, maxNumSegments uint) error {
+	options := []func(*esapi.IndicesForcemergeRequest){
+		es.Client.Indices.Forcemerge.WithContext(ctx),
+		es.Client.Indices.Forcemerge.WithIndex(splitIndexes(index)...),
+	}
+	if maxNumSegments > 0 {
+		options = append(options, es.Client.Indices.Forcemerge.WithMaxNumSegments(cast.ToInt(maxNumSegments)))
+	}
+
+	res, err := es.Client.Indices.Forcemerge(options...)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	defer utils.DrainAndClose(res.Body)
+
+	if res.IsError() {
+		return formatError(res)
+	}
 
 	return nil
 }
 
-func (es *V7) CreateIndex(esSetting IESSettings) error {
+func (es *V7) Bulk(buf *bytes.Buffer, option *WriteOption) error {
+	// Execute the bulk request
+	res, err := es.Client.Bulk(bytes.NewReader(buf.Bytes()),
+		es.Client.Bulk.WithWaitForActiveShards(option.waitForActiveShards()),
+		es.Client.Bulk.WithRefresh(option.refresh()))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	defer utils.DrainAndClose(res.Body)
+
+	if res.IsError() {
+		return formatError(res)
+	}
+
+	return checkBulkResponse(res.Body)
+}
+
+func (es *V7) CreateIndex(esSetting IESSettings, option *WriteOption) error {
 	indexBodyMap := lo.Assign(
 		esSetting.GetSettings(),
 		esSetting.GetMappings(),
@@ -360,8 +406,9 @@ func (es *V7) CreateIndex(esSetting IESSettings) error {
 	indexSettingsBytes, _ := json.Marshal(indexBodyMap)
 
 	req := esapi.IndicesCreateRequest{
-		Index: esSetting.GetIndex(),
-		Body:  bytes.NewBuffer(indexSettingsBytes),
+		Index:               esSetting.GetIndex(),
+		Body:                bytes.NewBuffer(indexSettingsBytes),
+		WaitForActiveShards: option.waitForActiveShards(),
 	}
 
 	res, err := req.Do(context.Background(), es)
@@ -369,14 +416,12 @@ func (es *V7) CreateIndex(esSetting IESSettings) error {
 		return errors.WithStack(err)
 	}
 
+	defer utils.DrainAndClose(res.Body)
+
 	if res.IsError() {
 		return formatError(res)
 	}
 
-	defer func() {
-		_ = res.Body.Close()
-	}()
-
 	return nil
 }
 
@@ -386,6 +431,8 @@ func (es *V7) IndexExisted(indexName string) (bool, error) {
 		return false, errors.WithStack(err)
 	}
 
+	defer utils.DrainAndClose(res.Body)
+
 	if res.StatusCode == 404 {
 		return false, nil
 	}
@@ -394,10 +441,6 @@ func (es *V7) IndexExisted(indexName string) (bool, error) {
 		return false, formatError(res)
 	}
 
-	defer func() {
-		_ = res.Body.Close()
-	}()
-
 	return res.StatusCode == 200, nil
 }
 
@@ -407,60 +450,312 @@ func (es *V7) DeleteIndex(index string) error {
 		return errors.WithStack(err)
 	}
 
+	defer utils.DrainAndClose(res.Body)
+
+	if res.IsError() {
+		return formatError(res)
+	}
+
+	return nil
+}
+
+func (es *V7) PutMapping(index string, properties map[string]interface{}) error {
+	bodyBytes, _ := json.Marshal(map[string]interface{}{"properties": properties})
+
+	req := esapi.IndicesPutMappingRequest{
+		Index: []string{index},
+		Body:  bytes.NewBuffer(bodyBytes),
+	}
+
+	res, err := req.Do(context.Background(), es)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	defer utils.DrainAndClose(res.Body)
+
 	if res.IsError() {
 		return formatError(res)
 	}
 
-	defer func() {
-		_ = res.Body.Close()
-	}()
+	return nil
+}
+
+func (es *V7) BulkStream(body io.Reader, option *WriteOption) error {
+	req, err := newBulkStreamRequest(body, option)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	httpRes, err := es.Client.Perform(req)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	res := &esapi.Response{StatusCode: httpRes.StatusCode, Header: httpRes.Header, Body: httpRes.Body}
+	defer utils.DrainAndClose(res.Body)
+
+	if res.IsError() {
+		return formatError(res)
+	}
+	return checkBulkResponse(res.Body)
+}
+
+// OpenIndex opens the index and waits until its primaries are active
+func (es *V7) OpenIndex(ctx context.Context, index string) error {
+	res, err := es.Client.Indices.Open([]string{index}, es.Client.Indices.Open.WithContext(ctx))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if res.IsError() {
+		return formatIndexStateError(res, "open", index)
+	}
+	utils.DrainAndClose(res.Body)
+
+	return es.waitIndexReady(ctx, index)
+}
+
+func (es *V7) waitIndexReady(ctx context.Context, index string) error {
+	res, err := es.Client.Cluster.Health(
+		es.Client.Cluster.Health.WithContext(ctx),
+		es.Client.Cluster.Health.WithIndex(index),
+		es.Client.Cluster.Health.WithWaitForStatus("yellow"),
+		es.Client.Cluster.Health.WithTimeout(indexReadyTimeout),
+	)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	defer utils.DrainAndClose(res.Body)
+
+	if res.IsError() {
+		return errors.Wrapf(formatError(res), "index %s is not ready after %s", index, indexReadyTimeout)
+	}
 
 	return nil
 }
 
-func (es *V7) GetIndexes() ([]string, error) {
-	res, err := es.Client.Cat.Indices()
+func (es *V7) CloseIndex(ctx context.Context, index string) error {
+	res, err := es.Client.Indices.Close([]string{index}, es.Client.Indices.Close.WithContext(ctx))
 	if err != nil {
-		log.Fatalf("Error getting indices: %s", err)
-		return nil, err
+		return errors.WithStack(err)
+	}
+
+	defer utils.DrainAndClose(res.Body)
+
+	if res.IsError() {
+		return formatIndexStateError(res, "close", index)
+	}
+
+	return nil
+}
+
+func (es *V7) GetAliasIndexes(ctx context.Context, alias string) ([]string, error) {
+	res, err := es.Client.Indices.GetAlias(
+		es.Client.Indices.GetAlias.WithContext(ctx),
+		es.Client.Indices.GetAlias.WithName(alias),
+	)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	defer utils.DrainAndClose(res.Body)
+
+	if res.StatusCode == 404 {
+		return nil, nil
 	}
 
 	if res.IsError() {
 		return nil, formatError(res)
 	}
 
-	defer func() {
-		_ = res.Body.Close()
-	}()
+	return parseAliasIndexes(res.Body)
+}
+
+func (es *V7) UpdateAliases(ctx context.Context, actions []AliasAction) error {
+	body, err := aliasActionsBody(actions)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	res, err := es.Client.Indices.UpdateAliases(body, es.Client.Indices.UpdateAliases.WithContext(ctx))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	defer utils.DrainAndClose(res.Body)
 
-	var indices []string
-	scanner := bufio.NewScanner(res.Body)
-	for scanner.Scan() {
-		value := scanner.Text()
-		segments := strings.Fields(value)
-		indices = append(indices, segments[2])
+	if res.IsError() {
+		return formatError(res)
 	}
 
-	if err := scanner.Err(); err != nil {
+	return nil
+}
+
+func (es *V7) IndexClosed(ctx context.Context, index string) (bool, error) {
+	res, err := es.Client.Cat.Indices(
+		es.Client.Cat.Indices.WithContext(ctx),
+		es.Client.Cat.Indices.WithIndex(index),
+		es.Client.Cat.Indices.WithH("index", "status"),
+		es.Client.Cat.Indices.WithFormat("json"),
+	)
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+
+	defer utils.DrainAndClose(res.Body)
+
+	if res.IsError() {
+		return false, formatError(res)
+	}
+
+	return parseCatIndexClosed(res.Body)
+}
+
+func (es *V7) GetIndexSizes() (map[string]uint64, error) {
+	res, err := es.Client.Cat.Indices(
+		es.Client.Cat.Indices.WithH("index", "store.size"),
+		es.Client.Cat.Indices.WithBytes("b"),
+		es.Client.Cat.Indices.WithFormat("json"),
+	)
+	if err != nil {
 		return nil, errors.WithStack(err)
 	}
 
-	return indices, nil
+	defer utils.DrainAndClose(res.Body)
+
+	if res.IsError() {
+		return nil, formatError(res)
+	}
+
+	return parseCatIndexSizes(res.Body)
+}
+
+func (es *V7) GetHiddenIndexes() (map[string]bool, error) {
+	res, err := es.Client.Indices.GetSettings(
+		es.Client.Indices.GetSettings.WithName("index.hidden"),
+		es.Client.Indices.GetSettings.WithExpandWildcards("all"),
+	)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	defer utils.DrainAndClose(res.Body)
+
+	if res.IsError() {
+		return nil, formatError(res)
+	}
+
+	return parseHiddenIndexes(res.Body)
+}
+
+func (es *V7) GetIndexStats(ctx context.Context, index string) (*IndexStats, error) {
+	res, err := es.Client.Indices.Stats(
+		es.Client.Indices.Stats.WithContext(ctx),
+		es.Client.Indices.Stats.WithIndex(splitIndexes(index)...),
+		es.Client.Indices.Stats.WithMetric("docs", "store", "segments"),
+	)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	defer utils.DrainAndClose(res.Body)
+
+	if res.IsError() {
+		return nil, formatError(res)
+	}
+
+	return parseIndexStats(res.Body)
+}
+
+func (es *V7) GetIndexes() ([]string, error) {
+	res, err := es.Client.Cat.Indices(
+		es.Client.Cat.Indices.WithH("index"),
+		es.Client.Cat.Indices.WithFormat("json"),
+	)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	defer utils.DrainAndClose(res.Body)
+
+	if res.IsError() {
+		return nil, formatError(res)
+	}
+
+	return parseCatIndices(res.Body)
 }
 
 func (es *V7) Count(ctx context.Context, index string) (uint64, error) {
-	res, err := es.Client.Count(es.Client.Count.WithIndex(index))
+	res, err := es.Client.Count(es.Client.Count.WithContext(ctx), es.Client.Count.WithIndex(index))
 	if err != nil {
 		return 0, errors.WithStack(err)
 	}
 
+	defer utils.DrainAndClose(res.Body)
+
 	if res.IsError() {
 		return 0, formatError(res)
 	}
 
-	defer func() {
-		_ = res.Body.Close()
-	}()
+	var countResult map[string]interface{}
+	if err := json.NewDecoder(res.Body).Decode(&countResult); err != nil {
+		return 0, errors.WithStack(err)
+	}
+
+	return cast.ToUint64(countResult["count"]), nil
+}
+
+func (es *V7) SearchByQuery(ctx context.Context, index string, query map[string]interface{}) (map[string]interface{}, error) {
+	var buf bytes.Buffer
+	_ = json.NewEncoder(&buf).Encode(query)
+
+	res, err := es.Client.Search(
+		es.Client.Search.WithContext(ctx),
+		es.Client.Search.WithIndex(index),
+		es.Client.Search.WithBody(&buf),
+	)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	defer utils.DrainAndClose(res.Body)
+
+	if res.IsError() {
+		return nil, formatError(res)
+	}
+
+	var searchResult map[string]interface{}
+	if err := decodeResponse(res.Body, &searchResult); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return searchResult, nil
+}
+
+func (es *V7) CountByQuery(ctx context.Context, index string, query map[string]interface{}) (uint64, error) {
+	countOptions := []func(*esapi.CountRequest){
+		es.Client.Count.WithContext(ctx),
+		es.Client.Count.WithIndex(index),
+	}
+
+	if queryBody, ok := query["query"]; ok {
+		var buf bytes.Buffer
+		_ = json.NewEncoder(&buf).Encode(map[string]interface{}{
+			"query": queryBody,
+		})
+		countOptions = append(countOptions, es.Client.Count.WithBody(&buf))
+	}
+
+	res, err := es.Client.Count(countOptions...)
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+
+	defer utils.DrainAndClose(res.Body)
+
+	if res.IsError() {
+		return 0, formatError(res)
+	}
 
 	var countResult map[string]interface{}
 	if err := json.NewDecoder(res.Body).Decode(&countResult); err != nil {
@@ -477,16 +772,91 @@ func (es *V7) CreateTemplate(ctx context.Context, name string, body map[string]i
 		return errors.WithStack(err)
 	}
 
+	defer utils.DrainAndClose(res.Body)
+
 	if res.IsError() {
 		return formatError(res)
 	}
 
-	defer func() {
-		_ = res.Body.Close()
-	}()
 	return nil
 }
 
+func (es *V7) Reindex(ctx context.Context, source, dest string, option *ReindexOption) (*ReindexResult, error) {
+	bodyBytes, err := json.Marshal(reindexBody(source, dest, option))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	options := []func(*esapi.ReindexRequest){
+		es.Client.Reindex.WithContext(ctx),
+		es.Client.Reindex.WithWaitForCompletion(false),
+	}
+	if option != nil && option.Slices > 0 {
+		options = append(options, es.Client.Reindex.WithSlices(cast.ToInt(option.Slices)))
+	}
+	if option != nil && option.RequestsPerSecond > 0 {
+		options = append(options, es.Client.Reindex.WithRequestsPerSecond(cast.ToInt(option.RequestsPerSecond)))
+	}
+
+	res, err := es.Client.Reindex(bytes.NewReader(bodyBytes), options...)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	defer utils.DrainAndClose(res.Body)
+
+	if res.IsError() {
+		return nil, formatError(res)
+	}
+
+	taskId, err := parseReindexTask(res.Body)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return waitForReindexTask(ctx, taskId, option, es.getTask)
+}
+
+func (es *V7) getTask(ctx context.Context, taskId string) (map[string]interface{}, error) {
+	res, err := es.Client.Tasks.Get(taskId, es.Client.Tasks.Get.WithContext(ctx))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	defer utils.DrainAndClose(res.Body)
+
+	if res.IsError() {
+		return nil, formatError(res)
+	}
+
+	var task map[string]interface{}
+	if err := json.NewDecoder(res.Body).Decode(&task); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return task, nil
+}
+
+func (es *V7) GetTemplate(ctx context.Context, name string) (map[string]interface{}, error) {
+	res, err := es.Client.Indices.GetTemplate(
+		es.Client.Indices.GetTemplate.WithContext(ctx),
+		es.Client.Indices.GetTemplate.WithName(name),
+	)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	defer utils.DrainAndClose(res.Body)
+
+	if res.StatusCode == 404 {
+		return nil, nil
+	}
+
+	if res.IsError() {
+		return nil, formatError(res)
+	}
+
+	return parseTemplate(res.Body, name)
+}
+
 func (es *V7) ClusterHealth(ctx context.Context) (map[string]interface{}, error) {
 	// Get Cluster Health
 	res, err := es.Client.Cluster.Health()
@@ -494,14 +864,12 @@ func (es *V7) ClusterHealth(ctx context.Context) (map[string]interface{}, error)
 		return nil, errors.WithStack(err)
 	}
 
+	defer utils.DrainAndClose(res.Body)
+
 	if res.IsError() {
 		return nil, formatError(res)
 	}
 
-	defer func() {
-		_ = res.Body.Close()
-	}()
-
 	var clusterHealthResp map[string]interface{}
 	if err := json.NewDecoder(res.Body).Decode(&clusterHealthResp); err != nil {
 		return nil, errors.WithStack(err)
@@ -517,14 +885,12 @@ func (es *V7) GetInfo(ctx context.Context) (map[string]interface{}, error) {
 		return nil, errors.WithStack(err)
 	}
 
+	defer utils.DrainAndClose(res.Body)
+
 	if res.IsError() {
 		return nil, formatError(res)
 	}
 
-	defer func() {
-		_ = res.Body.Close()
-	}()
-
 	var clusterHealthResp map[string]interface{}
 	if err := json.NewDecoder(res.Body).Decode(&clusterHealthResp); err != nil {
 		return nil, errors.WithStack(err)
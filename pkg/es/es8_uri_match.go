@@ -61,7 +61,7 @@ func getUriParserRuleMapWithRequestActionForV8() map[RequestActionType]*UriParse
 			[]*MatchRule{
 				newMatchRule(MethodGet, "/${index}?/_mget", 1),
 			},
-			true,
+			false,
 		},
 		RequestActionTypeBulkDocument: {
 			[]*MatchRule{
@@ -73,13 +73,50 @@ func getUriParserRuleMapWithRequestActionForV8() map[RequestActionType]*UriParse
 			[]*MatchRule{
 				newMatchRule(MethodGet, "/${index}?/_search", 1),
 			},
-			true,
+			false,
 		},
 		RequestActionTypeSearchDocumentWithLimit: {
 			[]*MatchRule{
 				newMatchRule(MethodPost, "/${index}?/_search", 1),
 			},
-			true,
+			false,
+		},
+		RequestActionTypeCountDocument: {
+			[]*MatchRule{
+				newMatchRule(MethodGet, "/${index}?/_count", 1),
+				newMatchRule(MethodPost, "/${index}?/_count", 1),
+			},
+			false,
+		},
+		RequestActionTypeSearchScroll: {
+			[]*MatchRule{
+				newMatchRule(MethodGet, "/_search/scroll/${scrollId}?", 1),
+			},
+			false,
+		},
+		RequestActionTypeSearchScrollWithBody: {
+			[]*MatchRule{
+				newMatchRule(MethodPost, "/_search/scroll/${scrollId}?", 1),
+			},
+			false,
+		},
+		RequestActionTypeClearScroll: {
+			[]*MatchRule{
+				newMatchRule(MethodDelete, "/_search/scroll/${scrollId}?", 1),
+			},
+			false,
+		},
+		RequestActionTypeOpenPointInTime: {
+			[]*MatchRule{
+				newMatchRule(MethodPost, "/${index}/_pit", 1),
+			},
+			false,
+		},
+		RequestActionTypeClosePointInTime: {
+			[]*MatchRule{
+				newMatchRule(MethodDelete, "/_pit", 1),
+			},
+			false,
 		},
 	}
 }
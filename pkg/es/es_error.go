@@ -0,0 +1,141 @@
+package es
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/spf13/cast"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// ESError is a failed answer of es, Type and Reason are taken from the error object of the body
+type ESError struct {
+	StatusCode int
+	Type       string
+	Reason     string
+	Body       string
+}
+
+func (e *ESError) Error() string {
+	return fmt.Sprintf("status: %d %s, body: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Body)
+}
+
+func newESError(res IResponse) *ESError {
+	status := res.Status()
+	// String prefixes the body with the bracketed status, e.g. `[404 Not Found] {...}`
+	body := res.String()
+	if strings.HasPrefix(body, "[") {
+		if idx := strings.Index(body, "]"); idx >= 0 {
+			body = strings.TrimSpace(body[idx+1:])
+		}
+	}
+
+	esError := &ESError{
+		StatusCode: cast.ToInt(strings.SplitN(status, " ", 2)[0]),
+		Body:       body,
+	}
+
+	var errorBody struct {
+		Error json.RawMessage `json:"error"`
+	}
+	if err := json.Unmarshal([]byte(body), &errorBody); err != nil || len(errorBody.Error) == 0 {
+		return esError
+	}
+
+	var errorObject struct {
+		Type   string `json:"type"`
+		Reason string `json:"reason"`
+	}
+	if err := json.Unmarshal(errorBody.Error, &errorObject); err == nil {
+		esError.Type = errorObject.Type
+		esError.Reason = errorObject.Reason
+	} else {
+		// some answers carry the error as a plain string
+		esError.Reason = strings.Trim(string(errorBody.Error), `"`)
+	}
+	return esError
+}
+
+func hasStatusCode(err error, statusCode int) bool {
+	var esError *ESError
+	return errors.As(err, &esError) && esError.StatusCode == statusCode
+}
+
+func IsNotFound(err error) bool {
+	return hasStatusCode(err, http.StatusNotFound)
+}
+
+func IsTooManyRequests(err error) bool {
+	return hasStatusCode(err, http.StatusTooManyRequests)
+}
+
+func IsServiceUnavailable(err error) bool {
+	return hasStatusCode(err, http.StatusServiceUnavailable)
+}
+
+// BulkItemError is an action es rejected in a bulk request, Position is its order among the actions of the body
+type BulkItemError struct {
+	Position int
+	Index    string
+	ID       string
+	Status   int
+	Type     string
+	Reason   string
+}
+
+func (e *BulkItemError) Error() string {
+	return fmt.Sprintf("status: %d, %s: %s", e.Status, e.Type, e.Reason)
+}
+
+// BulkError is returned by Bulk when the request succeeded but some of its actions failed, e.g. on a mapping
+// conflict. The other actions are applied.
+type BulkError struct {
+	Items []*BulkItemError
+}
+
+func (e *BulkError) Error() string {
+	return fmt.Sprintf("%d bulk actions failed, first: %s", len(e.Items), e.Items[0].Error())
+}
+
+// checkBulkResponse returns a BulkError with the failed actions of a bulk answer
+func checkBulkResponse(body io.Reader) error {
+	var bulkResponse struct {
+		Errors bool `json:"errors"`
+		Items  []map[string]struct {
+			Index  string `json:"_index"`
+			ID     string `json:"_id"`
+			Status int    `json:"status"`
+			Error  *struct {
+				Type   string `json:"type"`
+				Reason string `json:"reason"`
+			} `json:"error"`
+		} `json:"items"`
+	}
+	if err := json.NewDecoder(body).Decode(&bulkResponse); err != nil || !bulkResponse.Errors {
+		return nil
+	}
+
+	bulkError := &BulkError{}
+	for position, item := range bulkResponse.Items {
+		for _, result := range item {
+			if result.Error == nil {
+				continue
+			}
+			bulkError.Items = append(bulkError.Items, &BulkItemError{
+				Position: position,
+				Index:    result.Index,
+				ID:       result.ID,
+				Status:   result.Status,
+				Type:     result.Error.Type,
+				Reason:   result.Error.Reason,
+			})
+		}
+	}
+
+	if len(bulkError.Items) == 0 {
+		return nil
+	}
+	return bulkError
+}
@@ -0,0 +1,7 @@
+//go:build go_json
+
+package es
+
+import json "github.com/goccy/go-json"
+
+var unmarshalJSON = json.Unmarshal
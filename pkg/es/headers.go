@@ -0,0 +1,84 @@
+package es
+
+import (
+	"github.com/CharellKing/ela-lib/config"
+	"github.com/samber/lo"
+	"net/http"
+	"runtime/debug"
+	"strings"
+)
+
+const (
+	redactedHeaderValue = "******"
+	modulePath          = "github.com/CharellKing/ela-lib"
+)
+
+// DefaultUserAgent identifies the requests of this lib to the clusters, config.ESConfig.UserAgent overrides it
+var DefaultUserAgent = "ela-lib/" + libVersion()
+
+var defaultSensitiveHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie"}
+
+// headerTransport sets the configured headers on every request of a version client
+type headerTransport struct {
+	base    http.RoundTripper
+	headers map[string]string
+}
+
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	setHeaders(req, t.headers)
+	return t.base.RoundTrip(req)
+}
+
+func newRoundTripper(transport *http.Transport, headers map[string]string) http.RoundTripper {
+	if len(headers) == 0 {
+		return transport
+	}
+	return &headerTransport{base: transport, headers: headers}
+}
+
+// libVersion is the version of the lib module in the build, devel when it is built from its own tree
+func libVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "devel"
+	}
+
+	if dep, ok := lo.Find(info.Deps, func(dep *debug.Module) bool { return dep.Path == modulePath }); ok {
+		return dep.Version
+	}
+	if info.Main.Path == modulePath && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		return info.Main.Version
+	}
+	return "devel"
+}
+
+// requestHeaders are the configured headers and the user agent, a User-Agent among the headers wins
+func requestHeaders(esConfig *config.ESConfig) map[string]string {
+	headers := lo.Assign(esConfig.Headers)
+	if lo.SomeBy(lo.Keys(headers), func(key string) bool { return strings.EqualFold(key, "User-Agent") }) {
+		return headers
+	}
+	headers["User-Agent"] = lo.Ternary(esConfig.UserAgent == "", DefaultUserAgent, esConfig.UserAgent)
+	return headers
+}
+
+func setHeaders(req *http.Request, headers map[string]string) {
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+}
+
+// RedactedHeaders returns the configured headers with the sensitive values masked, to be logged
+func RedactedHeaders(esConfig *config.ESConfig) map[string]string {
+	sensitiveHeaders := lo.Map(lo.Union(defaultSensitiveHeaders, esConfig.SensitiveHeaders), func(item string, _ int) string {
+		return http.CanonicalHeaderKey(strings.TrimSpace(item))
+	})
+
+	return lo.MapValues(esConfig.Headers, func(value string, key string) string {
+		if lo.Contains(sensitiveHeaders, http.CanonicalHeaderKey(key)) {
+			return redactedHeaderValue
+		}
+		return value
+	})
+}
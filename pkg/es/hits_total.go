@@ -0,0 +1,49 @@
+package es
+
+// TranslateHitsTotal reshapes the hits.total of a search answer, at the top and in the top_hits aggregations, into
+// the object of value and relation 7.x and later answer when toGte7, else into the plain number of the earlier ones
+func TranslateHitsTotal(body map[string]interface{}, toGte7 bool) {
+	if hits, ok := body["hits"].(map[string]interface{}); ok {
+		translateHitsTotal(hits, toGte7)
+	}
+	if aggregations, ok := body["aggregations"].(map[string]interface{}); ok {
+		translateAggregations(aggregations, toGte7)
+	}
+}
+
+func translateHitsTotal(hits map[string]interface{}, toGte7 bool) {
+	total, ok := hits["total"]
+	if !ok {
+		return
+	}
+
+	totalMap, isMap := total.(map[string]interface{})
+	if toGte7 && !isMap {
+		hits["total"] = map[string]interface{}{
+			"value":    total,
+			"relation": "eq",
+		}
+	} else if !toGte7 && isMap {
+		hits["total"] = totalMap["value"]
+	}
+}
+
+// translateAggregations walks the nested aggregations and buckets, top_hits carries the same hits.total as a search
+func translateAggregations(node interface{}, toGte7 bool) {
+	switch value := node.(type) {
+	case map[string]interface{}:
+		if hits, ok := value["hits"].(map[string]interface{}); ok {
+			translateHitsTotal(hits, toGte7)
+		}
+		for key, child := range value {
+			// hits carry the documents, their sources are never reshaped
+			if key != "hits" {
+				translateAggregations(child, toGte7)
+			}
+		}
+	case []interface{}:
+		for _, child := range value {
+			translateAggregations(child, toGte7)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package es
+
+import (
+	"github.com/CharellKing/ela-lib/utils"
+	"strings"
+)
+
+const (
+	maxIndexNameBytes  = 255
+	invalidIndexChars  = "\\/*?\"<>| ,#:"
+	invalidIndexPrefix = "-_+"
+)
+
+// ValidateIndexName checks the rules es applies to a new index name, so that a bad rename fails before any
+// request with a message telling what is wrong
+func ValidateIndexName(name string) error {
+	switch {
+	case name == "":
+		return utils.NewCustomError(utils.InvalidParams, "invalid index name: empty")
+	case name == "." || name == "..":
+		return utils.NewCustomError(utils.InvalidParams, "invalid index name %q: must not be . or ..", name)
+	case len(name) > maxIndexNameBytes:
+		return utils.NewCustomError(utils.InvalidParams, "invalid index name %q: longer than %d bytes", name, maxIndexNameBytes)
+	case strings.ToLower(name) != name:
+		return utils.NewCustomError(utils.InvalidParams, "invalid index name %q: must be lowercase", name)
+	case strings.ContainsAny(name, invalidIndexChars):
+		return utils.NewCustomError(utils.InvalidParams, "invalid index name %q: must not contain any of %q", name, invalidIndexChars)
+	case strings.ContainsAny(name[:1], invalidIndexPrefix):
+		return utils.NewCustomError(utils.InvalidParams, "invalid index name %q: must not start with any of %q", name, invalidIndexPrefix)
+	}
+	return nil
+}
@@ -0,0 +1,9 @@
+//go:build !jsoniter && !go_json
+
+package es
+
+import "encoding/json"
+
+// unmarshalJSON decodes the scroll responses, build with the tag jsoniter or go_json for a faster codec. The tags
+// are the ones gin takes, so a build switches both.
+var unmarshalJSON = json.Unmarshal
@@ -0,0 +1,157 @@
+package es
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/mitchellh/mapstructure"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// newScrollBody returns a scroll answer of 7.x with hits docs of a few fields each
+func newScrollBody(hits int) []byte {
+	return newPaddedScrollBody(hits, 0)
+}
+
+// newPaddedScrollBody returns a scroll answer like newScrollBody with padding more fields in every doc
+func newPaddedScrollBody(hits int, padding int) []byte {
+	var docs []map[string]interface{}
+	for i := 0; i < hits; i++ {
+		source := map[string]interface{}{
+			"message":   fmt.Sprintf("GET /api/v1/items/%d 200", i),
+			"status":    200,
+			"timestamp": "2024-01-02T03:04:05Z",
+			"tags":      []string{"web", "api"},
+			"user":      map[string]interface{}{"id": i, "name": "user"},
+		}
+		for j := 0; j < padding; j++ {
+			source[fmt.Sprintf("field%d", j)] = map[string]interface{}{"value": j, "text": "padding text of the doc"}
+		}
+		docs = append(docs, map[string]interface{}{
+			"_index":  "logs",
+			"_id":     fmt.Sprintf("%d", i),
+			"_score":  1.0,
+			"_source": source,
+		})
+	}
+	body, _ := json.Marshal(map[string]interface{}{
+		"_scroll_id": "scroll",
+		"took":       3,
+		"hits": map[string]interface{}{
+			"total": map[string]interface{}{"value": hits, "relation": "eq"},
+			"hits":  docs,
+		},
+	})
+	return body
+}
+
+// BenchmarkDecodeScrollResult compares decoding the hits through mapstructure with decoding them right into Doc,
+// with encoding/json and with the codec of the build. Run it with -tags go_json or -tags jsoniter to measure a
+// faster codec.
+func BenchmarkDecodeScrollResult(b *testing.B) {
+	body := newScrollBody(1000)
+
+	b.Run("mapstructure", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			var scrollResult struct {
+				Hits struct {
+					Docs []interface{} `json:"hits"`
+				} `json:"hits"`
+			}
+			if err := json.NewDecoder(bytes.NewReader(body)).Decode(&scrollResult); err != nil {
+				b.Fatal(err)
+			}
+			for _, hit := range scrollResult.Hits.Docs {
+				var hitDoc Doc
+				_ = mapstructure.Decode(hit, &hitDoc)
+			}
+		}
+	})
+
+	b.Run("encoding/json", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			var scrollResult ScrollResultV7
+			if err := json.NewDecoder(bytes.NewReader(body)).Decode(&scrollResult); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("codec", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			var scrollResult ScrollResultV7
+			if err := decodeResponse(bytes.NewReader(body), &scrollResult); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestDecodeScrollResponse(t *testing.T) {
+	body := newScrollBody(100)
+	var expected ScrollResultV7
+	if err := decodeResponse(bytes.NewReader(body), &expected); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, concurrency := range []int{1, 3, 8, 200} {
+		scrollResult, err := decodeScrollResponse(bytes.NewReader(body), concurrency)
+		if err != nil {
+			t.Fatalf("concurrency %d: %+v", concurrency, err)
+		}
+		if scrollResult.Total != 100 || scrollResult.ScrollId != "scroll" {
+			t.Errorf("concurrency %d: unexpected total %d and scroll id %s", concurrency, scrollResult.Total,
+				scrollResult.ScrollId)
+		}
+		if !reflect.DeepEqual(scrollResult.Docs, expected.Hits.Docs) {
+			t.Errorf("concurrency %d: expect the docs of the serial decode in the page order", concurrency)
+		}
+	}
+
+	// before 7.x the total is a plain number
+	scrollResult, err := decodeScrollResponse(
+		strings.NewReader(`{"_scroll_id":"s","hits":{"total":2,"hits":[{"_type":"doc","_id":"a"},{"_type":"doc","_id":"b"}]}}`), 2)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if scrollResult.Total != 2 || len(scrollResult.Docs) != 2 || scrollResult.Docs[1].ID != "b" ||
+		scrollResult.Docs[1].Type != "doc" {
+		t.Errorf("unexpected result of a 6.x answer %+v", scrollResult)
+	}
+
+	if _, err := decodeScrollResponse(strings.NewReader(`{"hits":{"total":1,"hits":[{"_id":1}]}}`), 2); err == nil {
+		t.Errorf("expect the error of an invalid hit")
+	}
+}
+
+// BenchmarkDecodeScrollConcurrency compares the serial decode of a page of 10k large docs with the concurrent one
+func BenchmarkDecodeScrollConcurrency(b *testing.B) {
+	body := newPaddedScrollBody(10000, 20)
+
+	b.Run("serial", func(b *testing.B) {
+		b.SetBytes(int64(len(body)))
+		for i := 0; i < b.N; i++ {
+			var scrollResult ScrollResultV7
+			if err := decodeResponse(bytes.NewReader(body), &scrollResult); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	for _, concurrency := range []int{2, 4, runtime.NumCPU()} {
+		b.Run(fmt.Sprintf("concurrency-%d", concurrency), func(b *testing.B) {
+			b.SetBytes(int64(len(body)))
+			for i := 0; i < b.N; i++ {
+				if _, err := decodeScrollResponse(bytes.NewReader(body), concurrency); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,7 @@
+//go:build jsoniter
+
+package es
+
+import jsoniter "github.com/json-iterator/go"
+
+var unmarshalJSON = jsoniter.ConfigCompatibleWithStandardLibrary.Unmarshal
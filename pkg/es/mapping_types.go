@@ -0,0 +1,153 @@
+package es
+
+import (
+	"github.com/CharellKing/ela-lib/config"
+	"github.com/CharellKing/ela-lib/utils"
+	"github.com/samber/lo"
+	path "github.com/segment-boneyard/go-map-path"
+	"github.com/spf13/cast"
+	"sort"
+	"strings"
+)
+
+const (
+	// v5MappingType names the type of a typeless mapping on 5.x, which forbids a leading underscore
+	v5MappingType = "doc"
+	// v6MappingType names the type of a typeless mapping on 6.x, as 7.x names its implicit type
+	v6MappingType = "_doc"
+)
+
+// typelessMappingKeys are the root keys of a typeless mapping, a typed mapping has its type names there instead
+var typelessMappingKeys = []string{"properties", "dynamic", "dynamic_templates", "date_detection",
+	"numeric_detection", "runtime", "enabled", "_source", "_routing", "_meta", "_all", "_field_names"}
+
+func isTypelessMapping(mappings map[string]interface{}) bool {
+	for key := range mappings {
+		if lo.Contains(typelessMappingKeys, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// mappingTypes returns the sorted type names of unwrapped mappings, none for a typeless mapping
+func mappingTypes(mappings map[string]interface{}) []string {
+	if isTypelessMapping(mappings) {
+		return nil
+	}
+	types := lo.Keys(mappings)
+	sort.Strings(types)
+	return types
+}
+
+// portableMappingKeys are the root keys of a mapping besides the properties which every version accepts, they are
+// kept verbatim when the mapping types are flattened
+var portableMappingKeys = []string{"_meta", "dynamic", "dynamic_templates", "date_detection", "numeric_detection"}
+
+// flattenMappingTypes returns the typeless mapping of unwrapped mappings, typed or not. The properties of several
+// types are merged, TranslateMappingTypes decides before whether such an index may be created. The portable root
+// keys are taken from the type with the most properties which has them.
+func flattenMappingTypes(mappings map[string]interface{}) map[string]interface{} {
+	if isTypelessMapping(mappings) {
+		mappings = map[string]interface{}{v6MappingType: mappings}
+	}
+
+	var typeMappingArray []map[string]interface{}
+	for _, typeName := range lo.Keys(mappings) {
+		typeMapping := cast.ToStringMap(mappings[typeName])
+		enabled := path.Path(typeMapping, "_source.enabled")
+		if enabled != nil && cast.ToBool(enabled) == false {
+			continue
+		}
+		typeMappingArray = append(typeMappingArray, typeMapping)
+	}
+
+	sort.SliceStable(typeMappingArray, func(i, j int) bool {
+		return len(cast.ToStringMap(typeMappingArray[i]["properties"])) > len(cast.ToStringMap(typeMappingArray[j]["properties"]))
+	})
+
+	mergedProperties := make(map[string]interface{})
+	for _, typeMapping := range typeMappingArray {
+		for key, value := range cast.ToStringMap(typeMapping["properties"]) {
+			mergedProperties[key] = value
+		}
+	}
+
+	flattened := map[string]interface{}{
+		"properties": mergedProperties,
+	}
+	for _, key := range portableMappingKeys {
+		for _, typeMapping := range typeMappingArray {
+			if value, ok := typeMapping[key]; ok {
+				flattened[key] = value
+				break
+			}
+		}
+	}
+	return flattened
+}
+
+// wrapMappingType returns unwrapped mappings typed as mappingType when they are typeless, typed mappings are kept,
+// so a mapping is never wrapped twice
+func wrapMappingType(mappings map[string]interface{}, mappingType string) map[string]interface{} {
+	if !isTypelessMapping(mappings) {
+		return mappings
+	}
+	return map[string]interface{}{mappingType: mappings}
+}
+
+// TranslateMappingTypes fits the mapping types of the source index to the target version before the index is
+// created. From 6.x on an index has one type, a multi-type source fails or keeps its first type by policy and the
+// dropped types are returned. On a typed target the mapping is named targetType when it is set, like the docs.
+func TranslateMappingTypes(esSetting IESSettings, targetVersion string, targetType string,
+	policy config.MultiTypePolicy) (IESSettings, []string, error) {
+	index := esSetting.GetIndex()
+	mappings := cast.ToStringMap(cast.ToStringMap(esSetting.GetMappings()[index])["mappings"])
+	types := mappingTypes(mappings)
+	typedTarget := strings.HasPrefix(targetVersion, "5.") || strings.HasPrefix(targetVersion, "6.")
+
+	translated := false
+	var droppedTypes []string
+	if len(types) > 1 && !strings.HasPrefix(targetVersion, "5.") {
+		if policy != config.MultiTypePolicyFirst {
+			return nil, nil, utils.NewCustomError(utils.MultipleMappingTypes,
+				"index %s has the mapping types %v, es %s allows one", index, types, targetVersion)
+		}
+		droppedTypes = types[1:]
+		mappings = map[string]interface{}{types[0]: mappings[types[0]]}
+		types = types[:1]
+		translated = true
+	}
+
+	if typedTarget && targetType != "" {
+		if isTypelessMapping(mappings) {
+			mappings = wrapMappingType(mappings, targetType)
+			translated = true
+		} else if len(types) == 1 && types[0] != targetType {
+			mappings = map[string]interface{}{targetType: mappings[types[0]]}
+			translated = true
+		}
+	}
+
+	if !translated {
+		return esSetting, nil, nil
+	}
+	return withMappings(esSetting, map[string]interface{}{
+		index: map[string]interface{}{"mappings": mappings},
+	}), droppedTypes, nil
+}
+
+// withMappings returns a copy of the settings of the same version with the mappings replaced
+func withMappings(esSetting IESSettings, mappings map[string]interface{}) IESSettings {
+	switch setting := esSetting.(type) {
+	case *V8Settings:
+		return NewV8Settings(setting.Settings, mappings, setting.Aliases, setting.SourceIndex)
+	case *V7Settings:
+		return NewV7Settings(setting.Settings, mappings, setting.Aliases, setting.SourceIndex)
+	case *V6Settings:
+		return NewV6Settings(setting.Settings, mappings, setting.Aliases, setting.SourceIndex)
+	case *V5Settings:
+		return NewV5Settings(setting.Settings, mappings, setting.Aliases, setting.SourceIndex)
+	}
+	return esSetting
+}
@@ -0,0 +1,114 @@
+package es
+
+import (
+	"encoding/json"
+	"github.com/CharellKing/ela-lib/config"
+	"github.com/CharellKing/ela-lib/utils"
+	"reflect"
+	"testing"
+)
+
+func decodeMapping(t *testing.T, mapping string) map[string]interface{} {
+	var decoded map[string]interface{}
+	if err := json.Unmarshal([]byte(mapping), &decoded); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	return decoded
+}
+
+func targetMappings(esSetting IESSettings, targetVersion string) map[string]interface{} {
+	switch targetVersion[:2] {
+	case "5.":
+		return esSetting.ToTargetV5Settings("b").GetMappings()
+	case "6.":
+		return esSetting.ToTargetV6Settings("b").GetMappings()
+	case "7.":
+		return esSetting.ToTargetV7Settings("b").GetMappings()
+	}
+	return esSetting.ToTargetV8Settings("b").GetMappings()
+}
+
+func TestTranslateMappingTypes(t *testing.T) {
+	const (
+		properties     = `{"properties":{"name":{"type":"keyword"}}}`
+		typeless       = `{"a":{"mappings":` + properties + `}}`
+		singleType     = `{"a":{"mappings":{"_doc":` + properties + `}}}`
+		multiType      = `{"a":{"mappings":{"user":` + properties + `,"tweet":{"properties":{"text":{"type":"text"}}}}}}`
+		typelessTarget = `{"mappings":` + properties + `}`
+	)
+	cases := []struct {
+		name          string
+		source        string
+		targetVersion string
+		targetType    string
+		policy        config.MultiTypePolicy
+		expected      string
+		droppedTypes  []string
+	}{
+		{"single type to typeless", singleType, "7.17.9", "", "", typelessTarget, nil},
+		{"single type to typed", singleType, "6.8.23", "", "", `{"mappings":{"_doc":` + properties + `}}`, nil},
+		{"single type renamed", singleType, "6.8.23", "doc", "", `{"mappings":{"doc":` + properties + `}}`, nil},
+		{"typeless to typeless", typeless, "8.12.2", "", "", typelessTarget, nil},
+		{"typeless to 6.x", typeless, "6.8.23", "", "", `{"mappings":{"_doc":` + properties + `}}`, nil},
+		{"typeless to 5.x", typeless, "5.6.16", "", "", `{"mappings":{"doc":` + properties + `}}`, nil},
+		{"typeless to target type", typeless, "6.8.23", "log", "", `{"mappings":{"log":` + properties + `}}`, nil},
+		{"multi type to 5.x", multiType, "5.6.16", "", "",
+			`{"mappings":{"user":` + properties + `,"tweet":{"properties":{"text":{"type":"text"}}}}}`, nil},
+		{"multi type first to typed", multiType, "6.8.23", "", config.MultiTypePolicyFirst,
+			`{"mappings":{"tweet":{"properties":{"text":{"type":"text"}}}}}`, []string{"user"}},
+		{"multi type first to typeless", multiType, "7.17.9", "", config.MultiTypePolicyFirst,
+			`{"mappings":{"properties":{"text":{"type":"text"}}}}`, []string{"user"}},
+	}
+	for _, c := range cases {
+		sourceSetting := NewV6Settings(nil, decodeMapping(t, c.source), nil, "a")
+		esSetting, droppedTypes, err := TranslateMappingTypes(sourceSetting, c.targetVersion, c.targetType, c.policy)
+		if err != nil {
+			t.Fatalf("%s %+v", c.name, err)
+		}
+		if !reflect.DeepEqual(droppedTypes, c.droppedTypes) {
+			t.Errorf("%s expect the dropped types %v, got %v", c.name, c.droppedTypes, droppedTypes)
+		}
+		if mappings := targetMappings(esSetting, c.targetVersion); !reflect.DeepEqual(mappings, decodeMapping(t, c.expected)) {
+			t.Errorf("%s expect the mappings %s, got %v", c.name, c.expected, mappings)
+		}
+	}
+
+	// a multi-type source fails by default on every version allowing one type
+	for _, targetVersion := range []string{"6.8.23", "7.17.9", "8.12.2"} {
+		sourceSetting := NewV5Settings(nil, decodeMapping(t, multiType), nil, "a")
+		if _, _, err := TranslateMappingTypes(sourceSetting, targetVersion, "", ""); !utils.IsCustomError(err, utils.MultipleMappingTypes) {
+			t.Errorf("%s expect a multiple mapping types error, got %v", targetVersion, err)
+		}
+	}
+}
+
+func TestMappingMetaAndDynamicTemplates(t *testing.T) {
+	const typeless = `{"_meta":{"owner":"search","version":3},"dynamic":"strict",` +
+		`"dynamic_templates":[{"ids":{"match":"*_id","mapping":{"type":"keyword"}}},` +
+		`{"strings":{"match_mapping_type":"string","mapping":{"type":"text"}}}],` +
+		`"properties":{"name":{"type":"keyword"}}}`
+	cases := []struct {
+		name          string
+		sourceSetting IESSettings
+		targetVersion string
+		expected      string
+	}{
+		{"6.x to 6.x", NewV6Settings(nil, decodeMapping(t, `{"a":{"mappings":{"_doc":`+typeless+`}}}`), nil, "a"),
+			"6.8.23", `{"mappings":{"_doc":` + typeless + `}}`},
+		{"7.x to 7.x", NewV7Settings(nil, decodeMapping(t, `{"a":{"mappings":`+typeless+`}}`), nil, "a"),
+			"7.17.9", `{"mappings":` + typeless + `}`},
+		{"6.x to 7.x", NewV6Settings(nil, decodeMapping(t, `{"a":{"mappings":{"_doc":`+typeless+`}}}`), nil, "a"),
+			"7.17.9", `{"mappings":` + typeless + `}`},
+		{"5.x to 8.x", NewV5Settings(nil, decodeMapping(t, `{"a":{"mappings":{"doc":`+typeless+`}}}`), nil, "a"),
+			"8.12.2", `{"mappings":` + typeless + `}`},
+	}
+	for _, c := range cases {
+		esSetting, _, err := TranslateMappingTypes(c.sourceSetting, c.targetVersion, "", "")
+		if err != nil {
+			t.Fatalf("%s %+v", c.name, err)
+		}
+		if mappings := targetMappings(esSetting, c.targetVersion); !reflect.DeepEqual(mappings, decodeMapping(t, c.expected)) {
+			t.Errorf("%s expect the mappings %s, got %v", c.name, c.expected, mappings)
+		}
+	}
+}
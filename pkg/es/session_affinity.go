@@ -0,0 +1,84 @@
+package es
+
+import (
+	"sync"
+	"time"
+)
+
+const defaultSessionAffinityTTL = 10 * time.Minute
+
+type sessionAffinityEntry struct {
+	address   string
+	expiredAt time.Time
+}
+
+// SessionAffinity remembers which address opened a scroll or point in time, since their ids are only valid there.
+// Entries expire after ttl without use.
+type SessionAffinity struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]*sessionAffinityEntry
+}
+
+func NewSessionAffinity(ttl time.Duration) *SessionAffinity {
+	return &SessionAffinity{
+		ttl:     ttl,
+		entries: make(map[string]*sessionAffinityEntry),
+	}
+}
+
+func (s *SessionAffinity) Get(id string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry, ok := s.entries[id]
+	if !ok {
+		return "", false
+	}
+
+	now := time.Now()
+	if now.After(entry.expiredAt) {
+		delete(s.entries, id)
+		return "", false
+	}
+	entry.expiredAt = now.Add(s.ttl)
+	return entry.address, true
+}
+
+func (s *SessionAffinity) Set(id string, address string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for key, entry := range s.entries {
+		if now.After(entry.expiredAt) {
+			delete(s.entries, key)
+		}
+	}
+
+	s.entries[id] = &sessionAffinityEntry{
+		address:   address,
+		expiredAt: now.Add(s.ttl),
+	}
+}
+
+func (s *SessionAffinity) Delete(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.entries, id)
+}
+
+func (s *SessionAffinity) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.entries)
+}
+
+func (s *SessionAffinity) Clear() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.entries = make(map[string]*sessionAffinityEntry)
+}
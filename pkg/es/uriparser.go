@@ -53,4 +53,14 @@ const (
 	RequestActionTypeMGetDocument            RequestActionType = "mgetDocument"
 	RequestActionTypeSearchDocument          RequestActionType = "searchDocument"
 	RequestActionTypeSearchDocumentWithLimit RequestActionType = "searchDocumentWithLimit"
+	RequestActionTypeCountDocument           RequestActionType = "countDocument"
+
+	// scroll ids only live on the cluster that opened them, so scroll actions always go to the master and
+	// their responses are never compared with the slave.
+	RequestActionTypeSearchScroll         RequestActionType = "searchScroll"
+	RequestActionTypeSearchScrollWithBody RequestActionType = "searchScrollWithBody"
+	RequestActionTypeClearScroll          RequestActionType = "clearScroll"
+
+	RequestActionTypeOpenPointInTime  RequestActionType = "openPointInTime"
+	RequestActionTypeClosePointInTime RequestActionType = "closePointInTime"
 )
@@ -0,0 +1,101 @@
+package gateway
+
+import (
+	"crypto/subtle"
+	"github.com/CharellKing/ela-lib/config"
+	"github.com/CharellKing/ela-lib/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/samber/lo"
+	"net/http"
+	"strings"
+)
+
+// authenticator reports whether the request carries valid credentials of one scheme
+type authenticator func(c *gin.Context) bool
+
+func basicAuthenticator(username, password string) authenticator {
+	return func(c *gin.Context) bool {
+		user, pass, hasAuth := c.Request.BasicAuth()
+		return hasAuth && user == username && pass == password
+	}
+}
+
+// tokenAuthenticator accepts `Authorization: <scheme> <token>` when the token is one of tokens
+func tokenAuthenticator(scheme string, tokens []string) authenticator {
+	return func(c *gin.Context) bool {
+		requestScheme, token, found := strings.Cut(c.GetHeader("Authorization"), " ")
+		if !found || !strings.EqualFold(requestScheme, scheme) {
+			return false
+		}
+
+		token = strings.TrimSpace(token)
+		return lo.ContainsBy(tokens, func(expected string) bool {
+			return subtle.ConstantTimeCompare([]byte(token), []byte(expected)) == 1
+		})
+	}
+}
+
+func authMiddleware(authenticators []authenticator, challengeBasic bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		for _, auth := range authenticators {
+			if auth(c) {
+				c.Next()
+				return
+			}
+		}
+
+		if challengeBasic {
+			c.Header("WWW-Authenticate", `Basic realm="Restricted"`)
+		}
+		c.AbortWithStatus(http.StatusUnauthorized)
+	}
+}
+
+func basicAuth(username, password string) gin.HandlerFunc {
+	return authMiddleware([]authenticator{basicAuthenticator(username, password)}, true)
+}
+
+// inboundAuth builds the middleware of the configured auth schemes
+func inboundAuth(cfg *config.GatewayCfg) (gin.HandlerFunc, error) {
+	schemes := lo.Uniq(cfg.AuthSchemes)
+	if len(schemes) == 0 {
+		schemes = []config.AuthScheme{config.AuthSchemeBasic}
+	}
+
+	var authenticators []authenticator
+	for _, scheme := range schemes {
+		switch config.AuthScheme(strings.ToLower(string(scheme))) {
+		case config.AuthSchemeBasic:
+			password, err := utils.ResolveSecret(cfg.Password)
+			if err != nil {
+				return nil, err
+			}
+			authenticators = append(authenticators, basicAuthenticator(cfg.User, password))
+		case config.AuthSchemeApiKey:
+			if len(cfg.ApiKeys) == 0 {
+				return nil, utils.NewCustomError(utils.InvalidParams, "auth scheme %s requires api_keys", scheme)
+			}
+			apiKeys, err := utils.ResolveSecrets(cfg.ApiKeys)
+			if err != nil {
+				return nil, err
+			}
+			authenticators = append(authenticators, tokenAuthenticator("ApiKey", apiKeys))
+		case config.AuthSchemeBearer:
+			if len(cfg.BearerTokens) == 0 {
+				return nil, utils.NewCustomError(utils.InvalidParams, "auth scheme %s requires bearer_tokens", scheme)
+			}
+			bearerTokens, err := utils.ResolveSecrets(cfg.BearerTokens)
+			if err != nil {
+				return nil, err
+			}
+			authenticators = append(authenticators, tokenAuthenticator("Bearer", bearerTokens))
+		default:
+			return nil, utils.NewCustomError(utils.InvalidParams, "invalid auth scheme %s", scheme)
+		}
+	}
+
+	challengeBasic := lo.ContainsBy(schemes, func(scheme config.AuthScheme) bool {
+		return strings.EqualFold(string(scheme), string(config.AuthSchemeBasic))
+	})
+	return authMiddleware(authenticators, challengeBasic), nil
+}
@@ -0,0 +1,111 @@
+package gateway
+
+import (
+	"github.com/CharellKing/ela-lib/config"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestInboundAuth(t *testing.T) {
+	auth, err := inboundAuth(&config.GatewayCfg{
+		User:         "user",
+		Password:     "password",
+		AuthSchemes:  []config.AuthScheme{config.AuthSchemeBasic, config.AuthSchemeApiKey, config.AuthSchemeBearer},
+		ApiKeys:      []string{"a2V5OnNlY3JldA=="},
+		BearerTokens: []string{"token"},
+	})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	engine := gin.New()
+	engine.GET("/", auth, func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	for _, testCase := range []struct {
+		name           string
+		setAuth        func(r *http.Request)
+		expectedStatus int
+	}{
+		{"basic", func(r *http.Request) { r.SetBasicAuth("user", "password") }, http.StatusOK},
+		{"basic wrong password", func(r *http.Request) { r.SetBasicAuth("user", "wrong") }, http.StatusUnauthorized},
+		{"apikey", func(r *http.Request) { r.Header.Set("Authorization", "ApiKey a2V5OnNlY3JldA==") }, http.StatusOK},
+		{"apikey wrong key", func(r *http.Request) { r.Header.Set("Authorization", "ApiKey wrong") }, http.StatusUnauthorized},
+		{"bearer", func(r *http.Request) { r.Header.Set("Authorization", "Bearer token") }, http.StatusOK},
+		{"bearer as apikey", func(r *http.Request) { r.Header.Set("Authorization", "ApiKey token") }, http.StatusUnauthorized},
+		{"none", func(r *http.Request) {}, http.StatusUnauthorized},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		testCase.setAuth(req)
+		recorder := httptest.NewRecorder()
+		engine.ServeHTTP(recorder, req)
+		if recorder.Code != testCase.expectedStatus {
+			t.Errorf("%s status %d, expected %d", testCase.name, recorder.Code, testCase.expectedStatus)
+		}
+	}
+}
+
+func TestInboundAuthOnlyConfiguredSchemes(t *testing.T) {
+	auth, err := inboundAuth(&config.GatewayCfg{
+		User:         "user",
+		Password:     "password",
+		AuthSchemes:  []config.AuthScheme{config.AuthSchemeBearer},
+		BearerTokens: []string{"token"},
+	})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	engine := gin.New()
+	engine.GET("/", auth, func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.SetBasicAuth("user", "password")
+	recorder := httptest.NewRecorder()
+	engine.ServeHTTP(recorder, req)
+	if recorder.Code != http.StatusUnauthorized {
+		t.Errorf("basic auth is accepted while only bearer is configured, status %d", recorder.Code)
+	}
+
+	if _, err := inboundAuth(&config.GatewayCfg{AuthSchemes: []config.AuthScheme{config.AuthSchemeApiKey}}); err == nil {
+		t.Errorf("apikey scheme without api_keys is accepted")
+	}
+}
+
+func TestInboundAuthSecretReference(t *testing.T) {
+	t.Setenv("ELA_TEST_GATEWAY_TOKEN", "env-token")
+	auth, err := inboundAuth(&config.GatewayCfg{
+		AuthSchemes:  []config.AuthScheme{config.AuthSchemeBearer},
+		BearerTokens: []string{"${ENV:ELA_TEST_GATEWAY_TOKEN}"},
+	})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	engine := gin.New()
+	engine.GET("/", auth, func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	for token, expectedStatus := range map[string]int{
+		"env-token":                     http.StatusOK,
+		"${ENV:ELA_TEST_GATEWAY_TOKEN}": http.StatusUnauthorized,
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		recorder := httptest.NewRecorder()
+		engine.ServeHTTP(recorder, req)
+		if recorder.Code != expectedStatus {
+			t.Errorf("token %s status %d, expected %d", token, recorder.Code, expectedStatus)
+		}
+	}
+
+	if _, err := inboundAuth(&config.GatewayCfg{
+		AuthSchemes:  []config.AuthScheme{config.AuthSchemeBearer},
+		BearerTokens: []string{"${ENV:ELA_TEST_GATEWAY_TOKEN_UNSET}"},
+	}); err == nil {
+		t.Fatalf("expect an error for an unset env")
+	}
+}
@@ -0,0 +1,233 @@
+package gateway
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/CharellKing/ela-lib/pkg/es"
+	"github.com/CharellKing/ela-lib/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"github.com/samber/lo"
+	"github.com/spf13/cast"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+)
+
+// bulkSplitAction is an action of a split bulk body, its item is answered at the same position of the response
+type bulkSplitAction struct {
+	actionType string
+	index      string
+}
+
+// bulkGroup is the part of a bulk body sent to one upstream, positions are the places of its actions in the body
+type bulkGroup struct {
+	// prefix is the index route of the upstream, the master has none
+	prefix    string
+	routed    bool
+	body      bytes.Buffer
+	positions []int
+}
+
+// splitBulkBody groups the actions of a bulk body by the upstream of their index, an action without `_index` has
+// the index of the uri. The master group comes first, the routed ones in the order they first appear. The lines are
+// kept as they are.
+func (gateway *ESGateway) splitBulkBody(bodyBytes []byte, defaultIndex string) ([]*bulkGroup, []*bulkSplitAction, error) {
+	var (
+		groups      []*bulkGroup
+		groupMap    = make(map[string]*bulkGroup)
+		actions     []*bulkSplitAction
+		sourceGroup *bulkGroup
+	)
+
+	reader := bufio.NewReader(bytes.NewReader(bodyBytes))
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			if line[len(line)-1] != '\n' {
+				line = append(line, '\n')
+			}
+
+			if sourceGroup != nil {
+				sourceGroup.body.Write(line)
+				sourceGroup = nil
+			} else {
+				action, err := parseBulkSplitAction(line, defaultIndex)
+				if err != nil {
+					return nil, nil, errors.WithStack(err)
+				}
+
+				prefix, routed := gateway.routePrefix(action.index)
+				key := lo.Ternary(routed, "route:"+prefix, "master")
+				group, ok := groupMap[key]
+				if !ok {
+					group = &bulkGroup{prefix: prefix, routed: routed}
+					groupMap[key] = group
+					groups = append(groups, group)
+				}
+				group.body.Write(line)
+				group.positions = append(group.positions, len(actions))
+				actions = append(actions, action)
+				// every action but delete is followed by its source
+				if action.actionType != "delete" {
+					sourceGroup = group
+				}
+			}
+		}
+
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, errors.WithStack(err)
+		}
+	}
+	if sourceGroup != nil {
+		return nil, nil, errors.New("the bulk body ends without the source of its last action")
+	}
+
+	sort.SliceStable(groups, func(i, j int) bool { return !groups[i].routed && groups[j].routed })
+	return groups, actions, nil
+}
+
+func parseBulkSplitAction(line []byte, defaultIndex string) (*bulkSplitAction, error) {
+	var actionMap map[string]struct {
+		Index string `json:"_index"`
+	}
+	if err := json.Unmarshal(line, &actionMap); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if len(actionMap) != 1 {
+		return nil, errors.Errorf("malformed bulk action %s", bytes.TrimSpace(line))
+	}
+
+	for actionType, metadata := range actionMap {
+		return &bulkSplitAction{
+			actionType: actionType,
+			index:      lo.Ternary(metadata.Index == "", defaultIndex, metadata.Index),
+		}, nil
+	}
+	return nil, nil
+}
+
+// splitBulk sends the actions of a bulk body to the upstreams of their indices and answers the items in the order
+// of the body. The master part is replayed to the slave like any master write, the routed parts are sent after the
+// master answered and are not replayed. An upstream which fails answers an error item for each of its actions.
+func (gateway *ESGateway) splitBulk(c *gin.Context, parseUriResult *es.UriPathParserResult) {
+	bodyReader := &limitedReader{reader: c.Request.Body, remain: gateway.MaxBodySize}
+	var bodyBuffer bytes.Buffer
+	if _, err := bodyBuffer.ReadFrom(bodyReader); err != nil {
+		gateway.abortWithBodyError(c, bodyReader, err)
+		return
+	}
+
+	groups, actions, err := gateway.splitBulkBody(bodyBuffer.Bytes(), parseUriResult.VariableMap["index"])
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("invalid bulk body: %s", err.Error()),
+		})
+		return
+	}
+
+	// a body of one upstream is served as it would be without the split
+	if len(groups) <= 1 {
+		c.Request.Body = io.NopCloser(bytes.NewReader(bodyBuffer.Bytes()))
+		if len(groups) == 1 && groups[0].routed {
+			gateway.proxy(c, gateway.IndexRoutes[groups[0].prefix], parseUriResult)
+			return
+		}
+		if resp, statusCode, ok := gateway.serveMaster(c, parseUriResult); ok {
+			c.JSON(statusCode, resp)
+		}
+		return
+	}
+
+	responses := make([]map[string]interface{}, len(groups))
+	statusCodes := make([]int, len(groups))
+	errs := make([]error, len(groups))
+	if !groups[0].routed {
+		c.Request.Body = io.NopCloser(bytes.NewReader(groups[0].body.Bytes()))
+		resp, statusCode, ok := gateway.serveMaster(c, parseUriResult)
+		if !ok {
+			return
+		}
+		responses[0], statusCodes[0] = resp, statusCode
+	}
+
+	var wg sync.WaitGroup
+	for i, group := range groups {
+		if !group.routed {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			upstream := gateway.IndexRoutes[group.prefix]
+			resp, statusCode, err := gateway.requestUpstream(c, upstream, bytes.NewReader(group.body.Bytes()), parseUriResult)
+			if err != nil {
+				errs[i] = errors.WithStack(err)
+				return
+			}
+			responses[i] = translateResponse(upstream.GetClusterVersion(), gateway.SourceES.GetClusterVersion(),
+				parseUriResult.RequestAction, resp)
+			statusCodes[i] = statusCode
+		}()
+	}
+	wg.Wait()
+
+	c.JSON(http.StatusOK, mergeBulkResponses(c, groups, actions, responses, statusCodes, errs))
+}
+
+// mergeBulkResponses puts the items of every group at the positions of its actions, the response has errors when
+// any group has and took the longest time of the groups
+func mergeBulkResponses(c *gin.Context, groups []*bulkGroup, actions []*bulkSplitAction,
+	responses []map[string]interface{}, statusCodes []int, errs []error) map[string]interface{} {
+	var (
+		items     = make([]interface{}, len(actions))
+		hasErrors bool
+		took      int64
+	)
+	for i, group := range groups {
+		groupItems, _ := responses[i]["items"].([]interface{})
+		if errs[i] == nil && statusCodes[i] < 300 && len(groupItems) == len(group.positions) {
+			for j, position := range group.positions {
+				items[position] = groupItems[j]
+			}
+			hasErrors = hasErrors || cast.ToBool(responses[i]["errors"])
+			took = max(took, cast.ToInt64(responses[i]["took"]))
+			continue
+		}
+
+		reason := lo.TernaryF(errs[i] != nil,
+			func() string { return errs[i].Error() },
+			func() string { return fmt.Sprintf("upstream response status %d: %+v", statusCodes[i], responses[i]) })
+		utils.GetLogger(c).Errorf("split bulk upstream %q: %s", group.prefix, reason)
+		status := lo.Ternary(statusCodes[i] >= 300, statusCodes[i], http.StatusBadGateway)
+		if errors.Is(errs[i], context.DeadlineExceeded) {
+			status = http.StatusGatewayTimeout
+		}
+		for _, position := range group.positions {
+			items[position] = map[string]interface{}{
+				actions[position].actionType: map[string]interface{}{
+					"_index": actions[position].index,
+					"status": status,
+					"error": map[string]interface{}{
+						"type":   "upstream_exception",
+						"reason": reason,
+					},
+				},
+			}
+		}
+		hasErrors = true
+	}
+
+	return map[string]interface{}{
+		"took":   took,
+		"errors": hasErrors,
+		"items":  items,
+	}
+}
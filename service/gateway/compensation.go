@@ -0,0 +1,53 @@
+package gateway
+
+import (
+	"encoding/json"
+	"github.com/CharellKing/ela-lib/pkg/es"
+	"github.com/pkg/errors"
+	"os"
+	"sync"
+	"time"
+)
+
+// FailedWrite is a slave write the master accepted but the slave did not, kept to compensate later
+type FailedWrite struct {
+	Time          time.Time            `json:"time"`
+	RequestAction es.RequestActionType `json:"request_action"`
+	VariableMap   map[string]string    `json:"variable_map"`
+	RawQuery      string               `json:"raw_query,omitempty"`
+	Body          string               `json:"body"`
+	Error         string               `json:"error"`
+}
+
+type CompensationQueue struct {
+	mutex sync.Mutex
+	file  string
+}
+
+func NewCompensationQueue(file string) *CompensationQueue {
+	return &CompensationQueue{file: file}
+}
+
+// Push appends the failed write as a json line
+func (queue *CompensationQueue) Push(failedWrite *FailedWrite) error {
+	line, err := json.Marshal(failedWrite)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+
+	file, err := os.OpenFile(queue.file, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package gateway
+
+import (
+	"github.com/CharellKing/ela-lib/config"
+	"github.com/gin-gonic/gin"
+	"github.com/samber/lo"
+	"github.com/spf13/cast"
+	"net/http"
+	"strings"
+)
+
+var (
+	defaultCORSMethods = []string{http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodDelete}
+	defaultCORSHeaders = []string{"Authorization", "Content-Type"}
+)
+
+// cors answers the preflight requests before they reach the auth and the proxy
+func cors(cfg *config.CORSCfg) gin.HandlerFunc {
+	allowAnyOrigin := lo.Contains(cfg.AllowedOrigins, "*")
+	methods := strings.Join(lo.Ternary(len(cfg.AllowedMethods) > 0, cfg.AllowedMethods, defaultCORSMethods), ", ")
+	headers := strings.Join(lo.Ternary(len(cfg.AllowedHeaders) > 0, cfg.AllowedHeaders, defaultCORSHeaders), ", ")
+
+	return func(c *gin.Context) {
+		origin := c.GetHeader("Origin")
+		if origin == "" {
+			c.Next()
+			return
+		}
+
+		preflight := c.Request.Method == http.MethodOptions && c.GetHeader("Access-Control-Request-Method") != ""
+		if !allowAnyOrigin && !lo.Contains(cfg.AllowedOrigins, origin) {
+			if preflight {
+				c.AbortWithStatus(http.StatusForbidden)
+				return
+			}
+			c.Next()
+			return
+		}
+
+		// credentials are not allowed with a wildcard origin, the origin is echoed then
+		if allowAnyOrigin && !cfg.AllowCredentials {
+			c.Header("Access-Control-Allow-Origin", "*")
+		} else {
+			c.Header("Access-Control-Allow-Origin", origin)
+			c.Writer.Header().Add("Vary", "Origin")
+		}
+		if cfg.AllowCredentials {
+			c.Header("Access-Control-Allow-Credentials", "true")
+		}
+
+		if !preflight {
+			c.Next()
+			return
+		}
+
+		c.Header("Access-Control-Allow-Methods", methods)
+		c.Header("Access-Control-Allow-Headers", headers)
+		if cfg.MaxAge > 0 {
+			c.Header("Access-Control-Max-Age", cast.ToString(cfg.MaxAge))
+		}
+		c.AbortWithStatus(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,57 @@
+package gateway
+
+import (
+	"github.com/CharellKing/ela-lib/config"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGatewayCORSPreflight(t *testing.T) {
+	masterES := newMockES(t, "7.10.2", okHandler)
+	gateway := &ESGateway{
+		Engine: gin.New(),
+		Auth:   basicAuth("user", "password"),
+		CORS: &config.CORSCfg{
+			AllowedOrigins: []string{"http://dashboard.local"},
+			AllowedHeaders: []string{"Authorization", "Content-Type", "kbn-xsrf"},
+			MaxAge:         600,
+		},
+		MasterES: masterES,
+		SlaveES:  masterES,
+		SourceES: masterES,
+		TargetES: masterES,
+	}
+	gateway.onRequest()
+
+	preflight := func(origin string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodOptions, "/a/_search", nil)
+		req.Header.Set("Origin", origin)
+		req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+		req.Header.Set("Access-Control-Request-Headers", "authorization,content-type")
+		recorder := httptest.NewRecorder()
+		gateway.Engine.ServeHTTP(recorder, req)
+		return recorder
+	}
+
+	recorder := preflight("http://dashboard.local")
+	if recorder.Code != http.StatusNoContent {
+		t.Fatalf("preflight status %d", recorder.Code)
+	}
+	for header, expected := range map[string]string{
+		"Access-Control-Allow-Origin":  "http://dashboard.local",
+		"Access-Control-Allow-Methods": "GET, HEAD, POST, PUT, DELETE",
+		"Access-Control-Allow-Headers": "Authorization, Content-Type, kbn-xsrf",
+		"Access-Control-Max-Age":       "600",
+	} {
+		if actual := recorder.Header().Get(header); actual != expected {
+			t.Errorf("%s is %q, expected %q", header, actual, expected)
+		}
+	}
+
+	recorder = preflight("http://unknown.local")
+	if recorder.Code != http.StatusForbidden || recorder.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf("unknown origin preflight status %d, headers %v", recorder.Code, recorder.Header())
+	}
+}
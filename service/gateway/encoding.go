@@ -0,0 +1,30 @@
+package gateway
+
+import (
+	"compress/gzip"
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"io"
+	"strings"
+)
+
+// decodeRequestBody replaces a gzip request body with the decompressed one, the upstreams always get a plain
+// body whether they accept the encoding or not. MaxBodySize then limits the decompressed size.
+func decodeRequestBody(c *gin.Context) error {
+	if !strings.EqualFold(strings.TrimSpace(c.GetHeader("Content-Encoding")), "gzip") {
+		return nil
+	}
+
+	reader, err := gzip.NewReader(c.Request.Body)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	c.Request.Body = struct {
+		io.Reader
+		io.Closer
+	}{reader, c.Request.Body}
+	c.Request.Header.Del("Content-Encoding")
+	c.Request.Header.Del("Content-Length")
+	c.Request.ContentLength = -1
+	return nil
+}
@@ -2,6 +2,8 @@ package gateway
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"github.com/CharellKing/ela-lib/config"
@@ -13,6 +15,21 @@ import (
 	"github.com/spf13/cast"
 	"io"
 	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const defaultMaxBodySize = 100 // MB
+
+const (
+	healthzPath = "/healthz"
+	readyzPath  = "/readyz"
+	metricsPath = "/metrics"
+	// configPath reads and updates the runtime settings, es index names never start with `_`
+	configPath = "/_gateway/config"
 )
 
 type ESGateway struct {
@@ -21,28 +38,48 @@ type ESGateway struct {
 	User     string
 	Password string
 
+	// MaxBodySize is the max bytes of a request body the gateway buffers
+	MaxBodySize int64
+
+	WriteMode    config.WriteMode
+	Compensation *CompensationQueue
+
+	CORS *config.CORSCfg
+
+	// ReadWeights and ShadowCompare, like WriteMode, are the initial settings, the admin endpoint changes them
+	ReadWeights   config.ReadWeights
+	ShadowCompare bool
+	// ShadowRetries re-reads the slave ShadowRetries times, ShadowRetryDelay apart, before a mismatch is reported
+	ShadowRetries    int
+	ShadowRetryDelay time.Duration
+	runtime          atomic.Pointer[runtimeCfg]
+	runtimeLock      sync.Mutex
+
+	// TLSConfig serves https, nil means plaintext
+	TLSConfig *tls.Config
+
+	// Auth guards the routes proxied to es, the operational endpoints are served without it
+	Auth gin.HandlerFunc
+
+	metrics gatewayMetrics
+
 	SourceES es.ES
 	TargetES es.ES
 
 	MasterES es.ES
 	SlaveES  es.ES
-}
 
-func basicAuth(username, password string) gin.HandlerFunc {
-	return func(c *gin.Context) {
-		user, pass, hasAuth := c.Request.BasicAuth()
-		if hasAuth && user == username && pass == password {
-			c.Next()
-		} else {
-			c.Header("WWW-Authenticate", `Basic realm="Restricted"`)
-			c.AbortWithStatus(http.StatusUnauthorized)
-		}
-	}
+	// IndexRoutes are the upstreams of the index prefixes, a routed request is neither replayed nor compared
+	IndexRoutes map[string]es.ES
+
+	// UpstreamTimeout bounds every upstream request, 0 means no limit. UpstreamReadRetries retries the stateless
+	// reads which failed transiently.
+	UpstreamTimeout     time.Duration
+	UpstreamReadRetries int
 }
 
 func NewESGateway(cfg *config.Config) (*ESGateway, error) {
 	engine := gin.Default()
-	engine.Use(basicAuth(cfg.GatewayCfg.User, cfg.GatewayCfg.Password))
 
 	var (
 		sourceES es.ES
@@ -65,16 +102,75 @@ func NewESGateway(cfg *config.Config) (*ESGateway, error) {
 		slaveES = sourceES
 	}
 
+	indexRoutes := make(map[string]es.ES)
+	for prefix, esName := range cfg.GatewayCfg.IndexRoutes {
+		esConfig, ok := cfg.ESConfigs[esName]
+		if !ok {
+			return nil, utils.NewCustomError(utils.InvalidParams, "index route %s refers to unknown elastic %s", prefix, esName)
+		}
+		if indexRoutes[strings.TrimSuffix(prefix, "*")], err = es.NewESV0(esConfig).GetES(); err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+
+	auth, err := inboundAuth(cfg.GatewayCfg)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	maxBodySize := cfg.GatewayCfg.MaxBodySize
+	if maxBodySize <= 0 {
+		maxBodySize = defaultMaxBodySize
+	}
+
+	writeMode := lo.Ternary(cfg.GatewayCfg.WriteMode == "", config.WriteModeAsync, cfg.GatewayCfg.WriteMode)
+	var compensation *CompensationQueue
+	switch writeMode {
+	case config.WriteModeAsync:
+	case config.WriteModeBestEffortQueue:
+		if cfg.GatewayCfg.CompensationFile == "" {
+			return nil, utils.NewCustomError(utils.InvalidParams, "write mode %s requires compensation_file", writeMode)
+		}
+		compensation = NewCompensationQueue(cfg.GatewayCfg.CompensationFile)
+	case config.WriteModeStrict:
+		if cfg.GatewayCfg.CompensationFile != "" {
+			compensation = NewCompensationQueue(cfg.GatewayCfg.CompensationFile)
+		}
+	default:
+		return nil, utils.NewCustomError(utils.InvalidParams, "invalid write mode %s", writeMode)
+	}
+
+	tlsConfig, err := newTLSConfig(cfg.GatewayCfg)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	shadowRetryDelay := lo.Ternary(cfg.GatewayCfg.ShadowRetryDelay == 0, 1000, cfg.GatewayCfg.ShadowRetryDelay)
 	return &ESGateway{
-		Engine:   engine,
-		Address:  cfg.GatewayCfg.Address,
-		User:     cfg.GatewayCfg.User,
-		Password: cfg.GatewayCfg.Password,
+		Engine:           engine,
+		TLSConfig:        tlsConfig,
+		Auth:             auth,
+		CORS:             cfg.GatewayCfg.CORS,
+		Address:          cfg.GatewayCfg.Address,
+		User:             cfg.GatewayCfg.User,
+		Password:         cfg.GatewayCfg.Password,
+		MaxBodySize:      int64(maxBodySize) * 1024 * 1024,
+		WriteMode:        writeMode,
+		Compensation:     compensation,
+		ReadWeights:      cfg.GatewayCfg.ReadWeights,
+		ShadowCompare:    cfg.GatewayCfg.ShadowCompare,
+		ShadowRetries:    int(cfg.GatewayCfg.ShadowRetries),
+		ShadowRetryDelay: time.Duration(shadowRetryDelay) * time.Millisecond,
 
 		SourceES: sourceES,
 		TargetES: targetES,
 		MasterES: masterES,
 		SlaveES:  slaveES,
+
+		IndexRoutes: indexRoutes,
+
+		UpstreamTimeout:     time.Duration(cfg.GatewayCfg.UpstreamTimeout) * time.Millisecond,
+		UpstreamReadRetries: int(cfg.GatewayCfg.UpstreamReadRetries),
 	}, nil
 }
 
@@ -114,17 +210,26 @@ func (bulkAction *BulkAction) ToStringArray() []string {
 	return bulkActionArray
 }
 
+func (gateway *ESGateway) getMasterDocTypeReservationType() es.DocTypeReservationType {
+	if gateway.MasterES.ClusterVersionGte7() == true && gateway.SourceES.ClusterVersionGte7() == false {
+		return es.DocTypeReservationTypeDelete
+	} else if gateway.MasterES.ClusterVersionGte7() == false && gateway.SourceES.ClusterVersionGte7() == true {
+		return es.DocTypeReservationTypeCreate
+	}
+	return es.DocTypeReservationTypeKeep
+}
+
+// needConvertMasterRequestBody reports whether the master request body has to be parsed, other bodies are streamed.
+func (gateway *ESGateway) needConvertMasterRequestBody(parserResult *es.UriPathParserResult) bool {
+	return parserResult.RequestAction == es.RequestActionTypeBulkDocument &&
+		gateway.getMasterDocTypeReservationType() != es.DocTypeReservationTypeKeep
+}
+
 func (gateway *ESGateway) convertMasterRequestBody(masterRequestBody []byte, parserResult *es.UriPathParserResult) ([]byte, error) {
 	var err error
 	requestBody := masterRequestBody
 	if parserResult.RequestAction == es.RequestActionTypeBulkDocument {
-		var docTypeReservationType = es.DocTypeReservationTypeKeep
-		if gateway.MasterES.ClusterVersionGte7() == true && gateway.SourceES.ClusterVersionGte7() == false {
-			docTypeReservationType = es.DocTypeReservationTypeDelete
-		} else if gateway.MasterES.ClusterVersionGte7() == false && gateway.SourceES.ClusterVersionGte7() == true {
-			docTypeReservationType = es.DocTypeReservationTypeCreate
-		}
-		requestBody, err = es.AdjustBulkRequestBodyWithOnlyDocType(masterRequestBody, docTypeReservationType)
+		requestBody, err = es.AdjustBulkRequestBodyWithOnlyDocType(masterRequestBody, gateway.getMasterDocTypeReservationType())
 		if err != nil {
 			return nil, errors.WithStack(err)
 		}
@@ -151,16 +256,32 @@ func (gateway *ESGateway) convertSalveRequestBody(masterRequestBody []byte,
 	return requestBody, nil
 }
 
-func (gateway *ESGateway) onHandler(c *gin.Context) {
-	bodyBytes, err := io.ReadAll(c.Request.Body)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": err.Error(),
-		})
+// limitedReader fails a read once more than remain bytes are read, unlike io.LimitReader which ends silently.
+type limitedReader struct {
+	reader   io.Reader
+	remain   int64
+	exceeded bool
+}
+
+func (r *limitedReader) Read(p []byte) (int, error) {
+	if r.remain < 0 {
+		r.exceeded = true
+		return 0, errors.New("request body too large")
 	}
 
-	c.Request.Body = io.NopCloser(bytes.NewBuffer(bodyBytes))
+	if int64(len(p)) > r.remain+1 {
+		p = p[:r.remain+1]
+	}
+	n, err := r.reader.Read(p)
+	r.remain -= int64(n)
+	if r.remain < 0 {
+		r.exceeded = true
+		return n, errors.New("request body too large")
+	}
+	return n, err
+}
 
+func (gateway *ESGateway) onHandler(c *gin.Context) {
 	parseUriResult := gateway.SourceES.MatchRule(c)
 	if parseUriResult == nil {
 		c.JSON(http.StatusBadRequest, gin.H{
@@ -169,55 +290,291 @@ func (gateway *ESGateway) onHandler(c *gin.Context) {
 		return
 	}
 
-	newBodyBytes, err := gateway.convertMasterRequestBody(bodyBytes, parseUriResult)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": err.Error(),
+	if err := decodeRequestBody(c); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": fmt.Sprintf("invalid gzip body: %s", err.Error()),
 		})
 		return
 	}
-	resp, statusCode, err := gateway.MasterES.Request(c, newBodyBytes, parseUriResult)
+
+	// the actions of a bulk body may belong to different upstreams, whatever the index of the uri
+	if parseUriResult.RequestAction == es.RequestActionTypeBulkDocument && len(gateway.IndexRoutes) > 0 {
+		gateway.splitBulk(c, parseUriResult)
+		return
+	}
+
+	if routeES := gateway.routeES(parseUriResult); routeES != nil {
+		gateway.proxy(c, routeES, parseUriResult)
+		return
+	}
+
+	if gateway.runtimeCfg().readSlave() && gateway.statelessRead(c, parseUriResult) {
+		gateway.proxy(c, gateway.SlaveES, parseUriResult)
+		return
+	}
+
+	if resp, statusCode, ok := gateway.serveMaster(c, parseUriResult); ok {
+		c.JSON(statusCode, resp)
+	}
+}
+
+// serveMaster sends the request to the master and replays a write to the slave as the write mode says. It answers
+// the client itself only on an error and then returns false, otherwise the translated master response.
+func (gateway *ESGateway) serveMaster(c *gin.Context, parseUriResult *es.UriPathParserResult) (map[string]interface{}, int, bool) {
+	runtime := gateway.runtimeCfg()
+	shadowCompare := runtime.ShadowCompare && gateway.statelessRead(c, parseUriResult)
+
+	// bodies which are converted or replayed to the slave are buffered under MaxBodySize, others are streamed
+	var (
+		masterBody io.Reader = c.Request.Body
+		bodyBuffer bytes.Buffer
+	)
+	bodyReader := &limitedReader{reader: c.Request.Body, remain: gateway.MaxBodySize}
+	if gateway.needConvertMasterRequestBody(parseUriResult) {
+		if _, err := bodyBuffer.ReadFrom(bodyReader); err != nil {
+			gateway.abortWithBodyError(c, bodyReader, err)
+			return nil, 0, false
+		}
+
+		newBodyBytes, err := gateway.convertMasterRequestBody(bodyBuffer.Bytes(), parseUriResult)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": err.Error(),
+			})
+			return nil, 0, false
+		}
+		masterBody = bytes.NewReader(newBodyBytes)
+	} else if gateway.SlaveES.IsWrite(parseUriResult.RequestAction) || shadowCompare {
+		masterBody = io.TeeReader(bodyReader, &bodyBuffer)
+	}
+
+	resp, statusCode, err := gateway.requestUpstream(c, gateway.MasterES, masterBody, parseUriResult)
 	if err != nil {
 		utils.GetLogger(c).Infof("master request error: %+v", err)
-		c.JSON(statusCode, gin.H{
-			"error": err.Error(),
-		})
-		return
+		gateway.abortWithBodyError(c, bodyReader, err)
+		return nil, 0, false
 	}
+
 	if gateway.SlaveES.IsWrite(parseUriResult.RequestAction) && statusCode < 300 {
-		utils.GoRecovery(c, func() {
-			newBodyBytes, err := gateway.convertSalveRequestBody(bodyBytes, resp, parseUriResult)
-			if err != nil {
-				utils.GetLogger(c).Errorf("convert slave request body: %+v", err)
-				return
-			}
-			newParseUriResult := gateway.convertSlaveMatchRule(resp, parseUriResult)
-			response, status, err := gateway.SlaveES.Request(c, newBodyBytes, newParseUriResult)
-			if err != nil {
-				utils.GetLogger(c).Errorf("slave request error: %+v", err)
-			}
+		// the master may answer before reading the whole body, drain it so the slave replays the full body
+		if _, err := io.Copy(io.Discard, masterBody); err != nil {
+			utils.GetLogger(c).Errorf("read request body for slave: %+v", err)
+		}
+		bodyBytes := bodyBuffer.Bytes()
 
-			if status >= 299 {
-				utils.GetLogger(c).Errorf("response: %+v, err: %+v", response, err)
+		if runtime.WriteMode == config.WriteModeStrict {
+			if err := gateway.writeSlave(c, bodyBytes, resp, parseUriResult); err != nil {
+				utils.GetLogger(c).Errorf("slave write error: %+v", err)
+				gateway.metrics.observeSlaveWriteFailure()
+				gateway.compensate(c, bodyBytes, parseUriResult, err)
+				c.JSON(http.StatusBadGateway, gin.H{
+					"error":           fmt.Sprintf("slave write error: %s", err.Error()),
+					"master_response": resp,
+				})
+				return nil, 0, false
 			}
+		} else {
+			// the gin context is recycled once the handler returns, the slave request works on a copy. resp is
+			// translated below, the slave gets the master response as it was
+			slaveCtx, masterResp := c.Copy(), resp
+			utils.GoRecovery(slaveCtx, func() {
+				if err := gateway.writeSlave(slaveCtx, bodyBytes, masterResp, parseUriResult); err != nil {
+					utils.GetLogger(slaveCtx).Errorf("slave write error: %+v", err)
+					gateway.metrics.observeSlaveWriteFailure()
+					if runtime.WriteMode == config.WriteModeBestEffortQueue {
+						gateway.compensate(slaveCtx, bodyBytes, parseUriResult, err)
+					}
+				}
+			})
+		}
+	}
+
+	resp = translateResponse(gateway.MasterES.GetClusterVersion(), gateway.SourceES.GetClusterVersion(), parseUriResult.RequestAction, resp)
+	if shadowCompare {
+		if _, err := io.Copy(io.Discard, masterBody); err != nil {
+			utils.GetLogger(c).Errorf("read request body for shadow read: %+v", err)
+		}
+		bodyBytes := bodyBuffer.Bytes()
+		shadowCtx := c.Copy()
+		utils.GoRecovery(shadowCtx, func() {
+			gateway.shadowCompare(shadowCtx, bodyBytes, parseUriResult, statusCode, resp)
 		})
 	}
+	return resp, statusCode, true
+}
+
+// routeES returns the upstream of the longest index route prefix of the request index, nil when none matches
+func (gateway *ESGateway) routeES(parseUriResult *es.UriPathParserResult) es.ES {
+	index, ok := parseUriResult.VariableMap["index"]
+	if !ok {
+		return nil
+	}
+	prefix, ok := gateway.routePrefix(index)
+	if !ok {
+		return nil
+	}
+	return gateway.IndexRoutes[prefix]
+}
+
+// routePrefix returns the longest index route prefix of index, false when none matches
+func (gateway *ESGateway) routePrefix(index string) (string, bool) {
+	var (
+		matchedPrefix string
+		matched       bool
+	)
+	for prefix := range gateway.IndexRoutes {
+		if strings.HasPrefix(index, prefix) && (!matched || len(prefix) > len(matchedPrefix)) {
+			matchedPrefix, matched = prefix, true
+		}
+	}
+	return matchedPrefix, matched
+}
+
+// upstreamLabels names the master, the slave and the routed upstreams, by their addresses when no name is set
+func (gateway *ESGateway) upstreamLabels() []upstreamLabel {
+	newLabel := func(role string, prefix string, upstream es.ES) upstreamLabel {
+		name := upstream.GetClusterName()
+		if name == "" {
+			name = strings.Join(upstream.GetAddresses(), ",")
+		}
+		return upstreamLabel{Role: role, Prefix: prefix, Name: name, Version: upstream.GetClusterVersion()}
+	}
 
-	if parseUriResult.RequestAction == es.RequestActionTypeSearchDocumentWithLimit ||
-		parseUriResult.RequestAction == es.RequestActionTypeSearchDocument {
-		resp = gateway.SourceES.GetSearchResponse(resp)
+	var labels []upstreamLabel
+	for role, upstream := range map[string]es.ES{"master": gateway.MasterES, "slave": gateway.SlaveES} {
+		if lo.IsNotEmpty(upstream) {
+			labels = append(labels, newLabel(role, "", upstream))
+		}
+	}
+	for prefix, upstream := range gateway.IndexRoutes {
+		labels = append(labels, newLabel("route", prefix, upstream))
 	}
+	sort.Slice(labels, func(i, j int) bool {
+		if labels[i].Role != labels[j].Role {
+			return labels[i].Role < labels[j].Role
+		}
+		return labels[i].Prefix < labels[j].Prefix
+	})
+	return labels
+}
+
+// proxy serves the request from the upstream alone, e.g. a read from the slave, it is not replayed anywhere else
+func (gateway *ESGateway) proxy(c *gin.Context, upstream es.ES, parseUriResult *es.UriPathParserResult) {
+	bodyReader := &limitedReader{reader: c.Request.Body, remain: gateway.MaxBodySize}
+	resp, statusCode, err := gateway.requestUpstream(c, upstream, bodyReader, parseUriResult)
+	if err != nil {
+		utils.GetLogger(c).Infof("proxy %s error: %+v", upstream.GetAddresses(), err)
+		gateway.abortWithBodyError(c, bodyReader, err)
+		return
+	}
+
+	resp = translateResponse(upstream.GetClusterVersion(), gateway.SourceES.GetClusterVersion(), parseUriResult.RequestAction, resp)
 	c.JSON(statusCode, resp)
 }
 
-func (gateway *ESGateway) onRequest() {
-	gateway.Engine.NoRoute(func(c *gin.Context) {
-		gateway.onHandler(c)
+func (gateway *ESGateway) writeSlave(c *gin.Context, bodyBytes []byte, masterResponse map[string]interface{}, parseUriResult *es.UriPathParserResult) error {
+	newBodyBytes, err := gateway.convertSalveRequestBody(bodyBytes, masterResponse, parseUriResult)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	newParseUriResult := gateway.convertSlaveMatchRule(masterResponse, parseUriResult)
+	response, status, err := gateway.requestUpstream(c, gateway.SlaveES, bytes.NewReader(newBodyBytes), newParseUriResult)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if status >= 300 {
+		return errors.Errorf("slave response status %d: %+v", status, response)
+	}
+	return nil
+}
+
+func (gateway *ESGateway) compensate(c *gin.Context, bodyBytes []byte, parseUriResult *es.UriPathParserResult, err error) {
+	if gateway.Compensation == nil {
+		return
+	}
+
+	failedWrite := &FailedWrite{
+		Time:          time.Now(),
+		RequestAction: parseUriResult.RequestAction,
+		VariableMap:   parseUriResult.VariableMap,
+		RawQuery:      c.Request.URL.RawQuery,
+		Body:          string(bodyBytes),
+		Error:         err.Error(),
+	}
+	if pushErr := gateway.Compensation.Push(failedWrite); pushErr != nil {
+		utils.GetLogger(c).Errorf("record failed slave write: %+v", pushErr)
+	}
+}
+
+func (gateway *ESGateway) abortWithBodyError(c *gin.Context, bodyReader *limitedReader, err error) {
+	statusCode := http.StatusInternalServerError
+	if bodyReader.exceeded {
+		statusCode = http.StatusRequestEntityTooLarge
+	} else if errors.Is(err, context.DeadlineExceeded) {
+		statusCode = http.StatusGatewayTimeout
+	}
+	c.JSON(statusCode, gin.H{
+		"error": err.Error(),
 	})
 }
 
+func (gateway *ESGateway) onHealthz(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+func (gateway *ESGateway) onReadyz(c *gin.Context) {
+	errs := make(map[string]string)
+	for name, esInstance := range map[string]es.ES{"master": gateway.MasterES, "slave": gateway.SlaveES} {
+		if _, err := esInstance.ClusterHealth(c); err != nil {
+			errs[name] = err.Error()
+		}
+	}
+
+	if len(errs) > 0 {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "unavailable", "errors": errs})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+func (gateway *ESGateway) onRequest() {
+	gateway.metrics.upstreams = gateway.upstreamLabels()
+	if gateway.CORS != nil {
+		gateway.Engine.Use(cors(gateway.CORS))
+	}
+	gateway.Engine.GET(healthzPath, gateway.onHealthz)
+	gateway.Engine.GET(readyzPath, gateway.onReadyz)
+	gateway.Engine.GET(metricsPath, gateway.metrics.handler)
+
+	var authHandlers []gin.HandlerFunc
+	if gateway.Auth != nil {
+		authHandlers = append(authHandlers, gateway.Auth)
+	}
+	gateway.Engine.GET(configPath, append(authHandlers, gateway.onGetConfig)...)
+	gateway.Engine.PUT(configPath, append(authHandlers, gateway.onUpdateConfig)...)
+
+	handlers := append([]gin.HandlerFunc{gateway.metrics.middleware()}, authHandlers...)
+	gateway.Engine.NoRoute(append(handlers, gateway.onHandler)...)
+}
+
 func (gateway *ESGateway) Run() {
 	gateway.onRequest()
 
-	_ = gateway.Engine.Run(gateway.Address)
+	server := gateway.newServer()
+	if gateway.TLSConfig != nil {
+		// the certificate is already loaded into the tls config
+		_ = server.ListenAndServeTLS("", "")
+		return
+	}
+	_ = server.ListenAndServe()
+}
+
+func (gateway *ESGateway) newServer() *http.Server {
+	return &http.Server{
+		// like gin, an empty address listens on :8080
+		Addr:      lo.Ternary(gateway.Address == "", ":8080", gateway.Address),
+		Handler:   gateway.Engine.Handler(),
+		TLSConfig: gateway.TLSConfig,
+	}
 }
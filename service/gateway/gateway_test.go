@@ -1,14 +1,77 @@
 package gateway
 
 import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
 	"context"
+	"encoding/json"
 	"fmt"
 	"github.com/CharellKing/ela-lib/config"
+	"github.com/CharellKing/ela-lib/pkg/es"
 	"github.com/CharellKing/ela-lib/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/samber/lo"
 	"github.com/spf13/viper"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
+func TestMain(m *testing.M) {
+	utils.InitLogger(&config.Config{Level: "error"})
+	gin.SetMode(gin.TestMode)
+	os.Exit(m.Run())
+}
+
+func newMockES(t *testing.T, version string, handler http.HandlerFunc) es.ES {
+	return newMockESWithConfig(t, version, &config.ESConfig{}, handler)
+}
+
+func newMockESWithConfig(t *testing.T, version string, esConfig *config.ESConfig, handler http.HandlerFunc) es.ES {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Elastic-Product", "Elasticsearch")
+		if r.URL.Path == "/" {
+			_, _ = fmt.Fprintf(w, `{"version":{"number":"%s"}}`, version)
+			return
+		}
+		handler(w, r)
+	}))
+	t.Cleanup(server.Close)
+
+	esConfig.Addresses = []string{server.URL}
+	mockES, err := es.NewESV0(esConfig).GetES()
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	return mockES
+}
+
+func okHandler(w http.ResponseWriter, r *http.Request) {
+	_, _ = io.Copy(io.Discard, r.Body)
+	_, _ = w.Write([]byte(`{"took":1,"errors":false,"items":[]}`))
+}
+
+func newTestGateway(sourceES es.ES, masterES es.ES, slaveES es.ES, maxBodySize int64) *ESGateway {
+	gateway := &ESGateway{
+		Engine:      gin.New(),
+		MaxBodySize: maxBodySize,
+		SourceES:    sourceES,
+		TargetES:    slaveES,
+		MasterES:    masterES,
+		SlaveES:     slaveES,
+	}
+	gateway.onRequest()
+	return gateway
+}
+
 func TestGatewayServer(t *testing.T) {
 	configPath := "D:\\code\\ela-lib\\config.yaml"
 	viper.SetConfigFile(configPath)
@@ -35,3 +98,590 @@ func TestGatewayServer(t *testing.T) {
 	esProxy.Run()
 
 }
+
+func TestGatewayStreamBulkBody(t *testing.T) {
+	received := make(chan struct{})
+	masterES := newMockES(t, "7.10.2", func(w http.ResponseWriter, r *http.Request) {
+		reader := bufio.NewReader(r.Body)
+		if _, err := reader.ReadString('\n'); err != nil {
+			t.Errorf("read first line %+v", err)
+		}
+		close(received)
+		_, _ = io.Copy(io.Discard, reader)
+		_, _ = w.Write([]byte(`{"took":1,"errors":false,"items":[]}`))
+	})
+	slaveES := newMockES(t, "7.10.2", okHandler)
+	gateway := newTestGateway(masterES, masterES, slaveES, 1024*1024)
+
+	pr, pw := io.Pipe()
+	req := httptest.NewRequest(http.MethodPost, "/_bulk", pr)
+	recorder := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		gateway.Engine.ServeHTTP(recorder, req)
+	}()
+
+	line := `{"index":{"_index":"a","_id":"1"}}` + "\n" + `{"field":"value"}` + "\n"
+	_, _ = pw.Write([]byte(line))
+
+	// the master must see the first line while the client is still sending, a buffering gateway would block here
+	select {
+	case <-received:
+	case <-time.After(5 * time.Second):
+		_ = pw.CloseWithError(fmt.Errorf("timeout"))
+		<-done
+		t.Fatalf("bulk body is not streamed to the master")
+	}
+
+	_, _ = pw.Write([]byte(strings.Repeat(line, 10000)))
+	_ = pw.Close()
+	<-done
+
+	if recorder.Code != http.StatusOK {
+		t.Errorf("status %d, body %s", recorder.Code, recorder.Body.String())
+	}
+}
+
+func TestGatewayBodyTooLarge(t *testing.T) {
+	body := strings.Repeat(`{"index":{"_index":"a","_id":"1"}}`+"\n"+`{"field":"value"}`+"\n", 100)
+	for _, sourceVersion := range []string{"7.10.2", "6.8.23"} {
+		sourceES := newMockES(t, sourceVersion, okHandler)
+		masterES := newMockES(t, "7.10.2", okHandler)
+		gateway := newTestGateway(sourceES, masterES, newMockES(t, "7.10.2", okHandler), 128)
+
+		recorder := httptest.NewRecorder()
+		gateway.Engine.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/_bulk", strings.NewReader(body)))
+		if recorder.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("source %s status %d, body %s", sourceVersion, recorder.Code, recorder.Body.String())
+		}
+	}
+}
+
+func TestGatewayWriteBodyToBothUpstreams(t *testing.T) {
+	body := `{"user":"kimchy","message":"` + strings.Repeat("x", 64*1024) + `"}`
+	recordBody := func(bodyCh chan string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			bodyBytes, _ := io.ReadAll(r.Body)
+			bodyCh <- string(bodyBytes)
+			_, _ = w.Write([]byte(`{"_index":"a","_id":"1","result":"created"}`))
+		}
+	}
+
+	masterBodyCh := make(chan string, 1)
+	slaveBodyCh := make(chan string, 1)
+	masterES := newMockES(t, "7.10.2", recordBody(masterBodyCh))
+	gateway := newTestGateway(masterES, masterES, newMockES(t, "7.10.2", recordBody(slaveBodyCh)), 1024*1024)
+
+	recorder := httptest.NewRecorder()
+	gateway.Engine.ServeHTTP(recorder, httptest.NewRequest(http.MethodPut, "/a/_doc/1", strings.NewReader(body)))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("status %d, body %s", recorder.Code, recorder.Body.String())
+	}
+
+	for name, bodyCh := range map[string]chan string{"master": masterBodyCh, "slave": slaveBodyCh} {
+		select {
+		case received := <-bodyCh:
+			if received != body {
+				t.Errorf("%s received %d bytes, expected %d", name, len(received), len(body))
+			}
+		case <-time.After(5 * time.Second):
+			t.Errorf("%s received nothing", name)
+		}
+	}
+}
+
+func TestGatewayWriteModeSlaveFailure(t *testing.T) {
+	body := `{"user":"kimchy"}`
+	for _, writeMode := range []config.WriteMode{config.WriteModeAsync, config.WriteModeBestEffortQueue, config.WriteModeStrict} {
+		slaveCalled := make(chan struct{}, 1)
+		masterES := newMockES(t, "7.10.2", func(w http.ResponseWriter, r *http.Request) {
+			_, _ = io.Copy(io.Discard, r.Body)
+			_, _ = w.Write([]byte(`{"_index":"a","_id":"1","result":"created"}`))
+		})
+		slaveES := newMockES(t, "7.10.2", func(w http.ResponseWriter, r *http.Request) {
+			_, _ = io.Copy(io.Discard, r.Body)
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_, _ = w.Write([]byte(`{"error":"unavailable"}`))
+			slaveCalled <- struct{}{}
+		})
+
+		compensationFile := filepath.Join(t.TempDir(), "compensation.log")
+		gateway := newTestGateway(masterES, masterES, slaveES, 1024*1024)
+		gateway.WriteMode = writeMode
+		if writeMode != config.WriteModeAsync {
+			gateway.Compensation = NewCompensationQueue(compensationFile)
+		}
+
+		recorder := httptest.NewRecorder()
+		gateway.Engine.ServeHTTP(recorder, httptest.NewRequest(http.MethodPut, "/a/_doc/1?refresh=true", strings.NewReader(body)))
+
+		expectedStatus := lo.Ternary(writeMode == config.WriteModeStrict, http.StatusBadGateway, http.StatusOK)
+		if recorder.Code != expectedStatus {
+			t.Errorf("%s status %d, expected %d, body %s", writeMode, recorder.Code, expectedStatus, recorder.Body.String())
+		}
+
+		select {
+		case <-slaveCalled:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s slave is not called", writeMode)
+		}
+
+		var records []string
+		for i := 0; i < 50; i++ {
+			content, _ := os.ReadFile(compensationFile)
+			records = lo.Compact(strings.Split(string(content), "\n"))
+			if len(records) > 0 || writeMode == config.WriteModeAsync {
+				break
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+
+		if writeMode == config.WriteModeAsync {
+			if len(records) != 0 {
+				t.Errorf("async records %v", records)
+			}
+			continue
+		}
+
+		if len(records) != 1 {
+			t.Fatalf("%s records %v", writeMode, records)
+		}
+		var failedWrite FailedWrite
+		if err := json.Unmarshal([]byte(records[0]), &failedWrite); err != nil {
+			t.Fatalf("%+v", err)
+		}
+		if failedWrite.Body != body || failedWrite.VariableMap["docId"] != "1" || failedWrite.RawQuery != "refresh=true" {
+			t.Errorf("%s record %+v", writeMode, failedWrite)
+		}
+	}
+}
+
+func TestGatewayHealthz(t *testing.T) {
+	masterES := newMockES(t, "7.10.2", okHandler)
+	gateway := &ESGateway{
+		Engine:   gin.New(),
+		Auth:     basicAuth("user", "password"),
+		MasterES: masterES,
+		SlaveES:  masterES,
+		SourceES: masterES,
+		TargetES: masterES,
+	}
+	gateway.onRequest()
+
+	for _, testCase := range []struct {
+		path           string
+		expectedStatus int
+	}{
+		{"/healthz", http.StatusOK},
+		{"/metrics", http.StatusOK},
+		{"/a/_search", http.StatusUnauthorized},
+	} {
+		recorder := httptest.NewRecorder()
+		gateway.Engine.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, testCase.path, nil))
+		if recorder.Code != testCase.expectedStatus {
+			t.Errorf("%s status %d, expected %d, body %s", testCase.path, recorder.Code, testCase.expectedStatus, recorder.Body.String())
+		}
+	}
+
+	recorder := httptest.NewRecorder()
+	gateway.Engine.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if !strings.Contains(recorder.Body.String(), `ela_gateway_requests_total{method="GET",code="401"} 1`) {
+		t.Errorf("metrics %s", recorder.Body.String())
+	}
+}
+
+func TestGatewayReadyz(t *testing.T) {
+	healthyES := newMockES(t, "7.10.2", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"status":"green"}`))
+	})
+	unhealthyES := newMockES(t, "7.10.2", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = w.Write([]byte(`{"error":"unavailable"}`))
+	})
+
+	for _, testCase := range []struct {
+		slaveES        es.ES
+		expectedStatus int
+	}{
+		{healthyES, http.StatusOK},
+		{unhealthyES, http.StatusServiceUnavailable},
+	} {
+		gateway := newTestGateway(healthyES, healthyES, testCase.slaveES, 1024)
+		recorder := httptest.NewRecorder()
+		gateway.Engine.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		if recorder.Code != testCase.expectedStatus {
+			t.Errorf("readyz status %d, expected %d, body %s", recorder.Code, testCase.expectedStatus, recorder.Body.String())
+		}
+	}
+}
+
+func TestGatewayUpdateConfig(t *testing.T) {
+	// a search is also replayed to the slave in the background, so only the master count is exact
+	var masterSearches, slaveSearches atomic.Int32
+	searchHandler := func(searches *atomic.Int32) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			_, _ = io.Copy(io.Discard, r.Body)
+			if strings.HasSuffix(r.URL.Path, "/_search") {
+				searches.Add(1)
+			}
+			_, _ = w.Write([]byte(`{"hits":{"total":{"value":0,"relation":"eq"},"hits":[]}}`))
+		}
+	}
+	masterES := newMockES(t, "7.10.2", searchHandler(&masterSearches))
+	slaveES := newMockES(t, "8.5.0", searchHandler(&slaveSearches))
+	gateway := newTestGateway(masterES, masterES, slaveES, 1024)
+
+	search := func() {
+		recorder := httptest.NewRecorder()
+		gateway.Engine.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/a/_search", strings.NewReader(`{}`)))
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("search status %d: %s", recorder.Code, recorder.Body.String())
+		}
+	}
+	updateConfig := func(body string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		gateway.Engine.ServeHTTP(recorder, httptest.NewRequest(http.MethodPut, "/_gateway/config", strings.NewReader(body)))
+		return recorder
+	}
+
+	search()
+	if masterSearches.Load() != 1 {
+		t.Fatalf("expect the search on the master, got master %d", masterSearches.Load())
+	}
+
+	if recorder := updateConfig(`{"read_weights":{"master":0,"slave":1}}`); recorder.Code != http.StatusOK {
+		t.Fatalf("update status %d: %s", recorder.Code, recorder.Body.String())
+	}
+	search()
+	if masterSearches.Load() != 1 || slaveSearches.Load() < 1 {
+		t.Fatalf("expect the search on the slave, got master %d slave %d", masterSearches.Load(), slaveSearches.Load())
+	}
+
+	if recorder := updateConfig(`{"address":"0.0.0.0:9200"}`); recorder.Code != http.StatusBadRequest {
+		t.Fatalf("expect 400 for an immutable field, got %d", recorder.Code)
+	}
+	if recorder := updateConfig(`{"write_mode":"unknown"}`); recorder.Code != http.StatusBadRequest {
+		t.Fatalf("expect 400 for an invalid write mode, got %d", recorder.Code)
+	}
+
+	recorder := httptest.NewRecorder()
+	gateway.Engine.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/_gateway/config", nil))
+	var cfg runtimeCfg
+	if err := json.Unmarshal(recorder.Body.Bytes(), &cfg); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if cfg.ReadWeights.Slave != 1 || cfg.ReadWeights.Master != 0 || cfg.WriteMode != config.WriteModeAsync {
+		t.Fatalf("unexpected config %+v", cfg)
+	}
+}
+
+func TestGatewayScrollStaysOnMaster(t *testing.T) {
+	masterES := newMockES(t, "7.10.2", func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		switch {
+		case r.URL.Path == "/a/_search" && r.URL.Query().Has("scroll"):
+			_, _ = w.Write([]byte(`{"_scroll_id":"s1","hits":{"total":{"value":2,"relation":"eq"},"hits":[{"_id":"1"}]}}`))
+		case r.URL.Path == "/_search/scroll" && strings.Contains(string(body), `"s1"`):
+			_, _ = w.Write([]byte(`{"_scroll_id":"s1","hits":{"total":{"value":2,"relation":"eq"},"hits":[{"_id":"2"}]}}`))
+		case r.URL.Path == "/_search" && strings.Contains(string(body), `"pit"`):
+			_, _ = w.Write([]byte(`{"pit_id":"p1","hits":{"total":{"value":0,"relation":"eq"},"hits":[]}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"error":"unknown scroll"}`))
+		}
+	})
+	var slaveRequests atomic.Int32
+	slaveES := newMockES(t, "7.10.2", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		slaveRequests.Add(1)
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(`{"error":"unknown scroll"}`))
+	})
+	gateway := newTestGateway(masterES, masterES, slaveES, 1024)
+	gateway.ReadWeights = config.ReadWeights{Master: 0, Slave: 1}
+	gateway.ShadowCompare = true
+
+	for _, request := range []*http.Request{
+		httptest.NewRequest(http.MethodPost, "/a/_search?scroll=1m", strings.NewReader(`{"size":1}`)),
+		httptest.NewRequest(http.MethodPost, "/_search/scroll", strings.NewReader(`{"scroll":"1m","scroll_id":"s1"}`)),
+		httptest.NewRequest(http.MethodPost, "/_search", strings.NewReader(`{"pit":{"id":"p1","keep_alive":"1m"}}`)),
+	} {
+		recorder := httptest.NewRecorder()
+		gateway.Engine.ServeHTTP(recorder, request)
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("%s: unexpected status %d %s", request.URL, recorder.Code, recorder.Body.String())
+		}
+	}
+
+	// the shadow reads would be sent after the client is answered
+	time.Sleep(50 * time.Millisecond)
+	if slaveRequests.Load() != 0 {
+		t.Errorf("expect the scroll and the point in time search only on the master, the slave got %d", slaveRequests.Load())
+	}
+}
+
+func TestGatewayGzipBulkBody(t *testing.T) {
+	body := `{"index":{"_index":"a","_id":"1"}}` + "\n" + `{"field":"value"}` + "\n"
+	type received struct {
+		body     string
+		encoding string
+	}
+	recordBody := func(receivedCh chan received) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			bodyBytes, _ := io.ReadAll(r.Body)
+			receivedCh <- received{body: string(bodyBytes), encoding: r.Header.Get("Content-Encoding")}
+			_, _ = w.Write([]byte(`{"took":1,"errors":false,"items":[{"index":{"_index":"a","_id":"1","status":201}}]}`))
+		}
+	}
+	masterCh := make(chan received, 1)
+	slaveCh := make(chan received, 1)
+	masterES := newMockES(t, "7.10.2", recordBody(masterCh))
+	gateway := newTestGateway(masterES, masterES, newMockES(t, "7.10.2", recordBody(slaveCh)), 1024*1024)
+
+	var gzipBody bytes.Buffer
+	writer := gzip.NewWriter(&gzipBody)
+	_, _ = writer.Write([]byte(body))
+	_ = writer.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/_bulk", &gzipBody)
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	req.Header.Set("Content-Encoding", "gzip")
+	recorder := httptest.NewRecorder()
+	gateway.Engine.ServeHTTP(recorder, req)
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("status %d, body %s", recorder.Code, recorder.Body.String())
+	}
+
+	for name, receivedCh := range map[string]chan received{"master": masterCh, "slave": slaveCh} {
+		select {
+		case got := <-receivedCh:
+			if !strings.Contains(got.body, `"field":"value"`) || got.encoding != "" {
+				t.Errorf("%s received %q with encoding %q", name, got.body, got.encoding)
+			}
+		case <-time.After(5 * time.Second):
+			t.Errorf("%s received nothing", name)
+		}
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/_bulk", strings.NewReader(body))
+	req.Header.Set("Content-Encoding", "gzip")
+	recorder = httptest.NewRecorder()
+	gateway.Engine.ServeHTTP(recorder, req)
+	if recorder.Code != http.StatusBadRequest {
+		t.Errorf("expect 400 for a body which is not gzip, got %d", recorder.Code)
+	}
+}
+
+func TestGatewayIndexRoutes(t *testing.T) {
+	recordUpstream := func(name string, upstreams chan string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			_, _ = io.Copy(io.Discard, r.Body)
+			upstreams <- name
+			_, _ = w.Write([]byte(`{"_index":"a","_id":"1","found":true,"_source":{}}`))
+		}
+	}
+
+	upstreams := make(chan string, 10)
+	masterES := newMockES(t, "7.10.2", recordUpstream("master", upstreams))
+	gateway := newTestGateway(masterES, masterES, newMockES(t, "7.10.2", recordUpstream("slave", upstreams)), 1024*1024)
+	gateway.IndexRoutes = map[string]es.ES{
+		"tenant_a-":     newMockES(t, "7.10.2", recordUpstream("a", upstreams)),
+		"tenant_b-":     newMockES(t, "8.12.2", recordUpstream("b", upstreams)),
+		"tenant_b-old-": newMockES(t, "6.8.23", recordUpstream("b-old", upstreams)),
+	}
+
+	for path, expected := range map[string]string{
+		"/tenant_a-1/_doc/1":     "a",
+		"/tenant_b-1/_doc/1":     "b",
+		"/tenant_b-old-1/_doc/1": "b-old",
+		"/other/_doc/1":          "master",
+	} {
+		recorder := httptest.NewRecorder()
+		gateway.Engine.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("%s status %d, body %s", path, recorder.Code, recorder.Body.String())
+		}
+		if received := <-upstreams; received != expected {
+			t.Errorf("expect %s served by %s, got %s", path, expected, received)
+		}
+		if len(upstreams) != 0 {
+			t.Errorf("%s reached more than one upstream, next %s", path, <-upstreams)
+		}
+	}
+}
+
+func TestGatewayUpstreamInfo(t *testing.T) {
+	masterES := newMockESWithConfig(t, "7.10.2", &config.ESConfig{Name: "prod-eu"}, okHandler)
+	slaveES := newMockES(t, "8.12.2", okHandler)
+	gateway := &ESGateway{
+		Engine:      gin.New(),
+		MasterES:    masterES,
+		SlaveES:     slaveES,
+		SourceES:    masterES,
+		TargetES:    slaveES,
+		IndexRoutes: map[string]es.ES{"tenant_a-": newMockESWithConfig(t, "6.8.23", &config.ESConfig{Name: "tenant-a"}, okHandler)},
+	}
+	gateway.onRequest()
+
+	recorder := httptest.NewRecorder()
+	gateway.Engine.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	for _, expected := range []string{
+		`ela_gateway_upstream_info{role="master",prefix="",name="prod-eu",version="7.10.2"} 1`,
+		// an upstream without a name is labeled by its addresses
+		fmt.Sprintf(`ela_gateway_upstream_info{role="slave",prefix="",name=%q,version="8.12.2"} 1`,
+			strings.Join(slaveES.GetAddresses(), ",")),
+		`ela_gateway_upstream_info{role="route",prefix="tenant_a-",name="tenant-a",version="6.8.23"} 1`,
+	} {
+		if !strings.Contains(recorder.Body.String(), expected) {
+			t.Errorf("expect %s in the metrics %s", expected, recorder.Body.String())
+		}
+	}
+}
+
+func TestGatewaySplitBulkByIndex(t *testing.T) {
+	// every upstream answers an item of the index of each action it received
+	bodies := make(chan string, 10)
+	bulkHandler := func(name string, status int) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			bodies <- name + "\n" + string(body)
+			if status != http.StatusOK {
+				w.WriteHeader(status)
+				_, _ = w.Write([]byte(`{"error":"unavailable"}`))
+				return
+			}
+
+			var items []string
+			reader := bufio.NewReader(bytes.NewReader(body))
+			for {
+				line, err := reader.ReadBytes('\n')
+				if err != nil {
+					break
+				}
+				var action map[string]map[string]interface{}
+				_ = json.Unmarshal(line, &action)
+				if _, ok := action["delete"]; !ok {
+					_, _ = reader.ReadBytes('\n')
+				}
+				for actionType, metadata := range action {
+					items = append(items, fmt.Sprintf(`{%q:{"_index":%q,"status":201}}`, actionType, metadata["_index"]))
+				}
+			}
+			_, _ = fmt.Fprintf(w, `{"took":%d,"errors":false,"items":[%s]}`, len(items), strings.Join(items, ","))
+		}
+	}
+
+	masterES := newMockES(t, "7.10.2", bulkHandler("master", http.StatusOK))
+	gateway := newTestGateway(masterES, masterES, newMockES(t, "7.10.2", okHandler), 1024*1024)
+	gateway.WriteMode = config.WriteModeStrict
+	gateway.IndexRoutes = map[string]es.ES{
+		"tenant_a-": newMockES(t, "7.10.2", bulkHandler("a", http.StatusOK)),
+		"tenant_b-": newMockES(t, "7.10.2", bulkHandler("b", http.StatusServiceUnavailable)),
+	}
+
+	body := strings.Join([]string{
+		`{"index":{"_index":"tenant_a-1","_id":"1"}}`, `{"field":1}`,
+		`{"index":{"_index":"other","_id":"2"}}`, `{"field":2}`,
+		`{"delete":{"_index":"tenant_a-2","_id":"3"}}`,
+		`{"create":{"_index":"tenant_b-1","_id":"4"}}`, `{"field":4}`,
+		`{"delete":{"_index":"other","_id":"5"}}`,
+		`{"update":{"_index":"tenant_a-1","_id":"6"}}`, `{"doc":{"field":6}}`,
+	}, "\n") + "\n"
+	recorder := httptest.NewRecorder()
+	gateway.Engine.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/_bulk", strings.NewReader(body)))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("status %d, body %s", recorder.Code, recorder.Body.String())
+	}
+
+	received := make(map[string]string)
+	for len(bodies) > 0 {
+		upstream, subBody, _ := strings.Cut(<-bodies, "\n")
+		received[upstream] = subBody
+	}
+	for upstream, expected := range map[string]string{
+		"master": `{"index":{"_index":"other","_id":"2"}}` + "\n" + `{"field":2}` + "\n" +
+			`{"delete":{"_index":"other","_id":"5"}}` + "\n",
+		"a": `{"index":{"_index":"tenant_a-1","_id":"1"}}` + "\n" + `{"field":1}` + "\n" +
+			`{"delete":{"_index":"tenant_a-2","_id":"3"}}` + "\n" +
+			`{"update":{"_index":"tenant_a-1","_id":"6"}}` + "\n" + `{"doc":{"field":6}}` + "\n",
+		"b": `{"create":{"_index":"tenant_b-1","_id":"4"}}` + "\n" + `{"field":4}` + "\n",
+	} {
+		if received[upstream] != expected {
+			t.Errorf("expect %s to receive\n%s\ngot\n%s", upstream, expected, received[upstream])
+		}
+	}
+
+	var resp struct {
+		Took   int                                 `json:"took"`
+		Errors bool                                `json:"errors"`
+		Items  []map[string]map[string]interface{} `json:"items"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if !resp.Errors || resp.Took != 3 || len(resp.Items) != 6 {
+		t.Fatalf("unexpected response %s", recorder.Body.String())
+	}
+	for i, expected := range []string{"index tenant_a-1 201", "index other 201", "delete tenant_a-2 201",
+		"create tenant_b-1 503", "delete other 201", "update tenant_a-1 201"} {
+		for actionType, item := range resp.Items[i] {
+			if got := fmt.Sprintf("%s %v %v", actionType, item["_index"], item["status"]); got != expected {
+				t.Errorf("expect item %d %s, got %s", i, expected, got)
+			}
+		}
+	}
+}
+
+func TestGatewayUpstreamTimeoutAndRetry(t *testing.T) {
+	defer func(delay time.Duration) { upstreamRetryDelay = delay }(upstreamRetryDelay)
+	upstreamRetryDelay = time.Millisecond
+
+	release := make(chan struct{})
+	defer close(release)
+	slowES := newMockES(t, "7.10.2", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		<-release
+		_, _ = w.Write([]byte(`{}`))
+	})
+	gateway := newTestGateway(slowES, slowES, newMockES(t, "7.10.2", okHandler), 1024*1024)
+	gateway.UpstreamTimeout = 50 * time.Millisecond
+
+	startTime := time.Now()
+	recorder := httptest.NewRecorder()
+	gateway.Engine.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/a/_doc/1", nil))
+	if recorder.Code != http.StatusGatewayTimeout {
+		t.Errorf("expect a slow upstream answered 504, got %d %s", recorder.Code, recorder.Body.String())
+	}
+	if elapsed := time.Since(startTime); elapsed > time.Second {
+		t.Errorf("expect the timeout to end the request, took %s", elapsed)
+	}
+
+	// the first request of every path fails transiently
+	var requests atomic.Int32
+	flakyES := newMockES(t, "7.10.2", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		if requests.Add(1)%2 == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_, _ = w.Write([]byte(`{"error":"unavailable"}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"took":1,"errors":false,"items":[],"_index":"a","_id":"1","found":true,"_source":{}}`))
+	})
+	gateway = newTestGateway(flakyES, flakyES, newMockES(t, "7.10.2", okHandler), 1024*1024)
+	gateway.UpstreamReadRetries = 2
+
+	recorder = httptest.NewRecorder()
+	gateway.Engine.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/a/_doc/1", nil))
+	if recorder.Code != http.StatusOK || requests.Load() != 2 {
+		t.Errorf("expect the read retried once, got status %d after %d requests", recorder.Code, requests.Load())
+	}
+
+	requests.Store(0)
+	recorder = httptest.NewRecorder()
+	gateway.Engine.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/_bulk",
+		strings.NewReader(`{"index":{"_index":"a","_id":"1"}}`+"\n"+`{"field":1}`+"\n")))
+	if recorder.Code != http.StatusServiceUnavailable || requests.Load() != 1 {
+		t.Errorf("expect the write not retried, got status %d after %d requests", recorder.Code, requests.Load())
+	}
+}
@@ -0,0 +1,102 @@
+package gateway
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/samber/lo"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+type requestMetricKey struct {
+	Method string
+	Code   int
+}
+
+// upstreamLabel names an upstream cluster of the gateway, Prefix is the index prefix of a routed upstream
+type upstreamLabel struct {
+	Role    string
+	Prefix  string
+	Name    string
+	Version string
+}
+
+// gatewayMetrics counts the proxied requests, it is exposed in the prometheus text format
+type gatewayMetrics struct {
+	mutex              sync.Mutex
+	requests           map[requestMetricKey]uint64
+	slaveWriteFailures uint64
+	shadowMismatches   uint64
+	// upstreams are exposed as an info metric, the other metrics can be joined on it
+	upstreams []upstreamLabel
+}
+
+func (metrics *gatewayMetrics) observeRequest(method string, code int) {
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+
+	if metrics.requests == nil {
+		metrics.requests = make(map[requestMetricKey]uint64)
+	}
+	metrics.requests[requestMetricKey{Method: method, Code: code}]++
+}
+
+func (metrics *gatewayMetrics) observeSlaveWriteFailure() {
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+
+	metrics.slaveWriteFailures++
+}
+
+func (metrics *gatewayMetrics) observeShadowMismatch() {
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+
+	metrics.shadowMismatches++
+}
+
+func (metrics *gatewayMetrics) middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+		metrics.observeRequest(c.Request.Method, c.Writer.Status())
+	}
+}
+
+func (metrics *gatewayMetrics) format() string {
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+
+	keys := lo.Keys(metrics.requests)
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Method != keys[j].Method {
+			return keys[i].Method < keys[j].Method
+		}
+		return keys[i].Code < keys[j].Code
+	})
+
+	var builder strings.Builder
+	builder.WriteString("# HELP ela_gateway_requests_total Requests proxied by the gateway.\n")
+	builder.WriteString("# TYPE ela_gateway_requests_total counter\n")
+	for _, key := range keys {
+		builder.WriteString(fmt.Sprintf("ela_gateway_requests_total{method=%q,code=\"%d\"} %d\n", key.Method, key.Code, metrics.requests[key]))
+	}
+	builder.WriteString("# HELP ela_gateway_slave_write_failures_total Writes the master accepted but the slave failed.\n")
+	builder.WriteString("# TYPE ela_gateway_slave_write_failures_total counter\n")
+	builder.WriteString(fmt.Sprintf("ela_gateway_slave_write_failures_total %d\n", metrics.slaveWriteFailures))
+	builder.WriteString("# HELP ela_gateway_shadow_mismatches_total Reads the slave answered differently from the master.\n")
+	builder.WriteString("# TYPE ela_gateway_shadow_mismatches_total counter\n")
+	builder.WriteString(fmt.Sprintf("ela_gateway_shadow_mismatches_total %d\n", metrics.shadowMismatches))
+	builder.WriteString("# HELP ela_gateway_upstream_info The upstream clusters of the gateway.\n")
+	builder.WriteString("# TYPE ela_gateway_upstream_info gauge\n")
+	for _, upstream := range metrics.upstreams {
+		builder.WriteString(fmt.Sprintf("ela_gateway_upstream_info{role=%q,prefix=%q,name=%q,version=%q} 1\n",
+			upstream.Role, upstream.Prefix, upstream.Name, upstream.Version))
+	}
+	return builder.String()
+}
+
+func (metrics *gatewayMetrics) handler(c *gin.Context) {
+	c.String(http.StatusOK, metrics.format())
+}
@@ -0,0 +1,133 @@
+package gateway
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/CharellKing/ela-lib/config"
+	"github.com/CharellKing/ela-lib/pkg/es"
+	"github.com/gin-gonic/gin"
+	"github.com/samber/lo"
+	"io"
+	"math/rand"
+	"net/http"
+)
+
+// readActions are the reads the read weights may send to the slave and shadow compare replays, statelessRead
+// leaves out the searches of a scroll or a point in time
+var readActions = []es.RequestActionType{
+	es.RequestActionTypeGetDocument,
+	es.RequestActionTypeGetDocumentOnlySource,
+	es.RequestActionTypeMGetDocument,
+	es.RequestActionTypeSearchDocument,
+	es.RequestActionTypeSearchDocumentWithLimit,
+	es.RequestActionTypeCountDocument,
+}
+
+// statelessRead tells whether the request is one of readActions which neither opens a scroll nor searches a point
+// in time, their ids only live on the cluster which created them. A search body is read under MaxBodySize and put
+// back for the upstream.
+func (gateway *ESGateway) statelessRead(c *gin.Context, parseUriResult *es.UriPathParserResult) bool {
+	if !lo.Contains(readActions, parseUriResult.RequestAction) {
+		return false
+	}
+	if parseUriResult.RequestAction != es.RequestActionTypeSearchDocument &&
+		parseUriResult.RequestAction != es.RequestActionTypeSearchDocumentWithLimit {
+		return true
+	}
+	if c.Request.URL.Query().Has("scroll") {
+		return false
+	}
+
+	bodyBytes, err := io.ReadAll(&limitedReader{reader: c.Request.Body, remain: gateway.MaxBodySize})
+	c.Request.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(bodyBytes), c.Request.Body), c.Request.Body}
+	if err != nil {
+		// the master fails a body over MaxBodySize
+		return false
+	}
+
+	var body map[string]json.RawMessage
+	if len(bytes.TrimSpace(bodyBytes)) == 0 || json.Unmarshal(bodyBytes, &body) != nil {
+		return true
+	}
+	_, ok := body["pit"]
+	return !ok
+}
+
+// runtimeCfg is the part of the gateway config the admin endpoint changes without restart, it is replaced as a
+// whole so a request sees either the old or the new settings
+type runtimeCfg struct {
+	WriteMode     config.WriteMode   `json:"write_mode"`
+	ReadWeights   config.ReadWeights `json:"read_weights"`
+	ShadowCompare bool               `json:"shadow_compare"`
+}
+
+func (cfg *runtimeCfg) readSlave() bool {
+	total := cfg.ReadWeights.Master + cfg.ReadWeights.Slave
+	if cfg.ReadWeights.Slave == 0 || total == 0 {
+		return false
+	}
+	return uint(rand.Intn(int(total))) < cfg.ReadWeights.Slave
+}
+
+// runtimeCfg returns the settings of the admin endpoint, the configured ones until it first updates them
+func (gateway *ESGateway) runtimeCfg() *runtimeCfg {
+	if cfg := gateway.runtime.Load(); cfg != nil {
+		return cfg
+	}
+	return &runtimeCfg{
+		WriteMode:     lo.Ternary(gateway.WriteMode == "", config.WriteModeAsync, gateway.WriteMode),
+		ReadWeights:   gateway.ReadWeights,
+		ShadowCompare: gateway.ShadowCompare,
+	}
+}
+
+func (gateway *ESGateway) onGetConfig(c *gin.Context) {
+	c.JSON(http.StatusOK, gateway.runtimeCfg())
+}
+
+// onUpdateConfig applies the given mutable fields to the new requests, any other field is rejected
+func (gateway *ESGateway) onUpdateConfig(c *gin.Context) {
+	var fields map[string]json.RawMessage
+	if err := c.ShouldBindJSON(&fields); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	gateway.runtimeLock.Lock()
+	defer gateway.runtimeLock.Unlock()
+
+	cfg := *gateway.runtimeCfg()
+	for field, value := range fields {
+		var err error
+		switch field {
+		case "write_mode":
+			err = json.Unmarshal(value, &cfg.WriteMode)
+		case "read_weights":
+			err = json.Unmarshal(value, &cfg.ReadWeights)
+		case "shadow_compare":
+			err = json.Unmarshal(value, &cfg.ShadowCompare)
+		default:
+			err = fmt.Errorf("%s can not be changed at runtime", field)
+		}
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%s: %s", field, err.Error())})
+			return
+		}
+	}
+
+	if !lo.Contains([]config.WriteMode{config.WriteModeAsync, config.WriteModeBestEffortQueue, config.WriteModeStrict}, cfg.WriteMode) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid write mode %s", cfg.WriteMode)})
+		return
+	}
+	if cfg.WriteMode == config.WriteModeBestEffortQueue && gateway.Compensation == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("write mode %s requires compensation_file", cfg.WriteMode)})
+		return
+	}
+
+	gateway.runtime.Store(&cfg)
+	c.JSON(http.StatusOK, &cfg)
+}
@@ -0,0 +1,83 @@
+package gateway
+
+import (
+	"bytes"
+	"github.com/CharellKing/ela-lib/pkg/es"
+	"github.com/CharellKing/ela-lib/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/spf13/cast"
+	"reflect"
+	"time"
+)
+
+// shadowCompare replays a read the master served on the slave and reports when the slave answers differently.
+// masterResponse is already translated for the source version. A mismatch is read again ShadowRetries times,
+// the slave may answer from before its refresh right after a write.
+func (gateway *ESGateway) shadowCompare(c *gin.Context, body []byte, parseUriResult *es.UriPathParserResult,
+	masterStatus int, masterResponse map[string]interface{}) {
+	masterView := shadowView(parseUriResult.RequestAction, masterResponse)
+	var slaveStatus int
+	for attempt := 0; ; attempt++ {
+		slaveResponse, status, err := gateway.requestUpstream(c, gateway.SlaveES, bytes.NewReader(body), parseUriResult)
+		if err != nil {
+			utils.GetLogger(c).Errorf("shadow read error: %+v", err)
+			return
+		}
+		slaveResponse = translateResponse(gateway.SlaveES.GetClusterVersion(), gateway.SourceES.GetClusterVersion(),
+			parseUriResult.RequestAction, slaveResponse)
+
+		slaveStatus = status
+		if masterStatus == slaveStatus && reflect.DeepEqual(masterView, shadowView(parseUriResult.RequestAction, slaveResponse)) {
+			return
+		}
+		if attempt >= gateway.ShadowRetries {
+			break
+		}
+		time.Sleep(gateway.ShadowRetryDelay)
+	}
+
+	gateway.metrics.observeShadowMismatch()
+	// the uri keeps the query, a routed read is looked for on the same shards of both clusters
+	utils.GetLogger(c).Warnf("shadow read mismatch %s %s, master status %d, slave status %d",
+		parseUriResult.RequestAction, c.Request.URL.RequestURI(), masterStatus, slaveStatus)
+}
+
+// shadowView keeps the part of a read response both clusters have to agree on, the timings, shards, scores and
+// versions legitimately differ
+func shadowView(action es.RequestActionType, response map[string]interface{}) interface{} {
+	docView := func(doc interface{}) interface{} {
+		docMap := cast.ToStringMap(doc)
+		return map[string]interface{}{
+			"_id":     docMap["_id"],
+			"found":   docMap["found"],
+			"_source": docMap["_source"],
+		}
+	}
+
+	switch action {
+	case es.RequestActionTypeGetDocument:
+		return docView(response)
+	case es.RequestActionTypeMGetDocument:
+		docs := cast.ToSlice(response["docs"])
+		views := make([]interface{}, 0, len(docs))
+		for _, doc := range docs {
+			views = append(views, docView(doc))
+		}
+		return views
+	case es.RequestActionTypeSearchDocument, es.RequestActionTypeSearchDocumentWithLimit:
+		hits := cast.ToStringMap(response["hits"])
+		docs := cast.ToSlice(hits["hits"])
+		views := make([]interface{}, 0, len(docs))
+		for _, doc := range docs {
+			views = append(views, docView(doc))
+		}
+		return map[string]interface{}{
+			"total": hits["total"],
+			"hits":  views,
+		}
+	case es.RequestActionTypeCountDocument:
+		return response["count"]
+	default:
+		return response
+	}
+}
@@ -0,0 +1,107 @@
+package gateway
+
+import (
+	"github.com/CharellKing/ela-lib/pkg/es"
+	"github.com/gin-gonic/gin"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestShadowCompareRetry(t *testing.T) {
+	masterES := newMockES(t, "7.10.2", okHandler)
+
+	for _, testCase := range []struct {
+		retries            int
+		expectedReads      int32
+		expectedMismatches uint64
+	}{
+		{0, 1, 1},
+		{1, 2, 0},
+		{3, 2, 0},
+	} {
+		// the slave answers the old source until it refreshes after the first read
+		var slaveReads atomic.Int32
+		slaveES := newMockES(t, "7.10.2", func(w http.ResponseWriter, r *http.Request) {
+			_, _ = io.Copy(io.Discard, r.Body)
+			if slaveReads.Add(1) == 1 {
+				_, _ = w.Write([]byte(`{"_index":"a","_id":"1","found":true,"_source":{"field":"old"}}`))
+				return
+			}
+			_, _ = w.Write([]byte(`{"_index":"a","_id":"1","found":true,"_source":{"field":"new"}}`))
+		})
+		gateway := newTestGateway(masterES, masterES, slaveES, 1024)
+		gateway.ShadowRetries = testCase.retries
+		gateway.ShadowRetryDelay = time.Millisecond
+
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodGet, "/a/_doc/1", nil)
+		parseUriResult := masterES.MatchRule(c)
+		if parseUriResult == nil {
+			t.Fatalf("no rule for %s", c.Request.URL.Path)
+		}
+
+		masterResponse := map[string]interface{}{
+			"_index": "a", "_id": "1", "found": true, "_source": map[string]interface{}{"field": "new"},
+		}
+		gateway.shadowCompare(c, nil, parseUriResult, http.StatusOK, masterResponse)
+
+		if slaveReads.Load() != testCase.expectedReads {
+			t.Errorf("retries %d: expect %d slave reads, got %d", testCase.retries, testCase.expectedReads, slaveReads.Load())
+		}
+		if gateway.metrics.shadowMismatches != testCase.expectedMismatches {
+			t.Errorf("retries %d: expect %d mismatches, got %d", testCase.retries, testCase.expectedMismatches,
+				gateway.metrics.shadowMismatches)
+		}
+	}
+}
+
+func TestGatewayRoutedReads(t *testing.T) {
+	// every upstream records the routing of the reads it is sent
+	newRoutingES := func(routings chan string) es.ES {
+		return newMockES(t, "7.10.2", func(w http.ResponseWriter, r *http.Request) {
+			_, _ = io.Copy(io.Discard, r.Body)
+			routings <- r.URL.Path + "?" + r.URL.Query().Get("routing")
+			_, _ = w.Write([]byte(`{"took":1,"hits":{"total":{"value":0},"hits":[]},"_index":"a","_id":"1","found":false}`))
+		})
+	}
+	masterRoutings, slaveRoutings := make(chan string, 4), make(chan string, 4)
+	masterES, slaveES := newRoutingES(masterRoutings), newRoutingES(slaveRoutings)
+	gateway := newTestGateway(masterES, masterES, slaveES, 1024*1024)
+	gateway.ShadowCompare = true
+
+	for _, request := range []*http.Request{
+		httptest.NewRequest(http.MethodPost, "/a/_search?routing=user-1", strings.NewReader(`{"query":{"match_all":{}}}`)),
+		httptest.NewRequest(http.MethodGet, "/a/_doc/1?routing=user-1", nil),
+	} {
+		recorder := httptest.NewRecorder()
+		gateway.Engine.ServeHTTP(recorder, request)
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("%s: unexpected status %d %s", request.URL, recorder.Code, recorder.Body.String())
+		}
+
+		// the shadow read is sent to the slave after the client is answered
+		expected := request.URL.Path + "?user-1"
+		for name, routings := range map[string]chan string{"master": masterRoutings, "slave": slaveRoutings} {
+			select {
+			case routing := <-routings:
+				if routing != expected {
+					t.Errorf("%s: expect %s, got %s", name, expected, routing)
+				}
+			case <-time.After(time.Second):
+				t.Errorf("%s: expect a read of %s", name, request.URL)
+			}
+		}
+	}
+
+	// a read is compared on the slave, not replayed to it as a write too
+	select {
+	case routing := <-slaveRoutings:
+		t.Errorf("expect no other slave read, got %s", routing)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
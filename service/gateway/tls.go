@@ -0,0 +1,45 @@
+package gateway
+
+import (
+	"crypto/tls"
+	"github.com/CharellKing/ela-lib/config"
+	"github.com/CharellKing/ela-lib/utils"
+	"github.com/pkg/errors"
+)
+
+const defaultTLSMinVersion = tls.VersionTLS12
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// newTLSConfig loads the certificate of the gateway, nil means the gateway serves plaintext
+func newTLSConfig(gatewayCfg *config.GatewayCfg) (*tls.Config, error) {
+	if gatewayCfg.TLSCertFile == "" && gatewayCfg.TLSKeyFile == "" {
+		return nil, nil
+	}
+	if gatewayCfg.TLSCertFile == "" || gatewayCfg.TLSKeyFile == "" {
+		return nil, utils.NewCustomError(utils.InvalidParams, "tls_cert_file and tls_key_file must be set together")
+	}
+
+	minVersion := uint16(defaultTLSMinVersion)
+	if gatewayCfg.TLSMinVersion != "" {
+		var ok bool
+		if minVersion, ok = tlsVersions[gatewayCfg.TLSMinVersion]; !ok {
+			return nil, utils.NewCustomError(utils.InvalidParams, "invalid tls min version %s", gatewayCfg.TLSMinVersion)
+		}
+	}
+
+	certificate, err := tls.LoadX509KeyPair(gatewayCfg.TLSCertFile, gatewayCfg.TLSKeyFile)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{certificate},
+		MinVersion:   minVersion,
+	}, nil
+}
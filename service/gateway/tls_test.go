@@ -0,0 +1,120 @@
+package gateway
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"github.com/CharellKing/ela-lib/config"
+	"github.com/gin-gonic/gin"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeTestCertificate writes a self signed certificate of 127.0.0.1
+func writeTestCertificate(t *testing.T) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "ela-gateway"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER}), 0600); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	return certFile, keyFile
+}
+
+func TestGatewayTLS(t *testing.T) {
+	certFile, keyFile := writeTestCertificate(t)
+	tlsConfig, err := newTLSConfig(&config.GatewayCfg{
+		TLSCertFile:   certFile,
+		TLSKeyFile:    keyFile,
+		TLSMinVersion: "1.3",
+	})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	gateway := &ESGateway{Engine: gin.New(), TLSConfig: tlsConfig}
+	gateway.onRequest()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	server := gateway.newServer()
+	go func() {
+		_ = server.ServeTLS(listener, "", "")
+	}()
+	t.Cleanup(func() {
+		_ = server.Close()
+	})
+
+	url := "https://" + listener.Addr().String() + healthzPath
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+	res, err := client.Get(url)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	_ = res.Body.Close()
+	if res.StatusCode != http.StatusOK || res.TLS == nil || res.TLS.Version != tls.VersionTLS13 {
+		t.Fatalf("unexpected response status %d, tls %+v", res.StatusCode, res.TLS)
+	}
+
+	oldClient := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+		InsecureSkipVerify: true,
+		MaxVersion:         tls.VersionTLS12,
+	}}}
+	if _, err := oldClient.Get(url); err == nil {
+		t.Fatalf("expect tls 1.2 to be rejected")
+	}
+}
+
+func TestNewTLSConfig(t *testing.T) {
+	if tlsConfig, err := newTLSConfig(&config.GatewayCfg{}); err != nil || tlsConfig != nil {
+		t.Fatalf("expect plaintext without certificate, got %+v %+v", tlsConfig, err)
+	}
+	if _, err := newTLSConfig(&config.GatewayCfg{TLSCertFile: "cert.pem"}); err == nil {
+		t.Fatalf("expect an error without key file")
+	}
+
+	certFile, keyFile := writeTestCertificate(t)
+	if _, err := newTLSConfig(&config.GatewayCfg{TLSCertFile: certFile, TLSKeyFile: keyFile, TLSMinVersion: "2.0"}); err == nil {
+		t.Fatalf("expect an error for an invalid min version")
+	}
+	tlsConfig, err := newTLSConfig(&config.GatewayCfg{TLSCertFile: certFile, TLSKeyFile: keyFile})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if tlsConfig.MinVersion != tls.VersionTLS12 {
+		t.Fatalf("expect tls 1.2 by default, got %x", tlsConfig.MinVersion)
+	}
+}
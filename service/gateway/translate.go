@@ -0,0 +1,30 @@
+package gateway
+
+import (
+	"github.com/CharellKing/ela-lib/pkg/es"
+	"github.com/spf13/cast"
+	"strings"
+)
+
+func versionGte7(version string) bool {
+	segments := strings.Split(version, ".")
+	return cast.ToInt(segments[0]) >= 7
+}
+
+// translateResponse reshapes the body a fromVersion cluster answered into what a toVersion client expects.
+// Since 7.x hits.total is an object of value and relation, before it is a number.
+func translateResponse(fromVersion string, toVersion string, action es.RequestActionType, body map[string]interface{}) map[string]interface{} {
+	toGte7 := versionGte7(toVersion)
+	if versionGte7(fromVersion) == toGte7 {
+		return body
+	}
+
+	switch action {
+	case es.RequestActionTypeSearchDocument, es.RequestActionTypeSearchDocumentWithLimit,
+		es.RequestActionTypeSearchScroll, es.RequestActionTypeSearchScrollWithBody:
+		es.TranslateHitsTotal(body, toGte7)
+	case es.RequestActionTypeCountDocument:
+		// _count answers a plain number in every version, it is passed through as is
+	}
+	return body
+}
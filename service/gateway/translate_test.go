@@ -0,0 +1,46 @@
+package gateway
+
+import (
+	"encoding/json"
+	"github.com/CharellKing/ela-lib/pkg/es"
+	"reflect"
+	"testing"
+)
+
+func TestTranslateResponse(t *testing.T) {
+	const (
+		searchV6 = `{"hits":{"total":2,"hits":[{"_source":{"hits":{"total":1}}}]},
+			"aggregations":{"by_user":{"buckets":[{"key":"a","top":{"hits":{"total":2,"hits":[]}}}]}}}`
+		searchV7 = `{"hits":{"total":{"value":2,"relation":"eq"},"hits":[{"_source":{"hits":{"total":1}}}]},
+			"aggregations":{"by_user":{"buckets":[{"key":"a","top":{"hits":{"total":{"value":2,"relation":"eq"},"hits":[]}}}]}}}`
+		count = `{"count":2,"_shards":{"total":1,"successful":1,"skipped":0,"failed":0}}`
+	)
+
+	cases := []struct {
+		fromVersion string
+		toVersion   string
+		action      es.RequestActionType
+		body        string
+		expected    string
+	}{
+		{"7.10.2", "6.8.23", es.RequestActionTypeSearchDocument, searchV7, searchV6},
+		{"6.8.23", "7.10.2", es.RequestActionTypeSearchDocumentWithLimit, searchV6, searchV7},
+		{"8.12.2", "5.6.16", es.RequestActionTypeSearchScroll, searchV7, searchV6},
+		{"7.10.2", "8.12.2", es.RequestActionTypeSearchDocument, searchV7, searchV7},
+		{"6.8.23", "5.6.16", es.RequestActionTypeSearchDocument, searchV6, searchV6},
+		{"7.10.2", "6.8.23", es.RequestActionTypeCountDocument, count, count},
+		{"6.8.23", "7.10.2", es.RequestActionTypeCountDocument, count, count},
+	}
+
+	for _, item := range cases {
+		var body, expected map[string]interface{}
+		_ = json.Unmarshal([]byte(item.body), &body)
+		_ = json.Unmarshal([]byte(item.expected), &expected)
+
+		actual := translateResponse(item.fromVersion, item.toVersion, item.action, body)
+		if !reflect.DeepEqual(actual, expected) {
+			actualBytes, _ := json.Marshal(actual)
+			t.Errorf("%s -> %s %s: %s", item.fromVersion, item.toVersion, item.action, actualBytes)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"github.com/CharellKing/ela-lib/pkg/es"
+	"github.com/CharellKing/ela-lib/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/pkg/errors"
+	"github.com/samber/lo"
+	"io"
+	"net/http"
+	"time"
+)
+
+// upstreamRetryDelay waits before a read is retried, the transient failures are usually short
+var upstreamRetryDelay = 100 * time.Millisecond
+
+// upstreamRetryStatuses are the answers of an upstream which is restarting or overloaded
+var upstreamRetryStatuses = []int{http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout}
+
+// requestUpstream sends the request to upstream within UpstreamTimeout. A stateless read which fails to reach the
+// upstream or is answered by a restarting one is sent again up to UpstreamReadRetries times, its body is buffered
+// then. A timed out read is not retried, the client already waited the whole timeout.
+func (gateway *ESGateway) requestUpstream(c *gin.Context, upstream es.ES, body io.Reader,
+	parseUriResult *es.UriPathParserResult) (map[string]interface{}, int, error) {
+	retries := lo.Ternary(lo.Contains(readActions, parseUriResult.RequestAction), gateway.UpstreamReadRetries, 0)
+	var bodyBytes []byte
+	if retries > 0 && body != nil {
+		var err error
+		if bodyBytes, err = io.ReadAll(body); err != nil {
+			return nil, http.StatusInternalServerError, errors.WithStack(err)
+		}
+	}
+
+	for attempt := 0; ; attempt++ {
+		if retries > 0 {
+			body = bytes.NewReader(bodyBytes)
+		}
+		resp, statusCode, err := gateway.requestUpstreamOnce(c, upstream, body, parseUriResult)
+		transient := lo.Ternary(err != nil, !errors.Is(err, context.DeadlineExceeded),
+			lo.Contains(upstreamRetryStatuses, statusCode))
+		if attempt >= retries || !transient {
+			return resp, statusCode, err
+		}
+
+		utils.GetLogger(c).Warnf("retry read on %s after status %d %v", upstream.GetAddresses(), statusCode, err)
+		time.Sleep(upstreamRetryDelay)
+	}
+}
+
+// requestUpstreamOnce runs the request on a copy of c whose context ends after UpstreamTimeout. It is detached from
+// the client request, the slave writes and the shadow reads go on after the client was answered.
+func (gateway *ESGateway) requestUpstreamOnce(c *gin.Context, upstream es.ES, body io.Reader,
+	parseUriResult *es.UriPathParserResult) (map[string]interface{}, int, error) {
+	ctx := context.WithoutCancel(c.Request.Context())
+	if gateway.UpstreamTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, gateway.UpstreamTimeout)
+		defer cancel()
+	}
+
+	upstreamCtx := c.Copy()
+	upstreamCtx.Request = c.Request.WithContext(ctx)
+	resp, statusCode, err := upstream.Request(upstreamCtx, body, parseUriResult)
+	if errors.Is(err, context.DeadlineExceeded) {
+		return resp, http.StatusGatewayTimeout, err
+	}
+	return resp, statusCode, err
+}
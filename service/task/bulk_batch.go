@@ -0,0 +1,151 @@
+package task
+
+import (
+	"bytes"
+	es2 "github.com/CharellKing/ela-lib/pkg/es"
+	"github.com/pkg/errors"
+	"sync"
+)
+
+// maxPooledBufferSize keeps a buffer grown by an oversized batch out of the pool
+const maxPooledBufferSize = 64 * 1024 * 1024
+
+// bufferPool holds the bulk body buffers of the finished workers, so that a new worker does not grow its own
+var bufferPool = sync.Pool{
+	New: func() interface{} {
+		return new(bytes.Buffer)
+	},
+}
+
+func getBuffer() *bytes.Buffer {
+	buf := bufferPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	return buf
+}
+
+func putBuffer(buf *bytes.Buffer) {
+	if buf.Cap() <= maxPooledBufferSize {
+		bufferPool.Put(buf)
+	}
+}
+
+// bulkBatch buffers the bulk body until it is flushed. With dedup enabled only the last action of
+// every doc id is kept, so a batch never carries stale state for an id written twice.
+type bulkBatch struct {
+	dedup bool
+	// keepDocs keeps the sent docs in the order of the body, to tell which doc a failed action wrote
+	keepDocs bool
+
+	buf *bytes.Buffer
+
+	items  [][]byte
+	idxMap map[string]int
+	size   int
+	docs   int
+
+	itemDocs []*es2.Doc
+	sent     []*es2.Doc
+}
+
+func newBulkBatch(dedup bool, keepDocs bool) *bulkBatch {
+	return &bulkBatch{
+		dedup:    dedup,
+		keepDocs: keepDocs,
+		buf:      getBuffer(),
+		idxMap:   make(map[string]int),
+	}
+}
+
+func (b *bulkBatch) add(es es2.ES, index string, doc *es2.Doc) error {
+	if !b.dedup {
+		if err := es.BulkBody(index, b.buf, doc); err != nil {
+			return errors.WithStack(err)
+		}
+		b.docs++
+		if b.keepDocs {
+			b.sent = append(b.sent, doc)
+		}
+		return nil
+	}
+
+	itemBuf := getBuffer()
+	defer putBuffer(itemBuf)
+	if err := es.BulkBody(index, itemBuf, doc); err != nil {
+		return errors.WithStack(err)
+	}
+
+	// a doc without _id gets a new one from es, it never duplicates another
+	if doc.ID != "" {
+		if idx, ok := b.idxMap[doc.ID]; ok {
+			b.size -= len(b.items[idx])
+			b.items[idx] = nil
+			b.docs--
+		}
+		b.idxMap[doc.ID] = len(b.items)
+	}
+	// the pooled buffer is reused, so the item keeps a copy
+	b.items = append(b.items, bytes.Clone(itemBuf.Bytes()))
+	if b.keepDocs {
+		b.itemDocs = append(b.itemDocs, doc)
+	}
+	b.size += itemBuf.Len()
+	b.docs++
+	return nil
+}
+
+// docCount is the number of docs the batch writes, a deduplicated doc counts once
+func (b *bulkBatch) docCount() int {
+	return b.docs
+}
+
+func (b *bulkBatch) Len() int {
+	if !b.dedup {
+		return b.buf.Len()
+	}
+	return b.size
+}
+
+// body returns the bulk body, the buffer is valid until reset is called.
+func (b *bulkBatch) body() *bytes.Buffer {
+	if b.dedup {
+		for idx, item := range b.items {
+			if item == nil {
+				continue
+			}
+			b.buf.Write(item)
+			if b.keepDocs {
+				b.sent = append(b.sent, b.itemDocs[idx])
+			}
+		}
+		b.items = b.items[:0]
+		b.itemDocs = b.itemDocs[:0]
+		b.idxMap = make(map[string]int)
+		b.size = 0
+	}
+	return b.buf
+}
+
+// sentDoc returns the doc of the action at position of the body, nil unless keepDocs
+func (b *bulkBatch) sentDoc(position int) *es2.Doc {
+	if position < 0 || position >= len(b.sent) {
+		return nil
+	}
+	return b.sent[position]
+}
+
+func (b *bulkBatch) reset() {
+	b.buf.Reset()
+	b.items = b.items[:0]
+	b.itemDocs = b.itemDocs[:0]
+	b.sent = b.sent[:0]
+	b.idxMap = make(map[string]int)
+	b.size = 0
+	b.docs = 0
+}
+
+// release returns the buffer to the pool, the batch is not usable afterwards. The es clients read the bulk body
+// before Bulk returns, so no request holds the buffer by then.
+func (b *bulkBatch) release() {
+	putBuffer(b.buf)
+	b.buf = nil
+}
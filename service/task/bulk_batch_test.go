@@ -0,0 +1,103 @@
+package task
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	es2 "github.com/CharellKing/ela-lib/pkg/es"
+	"testing"
+)
+
+func bulkActions(t *testing.T, body *bytes.Buffer) []string {
+	var actions []string
+	for _, line := range bytes.Split(bytes.TrimSpace(body.Bytes()), []byte("\n")) {
+		var meta map[string]interface{}
+		if err := json.Unmarshal(line, &meta); err != nil {
+			t.Fatalf("%+v", err)
+		}
+		for action, metadata := range meta {
+			if metadata, ok := metadata.(map[string]interface{}); ok && metadata["_index"] != nil {
+				actions = append(actions, action+":"+metadata["_id"].(string))
+			}
+		}
+	}
+	return actions
+}
+
+func TestBulkBatchDedup(t *testing.T) {
+	es := &es2.V7{}
+	docs := []*es2.Doc{
+		{ID: "1", Op: es2.OperationCreate, Source: map[string]interface{}{"v": 1}},
+		{ID: "2", Op: es2.OperationCreate, Source: map[string]interface{}{"v": 1}},
+		{ID: "1", Op: es2.OperationUpdate, Type: "doc", Source: map[string]interface{}{"v": 2}},
+		{ID: "1", Op: es2.OperationDelete},
+	}
+
+	batch := newBulkBatch(false, false)
+	for _, doc := range docs {
+		if err := batch.add(es, "idx", doc); err != nil {
+			t.Fatalf("%+v", err)
+		}
+	}
+	if actions := bulkActions(t, batch.body()); len(actions) != 4 {
+		t.Fatalf("expected every action without dedup, got %+v", actions)
+	}
+	batch.reset()
+
+	batch = newBulkBatch(true, true)
+	for _, doc := range docs {
+		if err := batch.add(es, "idx", doc); err != nil {
+			t.Fatalf("%+v", err)
+		}
+	}
+	size := batch.Len()
+	body := batch.body()
+	if size != body.Len() {
+		t.Fatalf("expected tracked size %d to match body %d", size, body.Len())
+	}
+
+	actions := bulkActions(t, body)
+	if len(actions) != 2 || actions[0] != "index:2" || actions[1] != "delete:1" {
+		t.Fatalf("expected last action per id, got %+v", actions)
+	}
+	if batch.sentDoc(0) != docs[1] || batch.sentDoc(1) != docs[3] || batch.sentDoc(2) != nil {
+		t.Fatalf("expected the sent docs in the body order")
+	}
+
+	batch.reset()
+	if batch.Len() != 0 {
+		t.Fatalf("expected empty batch after reset, got %d", batch.Len())
+	}
+}
+
+// BenchmarkBulkBatch runs the batches of short lived bulk workers, each batch is flushed twice
+func BenchmarkBulkBatch(b *testing.B) {
+	es := &es2.V7{}
+	docs := make([]*es2.Doc, 0, 500)
+	for i := 0; i < 500; i++ {
+		docs = append(docs, &es2.Doc{
+			ID:     fmt.Sprintf("%d", i),
+			Op:     es2.OperationCreate,
+			Source: map[string]interface{}{"message": "GET /api/v1/items 200", "status": 200, "n": i},
+		})
+	}
+
+	for _, dedup := range []bool{false, true} {
+		b.Run(fmt.Sprintf("dedup=%v", dedup), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				batch := newBulkBatch(dedup, false)
+				for flush := 0; flush < 2; flush++ {
+					for _, doc := range docs {
+						if err := batch.add(es, "idx", doc); err != nil {
+							b.Fatal(err)
+						}
+					}
+					_ = batch.body()
+					batch.reset()
+				}
+				batch.release()
+			}
+		})
+	}
+}
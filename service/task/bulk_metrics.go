@@ -0,0 +1,110 @@
+package task
+
+import (
+	"fmt"
+	"github.com/spf13/cast"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// bulkDurationBuckets are the upper bounds in seconds of the bulk request durations
+	bulkDurationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+	// bulkDocsBuckets are the upper bounds of the docs sent in a bulk request
+	bulkDocsBuckets = []float64{1, 10, 50, 100, 250, 500, 1000, 2500, 5000, 10000}
+)
+
+// histogram counts the observations per bucket, counts[i] are the ones up to bounds[i] and the last one the rest
+type histogram struct {
+	bounds []float64
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func newHistogram(bounds []float64) histogram {
+	return histogram{bounds: bounds, counts: make([]uint64, len(bounds)+1)}
+}
+
+func (h *histogram) observe(value float64) {
+	i := 0
+	for i < len(h.bounds) && value > h.bounds[i] {
+		i++
+	}
+	h.counts[i]++
+	h.count++
+	h.sum += value
+}
+
+// format writes the histogram in the prometheus text format, its buckets are cumulative
+func (h *histogram) format(builder *strings.Builder, name string, help string) {
+	builder.WriteString(fmt.Sprintf("# HELP %s %s\n", name, help))
+	builder.WriteString(fmt.Sprintf("# TYPE %s histogram\n", name))
+	var cumulative uint64
+	for i, bound := range h.bounds {
+		cumulative += h.counts[i]
+		builder.WriteString(fmt.Sprintf("%s_bucket{le=%q} %d\n", name, cast.ToString(bound), cumulative))
+	}
+	builder.WriteString(fmt.Sprintf("%s_bucket{le=\"+Inf\"} %d\n", name, h.count))
+	builder.WriteString(fmt.Sprintf("%s_sum %s\n", name, cast.ToString(h.sum)))
+	builder.WriteString(fmt.Sprintf("%s_count %d\n", name, h.count))
+}
+
+// BulkMetrics observes the bulk requests of the migrators sharing it, to see the effect of tuning the write size
+// and parallelism. It is exposed in the prometheus text format, a nil BulkMetrics observes nothing.
+type BulkMetrics struct {
+	mutex    sync.Mutex
+	duration histogram
+	docs     histogram
+	inflight int64
+}
+
+func NewBulkMetrics() *BulkMetrics {
+	return &BulkMetrics{
+		duration: newHistogram(bulkDurationBuckets),
+		docs:     newHistogram(bulkDocsBuckets),
+	}
+}
+
+// startRequest counts a bulk request in flight, the returned func observes its duration once it is answered
+func (metrics *BulkMetrics) startRequest() func() {
+	if metrics == nil {
+		return func() {}
+	}
+
+	metrics.mutex.Lock()
+	metrics.inflight++
+	metrics.mutex.Unlock()
+
+	startTime := time.Now()
+	return func() {
+		metrics.mutex.Lock()
+		defer metrics.mutex.Unlock()
+		metrics.inflight--
+		metrics.duration.observe(time.Since(startTime).Seconds())
+	}
+}
+
+func (metrics *BulkMetrics) observeDocs(docs int) {
+	if metrics == nil {
+		return
+	}
+
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+	metrics.docs.observe(float64(docs))
+}
+
+func (metrics *BulkMetrics) Format() string {
+	metrics.mutex.Lock()
+	defer metrics.mutex.Unlock()
+
+	var builder strings.Builder
+	metrics.duration.format(&builder, "ela_bulk_request_duration_seconds", "Duration of the bulk requests to the target.")
+	metrics.docs.format(&builder, "ela_bulk_request_docs", "Docs sent in a bulk request to the target.")
+	builder.WriteString("# HELP ela_bulk_requests_inflight Bulk requests sent to the target and not answered yet.\n")
+	builder.WriteString("# TYPE ela_bulk_requests_inflight gauge\n")
+	builder.WriteString(fmt.Sprintf("ela_bulk_requests_inflight %d\n", metrics.inflight))
+	return builder.String()
+}
@@ -0,0 +1,43 @@
+package task
+
+import (
+	"context"
+	"github.com/CharellKing/ela-lib/config"
+	es2 "github.com/CharellKing/ela-lib/pkg/es"
+	"strings"
+	"testing"
+)
+
+func TestMigratorWithBulkMetrics(t *testing.T) {
+	sourceES := newFakeES(map[string][]*es2.Doc{"a": newFakeDocs(25)})
+	metrics := NewBulkMetrics()
+
+	err := NewMigrator(context.Background(), sourceES, newFakeES(nil)).
+		WithIndexPair(config.IndexPair{SourceIndex: "a", TargetIndex: "b"}).
+		WithActionParallelism(1).
+		WithBulkMetrics(metrics).
+		Sync(false)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	// the 25 docs fit a bulk request
+	if metrics.duration.count != 1 || metrics.docs.count != 1 || metrics.docs.sum != 25 || metrics.inflight != 0 {
+		t.Errorf("unexpected observations, duration %+v, docs %+v, inflight %d",
+			metrics.duration, metrics.docs, metrics.inflight)
+	}
+
+	text := metrics.Format()
+	for _, line := range []string{
+		`ela_bulk_request_docs_bucket{le="10"} 0`,
+		`ela_bulk_request_docs_bucket{le="50"} 1`,
+		`ela_bulk_request_docs_bucket{le="+Inf"} 1`,
+		`ela_bulk_request_docs_sum 25`,
+		`ela_bulk_request_duration_seconds_count 1`,
+		`ela_bulk_requests_inflight 0`,
+	} {
+		if !strings.Contains(text, line+"\n") {
+			t.Errorf("expect %s in\n%s", line, text)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package task
+
+import (
+	"bytes"
+	es2 "github.com/CharellKing/ela-lib/pkg/es"
+	"github.com/pkg/errors"
+	"io"
+)
+
+// bulkWriter collects the actions of one bulk request, it is either buffered by bulkBatch or streamed by bulkStream
+type bulkWriter interface {
+	add(es es2.ES, index string, doc *es2.Doc) error
+	// Len is the size of the body written since the last reset
+	Len() int
+	docCount() int
+	sentDoc(position int) *es2.Doc
+	reset()
+	release()
+}
+
+// bulkStream writes every action to the body of a running bulk request, so only one action is held in memory
+// instead of the whole batch. The request starts with the first action and ends on close.
+type bulkStream struct {
+	send func(body io.Reader) error
+	// keepDocs keeps the sent docs in the order of the body, to tell which doc a failed action wrote
+	keepDocs bool
+
+	buf    *bytes.Buffer
+	writer *io.PipeWriter
+	done   chan error
+	size   int
+	docs   int
+	sent   []*es2.Doc
+}
+
+func newBulkStream(send func(body io.Reader) error, keepDocs bool) *bulkStream {
+	return &bulkStream{
+		send:     send,
+		keepDocs: keepDocs,
+		buf:      getBuffer(),
+	}
+}
+
+func (s *bulkStream) add(es es2.ES, index string, doc *es2.Doc) error {
+	s.buf.Reset()
+	if err := es.BulkBody(index, s.buf, doc); err != nil {
+		return errors.WithStack(err)
+	}
+
+	if s.writer == nil {
+		s.start()
+	}
+	// a write fails only when the request already ended, close returns the error of the request
+	_, _ = s.writer.Write(s.buf.Bytes())
+	s.size += s.buf.Len()
+	s.docs++
+	if s.keepDocs {
+		s.sent = append(s.sent, doc)
+	}
+	return nil
+}
+
+func (s *bulkStream) start() {
+	reader, writer := io.Pipe()
+	s.writer = writer
+	s.done = make(chan error, 1)
+	go func() {
+		err := s.send(reader)
+		// the request may end before it read the whole body, unblock the writer then
+		_ = reader.CloseWithError(err)
+		s.done <- err
+	}()
+}
+
+// close ends the body and waits for the answer of the request
+func (s *bulkStream) close() error {
+	if s.writer == nil {
+		return nil
+	}
+	_ = s.writer.Close()
+	return <-s.done
+}
+
+func (s *bulkStream) docCount() int {
+	return s.docs
+}
+
+func (s *bulkStream) Len() int {
+	return s.size
+}
+
+// sentDoc returns the doc of the action at position of the body, nil unless keepDocs
+func (s *bulkStream) sentDoc(position int) *es2.Doc {
+	if position < 0 || position >= len(s.sent) {
+		return nil
+	}
+	return s.sent[position]
+}
+
+func (s *bulkStream) reset() {
+	s.writer = nil
+	s.done = nil
+	s.sent = s.sent[:0]
+	s.size = 0
+	s.docs = 0
+}
+
+// release returns the buffer to the pool, the stream must be closed before
+func (s *bulkStream) release() {
+	putBuffer(s.buf)
+	s.buf = nil
+}
@@ -0,0 +1,80 @@
+package task
+
+import (
+	"bytes"
+	es2 "github.com/CharellKing/ela-lib/pkg/es"
+	"github.com/pkg/errors"
+	"io"
+	"testing"
+)
+
+func TestBulkStreamMatchesBatch(t *testing.T) {
+	es := &es2.V7{}
+	docs := []*es2.Doc{
+		{ID: "1", Op: es2.OperationCreate, Source: map[string]interface{}{"v": 1}},
+		{ID: "2", Op: es2.OperationCreateOnly, Routing: "r", Source: map[string]interface{}{"v": 1}},
+		{ID: "1", Op: es2.OperationUpdate, Type: "doc", Source: map[string]interface{}{"v": 2}},
+		{ID: "2", Op: es2.OperationDelete},
+	}
+
+	batch := newBulkBatch(false, false)
+	defer batch.release()
+	var streamed []*bytes.Buffer
+	stream := newBulkStream(func(body io.Reader) error {
+		buf := &bytes.Buffer{}
+		_, err := buf.ReadFrom(body)
+		streamed = append(streamed, buf)
+		return err
+	}, true)
+	defer stream.release()
+
+	for _, doc := range docs {
+		if err := batch.add(es, "idx", doc); err != nil {
+			t.Fatalf("%+v", err)
+		}
+		if err := stream.add(es, "idx", doc); err != nil {
+			t.Fatalf("%+v", err)
+		}
+	}
+	if stream.Len() != batch.Len() || stream.docCount() != len(docs) {
+		t.Fatalf("expect the size %d of %d docs, got %d of %d", batch.Len(), len(docs), stream.Len(), stream.docCount())
+	}
+	if err := stream.close(); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if len(streamed) != 1 || !bytes.Equal(streamed[0].Bytes(), batch.body().Bytes()) {
+		t.Fatalf("expect the streamed body to equal the buffered one, got %v", streamed)
+	}
+	if stream.sentDoc(2) != docs[2] {
+		t.Errorf("expect the third action to write the third doc")
+	}
+
+	// every flush starts a new request
+	stream.reset()
+	if err := stream.add(es, "idx", docs[0]); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if err := stream.close(); err != nil || len(streamed) != 2 {
+		t.Fatalf("expect a second request, got %d with %v", len(streamed), err)
+	}
+}
+
+func TestBulkStreamRequestError(t *testing.T) {
+	es := &es2.V7{}
+	sendErr := errors.New("connection refused")
+	stream := newBulkStream(func(body io.Reader) error {
+		return sendErr
+	}, false)
+	defer stream.release()
+
+	// the writes must not block on a request that never reads the body
+	for i := 0; i < 100; i++ {
+		doc := &es2.Doc{ID: "1", Op: es2.OperationCreate, Source: map[string]interface{}{"v": i}}
+		if err := stream.add(es, "idx", doc); err != nil {
+			t.Fatalf("%+v", err)
+		}
+	}
+	if err := stream.close(); !errors.Is(err, sendErr) {
+		t.Fatalf("expect the request error, got %v", err)
+	}
+}
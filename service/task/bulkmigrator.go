@@ -11,11 +11,14 @@ import (
 	"github.com/pkg/errors"
 	"github.com/samber/lo"
 	"github.com/spf13/cast"
+	"math/rand"
 	"os"
 	"regexp"
+	"sort"
 	"strings"
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
 type BulkMigrator struct {
@@ -24,6 +27,9 @@ type BulkMigrator struct {
 	SourceES es2.ES
 	TargetES es2.ES
 
+	// Defaults are the values a With* method falls back to on 0
+	Defaults MigratorDefaults
+
 	Parallelism uint
 
 	IndexPairMap map[string]*config.IndexPair
@@ -51,34 +57,189 @@ type BulkMigrator struct {
 	Pattern string
 
 	IndexFileRoot string
+
+	MaxDocs uint
+
+	AutoSlice bool
+
+	// ReadParallel bounds the slices of an index scrolled at once, 0 scrolls all of them at once
+	ReadParallel uint
+
+	// MaxOpenScrolls bounds the scrolls open on the source across all the index pairs, scrollSlots is shared by them
+	MaxOpenScrolls uint
+	scrollSlots    chan struct{}
+
+	// SortFields sorts the source scrolls of every index pair
+	SortFields []string
+
+	// MultiTypePolicy decides how the targets of multi-type sources are created
+	MultiTypePolicy config.MultiTypePolicy
+	// ClosedIndexPolicy decides how the closed source indices are synced
+	ClosedIndexPolicy config.ClosedIndexPolicy
+	// TemplateConflictPolicy decides what happens to the index templates existing on the target
+	TemplateConflictPolicy config.TemplateConflictPolicy
+
+	BulkDedup bool
+
+	ConflictPolicy config.ConflictPolicy
+
+	TimestampField string
+
+	ForceMergeSegments uint
+
+	TargetType string
+
+	IndexTimeout time.Duration
+
+	ProgressLogInterval time.Duration
+
+	AutoGenerateIds bool
+
+	UnorderedArrayFields []string
+
+	CompareIgnoreFields []string
+
+	CompareCheckpointFile string
+
+	// CompareSettings diffs the index settings and mappings of every index pair with its docs
+	CompareSettings bool
+
+	ProgressTotalDocs bool
+
+	// MinIndexBytes and MaxIndexBytes bound the store size of the source indices to migrate, 0 is unbounded
+	MinIndexBytes uint64
+	MaxIndexBytes uint64
+
+	OrderBySize     bool
+	OrderBySizeDesc bool
+
+	// StartStagger is the max random delay between the starts of the first Parallelism index pairs, 0 starts
+	// them all at once
+	StartStagger time.Duration
+
+	// DeadLetter receives the docs the target rejects, shared by every index pair
+	DeadLetter DeadLetterSink
+
+	// MaxInflightBulk bounds the bulk requests of every index pair sent at once, 0 is one per bulk worker
+	MaxInflightBulk uint
+
+	// AllowSelf lets the index pairs read and rewrite the same index of the same cluster
+	AllowSelf bool
+
+	// BulkMetrics observes the bulk requests of every index pair
+	BulkMetrics *BulkMetrics
+
+	// StreamBulk streams the bulk bodies of every index pair instead of buffering the batches
+	StreamBulk bool
+
+	// WaitForActiveShards is the number of active shard copies the writes of every index pair wait for
+	WaitForActiveShards string
+
+	// RequireExistingTarget fails the index pairs whose target index does not exist instead of creating it
+	RequireExistingTarget bool
+
+	// TrackTotalHits counts the exact total of the source scrolls of every index pair, true by default
+	TrackTotalHits bool
+
+	// VerifyCount fails the index pairs whose target counts fewer docs than the source after the sync
+	VerifyCount bool
+	// BulkRefreshPolicy is the refresh of the bulk requests of every index pair
+	BulkRefreshPolicy config.BulkRefreshPolicy
+
+	// DocTransformer rewrites the docs of every index pair before they are bulked
+	DocTransformer DocTransformer
+
+	// FieldCoercions converts the fields of the docs of every index pair before they are bulked
+	FieldCoercions map[string]CoercionFunc
+
+	// Rollup aggregates the docs of every index pair into a doc per time bucket
+	Rollup *config.RollupCfg
+
+	// IndexRetry syncs a failed index pair again from a fresh scroll up to this many times before it is failed,
+	// IndexRetryCleanup decides what happens to the docs of the failed attempt
+	IndexRetry        uint
+	IndexRetryCleanup config.IndexRetryCleanup
+
+	// DenyIndexes are index names and wildcards which are never migrated, whichever way their pairs were added
+	DenyIndexes []string
+
+	// pause is shared by the clones, so Pause holds a run started from any of them
+	pause *pauseGate
+
+	// indexSizes are the source store sizes a size filter or ordering fetched
+	indexSizes map[string]uint64
+
+	// IndexPairOptions override the settings of single index pairs, keyed by the index pair key
+	IndexPairOptions map[string]func(*Migrator) *Migrator
+
+	// CompatibilityIssue is set when the cluster versions are an unsupported jump
+	CompatibilityIssue string
 }
 
 func NewBulkMigratorWithES(ctx context.Context, sourceES, targetES es2.ES) *BulkMigrator {
+	return NewBulkMigratorWithDefaults(ctx, sourceES, targetES, DefaultMigratorDefaults())
+}
+
+// NewBulkMigratorWithDefaults starts from defaults instead of the built-in ones, the With* methods still
+// override them and fall back to them on 0.
+func NewBulkMigratorWithDefaults(ctx context.Context, sourceES, targetES es2.ES, defaults MigratorDefaults) *BulkMigrator {
 	if lo.IsNotEmpty(sourceES) {
 		ctx = utils.SetCtxKeySourceESVersion(ctx, sourceES.GetClusterVersion())
+		ctx = utils.SetCtxKeySourceESName(ctx, sourceES.GetClusterName())
 	}
 
 	if lo.IsNotEmpty(targetES) {
 		ctx = utils.SetCtxKeyTargetESVersion(ctx, targetES.GetClusterVersion())
+		ctx = utils.SetCtxKeyTargetESName(ctx, targetES.GetClusterName())
 	}
 
+	var compatibilityIssue string
+	if lo.IsNotEmpty(sourceES) && lo.IsNotEmpty(targetES) {
+		compatibilityIssue = checkCompatibility(sourceES.GetClusterVersion(), targetES.GetClusterVersion())
+		if compatibilityIssue != "" {
+			utils.GetLogger(ctx).Warn(compatibilityIssue)
+		}
+	}
+
+	defaults = defaults.withFallback()
 	return &BulkMigrator{
-		ctx:               ctx,
-		SourceES:          sourceES,
-		TargetES:          targetES,
-		Parallelism:       defaultParallelism,
-		IndexPairMap:      make(map[string]*config.IndexPair),
-		Error:             nil,
-		ScrollSize:        defaultScrollSize,
-		ScrollTime:        defaultScrollTime,
-		SliceSize:         defaultSliceSize,
-		BufferCount:       defaultBufferCount,
-		ActionSize:        defaultActionSize,
-		ActionParallelism: defaultActionParallelism,
+		ctx:                ctx,
+		CompatibilityIssue: compatibilityIssue,
+		SourceES:           sourceES,
+		TargetES:           targetES,
+		Defaults:           defaults,
+		Parallelism:        defaults.Parallelism,
+		IndexPairMap:       make(map[string]*config.IndexPair),
+		Error:              nil,
+		ScrollSize:         defaults.ScrollSize,
+		ScrollTime:         defaults.ScrollTime,
+		SliceSize:          defaults.SliceSize,
+		BufferCount:        defaults.BufferCount,
+		ActionSize:         defaults.ActionSize,
+		ActionParallelism:  defaults.ActionParallelism,
+		TrackTotalHits:     true,
+		pause:              newPauseGate(),
 	}
 }
 
+// NewBulkMigrator detects the versions of both clusters and pings them, so an unreachable cluster or bad
+// credentials fail here with a ConnectionFailed or AuthFailed custom error instead of in the middle of a run.
 func NewBulkMigrator(ctx context.Context, srcConfig *config.ESConfig, dstConfig *config.ESConfig) (*BulkMigrator, error) {
+	srcES, err := newValidatedES(ctx, "source", srcConfig)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	dstES, err := newValidatedES(ctx, "target", dstConfig)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return NewBulkMigratorWithES(ctx, srcES, dstES), nil
+}
+
+// NewBulkMigratorNoValidate only detects the versions of both clusters without pinging them.
+func NewBulkMigratorNoValidate(ctx context.Context, srcConfig *config.ESConfig, dstConfig *config.ESConfig) (*BulkMigrator, error) {
 	srcES, err := es2.NewESV0(srcConfig).GetES()
 	if err != nil {
 		return nil, errors.WithStack(err)
@@ -92,10 +253,46 @@ func NewBulkMigrator(ctx context.Context, srcConfig *config.ESConfig, dstConfig
 	return NewBulkMigratorWithES(ctx, srcES, dstES), nil
 }
 
+func newValidatedES(ctx context.Context, side string, esConfig *config.ESConfig) (es2.ES, error) {
+	if esConfig == nil || len(esConfig.Addresses) == 0 {
+		return nil, utils.NewCustomError(utils.InvalidParams, "%s es has no address", side)
+	}
+
+	utils.GetLogger(ctx).Debugf("%s es %v, headers %v", side, esConfig.Addresses, es2.RedactedHeaders(esConfig))
+	es, err := es2.NewESV0(esConfig).GetES()
+	if err != nil {
+		return nil, errors.Wrapf(err, "%s es", side)
+	}
+
+	if _, err := es.ClusterHealth(ctx); err != nil {
+		return nil, errors.Wrapf(err, "ping %s es", side)
+	}
+	return es, nil
+}
+
+// Close releases the connections of both clusters
+func (m *BulkMigrator) Close() error {
+	errs := &utils.Errs{}
+	if lo.IsNotEmpty(m.SourceES) {
+		errs.Add(m.SourceES.Close())
+	}
+	if lo.IsNotEmpty(m.TargetES) && m.TargetES != m.SourceES {
+		errs.Add(m.TargetES.Close())
+	}
+	return errs.Ret()
+}
+
 func (m *BulkMigrator) GetCtx() context.Context {
 	return m.ctx
 }
 
+// withCtx replaces the context the bulk migrator runs with, e.g. to cancel it on a signal
+func (m *BulkMigrator) withCtx(ctx context.Context) *BulkMigrator {
+	newBulkMigrator := m.clone()
+	newBulkMigrator.ctx = ctx
+	return newBulkMigrator
+}
+
 func (m *BulkMigrator) getIndexPairKey(indexPair *config.IndexPair) string {
 	return fmt.Sprintf("%s:%s", indexPair.SourceIndex, indexPair.TargetIndex)
 }
@@ -105,24 +302,12 @@ func (m *BulkMigrator) WithIndexPairs(indexPairs ...*config.IndexPair) *BulkMigr
 		return m
 	}
 
-	newBulkMigrator := &BulkMigrator{
-		ctx:               m.ctx,
-		SourceES:          m.SourceES,
-		TargetES:          m.TargetES,
-		Parallelism:       m.Parallelism,
-		IndexPairMap:      m.IndexPairMap,
-		Error:             m.Error,
-		ScrollSize:        m.ScrollSize,
-		ScrollTime:        m.ScrollTime,
-		SliceSize:         m.SliceSize,
-		BufferCount:       m.BufferCount,
-		ActionParallelism: m.ActionParallelism,
-		ActionSize:        m.ActionSize,
-		Ids:               m.Ids,
-		IndexFilePairMap:  m.IndexFilePairMap,
-		Pattern:           m.Pattern,
-		IndexFileRoot:     m.IndexFileRoot,
-		IndexTemplates:    m.IndexTemplates,
+	newBulkMigrator := m.clone()
+
+	indexPairs, err := m.expandIndexPairs(indexPairs)
+	if err != nil {
+		newBulkMigrator.Error = err
+		return newBulkMigrator
 	}
 
 	newIndexPairsMap := make(map[string]*config.IndexPair)
@@ -139,30 +324,39 @@ func (m *BulkMigrator) WithIndexPairs(indexPairs ...*config.IndexPair) *BulkMigr
 	return newBulkMigrator
 }
 
+// expandIndexPairs replaces the comma separated and wildcard source indices with concrete pairs
+func (m *BulkMigrator) expandIndexPairs(indexPairs []*config.IndexPair) ([]*config.IndexPair, error) {
+	if !lo.SomeBy(indexPairs, func(indexPair *config.IndexPair) bool { return isMultiIndexSpec(indexPair.SourceIndex) }) {
+		return indexPairs, nil
+	}
+
+	indexes, err := m.SourceES.GetIndexes()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	var expandedPairs []*config.IndexPair
+	for _, indexPair := range indexPairs {
+		if !isMultiIndexSpec(indexPair.SourceIndex) {
+			expandedPairs = append(expandedPairs, indexPair)
+			continue
+		}
+
+		pairs, err := expandIndexPair(indexPair, indexes)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		expandedPairs = append(expandedPairs, pairs...)
+	}
+	return expandedPairs, nil
+}
+
 func (m *BulkMigrator) WithIndexFilePairs(indexFilePairs ...*config.IndexFilePair) *BulkMigrator {
 	if m.Error != nil {
 		return m
 	}
 
-	newBulkMigrator := &BulkMigrator{
-		ctx:               m.ctx,
-		SourceES:          m.SourceES,
-		TargetES:          m.TargetES,
-		Parallelism:       m.Parallelism,
-		IndexPairMap:      m.IndexPairMap,
-		Error:             m.Error,
-		ScrollSize:        m.ScrollSize,
-		ScrollTime:        m.ScrollTime,
-		SliceSize:         m.SliceSize,
-		BufferCount:       m.BufferCount,
-		ActionParallelism: m.ActionParallelism,
-		ActionSize:        m.ActionSize,
-		Ids:               m.Ids,
-		IndexFilePairMap:  m.IndexFilePairMap,
-		Pattern:           m.Pattern,
-		IndexFileRoot:     m.IndexFileRoot,
-		IndexTemplates:    m.IndexTemplates,
-	}
+	newBulkMigrator := m.clone()
 
 	newIndexPairsMap := make(map[string]*config.IndexFilePair)
 	for _, importIndexFilePair := range indexFilePairs {
@@ -182,25 +376,7 @@ func (m *BulkMigrator) WithIndexTemplates(indexTemplates ...*config.IndexTemplat
 		return m
 	}
 
-	newBulkMigrator := &BulkMigrator{
-		ctx:               m.ctx,
-		SourceES:          m.SourceES,
-		TargetES:          m.TargetES,
-		Parallelism:       m.Parallelism,
-		IndexPairMap:      m.IndexPairMap,
-		Error:             m.Error,
-		ScrollSize:        m.ScrollSize,
-		ScrollTime:        m.ScrollTime,
-		SliceSize:         m.SliceSize,
-		BufferCount:       m.BufferCount,
-		ActionParallelism: m.ActionParallelism,
-		ActionSize:        m.ActionSize,
-		Ids:               m.Ids,
-		IndexFilePairMap:  m.IndexFilePairMap,
-		Pattern:           m.Pattern,
-		IndexFileRoot:     m.IndexFileRoot,
-		IndexTemplates:    m.IndexTemplates,
-	}
+	newBulkMigrator := m.clone()
 
 	newIndexTemplateMap := make(map[string]*config.IndexTemplate)
 	for _, indexTemplate := range indexTemplates {
@@ -215,357 +391,876 @@ func (m *BulkMigrator) WithIndexTemplates(indexTemplates ...*config.IndexTemplat
 	return newBulkMigrator
 }
 
-func (m *BulkMigrator) WithIndexFileRoot(indexFileRoot string) *BulkMigrator {
+// WithIndexListFile adds the index pairs listed in the file, see parseIndexList for its format
+func (m *BulkMigrator) WithIndexListFile(path string) *BulkMigrator {
+	if m.Error != nil || path == "" {
+		return m
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		newBulkMigrator := m.clone()
+		newBulkMigrator.Error = errors.WithStack(err)
+		return newBulkMigrator
+	}
+	defer func() {
+		_ = file.Close()
+	}()
+
+	indexPairs, err := parseIndexList(file)
+	if err != nil {
+		newBulkMigrator := m.clone()
+		newBulkMigrator.Error = errors.Wrapf(err, "index list %s", path)
+		return newBulkMigrator
+	}
+	return m.WithIndexPairs(indexPairs...)
+}
+
+func (m *BulkMigrator) WithIndexFileRoot(indexFileRoot string) *BulkMigrator {
+	if m.Error != nil {
+		return m
+	}
+
+	newBulkMigrator := m.clone()
+	newBulkMigrator.IndexFileRoot = indexFileRoot
+
+	return newBulkMigrator
+}
+
+func (m *BulkMigrator) WithScrollSize(scrollSize uint) *BulkMigrator {
+	if m.Error != nil {
+		return m
+	}
+
+	if scrollSize == 0 {
+		scrollSize = m.Defaults.ScrollSize
+	}
+
+	newBulkMigrator := m.clone()
+	newBulkMigrator.ScrollSize = scrollSize
+	return newBulkMigrator
+}
+
+func (m *BulkMigrator) WithScrollTime(scrollTime uint) *BulkMigrator {
+	if m.Error != nil {
+		return m
+	}
+
+	if scrollTime == 0 {
+		scrollTime = m.Defaults.ScrollTime
+	}
+	newBulkMigrator := m.clone()
+	newBulkMigrator.ScrollTime = scrollTime
+	return newBulkMigrator
+}
+
+func (m *BulkMigrator) WithSliceSize(sliceSize uint) *BulkMigrator {
+	if m.Error != nil {
+		return m
+	}
+
+	if sliceSize == 0 {
+		sliceSize = m.Defaults.SliceSize
+	}
+	newBulkMigrator := m.clone()
+	newBulkMigrator.SliceSize = sliceSize
+	return newBulkMigrator
+}
+
+func (m *BulkMigrator) WithBufferCount(bufferCount uint) *BulkMigrator {
+	if m.Error != nil {
+		return m
+	}
+
+	if bufferCount == 0 {
+		bufferCount = m.Defaults.BufferCount
+	}
+	newBulkMigrator := m.clone()
+	newBulkMigrator.BufferCount = bufferCount
+	return newBulkMigrator
+}
+
+func (m *BulkMigrator) WithActionParallelism(actionParallelism uint) *BulkMigrator {
+	if m.Error != nil {
+		return m
+	}
+
+	if actionParallelism == 0 {
+		actionParallelism = m.Defaults.ActionParallelism
+	}
+
+	newBulkMigrator := m.clone()
+	newBulkMigrator.ActionParallelism = actionParallelism
+	return newBulkMigrator
+}
+
+func (m *BulkMigrator) WithActionSize(actionSize uint) *BulkMigrator {
+	if m.Error != nil {
+		return m
+	}
+
+	if actionSize == 0 {
+		actionSize = m.Defaults.ActionSize
+	}
+
+	newBulkMigrator := m.clone()
+	newBulkMigrator.ActionSize = actionSize
+	return newBulkMigrator
+}
+
+func (m *BulkMigrator) filterIndexes(pattern string) ([]string, error) {
+	if lo.IsEmpty(pattern) {
+		return nil, nil
+	}
+	ignoreSystemIndex := utils.GetCtxKeyIgnoreSystemIndex(m.ctx)
+
+	indexes, err := m.SourceES.GetIndexes()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	// newer clusters hide indices without a dot prefix with the `index.hidden` setting
+	var hiddenIndexes map[string]bool
+	if ignoreSystemIndex {
+		if hiddenIndexes, err = m.SourceES.GetHiddenIndexes(); err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+
+	var filteredIndexes []string
+	for _, index := range indexes {
+		if ignoreSystemIndex && strings.HasPrefix(index, ".") {
+			continue
+		}
+
+		ok, err := regexp.Match(pattern, []byte(index))
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		if !ok {
+			continue
+		}
+
+		if hiddenIndexes[index] {
+			continue
+		}
+		filteredIndexes = append(filteredIndexes, index)
+	}
+	return filteredIndexes, nil
+}
+
+func (m *BulkMigrator) WithPatternIndexes(pattern string) *BulkMigrator {
+	if m.Error != nil {
+		return m
+	}
+
+	newBulkMigrator := m.clone()
+	newBulkMigrator.Pattern = pattern
+
+	return newBulkMigrator
+}
+
+// WithIgnoreSystemIndex leaves the dot prefixed and the hidden indices out of the ones matched by the pattern, the
+// index pairs given by WithIndexPairs are kept whatever their names
+func (m *BulkMigrator) WithIgnoreSystemIndex(ignoreSystemIndex bool) *BulkMigrator {
+	if m.Error != nil {
+		return m
+	}
+
+	newBulkMigrator := m.clone()
+	newBulkMigrator.ctx = utils.SetCtxKeyIgnoreSystemIndex(m.ctx, ignoreSystemIndex)
+	return newBulkMigrator
+}
+
+func (m *BulkMigrator) WithParallelism(parallelism uint) *BulkMigrator {
+	if m.Error != nil {
+		return m
+	}
+
+	if parallelism == 0 {
+		parallelism = m.Defaults.Parallelism
+	}
+	newBulkMigrator := m.clone()
+	newBulkMigrator.Parallelism = parallelism
+	return newBulkMigrator
+}
+
+func (m *BulkMigrator) WithIds(ids []string) *BulkMigrator {
+	if m.Error != nil {
+		return m
+	}
+
+	newBulkMigrator := m.clone()
+	newBulkMigrator.Ids = ids
+	return newBulkMigrator
+}
+
+// WithMaxDocs stops syncing each index after maxDocs documents, 0 means no limit.
+func (m *BulkMigrator) WithMaxDocs(maxDocs uint) *BulkMigrator {
+	if m.Error != nil {
+		return m
+	}
+
+	newBulkMigrator := m.clone()
+	newBulkMigrator.MaxDocs = maxDocs
+	return newBulkMigrator
+}
+
+func (m *BulkMigrator) WithAutoSlice(autoSlice bool) *BulkMigrator {
+	if m.Error != nil {
+		return m
+	}
+
+	newBulkMigrator := m.clone()
+	newBulkMigrator.AutoSlice = autoSlice
+	return newBulkMigrator
+}
+
+// WithMaxOpenScrolls bounds the scrolls open on the source across all the index pairs running in parallel, a slice
+// waits for a free one before its scroll is opened. 0 is unbounded.
+func (m *BulkMigrator) WithMaxOpenScrolls(maxOpenScrolls uint) *BulkMigrator {
+	if m.Error != nil {
+		return m
+	}
+
+	newBulkMigrator := m.clone()
+	newBulkMigrator.MaxOpenScrolls = maxOpenScrolls
+	newBulkMigrator.scrollSlots = nil
+	if maxOpenScrolls > 0 {
+		newBulkMigrator.scrollSlots = make(chan struct{}, maxOpenScrolls)
+	}
+	return newBulkMigrator
+}
+
+// WithReadParallel bounds the slices of every index scrolled at once, see Migrator.WithReadParallel
+func (m *BulkMigrator) WithReadParallel(readParallel uint) *BulkMigrator {
+	if m.Error != nil {
+		return m
+	}
+
+	newBulkMigrator := m.clone()
+	newBulkMigrator.ReadParallel = readParallel
+	return newBulkMigrator
+}
+
+// WithMultiTypePolicy decides how the targets of multi-type sources are created, see Migrator.WithMultiTypePolicy
+func (m *BulkMigrator) WithMultiTypePolicy(policy config.MultiTypePolicy) *BulkMigrator {
+	if m.Error != nil {
+		return m
+	}
+
+	newBulkMigrator := m.clone()
+	newBulkMigrator.MultiTypePolicy = policy
+	return newBulkMigrator
+}
+
+// WithClosedIndexPolicy decides how the closed source indices are synced, see Migrator.WithClosedIndexPolicy
+func (m *BulkMigrator) WithClosedIndexPolicy(policy config.ClosedIndexPolicy) *BulkMigrator {
+	if m.Error != nil {
+		return m
+	}
+
+	newBulkMigrator := m.clone()
+	newBulkMigrator.ClosedIndexPolicy = policy
+	return newBulkMigrator
+}
+
+// WithTemplateConflictPolicy decides what happens to the index templates existing on the target, see
+// Migrator.WithTemplateConflictPolicy
+func (m *BulkMigrator) WithTemplateConflictPolicy(policy config.TemplateConflictPolicy) *BulkMigrator {
+	if m.Error != nil {
+		return m
+	}
+
+	newBulkMigrator := m.clone()
+	newBulkMigrator.TemplateConflictPolicy = policy
+	return newBulkMigrator
+}
+
+// WithSortFields sorts the source scrolls of every index pair, see Migrator.WithSortFields
+func (m *BulkMigrator) WithSortFields(sortFields []string) *BulkMigrator {
+	if m.Error != nil {
+		return m
+	}
+
+	newBulkMigrator := m.clone()
+	newBulkMigrator.SortFields = sortFields
+	return newBulkMigrator
+}
+
+func (m *BulkMigrator) WithBulkDedup(bulkDedup bool) *BulkMigrator {
+	if m.Error != nil {
+		return m
+	}
+
+	newBulkMigrator := m.clone()
+	newBulkMigrator.BulkDedup = bulkDedup
+	return newBulkMigrator
+}
+
+func (m *BulkMigrator) WithConflictPolicy(policy config.ConflictPolicy, timestampField string) *BulkMigrator {
+	if m.Error != nil {
+		return m
+	}
+
+	newBulkMigrator := m.clone()
+	newBulkMigrator.ConflictPolicy = policy
+	newBulkMigrator.TimestampField = timestampField
+	return newBulkMigrator
+}
+
+// WithForceMergeSegments force merges every target index after it is synced, 0 skips it
+func (m *BulkMigrator) WithForceMergeSegments(segments uint) *BulkMigrator {
+	if m.Error != nil {
+		return m
+	}
+
+	newBulkMigrator := m.clone()
+	newBulkMigrator.ForceMergeSegments = segments
+	return newBulkMigrator
+}
+
+func (m *BulkMigrator) WithTargetType(typeName string) *BulkMigrator {
+	if m.Error != nil {
+		return m
+	}
+
+	newBulkMigrator := m.clone()
+	newBulkMigrator.TargetType = typeName
+	return newBulkMigrator
+}
+
+// WithIndexTimeout bounds the run of every index, a stuck index is cancelled and reported as failed with the
+// deadline error so it does not hold a worker, 0 means no limit
+func (m *BulkMigrator) WithIndexTimeout(timeout time.Duration) *BulkMigrator {
+	if m.Error != nil {
+		return m
+	}
+
+	newBulkMigrator := m.clone()
+	newBulkMigrator.IndexTimeout = timeout
+	return newBulkMigrator
+}
+
+// WithProgressLogInterval logs the task progress at most once per interval, 0 logs every finished index
+func (m *BulkMigrator) WithProgressLogInterval(interval time.Duration) *BulkMigrator {
+	if m.Error != nil {
+		return m
+	}
+
+	newBulkMigrator := m.clone()
+	newBulkMigrator.ProgressLogInterval = interval
+	return newBulkMigrator
+}
+
+// WithStartStagger delays the start of each of the first Parallelism index pairs by up to maxDelay, so their
+// scrolls do not hit the source all at once
+func (m *BulkMigrator) WithStartStagger(maxDelay time.Duration) *BulkMigrator {
+	if m.Error != nil {
+		return m
+	}
+
+	newBulkMigrator := m.clone()
+	newBulkMigrator.StartStagger = maxDelay
+	return newBulkMigrator
+}
+
+// WithDeadLetter writes the docs the target rejects to sink, see Migrator.WithDeadLetter
+func (m *BulkMigrator) WithDeadLetter(sink DeadLetterSink) *BulkMigrator {
+	if m.Error != nil {
+		return m
+	}
+
+	newBulkMigrator := m.clone()
+	newBulkMigrator.DeadLetter = sink
+	return newBulkMigrator
+}
+
+// WithBulkMetrics observes the bulk requests of every index pair in metrics, see Migrator.WithBulkMetrics
+func (m *BulkMigrator) WithBulkMetrics(metrics *BulkMetrics) *BulkMigrator {
+	if m.Error != nil {
+		return m
+	}
+
+	newBulkMigrator := m.clone()
+	newBulkMigrator.BulkMetrics = metrics
+	return newBulkMigrator
+}
+
+// WithAllowSelf lets the index pairs read and rewrite the same index of the same cluster, see Migrator.WithAllowSelf
+func (m *BulkMigrator) WithAllowSelf(allowSelf bool) *BulkMigrator {
+	if m.Error != nil {
+		return m
+	}
+
+	newBulkMigrator := m.clone()
+	newBulkMigrator.AllowSelf = allowSelf
+	return newBulkMigrator
+}
+
+// WithMaxInflightBulk bounds the bulk requests of every index pair sent at once, see Migrator.WithMaxInflightBulk
+func (m *BulkMigrator) WithMaxInflightBulk(maxInflightBulk uint) *BulkMigrator {
+	if m.Error != nil {
+		return m
+	}
+
+	newBulkMigrator := m.clone()
+	newBulkMigrator.MaxInflightBulk = maxInflightBulk
+	return newBulkMigrator
+}
+
+// WithWaitForActiveShards sets the active shard copies the writes of every index pair wait for, see
+// Migrator.WithWaitForActiveShards
+func (m *BulkMigrator) WithWaitForActiveShards(waitForActiveShards string) *BulkMigrator {
+	if m.Error != nil {
+		return m
+	}
+
+	newBulkMigrator := m.clone()
+	newBulkMigrator.WaitForActiveShards = waitForActiveShards
+	return newBulkMigrator
+}
+
+// WithRequireExistingTarget never creates the target indices, see Migrator.WithRequireExistingTarget
+func (m *BulkMigrator) WithRequireExistingTarget(requireExistingTarget bool) *BulkMigrator {
+	if m.Error != nil {
+		return m
+	}
+
+	newBulkMigrator := m.clone()
+	newBulkMigrator.RequireExistingTarget = requireExistingTarget
+	return newBulkMigrator
+}
+
+// WithTrackTotalHits sets the exact total of the source scrolls, see Migrator.WithTrackTotalHits
+func (m *BulkMigrator) WithTrackTotalHits(trackTotalHits bool) *BulkMigrator {
+	if m.Error != nil {
+		return m
+	}
+
+	newBulkMigrator := m.clone()
+	newBulkMigrator.TrackTotalHits = trackTotalHits
+	return newBulkMigrator
+}
+
+// WithBulkRefreshPolicy sets the refresh of the bulk requests of every index pair, see Migrator.WithBulkRefreshPolicy
+func (m *BulkMigrator) WithBulkRefreshPolicy(policy config.BulkRefreshPolicy) *BulkMigrator {
+	if m.Error != nil {
+		return m
+	}
+
+	newBulkMigrator := m.clone()
+	newBulkMigrator.BulkRefreshPolicy = policy
+	return newBulkMigrator
+}
+
+// WithVerifyCount verifies the doc count of every synced index pair, see Migrator.WithVerifyCount
+func (m *BulkMigrator) WithVerifyCount(verifyCount bool) *BulkMigrator {
+	if m.Error != nil {
+		return m
+	}
+
+	newBulkMigrator := m.clone()
+	newBulkMigrator.VerifyCount = verifyCount
+	return newBulkMigrator
+}
+
+// WithDocTransformer rewrites the docs of every index pair, see Migrator.WithDocTransformer
+func (m *BulkMigrator) WithDocTransformer(transformer DocTransformer) *BulkMigrator {
+	if m.Error != nil {
+		return m
+	}
+
+	newBulkMigrator := m.clone()
+	newBulkMigrator.DocTransformer = transformer
+	return newBulkMigrator
+}
+
+// WithFieldCoercions converts the fields of the docs of every index pair, see Migrator.WithFieldCoercions
+func (m *BulkMigrator) WithFieldCoercions(coercions map[string]CoercionFunc) *BulkMigrator {
+	if m.Error != nil {
+		return m
+	}
+
+	newBulkMigrator := m.clone()
+	newBulkMigrator.FieldCoercions = coercions
+	return newBulkMigrator
+}
+
+// WithIndexRetry syncs a failed index pair again from scratch up to n times, every retry starts a fresh scroll
+func (m *BulkMigrator) WithIndexRetry(n uint) *BulkMigrator {
+	if m.Error != nil {
+		return m
+	}
+
+	newBulkMigrator := m.clone()
+	newBulkMigrator.IndexRetry = n
+	return newBulkMigrator
+}
+
+// WithRollup aggregates the docs of every index pair, see Migrator.WithRollup
+func (m *BulkMigrator) WithRollup(rollup *config.RollupCfg) *BulkMigrator {
+	if m.Error != nil {
+		return m
+	}
+
+	newBulkMigrator := m.clone()
+	newBulkMigrator.Rollup = rollup
+	return newBulkMigrator
+}
+
+// WithIndexRetryCleanup sets what a retry does with the target docs of the failed attempt. The default keep writes
+// over them, which leaves the docs deleted from the source meanwhile and duplicates auto generated ids, recreate
+// deletes and recreates the target from the source settings first.
+func (m *BulkMigrator) WithIndexRetryCleanup(cleanup config.IndexRetryCleanup) *BulkMigrator {
+	if m.Error != nil {
+		return m
+	}
+
+	newBulkMigrator := m.clone()
+	newBulkMigrator.IndexRetryCleanup = cleanup
+	return newBulkMigrator
+}
+
+// WithDenyIndexes removes the index pairs whose source or target index matches a name or wildcard of
+// denyIndexes, after every other selection, e.g. `.security*`
+func (m *BulkMigrator) WithDenyIndexes(denyIndexes []string) *BulkMigrator {
+	if m.Error != nil {
+		return m
+	}
+
+	newBulkMigrator := m.clone()
+	newBulkMigrator.DenyIndexes = denyIndexes
+	return newBulkMigrator
+}
+
+// WithStreamBulk streams the bulk bodies of every index pair, see Migrator.WithStreamBulk
+func (m *BulkMigrator) WithStreamBulk(streamBulk bool) *BulkMigrator {
 	if m.Error != nil {
 		return m
 	}
 
-	newBulkMigrator := &BulkMigrator{
-		ctx:               m.ctx,
-		SourceES:          m.SourceES,
-		TargetES:          m.TargetES,
-		Parallelism:       m.Parallelism,
-		IndexPairMap:      m.IndexPairMap,
-		Error:             m.Error,
-		ScrollSize:        m.ScrollSize,
-		ScrollTime:        m.ScrollTime,
-		SliceSize:         m.SliceSize,
-		BufferCount:       m.BufferCount,
-		ActionParallelism: m.ActionParallelism,
-		ActionSize:        m.ActionSize,
-		Ids:               m.Ids,
-		IndexFilePairMap:  m.IndexFilePairMap,
-		Pattern:           m.Pattern,
-		IndexFileRoot:     indexFileRoot,
-		IndexTemplates:    m.IndexTemplates,
-	}
-
+	newBulkMigrator := m.clone()
+	newBulkMigrator.StreamBulk = streamBulk
 	return newBulkMigrator
 }
 
-func (m *BulkMigrator) WithScrollSize(scrollSize uint) *BulkMigrator {
+// WithAutoGenerateIds lets the target assign new ids, see Migrator.WithAutoGenerateIds for the implications
+func (m *BulkMigrator) WithAutoGenerateIds(autoGenerateIds bool) *BulkMigrator {
 	if m.Error != nil {
 		return m
 	}
 
-	if scrollSize == 0 {
-		scrollSize = defaultScrollSize
-	}
-
-	return &BulkMigrator{
-		ctx:               m.ctx,
-		SourceES:          m.SourceES,
-		TargetES:          m.TargetES,
-		Parallelism:       m.Parallelism,
-		IndexPairMap:      m.IndexPairMap,
-		Error:             m.Error,
-		ScrollSize:        scrollSize,
-		ScrollTime:        m.ScrollTime,
-		SliceSize:         m.SliceSize,
-		BufferCount:       m.BufferCount,
-		ActionSize:        m.ActionSize,
-		Ids:               m.Ids,
-		ActionParallelism: m.ActionParallelism,
-		IndexFilePairMap:  m.IndexFilePairMap,
-		Pattern:           m.Pattern,
-		IndexFileRoot:     m.IndexFileRoot,
-		IndexTemplates:    m.IndexTemplates,
-	}
+	newBulkMigrator := m.clone()
+	newBulkMigrator.AutoGenerateIds = autoGenerateIds
+	return newBulkMigrator
 }
 
-func (m *BulkMigrator) WithScrollTime(scrollTime uint) *BulkMigrator {
+// WithUnorderedArrayFields compares the arrays of the dotted path fields regardless of their order, `*` for
+// every array
+func (m *BulkMigrator) WithUnorderedArrayFields(fields []string) *BulkMigrator {
 	if m.Error != nil {
 		return m
 	}
 
-	if scrollTime == 0 {
-		scrollTime = defaultScrollTime
-	}
-	return &BulkMigrator{
-		ctx:               m.ctx,
-		SourceES:          m.SourceES,
-		TargetES:          m.TargetES,
-		Parallelism:       m.Parallelism,
-		IndexPairMap:      m.IndexPairMap,
-		Error:             m.Error,
-		ScrollSize:        m.ScrollSize,
-		ScrollTime:        scrollTime,
-		SliceSize:         m.SliceSize,
-		BufferCount:       m.BufferCount,
-		ActionSize:        m.ActionSize,
-		Ids:               m.Ids,
-		ActionParallelism: m.ActionParallelism,
-		IndexFilePairMap:  m.IndexFilePairMap,
-		Pattern:           m.Pattern,
-		IndexFileRoot:     m.IndexFileRoot,
-		IndexTemplates:    m.IndexTemplates,
-	}
+	newBulkMigrator := m.clone()
+	newBulkMigrator.UnorderedArrayFields = fields
+	return newBulkMigrator
 }
 
-func (m *BulkMigrator) WithSliceSize(sliceSize uint) *BulkMigrator {
+// WithCompareIgnoreFields strips the dotted path fields from both sides before they are compared
+func (m *BulkMigrator) WithCompareIgnoreFields(fields []string) *BulkMigrator {
 	if m.Error != nil {
 		return m
 	}
 
-	if sliceSize == 0 {
-		sliceSize = defaultSliceSize
-	}
-	return &BulkMigrator{
-		ctx:               m.ctx,
-		SourceES:          m.SourceES,
-		TargetES:          m.TargetES,
-		Parallelism:       m.Parallelism,
-		IndexPairMap:      m.IndexPairMap,
-		Error:             m.Error,
-		ScrollSize:        m.ScrollSize,
-		ScrollTime:        m.ScrollTime,
-		SliceSize:         sliceSize,
-		BufferCount:       m.BufferCount,
-		ActionSize:        m.ActionSize,
-		Ids:               m.Ids,
-		ActionParallelism: m.ActionParallelism,
-		IndexFilePairMap:  m.IndexFilePairMap,
-		Pattern:           m.Pattern,
-		IndexFileRoot:     m.IndexFileRoot,
-		IndexTemplates:    m.IndexTemplates,
-	}
+	newBulkMigrator := m.clone()
+	newBulkMigrator.CompareIgnoreFields = fields
+	return newBulkMigrator
 }
 
-func (m *BulkMigrator) WithBufferCount(bufferCount uint) *BulkMigrator {
+// WithCompareSettings adds the diff of the index settings and mappings to the DiffResult of every index pair, a
+// pair whose docs are the same is reported when its settings differ
+func (m *BulkMigrator) WithCompareSettings(compareSettings bool) *BulkMigrator {
 	if m.Error != nil {
 		return m
 	}
 
-	if bufferCount == 0 {
-		bufferCount = defaultBufferCount
-	}
-	return &BulkMigrator{
-		ctx:               m.ctx,
-		SourceES:          m.SourceES,
-		TargetES:          m.TargetES,
-		Parallelism:       m.Parallelism,
-		IndexPairMap:      m.IndexPairMap,
-		Error:             m.Error,
-		ScrollSize:        m.ScrollSize,
-		ScrollTime:        m.ScrollTime,
-		SliceSize:         m.SliceSize,
-		BufferCount:       bufferCount,
-		ActionSize:        m.ActionSize,
-		Ids:               m.Ids,
-		ActionParallelism: m.ActionParallelism,
-		IndexFilePairMap:  m.IndexFilePairMap,
-		Pattern:           m.Pattern,
-		IndexFileRoot:     m.IndexFileRoot,
-		IndexTemplates:    m.IndexTemplates,
-	}
+	newBulkMigrator := m.clone()
+	newBulkMigrator.CompareSettings = compareSettings
+	return newBulkMigrator
 }
 
-func (m *BulkMigrator) WithActionParallelism(actionParallelism uint) *BulkMigrator {
+// WithCompareCheckpoint resumes Compare, and the compares of SyncDiff and Repair, from the index pairs the
+// checkpoint file records as finished and from the position of an index pair interrupted halfway. The compare reads the indices in `_id` order page by page instead
+// of scrolling them, es 8 only sorts on `_id` with indices.id_field_data.enabled.
+func (m *BulkMigrator) WithCompareCheckpoint(file string) *BulkMigrator {
 	if m.Error != nil {
 		return m
 	}
 
-	if actionParallelism == 0 {
-		actionParallelism = defaultActionParallelism
-	}
-
-	return &BulkMigrator{
-		ctx:               m.ctx,
-		SourceES:          m.SourceES,
-		TargetES:          m.TargetES,
-		Parallelism:       m.Parallelism,
-		IndexPairMap:      m.IndexPairMap,
-		Error:             m.Error,
-		ScrollSize:        m.ScrollSize,
-		ScrollTime:        m.ScrollTime,
-		SliceSize:         m.SliceSize,
-		BufferCount:       m.BufferCount,
-		ActionSize:        m.ActionSize,
-		Ids:               m.Ids,
-		ActionParallelism: actionParallelism,
-		IndexFilePairMap:  m.IndexFilePairMap,
-		Pattern:           m.Pattern,
-		IndexFileRoot:     m.IndexFileRoot,
-		IndexTemplates:    m.IndexTemplates,
-	}
+	newBulkMigrator := m.clone()
+	newBulkMigrator.CompareCheckpointFile = file
+	return newBulkMigrator
 }
 
-func (m *BulkMigrator) WithActionSize(actionSize uint) *BulkMigrator {
+// WithProgressTotalDocs counts the source docs of all the index pairs before Sync, the total progress is then
+// right from the start instead of growing as the index pairs start. The count adds a request per index pair
+// before any doc is migrated.
+func (m *BulkMigrator) WithProgressTotalDocs(progressTotalDocs bool) *BulkMigrator {
 	if m.Error != nil {
 		return m
 	}
 
-	if actionSize == 0 {
-		actionSize = defaultActionSize
-	}
-
-	return &BulkMigrator{
-		ctx:               m.ctx,
-		SourceES:          m.SourceES,
-		TargetES:          m.TargetES,
-		Parallelism:       m.Parallelism,
-		IndexPairMap:      m.IndexPairMap,
-		Error:             m.Error,
-		ScrollSize:        m.ScrollSize,
-		ScrollTime:        m.ScrollTime,
-		SliceSize:         m.SliceSize,
-		BufferCount:       m.BufferCount,
-		ActionSize:        actionSize,
-		Ids:               m.Ids,
-		ActionParallelism: m.ActionParallelism,
-		IndexFilePairMap:  m.IndexFilePairMap,
-		Pattern:           m.Pattern,
-		IndexFileRoot:     m.IndexFileRoot,
-		IndexTemplates:    m.IndexTemplates,
-	}
+	newBulkMigrator := m.clone()
+	newBulkMigrator.ProgressTotalDocs = progressTotalDocs
+	return newBulkMigrator
 }
 
-func (m *BulkMigrator) filterIndexes(pattern string) ([]string, error) {
-	if lo.IsEmpty(pattern) {
-		return nil, nil
-	}
-	ignoreSystemIndex := utils.GetCtxKeyIgnoreSystemIndex(m.ctx)
-
-	indexes, err := m.SourceES.GetIndexes()
-	if err != nil {
-		return nil, errors.WithStack(err)
+// WithSizeFilter only migrates the index pairs whose source store size is within [minBytes, maxBytes], a 0
+// maxBytes is unbounded
+func (m *BulkMigrator) WithSizeFilter(minBytes, maxBytes uint64) *BulkMigrator {
+	if m.Error != nil {
+		return m
 	}
 
-	var filteredIndexes []string
-	for _, index := range indexes {
-		if ignoreSystemIndex && strings.HasPrefix(index, ".") {
-			continue
-		}
-
-		ok, err := regexp.Match(pattern, []byte(index))
-		if err != nil {
-			return nil, errors.WithStack(err)
-		}
-
-		if ok {
-			filteredIndexes = append(filteredIndexes, index)
-		}
-	}
-	return filteredIndexes, nil
+	newBulkMigrator := m.clone()
+	newBulkMigrator.MinIndexBytes = minBytes
+	newBulkMigrator.MaxIndexBytes = maxBytes
+	return newBulkMigrator
 }
 
-func (m *BulkMigrator) WithPatternIndexes(pattern string) *BulkMigrator {
+// WithSizeOrdering starts the index pairs by their source store size, the biggest first when desc
+func (m *BulkMigrator) WithSizeOrdering(desc bool) *BulkMigrator {
 	if m.Error != nil {
 		return m
 	}
 
-	newBulkMigrator := &BulkMigrator{
-		ctx:               m.ctx,
-		SourceES:          m.SourceES,
-		TargetES:          m.TargetES,
-		Parallelism:       m.Parallelism,
-		IndexPairMap:      m.IndexPairMap,
-		Error:             m.Error,
-		ScrollSize:        m.ScrollSize,
-		ScrollTime:        m.ScrollTime,
-		SliceSize:         m.SliceSize,
-		BufferCount:       m.BufferCount,
-		Ids:               m.Ids,
-		ActionSize:        m.ActionSize,
-		ActionParallelism: m.ActionParallelism,
-		IndexFilePairMap:  m.IndexFilePairMap,
-		Pattern:           pattern,
-		IndexFileRoot:     m.IndexFileRoot,
-		IndexTemplates:    m.IndexTemplates,
-	}
-
+	newBulkMigrator := m.clone()
+	newBulkMigrator.OrderBySize = true
+	newBulkMigrator.OrderBySizeDesc = desc
 	return newBulkMigrator
 }
 
-func (m *BulkMigrator) WithParallelism(parallelism uint) *BulkMigrator {
+// WithIndexPairOptions applies option to the migrator of the index pair after the bulk settings, e.g. a
+// larger action size for one huge index
+func (m *BulkMigrator) WithIndexPairOptions(indexPair *config.IndexPair, option func(*Migrator) *Migrator) *BulkMigrator {
 	if m.Error != nil {
 		return m
 	}
 
-	if parallelism == 0 {
-		parallelism = defaultParallelism
-	}
-	return &BulkMigrator{
-		ctx:               m.ctx,
-		SourceES:          m.SourceES,
-		TargetES:          m.TargetES,
-		Parallelism:       parallelism,
-		IndexPairMap:      m.IndexPairMap,
-		Error:             m.Error,
-		ScrollSize:        m.ScrollSize,
-		ScrollTime:        m.ScrollTime,
-		SliceSize:         m.SliceSize,
-		BufferCount:       m.BufferCount,
-		Ids:               m.Ids,
-		ActionSize:        m.ActionSize,
-		ActionParallelism: m.ActionParallelism,
-		IndexFilePairMap:  m.IndexFilePairMap,
-		Pattern:           m.Pattern,
-		IndexFileRoot:     m.IndexFileRoot,
-		IndexTemplates:    m.IndexTemplates,
-	}
+	newBulkMigrator := m.clone()
+	newBulkMigrator.IndexPairOptions = lo.Assign(m.IndexPairOptions, map[string]func(*Migrator) *Migrator{
+		m.getIndexPairKey(indexPair): option,
+	})
+	return newBulkMigrator
 }
 
-func (m *BulkMigrator) WithIds(ids []string) *BulkMigrator {
+// WithStrictCompatibility fails the migrator when the cluster versions are an unsupported jump instead of
+// only warning about it.
+func (m *BulkMigrator) WithStrictCompatibility(strict bool) *BulkMigrator {
 	if m.Error != nil {
 		return m
 	}
 
-	return &BulkMigrator{
-		ctx:               m.ctx,
-		SourceES:          m.SourceES,
-		TargetES:          m.TargetES,
-		Parallelism:       m.Parallelism,
-		IndexPairMap:      m.IndexPairMap,
-		Error:             m.Error,
-		ScrollSize:        m.ScrollSize,
-		ScrollTime:        m.ScrollTime,
-		SliceSize:         m.SliceSize,
-		BufferCount:       m.BufferCount,
-		ActionSize:        m.ActionSize,
-		Ids:               ids,
-		ActionParallelism: m.ActionParallelism,
-		IndexFilePairMap:  m.IndexFilePairMap,
-		Pattern:           m.Pattern,
-		IndexFileRoot:     m.IndexFileRoot,
-		IndexTemplates:    m.IndexTemplates,
+	newBulkMigrator := m.clone()
+	if strict && m.CompatibilityIssue != "" {
+		newBulkMigrator.Error = utils.NewCustomError(utils.IncompatibleVersion, "%s", m.CompatibilityIssue)
 	}
+	return newBulkMigrator
+}
+
+// Pause holds the reads and the bulk writes of the running index pairs until Resume, they keep their position.
+// A pause longer than the scroll time expires the open scrolls.
+func (m *BulkMigrator) Pause() {
+	utils.GetLogger(m.ctx).Info("pause the migration")
+	m.pause.pause()
+}
+
+// Resume continues the index pairs held by Pause
+func (m *BulkMigrator) Resume() {
+	utils.GetLogger(m.ctx).Info("resume the migration")
+	m.pause.resume()
+}
+
+func (m *BulkMigrator) IsPaused() bool {
+	return m.pause.isPaused()
 }
 
 func (m *BulkMigrator) clone() *BulkMigrator {
 	return &BulkMigrator{
-		ctx:               m.ctx,
-		SourceES:          m.SourceES,
-		TargetES:          m.TargetES,
-		Parallelism:       m.Parallelism,
-		IndexPairMap:      m.IndexPairMap,
-		Error:             m.Error,
-		ScrollSize:        m.ScrollSize,
-		ScrollTime:        m.ScrollTime,
-		SliceSize:         m.SliceSize,
-		BufferCount:       m.BufferCount,
-		ActionSize:        m.ActionSize,
-		Ids:               m.Ids,
-		ActionParallelism: m.ActionParallelism,
-		IndexFilePairMap:  m.IndexFilePairMap,
-		Pattern:           m.Pattern,
-		IndexFileRoot:     m.IndexFileRoot,
-		IndexTemplates:    m.IndexTemplates,
+		ctx:                    m.ctx,
+		SourceES:               m.SourceES,
+		TargetES:               m.TargetES,
+		Parallelism:            m.Parallelism,
+		IndexPairMap:           m.IndexPairMap,
+		Error:                  m.Error,
+		ScrollSize:             m.ScrollSize,
+		ScrollTime:             m.ScrollTime,
+		SliceSize:              m.SliceSize,
+		BufferCount:            m.BufferCount,
+		ActionSize:             m.ActionSize,
+		Ids:                    m.Ids,
+		ActionParallelism:      m.ActionParallelism,
+		IndexFilePairMap:       m.IndexFilePairMap,
+		Pattern:                m.Pattern,
+		IndexFileRoot:          m.IndexFileRoot,
+		IndexTemplates:         m.IndexTemplates,
+		MaxDocs:                m.MaxDocs,
+		AutoSlice:              m.AutoSlice,
+		ReadParallel:           m.ReadParallel,
+		MaxOpenScrolls:         m.MaxOpenScrolls,
+		scrollSlots:            m.scrollSlots,
+		SortFields:             m.SortFields,
+		MultiTypePolicy:        m.MultiTypePolicy,
+		ClosedIndexPolicy:      m.ClosedIndexPolicy,
+		TemplateConflictPolicy: m.TemplateConflictPolicy,
+		BulkDedup:              m.BulkDedup,
+		ConflictPolicy:         m.ConflictPolicy,
+		TimestampField:         m.TimestampField,
+		ForceMergeSegments:     m.ForceMergeSegments,
+		TargetType:             m.TargetType,
+		CompatibilityIssue:     m.CompatibilityIssue,
+		IndexTimeout:           m.IndexTimeout,
+		ProgressLogInterval:    m.ProgressLogInterval,
+		AutoGenerateIds:        m.AutoGenerateIds,
+		UnorderedArrayFields:   m.UnorderedArrayFields,
+		CompareIgnoreFields:    m.CompareIgnoreFields,
+		CompareCheckpointFile:  m.CompareCheckpointFile,
+		CompareSettings:        m.CompareSettings,
+		IndexPairOptions:       m.IndexPairOptions,
+		ProgressTotalDocs:      m.ProgressTotalDocs,
+		MinIndexBytes:          m.MinIndexBytes,
+		MaxIndexBytes:          m.MaxIndexBytes,
+		OrderBySize:            m.OrderBySize,
+		OrderBySizeDesc:        m.OrderBySizeDesc,
+		StartStagger:           m.StartStagger,
+		DeadLetter:             m.DeadLetter,
+		MaxInflightBulk:        m.MaxInflightBulk,
+		AllowSelf:              m.AllowSelf,
+		BulkMetrics:            m.BulkMetrics,
+		StreamBulk:             m.StreamBulk,
+		WaitForActiveShards:    m.WaitForActiveShards,
+		RequireExistingTarget:  m.RequireExistingTarget,
+		TrackTotalHits:         m.TrackTotalHits,
+		VerifyCount:            m.VerifyCount,
+		BulkRefreshPolicy:      m.BulkRefreshPolicy,
+		DocTransformer:         m.DocTransformer,
+		FieldCoercions:         m.FieldCoercions,
+		Rollup:                 m.Rollup,
+		IndexRetry:             m.IndexRetry,
+		IndexRetryCleanup:      m.IndexRetryCleanup,
+		DenyIndexes:            m.DenyIndexes,
+		pause:                  m.pause,
+		indexSizes:             m.indexSizes,
+		Defaults:               m.Defaults,
 	}
 }
 
 func (m *BulkMigrator) Sync(force bool) error {
+	_, err := m.SyncWithStats(force)
+	return err
+}
+
+// SyncWithStats syncs like Sync and reports the stats of every index pair, keyed by the index pair key, and
+// their total
+func (m *BulkMigrator) SyncWithStats(force bool) (*SyncReport, error) {
+	startTime := time.Now()
 	newBulkMigrator := m.getIndexPairsFromPattern()
 	if newBulkMigrator.Error != nil {
-		return errors.WithStack(newBulkMigrator.Error)
+		return nil, errors.WithStack(newBulkMigrator.Error)
 	}
 
-	newBulkMigrator.parallelRun(func(migrator *Migrator) {
-		if err := migrator.Sync(force); err != nil {
+	// the total grows with the count of every index pair unless it is counted up front
+	progress := newGrowingDocProgress(newBulkMigrator.ctx)
+	if newBulkMigrator.ProgressTotalDocs {
+		total, err := newBulkMigrator.countTotalDocs()
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		progress = newDocProgress(newBulkMigrator.ctx, total)
+	}
+
+	var (
+		mutex    sync.Mutex
+		reported bool
+		timedOut = make(map[string]bool)
+		report   = &SyncReport{Indexes: make(map[string]*MigrationStats), Failed: make(map[string]error)}
+	)
+	newBulkMigrator.parallelRunWithTimeout(func(migrator *Migrator) {
+		stats, err := newBulkMigrator.syncWithRetry(migrator.withDocProgress(progress), force)
+		indexPairKey := newBulkMigrator.getIndexPairKey(migrator.IndexPair)
+		mutex.Lock()
+		// a sync which finishes after its index timeout is left out of the returned report
+		if !reported && !timedOut[indexPairKey] {
+			report.Indexes[indexPairKey] = stats
+			report.Total.Add(stats)
+			if err != nil {
+				report.Failed[indexPairKey] = err
+			}
+		}
+		mutex.Unlock()
+		if err != nil {
 			utils.GetLogger(migrator.GetCtx()).Errorf("sync %+v", err)
+			return
+		}
+
+		if newBulkMigrator.ForceMergeSegments > 0 {
+			if err := migrator.GetCtx().Err(); err != nil {
+				utils.GetLogger(migrator.GetCtx()).Warnf("skip force merge, %+v", err)
+				return
+			}
+			if err := migrator.ForceMergeTarget(newBulkMigrator.ForceMergeSegments); err != nil {
+				utils.GetLogger(migrator.GetCtx()).Errorf("force merge %+v", err)
+			}
+		}
+	}, func(migrator *Migrator, err error) {
+		indexPairKey := newBulkMigrator.getIndexPairKey(migrator.IndexPair)
+		mutex.Lock()
+		defer mutex.Unlock()
+		timedOut[indexPairKey] = true
+		if !reported {
+			report.Failed[indexPairKey] = err
 		}
 	})
-	return nil
+	mutex.Lock()
+	defer mutex.Unlock()
+	reported = true
+	report.Total.Duration = time.Since(startTime)
+	return report, nil
+}
+
+// countTotalDocs sums the source docs of the index pairs, each capped by MaxDocs like the sync
+func (m *BulkMigrator) countTotalDocs() (uint64, error) {
+	pool := pond.New(cast.ToInt(m.Parallelism), len(m.IndexPairMap))
+
+	var (
+		total atomic.Uint64
+		mutex sync.Mutex
+		errs  utils.Errs
+	)
+	for _, indexPair := range m.IndexPairMap {
+		pool.Submit(func() {
+			count, err := m.SourceES.Count(m.ctx, indexPair.SourceIndex)
+			if err != nil {
+				mutex.Lock()
+				errs.Add(errors.WithStack(err))
+				mutex.Unlock()
+				return
+			}
+			if m.MaxDocs > 0 {
+				count = min(count, uint64(m.MaxDocs))
+			}
+			total.Add(count)
+		})
+	}
+	pool.StopAndWait()
+
+	if err := errs.Ret(); err != nil {
+		return 0, err
+	}
+	return total.Load(), nil
 }
 
 func (m *BulkMigrator) SyncDiff() (map[string]*DiffResult, error) {
@@ -574,8 +1269,16 @@ func (m *BulkMigrator) SyncDiff() (map[string]*DiffResult, error) {
 		return nil, errors.WithStack(newBulkMigrator.Error)
 	}
 
+	checkpoint, err := m.loadCompareCheckpoint()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
 	var diffMap sync.Map
 	newBulkMigrator.parallelRun(func(migrator *Migrator) {
+		if checkpoint != nil {
+			migrator = migrator.withCompareCheckpoint(checkpoint, newBulkMigrator.getIndexPairKey(migrator.IndexPair))
+		}
 		diffResult, err := migrator.SyncDiff()
 		if utils.IsCustomError(err, utils.NonIndexExisted) {
 			diffMap.Store(newBulkMigrator.getIndexPairKey(migrator.IndexPair), &DiffResult{
@@ -609,18 +1312,105 @@ func (m *BulkMigrator) SyncDiff() (map[string]*DiffResult, error) {
 	return result, nil
 }
 
+func (m *BulkMigrator) Repair() (map[string]*RepairResult, error) {
+	newBulkMigrator := m.getIndexPairsFromPattern()
+	if newBulkMigrator.Error != nil {
+		return nil, errors.WithStack(newBulkMigrator.Error)
+	}
+
+	checkpoint, err := m.loadCompareCheckpoint()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	var repairMap sync.Map
+	newBulkMigrator.parallelRun(func(migrator *Migrator) {
+		if checkpoint != nil {
+			migrator = migrator.withCompareCheckpoint(checkpoint, newBulkMigrator.getIndexPairKey(migrator.IndexPair))
+		}
+		repairResult, err := migrator.Repair()
+		if utils.IsCustomError(err, utils.NonIndexExisted) {
+			utils.GetLogger(migrator.GetCtx()).Warn("target has no index")
+			return
+		}
+
+		if err != nil {
+			utils.GetLogger(migrator.GetCtx()).Errorf("repair %+v", err)
+		}
+
+		if repairResult != nil {
+			repairMap.Store(newBulkMigrator.getIndexPairKey(migrator.IndexPair), repairResult)
+		}
+	})
+
+	result := make(map[string]*RepairResult)
+	repairMap.Range(func(key, value interface{}) bool {
+		keyStr := cast.ToString(key)
+		result[keyStr] = value.(*RepairResult)
+		return true
+	})
+
+	return result, nil
+}
+
+// loadCompareCheckpoint loads the checkpoint file, a bulk migrator without one has a nil checkpoint
+func (m *BulkMigrator) loadCompareCheckpoint() (*CompareCheckpoint, error) {
+	if m.CompareCheckpointFile == "" {
+		return nil, nil
+	}
+	checkpoint, err := LoadCompareCheckpoint(m.CompareCheckpointFile)
+	return checkpoint, errors.WithStack(err)
+}
+
 func (m *BulkMigrator) Compare() (map[string]*DiffResult, error) {
+	return m.compareIndexPairs(func(_ string, migrator *Migrator) (*DiffResult, error) {
+		return migrator.Compare()
+	})
+}
+
+// CompareStream passes the diffs of every index pair to callback as they are found instead of keeping their
+// ids, the results only count them. callback is called by one goroutine at a time. An index pair the checkpoint
+// records as finished is not streamed again, the diffs of one resumed halfway are.
+func (m *BulkMigrator) CompareStream(callback func(indexPair string, diff DocDiff)) (map[string]*DiffResult, error) {
+	var callbackLock sync.Mutex
+	return m.compareIndexPairs(func(indexPairKey string, migrator *Migrator) (*DiffResult, error) {
+		return migrator.CompareStream(func(diff DocDiff) {
+			callbackLock.Lock()
+			defer callbackLock.Unlock()
+			callback(indexPairKey, diff)
+		})
+	})
+}
+
+func (m *BulkMigrator) compareIndexPairs(compare func(indexPairKey string, migrator *Migrator) (*DiffResult, error)) (map[string]*DiffResult, error) {
 	newBulkMigrator := m.getIndexPairsFromPattern()
 	if newBulkMigrator.Error != nil {
 		return nil, errors.WithStack(newBulkMigrator.Error)
 	}
 
+	checkpoint, err := m.loadCompareCheckpoint()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
 	var diffMap sync.Map
 
 	newBulkMigrator.parallelRun(func(migrator *Migrator) {
-		diffResult, err := migrator.Compare()
+		indexPairKey := newBulkMigrator.getIndexPairKey(migrator.IndexPair)
+		if diffResult, ok := checkpoint.Get(indexPairKey); ok {
+			utils.GetLogger(migrator.GetCtx()).Infof("compared before, resume %s", diffResult.toStr())
+			if diffResult.HasDiff() {
+				diffMap.Store(indexPairKey, diffResult)
+			}
+			return
+		}
+
+		if checkpoint != nil {
+			migrator = migrator.withCompareCheckpoint(checkpoint, indexPairKey)
+		}
+		diffResult, err := compare(indexPairKey, migrator)
 		if utils.IsCustomError(err, utils.NonIndexExisted) {
-			diffMap.Store(newBulkMigrator.getIndexPairKey(migrator.IndexPair), &DiffResult{
+			diffMap.Store(indexPairKey, &DiffResult{
 				SameCount:   *utils.ZeroAtomicUint64(),
 				CreateCount: *utils.MaxAtomicUint64(),
 				UpdateCount: *utils.ZeroAtomicUint64(),
@@ -634,8 +1424,16 @@ func (m *BulkMigrator) Compare() (map[string]*DiffResult, error) {
 			utils.GetLogger(migrator.GetCtx()).Errorf("compare %+v", err)
 			return
 		}
+		if m.CompareSettings {
+			if diffResult.SettingsDiff, err = migrator.CompareSettings(); err != nil {
+				utils.GetLogger(migrator.GetCtx()).Errorf("compare settings %+v", err)
+			}
+		}
+		if err := checkpoint.Save(indexPairKey, diffResult); err != nil {
+			utils.GetLogger(migrator.GetCtx()).Errorf("save compare checkpoint %+v", err)
+		}
 		if diffResult.HasDiff() {
-			diffMap.Store(newBulkMigrator.getIndexPairKey(migrator.IndexPair), diffResult)
+			diffMap.Store(indexPairKey, diffResult)
 		} else {
 			utils.GetLogger(migrator.GetCtx()).Info("no difference")
 		}
@@ -652,18 +1450,28 @@ func (m *BulkMigrator) Compare() (map[string]*DiffResult, error) {
 	return result, nil
 }
 
+// CopyIndexSettings only creates the target indices of all the index pairs with the source settings and
+// mappings, it is the entrypoint to prepare the structure ahead of a later data load. The errors of the index
+// pairs are returned together.
 func (m *BulkMigrator) CopyIndexSettings(force bool) error {
 	newBulkMigrator := m.getIndexPairsFromPattern()
 	if newBulkMigrator.Error != nil {
 		return errors.WithStack(newBulkMigrator.Error)
 	}
 
+	var (
+		mutex sync.Mutex
+		errs  utils.Errs
+	)
 	newBulkMigrator.parallelRun(func(migrator *Migrator) {
 		if err := migrator.CopyIndexSettings(force); err != nil {
 			utils.GetLogger(migrator.GetCtx()).Errorf("copyIndexSettings %+v", err)
+			mutex.Lock()
+			errs.Add(errors.Wrapf(err, "copy index settings %s", newBulkMigrator.getIndexPairKey(migrator.IndexPair)))
+			mutex.Unlock()
 		}
 	})
-	return nil
+	return errs.Ret()
 }
 
 func (m *BulkMigrator) CreateTemplates() error {
@@ -704,9 +1512,82 @@ func (m *BulkMigrator) getIndexPairsFromPattern() *BulkMigrator {
 	if len(newIndexPairsMap) > 0 {
 		newBulkMigrator.IndexPairMap = lo.Assign(newBulkMigrator.IndexPairMap, newIndexPairsMap)
 	}
+	return newBulkMigrator.filterIndexPairsBySize().filterDeniedIndexPairs()
+}
+
+// filterIndexPairsBySize fetches the source store sizes when a size filter or ordering is set and drops the
+// index pairs out of the size filter
+func (m *BulkMigrator) filterIndexPairsBySize() *BulkMigrator {
+	if m.Error != nil || (m.MinIndexBytes == 0 && m.MaxIndexBytes == 0 && !m.OrderBySize) {
+		return m
+	}
+
+	newBulkMigrator := m.clone()
+	newBulkMigrator.indexSizes, newBulkMigrator.Error = m.SourceES.GetIndexSizes()
+	if newBulkMigrator.Error != nil {
+		return newBulkMigrator
+	}
+
+	newBulkMigrator.IndexPairMap = lo.PickBy(m.IndexPairMap, func(key string, indexPair *config.IndexPair) bool {
+		size := newBulkMigrator.indexSizes[indexPair.SourceIndex]
+		if size < m.MinIndexBytes || (m.MaxIndexBytes > 0 && size > m.MaxIndexBytes) {
+			utils.GetLogger(m.ctx).Infof("skip index %s of %d bytes, out of the size filter [%d, %d]",
+				indexPair.SourceIndex, size, m.MinIndexBytes, m.MaxIndexBytes)
+			return false
+		}
+		return true
+	})
+	return newBulkMigrator
+}
+
+// filterDeniedIndexPairs drops the index pairs which touch a deny index, it runs last so no selection brings
+// them back
+func (m *BulkMigrator) filterDeniedIndexPairs() *BulkMigrator {
+	if m.Error != nil || len(m.DenyIndexes) == 0 {
+		return m
+	}
+
+	newBulkMigrator := m.clone()
+	var denyRegexps []*regexp.Regexp
+	for _, denyIndex := range m.DenyIndexes {
+		re, err := wildcardToRegexp(denyIndex)
+		if err != nil {
+			newBulkMigrator.Error = errors.WithStack(err)
+			return newBulkMigrator
+		}
+		denyRegexps = append(denyRegexps, re)
+	}
+	isDenied := func(index string) bool {
+		return lo.SomeBy(denyRegexps, func(re *regexp.Regexp) bool { return re.MatchString(index) })
+	}
+
+	newBulkMigrator.IndexPairMap = lo.PickBy(m.IndexPairMap, func(key string, indexPair *config.IndexPair) bool {
+		if isDenied(indexPair.SourceIndex) || isDenied(indexPair.TargetIndex) {
+			utils.GetLogger(m.ctx).Warnf("skip index pair %s, denied by the deny indexes", key)
+			return false
+		}
+		return true
+	})
 	return newBulkMigrator
 }
 
+// orderedIndexPairs returns the index pairs in the size ordering, in no particular order without it
+func (m *BulkMigrator) orderedIndexPairs() []*config.IndexPair {
+	indexPairs := lo.Values(m.IndexPairMap)
+	if !m.OrderBySize {
+		return indexPairs
+	}
+
+	sort.SliceStable(indexPairs, func(i, j int) bool {
+		sizeI, sizeJ := m.indexSizes[indexPairs[i].SourceIndex], m.indexSizes[indexPairs[j].SourceIndex]
+		if sizeI == sizeJ {
+			return indexPairs[i].SourceIndex < indexPairs[j].SourceIndex
+		}
+		return lo.Ternary(m.OrderBySizeDesc, sizeI > sizeJ, sizeI < sizeJ)
+	})
+	return indexPairs
+}
+
 func (m *BulkMigrator) getIndexFilePairsFromPattern() *BulkMigrator {
 	if m.Error != nil {
 		return m
@@ -834,49 +1715,156 @@ func (m *BulkMigrator) getIndexFilePairFromIndexFileRoot() *BulkMigrator {
 	return newBulkMigrator
 }
 
+// syncWithRetry syncs the index pair of migrator, up to IndexRetry more times while it fails, and returns the stats
+// of the last attempt
+func (m *BulkMigrator) syncWithRetry(migrator *Migrator, force bool) (*MigrationStats, error) {
+	stats, err := migrator.SyncWithStats(force)
+	for retry := uint(1); err != nil && retry <= m.IndexRetry; retry++ {
+		// a cancelled or timed out index is not retried
+		if migrator.GetCtx().Err() != nil {
+			break
+		}
+		utils.GetLogger(migrator.GetCtx()).Warnf("sync failed, retry %d/%d: %+v", retry, m.IndexRetry, err)
+		stats, err = migrator.SyncWithStats(force || m.IndexRetryCleanup == config.IndexRetryCleanupRecreate)
+	}
+	return stats, err
+}
+
+func (m *BulkMigrator) runWithIndexTimeout(migrator *Migrator, callback func(migrator *Migrator)) error {
+	if m.IndexTimeout <= 0 {
+		callback(migrator)
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(migrator.GetCtx(), m.IndexTimeout)
+	defer cancel()
+
+	done := make(chan struct{})
+	utils.GoRecovery(ctx, func() {
+		defer close(done)
+		callback(migrator.withCtx(ctx))
+	})
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+	}
+	select {
+	case <-done:
+		return nil
+	default:
+		// the callback is cancelled through ctx, the worker moves on without waiting for it
+		utils.GetLogger(ctx).Errorf("index failed, timeout after %s", m.IndexTimeout)
+		return errors.Wrapf(ctx.Err(), "timeout after %s", m.IndexTimeout)
+	}
+}
+
+// staggerStart waits a random delay up to StartStagger before the i-th submission while the pool fills, the
+// later ones wait for a free worker anyway
+func (m *BulkMigrator) staggerStart(i int) {
+	if m.StartStagger <= 0 || i == 0 || i >= cast.ToInt(m.Parallelism) {
+		return
+	}
+
+	select {
+	case <-time.After(time.Duration(rand.Int63n(int64(m.StartStagger)))):
+	case <-m.ctx.Done():
+	}
+}
+
+// newIndexPairMigrator builds the migrator of an index pair with the settings of m and the options of the pair
+func (m *BulkMigrator) newIndexPairMigrator(indexPair *config.IndexPair) *Migrator {
+	newMigrator := NewMigrator(m.ctx, m.SourceES, m.TargetES)
+	newMigrator = newMigrator.WithIndexPair(*indexPair).
+		WithScrollSize(m.ScrollSize).
+		WithScrollTime(m.ScrollTime).
+		WithSliceSize(m.SliceSize).
+		WithBufferCount(m.BufferCount).
+		WithActionParallelism(m.ActionParallelism).
+		WithActionSize(m.ActionSize).
+		WithIds(m.Ids).
+		WithMaxDocs(m.MaxDocs).
+		WithAutoSlice(m.AutoSlice).
+		WithReadParallel(m.ReadParallel).
+		withScrollSlots(m.MaxOpenScrolls, m.scrollSlots).
+		WithSortFields(m.SortFields).
+		WithMultiTypePolicy(m.MultiTypePolicy).
+		WithClosedIndexPolicy(m.ClosedIndexPolicy).
+		WithBulkDedup(m.BulkDedup).
+		WithConflictPolicy(m.ConflictPolicy, m.TimestampField).
+		WithTargetType(m.TargetType).
+		WithAutoGenerateIds(m.AutoGenerateIds).
+		WithUnorderedArrayFields(m.UnorderedArrayFields).
+		WithCompareIgnoreFields(m.CompareIgnoreFields).
+		WithDeadLetter(m.DeadLetter).
+		WithMaxInflightBulk(m.MaxInflightBulk).
+		WithBulkMetrics(m.BulkMetrics).
+		WithStreamBulk(m.StreamBulk).
+		WithWaitForActiveShards(m.WaitForActiveShards).
+		WithRequireExistingTarget(m.RequireExistingTarget).
+		WithAllowSelf(m.AllowSelf).
+		WithTrackTotalHits(m.TrackTotalHits).
+		WithVerifyCount(m.VerifyCount).
+		WithBulkRefreshPolicy(m.BulkRefreshPolicy).
+		WithDocTransformer(m.DocTransformer).
+		WithFieldCoercions(m.FieldCoercions).
+		WithRollup(m.Rollup).
+		withPause(m.pause)
+	if option, ok := m.IndexPairOptions[m.getIndexPairKey(indexPair)]; ok {
+		newMigrator = option(newMigrator)
+	}
+	return newMigrator
+}
+
 func (m *BulkMigrator) parallelRun(callback func(migrator *Migrator)) {
+	m.parallelRunWithTimeout(callback, func(*Migrator, error) {})
+}
+
+// parallelRunWithTimeout runs like parallelRun and calls onTimeout with the migrator of an index pair which ran out
+// of IndexTimeout, its callback may still be running
+func (m *BulkMigrator) parallelRunWithTimeout(callback func(migrator *Migrator), onTimeout func(migrator *Migrator, err error)) {
 	pool := pond.New(cast.ToInt(m.Parallelism), len(m.IndexPairMap))
-	finishCount := atomic.Int32{}
+	progress := newProgressLogger(m.ctx, len(m.IndexPairMap), m.ProgressLogInterval)
 
-	for _, indexPair := range m.IndexPairMap {
-		newMigrator := NewMigrator(m.ctx, m.SourceES, m.TargetES)
-		newMigrator = newMigrator.WithIndexPair(*indexPair).
-			WithScrollSize(m.ScrollSize).
-			WithScrollTime(m.ScrollTime).
-			WithSliceSize(m.SliceSize).
-			WithBufferCount(m.BufferCount).
-			WithActionParallelism(m.ActionParallelism).
-			WithActionSize(m.ActionSize).
-			WithIds(m.Ids)
+	for i, indexPair := range m.orderedIndexPairs() {
+		m.staggerStart(i)
+		newMigrator := m.newIndexPairMigrator(indexPair)
 
 		pool.Submit(func() {
-			callback(newMigrator)
-			finishCount.Add(1)
-			utils.GetLogger(newMigrator.GetCtx()).Infof("task progress %0.4f (%d, %d)", float64(finishCount.Load())/float64(len(m.IndexPairMap)), finishCount.Load(), len(m.IndexPairMap))
+			if err := m.runWithIndexTimeout(newMigrator, callback); err != nil {
+				onTimeout(newMigrator, err)
+			}
+			progress.done()
 		})
 	}
 	pool.StopAndWait()
 }
 
+func (m *BulkMigrator) newIndexTemplateMigrator(indexTemplate *config.IndexTemplate) *Migrator {
+	return NewMigrator(m.ctx, m.SourceES, m.TargetES).
+		WithIndexTemplate(*indexTemplate).
+		WithScrollSize(m.ScrollSize).
+		WithScrollTime(m.ScrollTime).
+		WithSliceSize(m.SliceSize).
+		WithBufferCount(m.BufferCount).
+		WithActionParallelism(m.ActionParallelism).
+		WithActionSize(m.ActionSize).
+		WithIds(m.Ids).
+		WithTemplateConflictPolicy(m.TemplateConflictPolicy)
+}
+
 func (m *BulkMigrator) parallelRunWithIndexTemplate(callback func(migrator *Migrator)) {
 	pool := pond.New(cast.ToInt(m.Parallelism), len(m.IndexPairMap))
-	finishCount := atomic.Int32{}
+	progress := newProgressLogger(m.ctx, len(m.IndexTemplates), m.ProgressLogInterval)
 
-	for _, indexTemplate := range m.IndexTemplates {
-		newMigrator := NewMigrator(m.ctx, m.SourceES, m.TargetES)
-		newMigrator = newMigrator.WithIndexTemplate(*indexTemplate).
-			WithScrollSize(m.ScrollSize).
-			WithScrollTime(m.ScrollTime).
-			WithSliceSize(m.SliceSize).
-			WithBufferCount(m.BufferCount).
-			WithActionParallelism(m.ActionParallelism).
-			WithActionSize(m.ActionSize).
-			WithIds(m.Ids)
+	for i, indexTemplate := range lo.Values(m.IndexTemplates) {
+		m.staggerStart(i)
+		newMigrator := m.newIndexTemplateMigrator(indexTemplate)
 
 		pool.Submit(func() {
-			callback(newMigrator)
-			finishCount.Add(1)
-			utils.GetLogger(newMigrator.GetCtx()).Infof("task progress %0.4f (%d, %d)", float64(finishCount.Load())/float64(len(m.IndexPairMap)), finishCount.Load(), len(m.IndexPairMap))
+			m.runWithIndexTimeout(newMigrator, callback)
+			progress.done()
 		})
 	}
 	pool.StopAndWait()
@@ -884,9 +1872,10 @@ func (m *BulkMigrator) parallelRunWithIndexTemplate(callback func(migrator *Migr
 
 func (m *BulkMigrator) parallelRunWithIndexFilePair(callback func(migrator *Migrator)) {
 	pool := pond.New(cast.ToInt(m.Parallelism), len(m.IndexPairMap))
-	finishCount := atomic.Int32{}
+	progress := newProgressLogger(m.ctx, len(m.IndexFilePairMap), m.ProgressLogInterval)
 
-	for _, indexFilePair := range m.IndexFilePairMap {
+	for i, indexFilePair := range lo.Values(m.IndexFilePairMap) {
+		m.staggerStart(i)
 		newMigrator := NewMigrator(m.ctx, m.SourceES, m.TargetES)
 		newMigrator = newMigrator.WithIndexFilePair(indexFilePair).
 			WithScrollSize(m.ScrollSize).
@@ -895,12 +1884,13 @@ func (m *BulkMigrator) parallelRunWithIndexFilePair(callback func(migrator *Migr
 			WithBufferCount(m.BufferCount).
 			WithActionParallelism(m.ActionParallelism).
 			WithActionSize(m.ActionSize).
-			WithIds(m.Ids)
+			WithIds(m.Ids).
+			WithAutoSlice(m.AutoSlice).
+			withPause(m.pause)
 
 		pool.Submit(func() {
-			callback(newMigrator)
-			finishCount.Add(1)
-			utils.GetLogger(newMigrator.GetCtx()).Infof("task progress %0.4f (%d, %d)", float64(finishCount.Load())/float64(len(m.IndexFilePairMap)), finishCount.Load(), len(m.IndexFilePairMap))
+			m.runWithIndexTimeout(newMigrator, callback)
+			progress.done()
 		})
 	}
 	pool.StopAndWait()
@@ -0,0 +1,874 @@
+package task
+
+import (
+	"context"
+	"github.com/CharellKing/ela-lib/config"
+	es2 "github.com/CharellKing/ela-lib/pkg/es"
+	"github.com/CharellKing/ela-lib/utils"
+	"github.com/pkg/errors"
+	"github.com/samber/lo"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBulkMigratorFilterHiddenIndexes(t *testing.T) {
+	es := newFakeES(map[string][]*es2.Doc{
+		"orders":         nil,
+		"orders-hidden":  nil,
+		".orders-system": nil,
+		"customers":      nil,
+	})
+	es.hidden = map[string]bool{"orders-hidden": true}
+
+	m := NewBulkMigratorWithES(context.Background(), es, es)
+	indexes, err := m.filterIndexes("orders")
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	sort.Strings(indexes)
+	if len(indexes) != 3 {
+		t.Fatalf("expected all matched indexes without ignoring system index, got %+v", indexes)
+	}
+
+	m.ctx = utils.SetCtxKeyIgnoreSystemIndex(m.ctx, true)
+	indexes, err = m.filterIndexes("orders")
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if len(indexes) != 1 || indexes[0] != "orders" {
+		t.Fatalf("expected only orders, got %+v", indexes)
+	}
+	if es.hiddenRequests != 1 {
+		t.Errorf("expected the hidden indexes read in 1 request, got %d", es.hiddenRequests)
+	}
+}
+
+func TestBulkMigratorWithIgnoreSystemIndex(t *testing.T) {
+	es := newFakeES(map[string][]*es2.Doc{".kibana": nil, "orders": nil})
+	indexPairKeys := func(m *BulkMigrator) []string {
+		newBulkMigrator := m.getIndexPairsFromPattern()
+		if newBulkMigrator.Error != nil {
+			t.Fatalf("%+v", newBulkMigrator.Error)
+		}
+		keys := lo.Keys(newBulkMigrator.IndexPairMap)
+		sort.Strings(keys)
+		return keys
+	}
+
+	m := NewBulkMigratorWithES(context.Background(), es, es).WithPatternIndexes(".*")
+	if keys := indexPairKeys(m); !reflect.DeepEqual(keys, []string{".kibana:.kibana", "orders:orders"}) {
+		t.Errorf("expect the system index by default, got %v", keys)
+	}
+
+	ignored := m.WithIgnoreSystemIndex(true)
+	if !utils.GetCtxKeyIgnoreSystemIndex(ignored.GetCtx()) || utils.GetCtxKeyIgnoreSystemIndex(m.GetCtx()) {
+		t.Errorf("expect the flag set on the new migrator only")
+	}
+	if keys := indexPairKeys(ignored); !reflect.DeepEqual(keys, []string{"orders:orders"}) {
+		t.Errorf("expect the system index ignored, got %v", keys)
+	}
+	if keys := indexPairKeys(ignored.WithIgnoreSystemIndex(false)); len(keys) != 2 {
+		t.Errorf("expect the system index back, got %v", keys)
+	}
+
+	// an explicit pair is kept whatever its name
+	explicit := ignored.WithIndexPairs(&config.IndexPair{SourceIndex: ".kibana", TargetIndex: ".kibana"})
+	if keys := indexPairKeys(explicit); !reflect.DeepEqual(keys, []string{".kibana:.kibana", "orders:orders"}) {
+		t.Errorf("expect the explicit system index pair, got %v", keys)
+	}
+}
+
+func newMockESConfig(t *testing.T, status int) *config.ESConfig {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Elastic-Product", "Elasticsearch")
+		w.WriteHeader(status)
+		if r.URL.Path == "/" {
+			_, _ = w.Write([]byte(`{"version":{"number":"7.10.2"}}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"status":"green"}`))
+	}))
+	t.Cleanup(server.Close)
+	return &config.ESConfig{Addresses: []string{server.URL}, User: "user", Password: "password"}
+}
+
+func TestNewBulkMigratorValidate(t *testing.T) {
+	healthy := newMockESConfig(t, http.StatusOK)
+	if _, err := NewBulkMigrator(context.Background(), healthy, healthy); err != nil {
+		t.Fatalf("healthy clusters %+v", err)
+	}
+
+	_, err := NewBulkMigrator(context.Background(), healthy, newMockESConfig(t, http.StatusUnauthorized))
+	if !utils.IsCustomError(err, utils.AuthFailed) {
+		t.Errorf("expected auth failed, got %+v", err)
+	}
+
+	unreachable := &config.ESConfig{Addresses: []string{"http://127.0.0.1:1"}}
+	_, err = NewBulkMigrator(context.Background(), unreachable, healthy)
+	if !utils.IsCustomError(err, utils.ConnectionFailed) {
+		t.Errorf("expected connection failed, got %+v", err)
+	}
+}
+
+func TestBulkMigratorCompatibility(t *testing.T) {
+	cases := []struct {
+		sourceVersion string
+		targetVersion string
+		hasIssue      bool
+	}{
+		{"5.6.16", "8.12.2", true},
+		{"6.8.23", "8.12.2", true},
+		{"8.12.2", "6.8.23", true},
+		{"5.6.16", "6.8.23", false},
+		{"6.8.23", "7.10.2", false},
+		{"7.10.2", "8.12.2", false},
+		{"7.10.2", "7.17.9", false},
+	}
+
+	for _, item := range cases {
+		sourceES := newFakeES(nil)
+		sourceES.version = item.sourceVersion
+		targetES := newFakeES(nil)
+		targetES.version = item.targetVersion
+
+		m := NewBulkMigratorWithES(context.Background(), sourceES, targetES)
+		if (m.CompatibilityIssue != "") != item.hasIssue {
+			t.Errorf("%s -> %s issue %q", item.sourceVersion, item.targetVersion, m.CompatibilityIssue)
+		}
+		if m.Error != nil {
+			t.Errorf("%s -> %s fails without strict mode: %+v", item.sourceVersion, item.targetVersion, m.Error)
+		}
+
+		strictErr := m.WithStrictCompatibility(true).Error
+		if utils.IsCustomError(strictErr, utils.IncompatibleVersion) != item.hasIssue {
+			t.Errorf("%s -> %s strict error %+v", item.sourceVersion, item.targetVersion, strictErr)
+		}
+	}
+
+	sourceES := newFakeES(nil)
+	sourceES.version = "5.6.16"
+	targetES := newFakeES(nil)
+	targetES.version = "8.12.2"
+	if issue := NewBulkMigratorWithES(context.Background(), sourceES, targetES).CompatibilityIssue; !strings.Contains(issue, "6.8") {
+		t.Errorf("5 -> 8 issue does not point at the recommended path: %s", issue)
+	}
+}
+
+func TestBulkMigratorExpandIndexPairs(t *testing.T) {
+	es := newFakeES(map[string][]*es2.Doc{
+		"a":          nil,
+		"b":          nil,
+		"logs-1":     nil,
+		"logs-2":     nil,
+		"metrics-1":  nil,
+		".logs-meta": nil,
+	})
+
+	cases := []struct {
+		indexPair *config.IndexPair
+		expected  []string
+	}{
+		{&config.IndexPair{SourceIndex: "a, b", TargetIndex: "{index}-copy"}, []string{"a:a-copy", "b:b-copy"}},
+		{&config.IndexPair{SourceIndex: "logs-*"}, []string{"logs-1:logs-1", "logs-2:logs-2"}},
+		{&config.IndexPair{SourceIndex: "logs-*,a", TargetIndex: "v2-{index}"}, []string{"a:v2-a", "logs-1:v2-logs-1", "logs-2:v2-logs-2"}},
+		{&config.IndexPair{SourceIndex: "*-1", TargetIndex: "all", Merge: true}, []string{"logs-1:all", "metrics-1:all"}},
+		{&config.IndexPair{SourceIndex: "a", TargetIndex: "c"}, []string{"a:c"}},
+	}
+
+	for _, item := range cases {
+		m := NewBulkMigratorWithES(context.Background(), es, es).WithIndexPairs(item.indexPair)
+		if m.Error != nil {
+			t.Errorf("%+v: %+v", item.indexPair, m.Error)
+			continue
+		}
+		keys := lo.Keys(m.IndexPairMap)
+		sort.Strings(keys)
+		if strings.Join(keys, " ") != strings.Join(item.expected, " ") {
+			t.Errorf("%+v expands to %v, expected %v", item.indexPair, keys, item.expected)
+		}
+	}
+
+	m := NewBulkMigratorWithES(context.Background(), es, es).
+		WithIndexPairs(&config.IndexPair{SourceIndex: "a,b", TargetIndex: "merged"})
+	if !utils.IsCustomError(m.Error, utils.InvalidParams) {
+		t.Errorf("ambiguous expansion is accepted: %+v", m.Error)
+	}
+
+	m = NewBulkMigratorWithES(context.Background(), es, es).
+		WithIndexPairs(&config.IndexPair{SourceIndex: "missing-*"})
+	if !utils.IsCustomError(m.Error, utils.NonIndexExisted) {
+		t.Errorf("empty expansion is accepted: %+v", m.Error)
+	}
+}
+
+func TestNewBulkMigratorWithDefaults(t *testing.T) {
+	es := newFakeES(nil)
+	m := NewBulkMigratorWithDefaults(context.Background(), es, es, MigratorDefaults{
+		Parallelism: 2,
+		ScrollSize:  500,
+	})
+
+	if m.Parallelism != 2 || m.ScrollSize != 500 {
+		t.Errorf("custom defaults are not applied, parallelism %d, scroll size %d", m.Parallelism, m.ScrollSize)
+	}
+	if m.SliceSize != defaultSliceSize || m.ActionParallelism != defaultActionParallelism {
+		t.Errorf("unset defaults do not fall back to the built-in ones, slice size %d, action parallelism %d",
+			m.SliceSize, m.ActionParallelism)
+	}
+
+	if scrollSize := m.WithScrollSize(100).ScrollSize; scrollSize != 100 {
+		t.Errorf("WithScrollSize does not override the default, got %d", scrollSize)
+	}
+	if scrollSize := m.WithScrollSize(100).WithScrollSize(0).ScrollSize; scrollSize != 500 {
+		t.Errorf("WithScrollSize(0) does not fall back to the custom default, got %d", scrollSize)
+	}
+}
+
+func TestBulkMigratorCloneKeepsCompareSettings(t *testing.T) {
+	es := newFakeES(nil)
+	m := NewBulkMigratorWithES(context.Background(), es, es).
+		WithUnorderedArrayFields([]string{"tags"}).
+		WithCompareIgnoreFields([]string{"updated_at"}).
+		WithCompareCheckpoint("checkpoint.json").
+		WithScrollSize(100)
+
+	if !reflect.DeepEqual(m.UnorderedArrayFields, []string{"tags"}) ||
+		!reflect.DeepEqual(m.CompareIgnoreFields, []string{"updated_at"}) ||
+		m.CompareCheckpointFile != "checkpoint.json" {
+		t.Errorf("compare settings are lost by a later With*, got %v %v %q",
+			m.UnorderedArrayFields, m.CompareIgnoreFields, m.CompareCheckpointFile)
+	}
+}
+
+func TestProgressLoggerInterval(t *testing.T) {
+	const total = 1000
+
+	cases := []struct {
+		interval time.Duration
+		maxLogs  int32
+	}{
+		{0, total},
+		{time.Hour, 1},
+	}
+
+	for _, item := range cases {
+		progress := newProgressLogger(context.Background(), total, item.interval)
+		logs := atomic.Int32{}
+		progress.logf = func(format string, args ...interface{}) {
+			logs.Add(1)
+		}
+
+		var wg sync.WaitGroup
+		for i := 0; i < total; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				progress.done()
+			}()
+		}
+		wg.Wait()
+
+		if progress.finished != total {
+			t.Errorf("interval %s: expected %d finished, got %d", item.interval, total, progress.finished)
+		}
+		if logs.Load() < 1 || logs.Load() > item.maxLogs {
+			t.Errorf("interval %s: expected between 1 and %d logs, got %d", item.interval, item.maxLogs, logs.Load())
+		}
+	}
+}
+
+// waitComparePosition loads the checkpoint once the compare abandoned at its index timeout saved the position
+// it stopped at
+func waitComparePosition(t *testing.T, checkpointFile string, indexPairKey string,
+	stopped func(position *ComparePosition) bool) *CompareCheckpoint {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		checkpoint, err := LoadCompareCheckpoint(checkpointFile)
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+		if position, ok := checkpoint.Position(indexPairKey); (ok && stopped(position)) || time.Now().After(deadline) {
+			return checkpoint
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestBulkMigratorCompareCheckpoint(t *testing.T) {
+	checkpointFile := filepath.Join(t.TempDir(), "compare.json")
+	compare := func(sourceES, targetES *fakeES) map[string]*DiffResult {
+		result, err := NewBulkMigratorWithES(context.Background(), sourceES, targetES).
+			WithIndexPairs(
+				&config.IndexPair{SourceIndex: "done", TargetIndex: "done"},
+				&config.IndexPair{SourceIndex: "stuck", TargetIndex: "stuck"}).
+			WithIndexTimeout(100 * time.Millisecond).
+			WithCompareCheckpoint(checkpointFile).
+			Compare()
+		if err != nil {
+			t.Fatalf("compare %+v", err)
+		}
+		return result
+	}
+
+	updatedDocs := newFakeDocs(3)
+	updatedDocs[0].Source = map[string]interface{}{"value": "updated"}
+
+	// the first run is interrupted on stuck, only done is checkpointed
+	sourceES := newFakeES(map[string][]*es2.Doc{"done": newFakeDocs(3), "stuck": newFakeDocs(3)})
+	sourceES.blockSearches = map[string]int{"stuck": 0}
+	targetES := newFakeES(map[string][]*es2.Doc{"done": updatedDocs, "stuck": newFakeDocs(2)})
+	result := compare(sourceES, targetES)
+	if _, ok := result["stuck:stuck"]; ok || result["done:done"] == nil || result["done:done"].UpdateCount.Load() != 1 {
+		t.Fatalf("unexpected first run result %v", lo.Keys(result))
+	}
+
+	checkpoint := waitComparePosition(t, checkpointFile, "stuck:stuck", func(*ComparePosition) bool {
+		return true
+	})
+	if _, ok := checkpoint.Get("stuck:stuck"); ok {
+		t.Errorf("the interrupted index pair is checkpointed")
+	}
+
+	// done is not compared again, its source no longer differs but the checkpointed diff is kept
+	sourceES = newFakeES(map[string][]*es2.Doc{"done": updatedDocs, "stuck": newFakeDocs(3)})
+	targetES = newFakeES(map[string][]*es2.Doc{"done": updatedDocs, "stuck": newFakeDocs(2)})
+	result = compare(sourceES, targetES)
+	if result["done:done"] == nil || result["done:done"].UpdateCount.Load() != 1 || result["done:done"].SameCount.Load() != 2 ||
+		!lo.Contains(result["done:done"].UpdateDocs, "0") {
+		t.Errorf("done is not resumed from the checkpoint")
+	}
+	if result["stuck:stuck"] == nil || result["stuck:stuck"].CreateCount.Load() != 1 || result["stuck:stuck"].SameCount.Load() != 2 {
+		t.Errorf("stuck is not compared on resume")
+	}
+
+	var total DiffResult
+	for _, diffResult := range result {
+		total.Merge(diffResult)
+	}
+	if total.SameCount.Load() != 4 || total.UpdateCount.Load() != 1 || total.CreateCount.Load() != 1 || len(total.CreateDocs) != 1 {
+		t.Errorf("unexpected merged result %s", total.toStr())
+	}
+}
+
+func TestBulkMigratorCompareResumesPartway(t *testing.T) {
+	checkpointFile := filepath.Join(t.TempDir(), "compare.json")
+	compare := func(sourceES, targetES *fakeES) map[string]*DiffResult {
+		result, err := NewBulkMigratorWithES(context.Background(), sourceES, targetES).
+			WithIndexPairs(&config.IndexPair{SourceIndex: "idx", TargetIndex: "idx"}).
+			WithScrollSize(2).
+			WithIndexTimeout(100 * time.Millisecond).
+			WithCompareCheckpoint(checkpointFile).
+			Compare()
+		if err != nil {
+			t.Fatalf("compare %+v", err)
+		}
+		return result
+	}
+	newESes := func() (*fakeES, *fakeES) {
+		targetDocs := newFakeDocs(9)
+		targetDocs[1].Source = map[string]interface{}{"value": "updated"}
+		targetDocs[7].Source = map[string]interface{}{"value": "updated"}
+		return newFakeES(map[string][]*es2.Doc{"idx": newFakeDocs(10)}),
+			newFakeES(map[string][]*es2.Doc{"idx": append(targetDocs, &es2.Doc{ID: "x"})})
+	}
+
+	// the first run is interrupted after 3 pages of the source
+	sourceES, targetES := newESes()
+	sourceES.blockSearches = map[string]int{"idx": 3}
+	if result := compare(sourceES, targetES); len(result) != 0 {
+		t.Fatalf("the interrupted index pair has a result %v", lo.Keys(result))
+	}
+	checkpoint := waitComparePosition(t, checkpointFile, "idx:idx", func(position *ComparePosition) bool {
+		return position.SourceAfter != ""
+	})
+	position, ok := checkpoint.Position("idx:idx")
+	if !ok || position.SourceAfter != "5" || position.Result.SameCount.Load() != 5 || position.Result.UpdateCount.Load() != 1 {
+		t.Fatalf("unexpected position %+v", position)
+	}
+
+	// the resumed run reads the source after 5 and still finds every diff
+	sourceES, targetES = newESes()
+	result := compare(sourceES, targetES)
+	if docs := sourceES.searchedDocs["idx"]; !reflect.DeepEqual(docs, []string{"6", "7", "8", "9"}) {
+		t.Errorf("expect the source docs after the position to be read, got %v", docs)
+	}
+	diffResult := result["idx:idx"]
+	if diffResult == nil || diffResult.SameCount.Load() != 7 || diffResult.CreateCount.Load() != 1 ||
+		diffResult.DeleteCount.Load() != 1 || !reflect.DeepEqual(diffResult.UpdateDocs, []string{"1", "7"}) {
+		t.Fatalf("unexpected resumed result %v", result)
+	}
+
+	checkpoint, err := LoadCompareCheckpoint(checkpointFile)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if _, ok := checkpoint.Position("idx:idx"); ok {
+		t.Errorf("the position of a finished index pair is kept")
+	}
+}
+
+func TestBulkMigratorCompareSkipsVerifiedIds(t *testing.T) {
+	checkpointFile := filepath.Join(t.TempDir(), "compare.json")
+	compare := func(sourceES, targetES *fakeES) map[string]*DiffResult {
+		result, err := NewBulkMigratorWithES(context.Background(), sourceES, targetES).
+			WithIndexPairs(&config.IndexPair{SourceIndex: "idx", TargetIndex: "idx"}).
+			WithScrollSize(2).
+			WithIndexTimeout(100 * time.Millisecond).
+			WithCompareCheckpoint(checkpointFile).
+			Compare()
+		if err != nil {
+			t.Fatalf("compare %+v", err)
+		}
+		return result
+	}
+	newESes := func() (*fakeES, *fakeES) {
+		return newFakeES(map[string][]*es2.Doc{"idx": newFakeDocs(10)}),
+			newFakeES(map[string][]*es2.Doc{"idx": append(newFakeDocs(10), &es2.Doc{ID: "x"})})
+	}
+
+	// the first run is interrupted on the third page of the target ids, after the 5 lookups of the source pages
+	sourceES, targetES := newESes()
+	targetES.blockSearches = map[string]int{"idx": 7}
+	compare(sourceES, targetES)
+	checkpoint := waitComparePosition(t, checkpointFile, "idx:idx", func(position *ComparePosition) bool {
+		return position.TargetAfter != ""
+	})
+	if position, ok := checkpoint.Position("idx:idx"); !ok || !position.SourceDone || position.TargetAfter != "3" {
+		t.Fatalf("unexpected position %+v", position)
+	}
+	if verified := checkpoint.Verified("idx:idx"); verified.Len() != 10 {
+		t.Fatalf("expect 10 verified ids, got %d", verified.Len())
+	}
+
+	// the resumed run only looks up the target id which is not verified on the source
+	sourceES, targetES = newESes()
+	result := compare(sourceES, targetES)
+	if ids := sourceES.searchedIds["idx"]; len(ids) != 0 {
+		t.Errorf("expect no verified doc fetched again, got %v", ids)
+	}
+	if diffResult := result["idx:idx"]; diffResult == nil || diffResult.SameCount.Load() != 10 ||
+		!reflect.DeepEqual(diffResult.DeleteDocs, []string{"x"}) {
+		t.Errorf("unexpected resumed result %v", result)
+	}
+}
+
+func TestBulkMigratorWithIndexListFile(t *testing.T) {
+	es := newFakeES(nil)
+	m := NewBulkMigratorWithES(context.Background(), es, es).
+		WithIndexPairs(&config.IndexPair{SourceIndex: "a", TargetIndex: "b"}).
+		WithIndexListFile(filepath.Join("testdata", "index_list.txt"))
+	if m.Error != nil {
+		t.Fatalf("%+v", m.Error)
+	}
+
+	keys := lo.Keys(m.IndexPairMap)
+	sort.Strings(keys)
+	expected := []string{"a:b", "events:events", "logs-2024:logs-2024-archive", "orders:orders", "users:users-v2"}
+	if strings.Join(keys, " ") != strings.Join(expected, " ") {
+		t.Errorf("unexpected index pairs %v", keys)
+	}
+
+	if _, err := parseIndexList(strings.NewReader("a\n=b\n")); !utils.IsCustomError(err, utils.InvalidParams) {
+		t.Errorf("expected an invalid line error, got %+v", err)
+	}
+	if m := NewBulkMigratorWithES(context.Background(), es, es).WithIndexListFile("testdata/missing.txt"); m.Error == nil {
+		t.Errorf("expected an error for a missing file")
+	}
+}
+
+func TestBulkMigratorWithIndexPairOptions(t *testing.T) {
+	es := newFakeES(nil)
+	hugePair := &config.IndexPair{SourceIndex: "huge", TargetIndex: "huge"}
+	m := NewBulkMigratorWithES(context.Background(), es, es).
+		WithIndexPairs(hugePair, &config.IndexPair{SourceIndex: "small", TargetIndex: "small"}).
+		WithActionSize(5).
+		WithIndexPairOptions(hugePair, func(migrator *Migrator) *Migrator {
+			return migrator.WithActionSize(50)
+		})
+
+	var actionSizes sync.Map
+	m.parallelRun(func(migrator *Migrator) {
+		actionSizes.Store(migrator.IndexPair.SourceIndex, migrator.ActionSize)
+	})
+
+	for index, expected := range map[string]uint{"huge": 50, "small": 5} {
+		if actionSize, _ := actionSizes.Load(index); actionSize != expected {
+			t.Errorf("index %s action size %v, expected %d", index, actionSize, expected)
+		}
+	}
+}
+
+func TestBulkMigratorProgressTotalDocs(t *testing.T) {
+	sourceES := newFakeES(map[string][]*es2.Doc{
+		"a": newFakeDocs(3),
+		"b": newFakeDocs(5),
+		"c": newFakeDocs(20),
+	})
+	targetES := newFakeES(nil)
+	m := NewBulkMigratorWithES(context.Background(), sourceES, targetES).
+		WithIndexPairs(
+			&config.IndexPair{SourceIndex: "a", TargetIndex: "a"},
+			&config.IndexPair{SourceIndex: "b", TargetIndex: "b"},
+			&config.IndexPair{SourceIndex: "c", TargetIndex: "c"}).
+		WithMaxDocs(10)
+
+	total, err := m.countTotalDocs()
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if total != 3+5+10 {
+		t.Fatalf("expect the total to sum the sources capped by max docs, got %d", total)
+	}
+
+	progress := newDocProgress(m.GetCtx(), total)
+	m.parallelRun(func(migrator *Migrator) {
+		if err := migrator.withDocProgress(progress).Sync(false); err != nil {
+			t.Errorf("sync %+v", err)
+		}
+	})
+	if progress.done != total {
+		t.Fatalf("expect every migrated doc in the total progress, got %d of %d", progress.done, total)
+	}
+}
+
+func TestBulkMigratorGrowingDocProgress(t *testing.T) {
+	sourceES := newFakeES(map[string][]*es2.Doc{
+		"a": newFakeDocs(3),
+		"b": newFakeDocs(5),
+		"c": newFakeDocs(20),
+	})
+	targetES := newFakeES(nil)
+	m := NewBulkMigratorWithES(context.Background(), sourceES, targetES).
+		WithIndexPairs(
+			&config.IndexPair{SourceIndex: "a", TargetIndex: "a"},
+			&config.IndexPair{SourceIndex: "b", TargetIndex: "b"},
+			&config.IndexPair{SourceIndex: "c", TargetIndex: "c"}).
+		WithMaxDocs(10)
+
+	progress := newGrowingDocProgress(m.GetCtx())
+	m.parallelRun(func(migrator *Migrator) {
+		if err := migrator.withDocProgress(progress).Sync(false); err != nil {
+			t.Errorf("sync %+v", err)
+		}
+	})
+	if progress.total != 3+5+10 || progress.done != progress.total {
+		t.Fatalf("expect the total to sum the counts of the index pairs, got %d of %d", progress.done, progress.total)
+	}
+
+	// a pre-counted total is not counted again
+	preCounted := newDocProgress(m.GetCtx(), 18)
+	m.parallelRun(func(migrator *Migrator) {
+		if err := migrator.withDocProgress(preCounted).Sync(true); err != nil {
+			t.Errorf("sync %+v", err)
+		}
+	})
+	if preCounted.total != 18 || preCounted.done != 18 {
+		t.Fatalf("expect the pre-counted total kept, got %d of %d", preCounted.done, preCounted.total)
+	}
+}
+
+func TestBulkMigratorSizeFilterAndOrdering(t *testing.T) {
+	es := newFakeES(nil)
+	es.sizes = map[string]uint64{"tiny": 5, "small": 100, "medium": 500, "large": 900, "huge": 5000}
+
+	var indexPairs []*config.IndexPair
+	for index := range es.sizes {
+		indexPairs = append(indexPairs, &config.IndexPair{SourceIndex: index, TargetIndex: index})
+	}
+	m := NewBulkMigratorWithES(context.Background(), es, es).
+		WithIndexPairs(indexPairs...).
+		WithParallelism(1).
+		WithSizeFilter(10, 1000)
+
+	for desc, expected := range map[bool][]string{
+		true:  {"large", "medium", "small"},
+		false: {"small", "medium", "large"},
+	} {
+		newBulkMigrator := m.WithSizeOrdering(desc).getIndexPairsFromPattern()
+		if newBulkMigrator.Error != nil {
+			t.Fatalf("%+v", newBulkMigrator.Error)
+		}
+
+		var order []string
+		newBulkMigrator.parallelRun(func(migrator *Migrator) {
+			order = append(order, migrator.IndexPair.SourceIndex)
+		})
+		if !reflect.DeepEqual(order, expected) {
+			t.Errorf("desc %v expect order %v, got %v", desc, expected, order)
+		}
+	}
+}
+
+func TestBulkMigratorCopyIndexSettings(t *testing.T) {
+	sourceES := newFakeES(map[string][]*es2.Doc{
+		"a":      newFakeDocs(3),
+		"b":      newFakeDocs(3),
+		"broken": newFakeDocs(3),
+	})
+	targetES := newFakeES(nil)
+	targetES.createErrs = map[string]error{"broken-copy": errors.New("mapper_parsing_exception")}
+
+	err := NewBulkMigratorWithES(context.Background(), sourceES, targetES).
+		WithIndexPairs(
+			&config.IndexPair{SourceIndex: "a", TargetIndex: "a-copy"},
+			&config.IndexPair{SourceIndex: "b", TargetIndex: "b-copy"},
+			&config.IndexPair{SourceIndex: "broken", TargetIndex: "broken-copy"}).
+		CopyIndexSettings(false)
+	if err == nil || !strings.Contains(err.Error(), "broken:broken-copy") {
+		t.Fatalf("expect the error of broken:broken-copy, got %v", err)
+	}
+
+	created := append([]string{}, targetES.created...)
+	sort.Strings(created)
+	if !reflect.DeepEqual(created, []string{"a-copy", "b-copy"}) {
+		t.Errorf("expect the target indices created, got %v", created)
+	}
+	if len(targetES.written) != 0 || len(sourceES.scrolls) != 0 {
+		t.Errorf("expect no doc copied, written %v, scrolled %d", lo.Keys(targetES.written), len(sourceES.scrolls))
+	}
+}
+
+func TestBulkMigratorSyncWithStats(t *testing.T) {
+	sourceES := newFakeES(map[string][]*es2.Doc{
+		"a": newFakeDocs(7),
+		"b": newFakeDocs(12),
+	})
+	targetES := newFakeES(nil)
+
+	report, err := NewBulkMigratorWithES(context.Background(), sourceES, targetES).
+		WithIndexPairs(
+			&config.IndexPair{SourceIndex: "a", TargetIndex: "a"},
+			&config.IndexPair{SourceIndex: "b", TargetIndex: "b"}).
+		SyncWithStats(false)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	if stats := report.Indexes["a:a"]; stats == nil || stats.DocsRead != 7 || stats.DocsWritten != 7 {
+		t.Errorf("unexpected stats of a:a %+v", stats)
+	}
+	if stats := report.Indexes["b:b"]; stats == nil || stats.DocsRead != 12 || stats.DocsWritten != 12 {
+		t.Errorf("unexpected stats of b:b %+v", stats)
+	}
+	total := report.Total
+	if total.DocsRead != 19 || total.DocsWritten != 19 || total.DocsFailed != 0 ||
+		total.BytesWritten != uint64(targetES.bulkBytes) || total.Duration <= 0 {
+		t.Errorf("unexpected total stats %+v, bulk bytes %d", total, targetES.bulkBytes)
+	}
+
+	failedES := newFakeES(nil)
+	failedES.bulkErr = errors.New("es_rejected_execution_exception")
+	stats, err := NewMigrator(context.Background(), sourceES, failedES).
+		WithIndexPair(config.IndexPair{SourceIndex: "a", TargetIndex: "a"}).
+		SyncWithStats(false)
+	if err == nil {
+		t.Fatalf("expect the bulk error")
+	}
+	if stats.DocsRead != 7 || stats.DocsFailed != 7 || stats.DocsWritten != 0 || stats.BytesWritten != 0 {
+		t.Errorf("unexpected stats of failed bulks %+v", stats)
+	}
+}
+
+func TestBulkMigratorWithMaxOpenScrolls(t *testing.T) {
+	sourceES := newFakeES(map[string][]*es2.Doc{"a": newFakeDocs(200), "b": newFakeDocs(200), "c": newFakeDocs(200)})
+	targetES := newFakeES(nil)
+
+	// 3 index pairs of 4 slices each open 12 scrolls at once without the bound
+	report, err := NewBulkMigratorWithES(context.Background(), sourceES, targetES).
+		WithIndexPairs(
+			&config.IndexPair{SourceIndex: "a", TargetIndex: "a"},
+			&config.IndexPair{SourceIndex: "b", TargetIndex: "b"},
+			&config.IndexPair{SourceIndex: "c", TargetIndex: "c"}).
+		WithParallelism(3).
+		WithSliceSize(4).
+		WithScrollSize(5).
+		WithBufferCount(1).
+		WithMaxOpenScrolls(2).
+		SyncWithStats(false)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	if report.Total.DocsWritten != 600 {
+		t.Errorf("expect all the docs written, got %+v", report.Total)
+	}
+	if sourceES.maxOpenScrolls > 2 || sourceES.openScrolls != 0 {
+		t.Errorf("expect 2 scrolls at most and all cleared, got %d at most and %d open",
+			sourceES.maxOpenScrolls, sourceES.openScrolls)
+	}
+}
+
+func TestBulkMigratorStartStagger(t *testing.T) {
+	es := newFakeES(nil)
+	var indexPairs []*config.IndexPair
+	for _, index := range []string{"a", "b", "c", "d"} {
+		indexPairs = append(indexPairs, &config.IndexPair{SourceIndex: index, TargetIndex: index})
+	}
+	m := NewBulkMigratorWithES(context.Background(), es, es).
+		WithIndexPairs(indexPairs...).
+		WithParallelism(4)
+
+	startSpread := func(m *BulkMigrator) time.Duration {
+		var (
+			mutex  sync.Mutex
+			starts []time.Time
+		)
+		m.parallelRun(func(migrator *Migrator) {
+			mutex.Lock()
+			starts = append(starts, time.Now())
+			mutex.Unlock()
+		})
+		first := lo.MinBy(starts, func(a, b time.Time) bool { return a.Before(b) })
+		last := lo.MaxBy(starts, func(a, b time.Time) bool { return a.After(b) })
+		return last.Sub(first)
+	}
+
+	if spread := startSpread(m.WithStartStagger(100 * time.Millisecond)); spread < time.Millisecond {
+		t.Errorf("expect staggered starts, got a spread of %s", spread)
+	}
+	if spread := startSpread(m); spread > 50*time.Millisecond {
+		t.Errorf("expect the starts at once without stagger, got a spread of %s", spread)
+	}
+}
+
+func TestBulkMigratorWithDenyIndexes(t *testing.T) {
+	es := newFakeES(map[string][]*es2.Doc{
+		"a":                 newFakeDocs(1),
+		"b":                 newFakeDocs(1),
+		".security":         newFakeDocs(1),
+		".security-7":       newFakeDocs(1),
+		"audit-2024.01.01":  newFakeDocs(1),
+		"orders":            newFakeDocs(1),
+		"orders-restricted": newFakeDocs(1),
+	})
+
+	newBulkMigrator := NewBulkMigratorWithES(context.Background(), es, es).
+		WithIndexPairs(
+			&config.IndexPair{SourceIndex: ".security", TargetIndex: ".security"},
+			&config.IndexPair{SourceIndex: "orders", TargetIndex: "orders-restricted"},
+			&config.IndexPair{SourceIndex: "audit-*", TargetIndex: "{index}"}).
+		WithPatternIndexes("^[ab]$|^\\.security-7$").
+		WithDenyIndexes([]string{".security*", "audit-*", "orders-restricted"}).
+		getIndexPairsFromPattern()
+	if newBulkMigrator.Error != nil {
+		t.Fatalf("%+v", newBulkMigrator.Error)
+	}
+
+	keys := lo.Keys(newBulkMigrator.IndexPairMap)
+	sort.Strings(keys)
+	if !reflect.DeepEqual(keys, []string{"a:a", "b:b"}) {
+		t.Errorf("expect the denied index pairs removed, got %v", keys)
+	}
+}
+
+func TestBulkMigratorPauseResume(t *testing.T) {
+	// a doc over the 1MB action size flushes a bulk request of its own
+	docs := newFakeDocs(8)
+	for _, doc := range docs {
+		doc.Source["padding"] = strings.Repeat("x", 1024*1024)
+	}
+	sourceES := newFakeES(map[string][]*es2.Doc{"a": docs})
+	targetES := newFakeES(nil)
+	targetES.bulkDelay = 5 * time.Millisecond
+
+	m := NewBulkMigratorWithES(context.Background(), sourceES, targetES).
+		WithIndexPairs(&config.IndexPair{SourceIndex: "a", TargetIndex: "a"}).
+		WithScrollSize(2).
+		WithActionParallelism(1).
+		WithActionSize(1)
+	done := make(chan error, 1)
+	go func() {
+		done <- m.Sync(false)
+	}()
+
+	writtenCount := func() int {
+		targetES.mu.Lock()
+		defer targetES.mu.Unlock()
+		return len(targetES.written["a"])
+	}
+	for writtenCount() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	m.Pause()
+	if !m.IsPaused() {
+		t.Fatal("expect the migrator paused")
+	}
+
+	// the bulk request in flight when pausing still ends
+	time.Sleep(50 * time.Millisecond)
+	pausedCount := writtenCount()
+	time.Sleep(100 * time.Millisecond)
+	if count := writtenCount(); count != pausedCount || count == len(docs) {
+		t.Fatalf("expect no write while paused, written %d then %d of %d", pausedCount, count, len(docs))
+	}
+
+	m.Resume()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("expect the sync to finish after resume")
+	}
+	if count := writtenCount(); count != len(docs) {
+		t.Errorf("expect %d docs written after resume, got %d", len(docs), count)
+	}
+}
+
+func TestBulkMigratorWithIndexRetry(t *testing.T) {
+	for _, testCase := range []struct {
+		retry         uint
+		cleanup       config.IndexRetryCleanup
+		expectedErr   bool
+		expectedStale bool
+	}{
+		{0, "", true, true},
+		{1, config.IndexRetryCleanupKeep, false, true},
+		{1, config.IndexRetryCleanupRecreate, false, false},
+	} {
+		sourceES := newFakeES(map[string][]*es2.Doc{"a": newFakeDocs(5)})
+		sourceES.scrollFailures = map[string]int{"a": 1}
+		// a doc left in the target by an earlier attempt, which the source no longer has
+		targetES := newFakeES(nil)
+		targetES.written["a"] = map[string]*es2.Doc{"stale": {ID: "stale"}}
+
+		report, err := NewBulkMigratorWithES(context.Background(), sourceES, targetES).
+			WithIndexPairs(&config.IndexPair{SourceIndex: "a", TargetIndex: "a"}).
+			WithIndexRetry(testCase.retry).
+			WithIndexRetryCleanup(testCase.cleanup).
+			SyncWithStats(false)
+		if err != nil {
+			t.Fatalf("%+v", err)
+		}
+
+		stats := report.Indexes["a:a"]
+		if failed := report.Failed["a:a"] != nil; failed != testCase.expectedErr {
+			t.Errorf("retry %d %s: expect the index failed %v, got %+v", testCase.retry, testCase.cleanup,
+				testCase.expectedErr, report.Failed)
+		}
+		if testCase.expectedErr {
+			continue
+		}
+		if stats.DocsWritten != 5 {
+			t.Errorf("retry %d %s: expect the retry to write 5 docs, got %+v", testCase.retry, testCase.cleanup, stats)
+		}
+		_, stale := targetES.written["a"]["stale"]
+		if stale != testCase.expectedStale {
+			t.Errorf("retry %d %s: expect the stale doc kept %v, got %v", testCase.retry, testCase.cleanup,
+				testCase.expectedStale, stale)
+		}
+		if recreated := lo.Contains(targetES.deleted, "a"); recreated == testCase.expectedStale {
+			t.Errorf("retry %d %s: unexpected deleted indices %v", testCase.retry, testCase.cleanup, targetES.deleted)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package task
+
+import (
+	"encoding/json"
+	"github.com/CharellKing/ela-lib/utils"
+	"github.com/pkg/errors"
+	"os"
+	"sync"
+)
+
+// CompareCheckpoint keeps in a json file the diff results of the index pairs a compare finished, a restarted
+// compare skips them and merges their results. An index pair interrupted halfway resumes from its position.
+type CompareCheckpoint struct {
+	mutex    sync.Mutex
+	file     string
+	finished map[string]*DiffResult
+	// positions are how far the unfinished index pairs went
+	positions map[string]*ComparePosition
+	// verified are the ids the unfinished index pairs found on both indices so far, they are saved with the positions
+	verified map[string]*VerifiedIDs
+}
+
+// compareCheckpointFile is the content of the checkpoint file, a file of an older version is the finished map only
+type compareCheckpointFile struct {
+	Finished  map[string]*DiffResult      `json:"finished"`
+	Positions map[string]*ComparePosition `json:"positions,omitempty"`
+	Verified  map[string]*VerifiedIDs     `json:"verified,omitempty"`
+}
+
+// ComparePosition is how far the compare of an index pair went, both indices are read in `_id` order. The source
+// is read up to SourceAfter, then the target up to TargetAfter once SourceDone. Result has the counts and the
+// ids of the diffs found so far.
+type ComparePosition struct {
+	SourceAfter string      `json:"source_after,omitempty"`
+	SourceDone  bool        `json:"source_done,omitempty"`
+	TargetAfter string      `json:"target_after,omitempty"`
+	Result      *DiffResult `json:"result"`
+}
+
+func LoadCompareCheckpoint(file string) (*CompareCheckpoint, error) {
+	checkpoint := &CompareCheckpoint{
+		file:      file,
+		finished:  make(map[string]*DiffResult),
+		positions: make(map[string]*ComparePosition),
+		verified:  make(map[string]*VerifiedIDs),
+	}
+	if !utils.FileIsExisted(file) {
+		return checkpoint, nil
+	}
+
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	var checkpointFile compareCheckpointFile
+	if err := json.Unmarshal(content, &checkpointFile); err != nil {
+		return nil, errors.Wrapf(err, "checkpoint %s", file)
+	}
+	// the keys of the index pairs always have a colon, so an older file never has the finished key
+	if checkpointFile.Finished == nil {
+		if err := json.Unmarshal(content, &checkpoint.finished); err != nil {
+			return nil, errors.Wrapf(err, "checkpoint %s", file)
+		}
+		return checkpoint, nil
+	}
+	checkpoint.finished = checkpointFile.Finished
+	if checkpointFile.Positions != nil {
+		checkpoint.positions = checkpointFile.Positions
+	}
+	if checkpointFile.Verified != nil {
+		checkpoint.verified = checkpointFile.Verified
+	}
+	return checkpoint, nil
+}
+
+// Get returns a copy of the diff result of a finished index pair, a nil checkpoint has none
+func (checkpoint *CompareCheckpoint) Get(indexPairKey string) (*DiffResult, bool) {
+	if checkpoint == nil {
+		return nil, false
+	}
+
+	checkpoint.mutex.Lock()
+	defer checkpoint.mutex.Unlock()
+
+	finished, ok := checkpoint.finished[indexPairKey]
+	if !ok {
+		return nil, false
+	}
+	diffResult := &DiffResult{}
+	diffResult.Merge(finished)
+	return diffResult, true
+}
+
+// Save records the index pair as finished and rewrites the file, a nil checkpoint saves nothing
+func (checkpoint *CompareCheckpoint) Save(indexPairKey string, diffResult *DiffResult) error {
+	if checkpoint == nil {
+		return nil
+	}
+
+	checkpoint.mutex.Lock()
+	defer checkpoint.mutex.Unlock()
+
+	finished := &DiffResult{}
+	finished.Merge(diffResult)
+	checkpoint.finished[indexPairKey] = finished
+	delete(checkpoint.positions, indexPairKey)
+	delete(checkpoint.verified, indexPairKey)
+	return checkpoint.write()
+}
+
+// Position returns a copy of how far the unfinished index pair went, a nil checkpoint has none
+func (checkpoint *CompareCheckpoint) Position(indexPairKey string) (*ComparePosition, bool) {
+	if checkpoint == nil {
+		return nil, false
+	}
+
+	checkpoint.mutex.Lock()
+	defer checkpoint.mutex.Unlock()
+
+	position, ok := checkpoint.positions[indexPairKey]
+	if !ok {
+		return nil, false
+	}
+	return position.copy(), true
+}
+
+// SavePosition records how far the unfinished index pair went and rewrites the file, a nil checkpoint saves
+// nothing
+func (checkpoint *CompareCheckpoint) SavePosition(indexPairKey string, position *ComparePosition) error {
+	if checkpoint == nil {
+		return nil
+	}
+
+	checkpoint.mutex.Lock()
+	defer checkpoint.mutex.Unlock()
+
+	checkpoint.positions[indexPairKey] = position.copy()
+	return checkpoint.write()
+}
+
+// Verified returns the ids the unfinished index pair verified so far, a nil checkpoint has none
+func (checkpoint *CompareCheckpoint) Verified(indexPairKey string) *VerifiedIDs {
+	if checkpoint == nil {
+		return nil
+	}
+
+	checkpoint.mutex.Lock()
+	defer checkpoint.mutex.Unlock()
+
+	verified, ok := checkpoint.verified[indexPairKey]
+	if !ok {
+		verified = NewVerifiedIDs()
+		checkpoint.verified[indexPairKey] = verified
+	}
+	return verified
+}
+
+func (checkpoint *CompareCheckpoint) write() error {
+	content, err := json.Marshal(compareCheckpointFile{
+		Finished:  checkpoint.finished,
+		Positions: checkpoint.positions,
+		Verified:  checkpoint.verified,
+	})
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	// write aside then rename, an interruption never leaves a truncated checkpoint
+	tmpFile := checkpoint.file + ".tmp"
+	if err := os.WriteFile(tmpFile, content, 0644); err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(os.Rename(tmpFile, checkpoint.file))
+}
+
+func (position *ComparePosition) copy() *ComparePosition {
+	result := &DiffResult{}
+	if position.Result != nil {
+		result.Merge(position.Result)
+	}
+	return &ComparePosition{
+		SourceAfter: position.SourceAfter,
+		SourceDone:  position.SourceDone,
+		TargetAfter: position.TargetAfter,
+		Result:      result,
+	}
+}
@@ -0,0 +1,77 @@
+package task
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCompareCheckpointVerifiedIDs(t *testing.T) {
+	const count = 200000
+	checkpointFile := filepath.Join(t.TempDir(), "compare.json")
+	checkpoint, err := LoadCompareCheckpoint(checkpointFile)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	verified := checkpoint.Verified("a:a")
+	for i := 0; i < count; i++ {
+		verified.Add(fmt.Sprintf("doc-%020d", i))
+	}
+	checkpoint.Verified("b:b").Add("b")
+	if err := checkpoint.SavePosition("a:a", &ComparePosition{}); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	// b is finished, its verified ids are dropped
+	if err := checkpoint.Save("b:b", &DiffResult{}); err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	// the 24 bytes ids take less than 8 bytes each
+	info, err := os.Stat(checkpointFile)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if info.Size() > 8*count {
+		t.Errorf("expect a compact checkpoint, got %d bytes", info.Size())
+	}
+
+	checkpoint, err = LoadCompareCheckpoint(checkpointFile)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	verified = checkpoint.Verified("a:a")
+	if verified.Len() != count {
+		t.Fatalf("expect %d verified ids, got %d", count, verified.Len())
+	}
+	for i := 0; i < count; i += 97 {
+		if id := fmt.Sprintf("doc-%020d", i); !verified.Contains(id) {
+			t.Fatalf("expect %s verified", id)
+		}
+	}
+	if verified.Contains(fmt.Sprintf("doc-%020d", count)) {
+		t.Errorf("expect an id never added not verified")
+	}
+	if checkpoint.Verified("b:b").Len() != 0 {
+		t.Errorf("expect the verified ids of the finished b dropped")
+	}
+	if _, ok := checkpoint.Get("b:b"); !ok {
+		t.Errorf("expect b finished")
+	}
+}
+
+func TestLoadCompareCheckpointOfOlderVersion(t *testing.T) {
+	checkpointFile := filepath.Join(t.TempDir(), "compare.json")
+	if err := os.WriteFile(checkpointFile, []byte(`{"a:a":{"same_count":2}}`), 0644); err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	checkpoint, err := LoadCompareCheckpoint(checkpointFile)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if diffResult, ok := checkpoint.Get("a:a"); !ok || diffResult.SameCount.Load() != 2 {
+		t.Errorf("expect a finished with 2 same docs, got %v", diffResult)
+	}
+}
@@ -0,0 +1,112 @@
+package task
+
+import (
+	es2 "github.com/CharellKing/ela-lib/pkg/es"
+	"github.com/pkg/errors"
+	"github.com/spf13/cast"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// epochMillisDateLayout keeps the millis of an epoch date, es parses it as strict_date_optional_time
+const epochMillisDateLayout = "2006-01-02T15:04:05.000Z07:00"
+
+// CoercionFunc converts the value of a field into the type the target mapping expects, e.g. "123" into 123 for an
+// integer field. A failed doc is not written, it goes to the dead letter sink.
+type CoercionFunc func(value interface{}) (interface{}, error)
+
+// CoerceInteger converts a whole number or its text into an int64, "12.5" fails rather than losing the fraction
+func CoerceInteger(value interface{}) (interface{}, error) {
+	number, err := cast.ToFloat64E(strings.TrimSpace(cast.ToString(value)))
+	if err != nil {
+		return nil, errors.Errorf("%v is not a number", value)
+	}
+	if number != float64(int64(number)) {
+		return nil, errors.Errorf("%v is not an integer", value)
+	}
+	return int64(number), nil
+}
+
+// CoerceFloat converts a number or its text into a float64
+func CoerceFloat(value interface{}) (interface{}, error) {
+	number, err := cast.ToFloat64E(strings.TrimSpace(cast.ToString(value)))
+	if err != nil {
+		return nil, errors.Errorf("%v is not a number", value)
+	}
+	return number, nil
+}
+
+// CoerceBoolean converts true, false, 1, 0 and their texts into a bool
+func CoerceBoolean(value interface{}) (interface{}, error) {
+	boolean, err := strconv.ParseBool(strings.TrimSpace(cast.ToString(value)))
+	if err != nil {
+		return nil, errors.Errorf("%v is not a boolean", value)
+	}
+	return boolean, nil
+}
+
+// CoerceString converts a scalar into its text, e.g. the numeric ids of a keyword field
+func CoerceString(value interface{}) (interface{}, error) {
+	text, err := cast.ToStringE(value)
+	if err != nil {
+		return nil, errors.Errorf("%v is not a scalar", value)
+	}
+	return text, nil
+}
+
+// CoerceEpochMillisToDate converts epoch millis, a number or its text, into a UTC date with millis
+func CoerceEpochMillisToDate(value interface{}) (interface{}, error) {
+	millis, err := CoerceInteger(value)
+	if err != nil {
+		return nil, errors.Errorf("%v is not epoch millis", value)
+	}
+	return time.UnixMilli(millis.(int64)).UTC().Format(epochMillisDateLayout), nil
+}
+
+// coerceDoc returns a copy of doc whose fields are converted by coercions, the dotted paths reach into objects and
+// a missing or null field is left as it is. doc itself is not modified, the dead letter sink gets it on a failure.
+func coerceDoc(doc *es2.Doc, coercions map[string]CoercionFunc) (*es2.Doc, error) {
+	newDoc := *doc
+	for field, coercion := range coercions {
+		value, ok := getSourceValue(newDoc.Source, field)
+		if !ok || value == nil {
+			continue
+		}
+
+		newValue, err := coercion(value)
+		if err != nil {
+			return nil, errors.Wrapf(err, "coerce field %s of doc %s", field, doc.ID)
+		}
+		newDoc.Source = setSourceValue(newDoc.Source, strings.Split(field, "."), newValue)
+	}
+	return &newDoc, nil
+}
+
+func getSourceValue(source map[string]interface{}, field string) (interface{}, bool) {
+	var value interface{} = source
+	for _, key := range strings.Split(field, ".") {
+		valueMap, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if value, ok = valueMap[key]; !ok {
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// setSourceValue copies the maps on the path of keys before setting the value, the other fields are shared
+func setSourceValue(source map[string]interface{}, keys []string, value interface{}) map[string]interface{} {
+	newSource := make(map[string]interface{}, len(source))
+	for key, fieldValue := range source {
+		newSource[key] = fieldValue
+	}
+	if len(keys) == 1 {
+		newSource[keys[0]] = value
+		return newSource
+	}
+	newSource[keys[0]] = setSourceValue(cast.ToStringMap(source[keys[0]]), keys[1:], value)
+	return newSource
+}
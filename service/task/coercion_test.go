@@ -0,0 +1,108 @@
+package task
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/CharellKing/ela-lib/config"
+	es2 "github.com/CharellKing/ela-lib/pkg/es"
+	"github.com/spf13/cast"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMigratorWithFieldCoercions(t *testing.T) {
+	created := time.Date(2024, 3, 1, 12, 30, 0, 250*int(time.Millisecond), time.UTC)
+	sourceES := newFakeES(map[string][]*es2.Doc{"a": {
+		{ID: "1", Source: map[string]interface{}{"age": "123", "user": map[string]interface{}{"created": created.UnixMilli()}}},
+		{ID: "2", Source: map[string]interface{}{"age": " 45 ", "user": map[string]interface{}{"created": "1700000000000"}}},
+		{ID: "3", Source: map[string]interface{}{"age": "twelve", "user": map[string]interface{}{"created": created.UnixMilli()}}},
+		{ID: "4", Source: map[string]interface{}{"user": map[string]interface{}{"name": "d"}}},
+	}})
+	targetES := newFakeES(nil)
+
+	deadLetterFile := filepath.Join(t.TempDir(), "dead_letter.ndjson")
+	sink, err := NewFileDeadLetterSink(deadLetterFile)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	stats, err := NewMigrator(context.Background(), sourceES, targetES).
+		WithIndexPair(config.IndexPair{SourceIndex: "a", TargetIndex: "b"}).
+		WithActionParallelism(1).
+		WithDeadLetter(sink).
+		WithFieldCoercions(map[string]CoercionFunc{"age": CoerceInteger, "user.created": CoerceEpochMillisToDate}).
+		SyncWithStats(false)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if stats.DocsWritten != 3 || stats.DocsFailed != 1 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+
+	written := targetES.written["b"]
+	// the fake target round trips the source through json, the integers come back as float64
+	if written["1"].Source["age"] != float64(123) ||
+		cast.ToStringMap(written["1"].Source["user"])["created"] != "2024-03-01T12:30:00.250Z" {
+		t.Errorf("unexpected doc 1 %v", written["1"].Source)
+	}
+	if written["2"].Source["age"] != float64(45) ||
+		cast.ToStringMap(written["2"].Source["user"])["created"] != "2023-11-14T22:13:20.000Z" {
+		t.Errorf("unexpected doc 2 %v", written["2"].Source)
+	}
+	if _, ok := written["4"].Source["age"]; ok || cast.ToStringMap(written["4"].Source["user"])["name"] != "d" {
+		t.Errorf("expect doc 4 as it is, got %v", written["4"].Source)
+	}
+	// the source docs are not modified
+	if sourceES.docs["a"][0].Source["age"] != "123" {
+		t.Errorf("expect the source doc unchanged, got %v", sourceES.docs["a"][0].Source)
+	}
+
+	content, err := os.ReadFile(deadLetterFile)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	var deadLetter map[string]interface{}
+	if err := json.Unmarshal(content, &deadLetter); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if deadLetter["_index"] != "b" || deadLetter["_id"] != "3" ||
+		!strings.Contains(cast.ToString(deadLetter["error"]), "coerce field age of doc 3") {
+		t.Errorf("unexpected dead letter %v", deadLetter)
+	}
+}
+
+func TestCoercions(t *testing.T) {
+	for _, testCase := range []struct {
+		coercion CoercionFunc
+		value    interface{}
+		expected interface{}
+	}{
+		{CoerceInteger, "123", int64(123)},
+		{CoerceInteger, float64(7), int64(7)},
+		{CoerceInteger, "12.5", nil},
+		{CoerceFloat, "12.5", 12.5},
+		{CoerceBoolean, "true", true},
+		{CoerceBoolean, "0", false},
+		{CoerceBoolean, "yes", nil},
+		{CoerceString, float64(42), "42"},
+		{CoerceEpochMillisToDate, int64(0), "1970-01-01T00:00:00.000Z"},
+		{CoerceEpochMillisToDate, "2024-03-01", nil},
+	} {
+		value, err := testCase.coercion(testCase.value)
+		if testCase.expected == nil {
+			if err == nil {
+				t.Errorf("expect %v to fail, got %v", testCase.value, value)
+			}
+			continue
+		}
+		if err != nil || value != testCase.expected {
+			t.Errorf("expect %v to be %v, got %v %v", testCase.value, testCase.expected, value, err)
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package task
+
+import (
+	"context"
+	es2 "github.com/CharellKing/ela-lib/pkg/es"
+	"github.com/CharellKing/ela-lib/utils"
+	"github.com/pkg/errors"
+	"github.com/samber/lo"
+	"github.com/spf13/cast"
+	"time"
+)
+
+// comparePositionInterval is how often a resumable compare saves its position
+const comparePositionInterval = 10 * time.Second
+
+// withCompareCheckpoint makes the compare resumable, it records in checkpoint under indexPairKey how far it went
+func (m *Migrator) withCompareCheckpoint(checkpoint *CompareCheckpoint, indexPairKey string) *Migrator {
+	newMigrator := m.clone()
+	newMigrator.compareCheckpoint = checkpoint
+	newMigrator.compareCheckpointKey = indexPairKey
+	return newMigrator
+}
+
+// compareFromPosition compares the index pair from the position of the checkpoint, a scroll can't start partway
+// through an index. The source is read a page at a time in `_id` order and its docs are looked up on the target,
+// then the ids of the target are read the same way and looked up on the source to find the deletes. The ids found
+// on both are verified, the target ids are only looked up on the source when they are not. The diffs found before
+// an interruption are passed to callback again.
+func (m *Migrator) compareFromPosition(ctx context.Context, callback func(diff DocDiff)) (*DiffResult, error) {
+	position, ok := m.compareCheckpoint.Position(m.compareCheckpointKey)
+	if ok {
+		utils.GetLogger(ctx).Infof("resume compare after source %q, target %q, %s",
+			position.SourceAfter, position.TargetAfter, position.Result.toStr())
+	} else {
+		position = &ComparePosition{Result: &DiffResult{}}
+	}
+
+	for _, id := range position.Result.CreateDocs {
+		callback(DocDiff{ID: id, Type: DiffTypeCreate})
+	}
+	for _, id := range position.Result.UpdateDocs {
+		callback(DocDiff{ID: id, Type: DiffTypeUpdate})
+	}
+	for _, id := range position.Result.DeleteDocs {
+		callback(DocDiff{ID: id, Type: DiffTypeDelete})
+	}
+
+	verified := m.compareCheckpoint.Verified(m.compareCheckpointKey)
+	emit := func(diff DocDiff) {
+		position.Result.count(diff.Type)
+		position.Result.appendDoc(diff)
+		callback(diff)
+	}
+
+	lastSaveTime := time.Now()
+	save := func(force bool) {
+		if !force && time.Since(lastSaveTime) < comparePositionInterval {
+			return
+		}
+		if err := m.compareCheckpoint.SavePosition(m.compareCheckpointKey, position); err != nil {
+			utils.GetLogger(ctx).Errorf("save compare position %+v", err)
+		}
+		lastSaveTime = time.Now()
+	}
+	// an interrupted compare saves where it stopped
+	fail := func(err error) (*DiffResult, error) {
+		save(true)
+		return position.Result.counts(), errors.WithStack(err)
+	}
+
+	for !position.SourceDone {
+		m.pause.wait(ctx)
+		sourceDocs, err := m.searchAfter(ctx, m.SourceES, m.IndexPair.SourceIndex, position.SourceAfter, true)
+		if err != nil {
+			return fail(err)
+		}
+		if len(sourceDocs) <= 0 {
+			position.SourceDone = true
+			save(true)
+			break
+		}
+
+		targetDocs, err := m.lookupDocs(ctx, m.TargetES, m.IndexPair.TargetIndex, sourceDocs, true)
+		if err != nil {
+			return fail(err)
+		}
+		for _, sourceDoc := range sourceDocs {
+			targetDoc, ok := targetDocs[sourceDoc.ID]
+			switch {
+			case !ok:
+				emit(DocDiff{ID: sourceDoc.ID, Type: DiffTypeCreate})
+				continue
+			case targetDoc.Hash != sourceDoc.Hash:
+				emit(DocDiff{ID: sourceDoc.ID, Type: DiffTypeUpdate})
+			default:
+				position.Result.SameCount.Add(1)
+			}
+			verified.Add(sourceDoc.ID)
+		}
+		position.SourceAfter = sourceDocs[len(sourceDocs)-1].ID
+		save(false)
+	}
+
+	for {
+		m.pause.wait(ctx)
+		targetDocs, err := m.searchAfter(ctx, m.TargetES, m.IndexPair.TargetIndex, position.TargetAfter, false)
+		if err != nil {
+			return fail(err)
+		}
+		if len(targetDocs) <= 0 {
+			break
+		}
+
+		position.TargetAfter = targetDocs[len(targetDocs)-1].ID
+		targetDocs = lo.Filter(targetDocs, func(targetDoc *es2.Doc, _ int) bool {
+			return !verified.Contains(targetDoc.ID)
+		})
+		if len(targetDocs) <= 0 {
+			save(false)
+			continue
+		}
+
+		sourceDocs, err := m.lookupDocs(ctx, m.SourceES, m.IndexPair.SourceIndex, targetDocs, false)
+		if err != nil {
+			return fail(err)
+		}
+		for _, targetDoc := range targetDocs {
+			if _, ok := sourceDocs[targetDoc.ID]; !ok {
+				emit(DocDiff{ID: targetDoc.ID, Type: DiffTypeDelete})
+			}
+		}
+		save(false)
+	}
+	return position.Result.counts(), nil
+}
+
+// searchAfter reads the page of the docs of the index after the id in `_id` order, es 8 only sorts on `_id` with
+// indices.id_field_data.enabled
+func (m *Migrator) searchAfter(ctx context.Context, es es2.ES, index string, afterId string, withSource bool) ([]*es2.Doc, error) {
+	body := map[string]interface{}{
+		"size": m.ScrollSize,
+		"sort": []interface{}{map[string]interface{}{"_id": "asc"}},
+	}
+	if query := getQueryMap(m.Ids); query != nil {
+		body["query"] = query["query"]
+	}
+	if afterId != "" {
+		body["search_after"] = []interface{}{afterId}
+	}
+	return m.searchDocs(ctx, es, index, body, withSource)
+}
+
+// lookupDocs reads the docs of the index with the ids of docs
+func (m *Migrator) lookupDocs(ctx context.Context, es es2.ES, index string, docs []*es2.Doc, withSource bool) (map[string]*es2.Doc, error) {
+	body := getQueryMap(lo.Map(docs, func(doc *es2.Doc, _ int) string {
+		return doc.ID
+	}))
+	body["size"] = len(docs)
+	foundDocs, err := m.searchDocs(ctx, es, index, body, withSource)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return lo.KeyBy(foundDocs, func(doc *es2.Doc) string {
+		return doc.ID
+	}), nil
+}
+
+// searchDocs runs a single search and returns its hits, the docs read with their source are fixed and hashed like
+// the scrolled ones
+func (m *Migrator) searchDocs(ctx context.Context, es es2.ES, index string, body map[string]interface{}, withSource bool) ([]*es2.Doc, error) {
+	if !withSource {
+		body["_source"] = false
+	}
+	result, err := es.SearchByQuery(ctx, index, body)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	hits, _ := utils.GetValueFromMapByPath(result, "hits.hits")
+	var docs []*es2.Doc
+	for _, hit := range cast.ToSlice(hits) {
+		hitMap := cast.ToStringMap(hit)
+		doc := &es2.Doc{
+			Type:    cast.ToString(hitMap["_type"]),
+			ID:      cast.ToString(hitMap["_id"]),
+			Routing: cast.ToString(hitMap["_routing"]),
+			Source:  cast.ToStringMap(hitMap["_source"]),
+		}
+		if withSource {
+			if doc, err = es2.FixDoc(ctx, doc); err != nil {
+				return nil, errors.WithStack(err)
+			}
+			doc.Hash = m.getDocHash(doc)
+		}
+		docs = append(docs, doc)
+	}
+	return docs, nil
+}
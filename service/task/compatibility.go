@@ -0,0 +1,38 @@
+package task
+
+import (
+	"fmt"
+	"github.com/spf13/cast"
+	"strings"
+)
+
+type versionJump struct {
+	SourceMajor int
+	TargetMajor int
+}
+
+// compatibilityMatrix lists the version jumps with known pitfalls and the recommended path, a jump that is
+// not listed is supported.
+var compatibilityMatrix = map[versionJump]string{
+	{SourceMajor: 5, TargetMajor: 7}: "migrate 5.x to 6.8 first, then 6.8 to 7.x",
+	{SourceMajor: 5, TargetMajor: 8}: "migrate 5.x to 6.8, then 6.8 to 7.17, then 7.17 to 8.x",
+	{SourceMajor: 6, TargetMajor: 8}: "migrate 6.x to 7.17 first, then 7.17 to 8.x",
+	{SourceMajor: 6, TargetMajor: 5}: "downgrade is not supported, the mappings of 6.x may be rejected by 5.x",
+	{SourceMajor: 7, TargetMajor: 5}: "downgrade is not supported, the typeless mappings of 7.x are rejected by 5.x",
+	{SourceMajor: 8, TargetMajor: 5}: "downgrade is not supported, the typeless mappings of 8.x are rejected by 5.x",
+	{SourceMajor: 8, TargetMajor: 6}: "downgrade is not supported, migrate 8.x to 7.17 first",
+}
+
+func getMajorVersion(version string) int {
+	return cast.ToInt(strings.Split(version, ".")[0])
+}
+
+// checkCompatibility returns the issue of migrating sourceVersion to targetVersion, empty when it is supported.
+func checkCompatibility(sourceVersion string, targetVersion string) string {
+	jump := versionJump{SourceMajor: getMajorVersion(sourceVersion), TargetMajor: getMajorVersion(targetVersion)}
+	recommendation, ok := compatibilityMatrix[jump]
+	if !ok {
+		return ""
+	}
+	return fmt.Sprintf("migrating %s to %s is not supported directly: %s", sourceVersion, targetVersion, recommendation)
+}
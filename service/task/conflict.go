@@ -0,0 +1,121 @@
+package task
+
+import (
+	"cmp"
+	"context"
+	es2 "github.com/CharellKing/ela-lib/pkg/es"
+	"github.com/CharellKing/ela-lib/utils"
+	"github.com/pkg/errors"
+	"github.com/samber/lo"
+	"github.com/spf13/cast"
+)
+
+// skipNewerTargetDocs reads the target version of every ScrollSize docs and drops the source docs
+// whose target timestamp is newer. A batch whose target lookup fails is dropped rather than risk
+// overwriting newer data.
+func (m *Migrator) skipNewerTargetDocs(ctx context.Context, docCh chan *es2.Doc, errCh chan error) chan *es2.Doc {
+	outCh := make(chan *es2.Doc, m.BufferCount)
+
+	utils.GoRecovery(m.GetCtx(), func() {
+		defer close(outCh)
+
+		var skipCount int
+		batch := make([]*es2.Doc, 0, m.ScrollSize)
+		flush := func() {
+			if len(batch) <= 0 {
+				return
+			}
+			defer func() {
+				batch = batch[:0]
+			}()
+
+			targetDocs, err := m.getDocsByIds(ctx, m.TargetES, m.IndexPair.TargetIndex,
+				lo.Map(batch, func(doc *es2.Doc, _ int) string { return doc.ID }))
+			if err != nil {
+				errCh <- errors.WithStack(err)
+				return
+			}
+
+			for _, doc := range batch {
+				if targetDoc, ok := targetDocs[doc.ID]; ok && m.isTargetNewer(doc, targetDoc) {
+					skipCount++
+					continue
+				}
+				outCh <- doc
+			}
+		}
+
+		for doc := range docCh {
+			batch = append(batch, doc)
+			if uint(len(batch)) >= m.ScrollSize {
+				flush()
+			}
+		}
+		flush()
+
+		utils.GetLogger(m.GetCtx()).Infof("skip %d docs whose target is newer", skipCount)
+	})
+
+	return outCh
+}
+
+func (m *Migrator) getDocsByIds(ctx context.Context, es es2.ES, index string, ids []string) (map[string]*es2.Doc, error) {
+	docs := make(map[string]*es2.Doc)
+
+	scrollResult, err := es.NewScroll(ctx, index, &es2.ScrollOption{
+		Query:      getQueryMap(ids),
+		ScrollSize: cast.ToUint(len(ids)),
+		ScrollTime: m.ScrollTime,
+	})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	defer func() {
+		if err := es.ClearScroll(scrollResult.ScrollId); err != nil {
+			utils.GetLogger(ctx).Errorf("clear scroll %+v", err)
+		}
+	}()
+
+	for len(scrollResult.Docs) > 0 {
+		for _, doc := range scrollResult.Docs {
+			docs[doc.ID] = doc
+		}
+
+		if scrollResult, err = es.NextScroll(ctx, scrollResult.ScrollId, m.ScrollTime); err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+	return docs, nil
+}
+
+func (m *Migrator) isTargetNewer(sourceDoc *es2.Doc, targetDoc *es2.Doc) bool {
+	targetValue, ok := utils.GetValueFromMapByPath(targetDoc.Source, m.TimestampField)
+	if !ok {
+		return false
+	}
+
+	sourceValue, ok := utils.GetValueFromMapByPath(sourceDoc.Source, m.TimestampField)
+	if !ok {
+		return true
+	}
+
+	return compareTimestamp(targetValue, sourceValue) > 0
+}
+
+// compareTimestamp compares epoch numbers numerically and date strings as time, falling back to string order.
+func compareTimestamp(a, b interface{}) int {
+	aNumber, aErr := cast.ToFloat64E(a)
+	bNumber, bErr := cast.ToFloat64E(b)
+	if aErr == nil && bErr == nil {
+		return cmp.Compare(aNumber, bNumber)
+	}
+
+	aTime, aErr := cast.ToTimeE(a)
+	bTime, bErr := cast.ToTimeE(b)
+	if aErr == nil && bErr == nil {
+		return aTime.Compare(bTime)
+	}
+
+	return cmp.Compare(cast.ToString(a), cast.ToString(b))
+}
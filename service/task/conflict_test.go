@@ -0,0 +1,91 @@
+package task
+
+import (
+	"context"
+	"github.com/CharellKing/ela-lib/config"
+	es2 "github.com/CharellKing/ela-lib/pkg/es"
+	"github.com/CharellKing/ela-lib/utils"
+	"testing"
+)
+
+func newConflictES() (*fakeES, *fakeES) {
+	sourceES := newFakeES(map[string][]*es2.Doc{
+		"idx": {
+			{ID: "1", Source: map[string]interface{}{"v": "source", "updated_at": "2024-01-02T00:00:00Z"}},
+			{ID: "2", Source: map[string]interface{}{"v": "source", "updated_at": "2024-01-02T00:00:00Z"}},
+			{ID: "3", Source: map[string]interface{}{"v": "source", "updated_at": "2024-01-02T00:00:00Z"}},
+		},
+	})
+	targetES := newFakeES(map[string][]*es2.Doc{
+		"idx": {
+			{ID: "1", Source: map[string]interface{}{"v": "target", "updated_at": "2024-01-01T00:00:00Z"}},
+			{ID: "2", Source: map[string]interface{}{"v": "target", "updated_at": "2024-01-03T00:00:00Z"}},
+		},
+	})
+	return sourceES, targetES
+}
+
+func targetValues(t *testing.T, es *fakeES) map[string]string {
+	values := make(map[string]string)
+	for _, doc := range es.docs["idx"] {
+		values[doc.ID] = doc.Source["v"].(string)
+	}
+	if len(values) != 3 {
+		t.Fatalf("expected 3 target docs, got %+v", values)
+	}
+	return values
+}
+
+func TestMigratorConflictPolicy(t *testing.T) {
+	cases := []struct {
+		policy   config.ConflictPolicy
+		expected map[string]string
+	}{
+		{config.ConflictPolicyOverwrite, map[string]string{"1": "source", "2": "source", "3": "source"}},
+		{config.ConflictPolicySkipExisting, map[string]string{"1": "target", "2": "target", "3": "source"}},
+		{config.ConflictPolicyNewerWins, map[string]string{"1": "source", "2": "target", "3": "source"}},
+	}
+
+	for _, c := range cases {
+		sourceES, targetES := newConflictES()
+		err := NewMigrator(context.Background(), sourceES, targetES).
+			WithIndexPair(config.IndexPair{SourceIndex: "idx", TargetIndex: "idx"}).
+			WithSliceSize(1).
+			WithScrollSize(2).
+			WithConflictPolicy(c.policy, "updated_at").
+			Sync(false)
+		if err != nil {
+			t.Fatalf("%s sync %+v", c.policy, err)
+		}
+
+		values := targetValues(t, targetES)
+		for id, expected := range c.expected {
+			if values[id] != expected {
+				t.Errorf("%s doc %s expected %s, got %s", c.policy, id, expected, values[id])
+			}
+		}
+	}
+}
+
+func TestMigratorConflictPolicyRequiresTimestampField(t *testing.T) {
+	sourceES, targetES := newConflictES()
+	err := NewMigrator(context.Background(), sourceES, targetES).
+		WithIndexPair(config.IndexPair{SourceIndex: "idx", TargetIndex: "idx"}).
+		WithConflictPolicy(config.ConflictPolicyNewerWins, "").
+		Sync(false)
+	if !utils.IsCustomError(err, utils.InvalidParams) {
+		t.Fatalf("expected invalid params error, got %+v", err)
+	}
+}
+
+func TestCompareTimestamp(t *testing.T) {
+	if compareTimestamp(1700000000001.0, 1700000000000.0) <= 0 {
+		t.Errorf("expected larger epoch to be newer")
+	}
+	if compareTimestamp("2024-01-02T00:00:00Z", "2024-01-01T23:00:00+02:00") <= 0 {
+		t.Errorf("expected time zones to be honored")
+	}
+	if compareTimestamp("b", "a") <= 0 {
+		t.Errorf("expected string fallback")
+	}
+}
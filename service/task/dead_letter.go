@@ -0,0 +1,54 @@
+package task
+
+import (
+	"encoding/json"
+	es2 "github.com/CharellKing/ela-lib/pkg/es"
+	"github.com/pkg/errors"
+	"os"
+	"sync"
+)
+
+// DeadLetterSink receives the docs es rejected in a bulk request, e.g. on a mapping conflict, and the docs whose
+// fields failed to coerce, so they can be inspected and fixed after the migration. The bulk workers write to it
+// concurrently.
+type DeadLetterSink interface {
+	Write(index string, doc *es2.Doc, reason error) error
+}
+
+// FileDeadLetterSink appends the rejected docs to a NDJSON file, one line per doc with the failure reason
+type FileDeadLetterSink struct {
+	mutex sync.Mutex
+	file  *os.File
+}
+
+func NewFileDeadLetterSink(filePath string) (*FileDeadLetterSink, error) {
+	file, err := os.OpenFile(filePath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return &FileDeadLetterSink{file: file}, nil
+}
+
+func (sink *FileDeadLetterSink) Write(index string, doc *es2.Doc, reason error) error {
+	line, err := json.Marshal(map[string]interface{}{
+		"_index":  index,
+		"_type":   doc.Type,
+		"_id":     doc.ID,
+		"_source": doc.Source,
+		"error":   reason.Error(),
+	})
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	sink.mutex.Lock()
+	defer sink.mutex.Unlock()
+	if _, err := sink.file.Write(append(line, '\n')); err != nil {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+func (sink *FileDeadLetterSink) Close() error {
+	return errors.WithStack(sink.file.Close())
+}
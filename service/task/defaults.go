@@ -0,0 +1,44 @@
+package task
+
+// MigratorDefaults sets the tuning values of a bulk migrator at once, a 0 field keeps the built-in default.
+type MigratorDefaults struct {
+	Parallelism       uint
+	ScrollSize        uint
+	ScrollTime        uint
+	SliceSize         uint
+	BufferCount       uint
+	ActionSize        uint // MB
+	ActionParallelism uint
+}
+
+func DefaultMigratorDefaults() MigratorDefaults {
+	return MigratorDefaults{
+		Parallelism:       defaultParallelism,
+		ScrollSize:        defaultScrollSize,
+		ScrollTime:        defaultScrollTime,
+		SliceSize:         defaultSliceSize,
+		BufferCount:       defaultBufferCount,
+		ActionSize:        defaultActionSize,
+		ActionParallelism: defaultActionParallelism,
+	}
+}
+
+func (d MigratorDefaults) withFallback() MigratorDefaults {
+	builtIn := DefaultMigratorDefaults()
+	fallback := func(value uint, defaultValue uint) uint {
+		if value == 0 {
+			return defaultValue
+		}
+		return value
+	}
+
+	return MigratorDefaults{
+		Parallelism:       fallback(d.Parallelism, builtIn.Parallelism),
+		ScrollSize:        fallback(d.ScrollSize, builtIn.ScrollSize),
+		ScrollTime:        fallback(d.ScrollTime, builtIn.ScrollTime),
+		SliceSize:         fallback(d.SliceSize, builtIn.SliceSize),
+		BufferCount:       fallback(d.BufferCount, builtIn.BufferCount),
+		ActionSize:        fallback(d.ActionSize, builtIn.ActionSize),
+		ActionParallelism: fallback(d.ActionParallelism, builtIn.ActionParallelism),
+	}
+}
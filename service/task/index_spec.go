@@ -0,0 +1,118 @@
+package task
+
+import (
+	"bufio"
+	"github.com/CharellKing/ela-lib/config"
+	"github.com/CharellKing/ela-lib/utils"
+	"github.com/pkg/errors"
+	"github.com/samber/lo"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// targetIndexPlaceholder in a target index is replaced with every expanded source index, e.g. `{index}-v2`
+const targetIndexPlaceholder = "{index}"
+
+func isMultiIndexSpec(index string) bool {
+	return strings.ContainsAny(index, ",*")
+}
+
+// wildcardToRegexp converts an es wildcard like `logs-*` into an anchored regexp.
+func wildcardToRegexp(wildcard string) (*regexp.Regexp, error) {
+	pattern := strings.ReplaceAll(regexp.QuoteMeta(wildcard), `\*`, ".*")
+	re, err := regexp.Compile("^" + pattern + "$")
+	return re, errors.WithStack(err)
+}
+
+// expandIndexSpec resolves a comma separated list of indices and wildcards against indexes. Like es, a wildcard
+// only matches the dot prefixed indices when it starts with a dot.
+func expandIndexSpec(spec string, indexes []string) ([]string, error) {
+	var expanded []string
+	for _, item := range splitIndexSpec(spec) {
+		if !strings.Contains(item, "*") {
+			expanded = append(expanded, item)
+			continue
+		}
+
+		re, err := wildcardToRegexp(item)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		for _, index := range indexes {
+			if strings.HasPrefix(index, ".") && !strings.HasPrefix(item, ".") {
+				continue
+			}
+			if re.MatchString(index) {
+				expanded = append(expanded, index)
+			}
+		}
+	}
+	return lo.Uniq(expanded), nil
+}
+
+func splitIndexSpec(spec string) []string {
+	return lo.FilterMap(strings.Split(spec, ","), func(item string, _ int) (string, bool) {
+		item = strings.TrimSpace(item)
+		return item, item != ""
+	})
+}
+
+// expandIndexPair maps every source index of a multi index spec to its target. A plain target shared by
+// several sources merges them, which is rejected unless the pair allows merging.
+func expandIndexPair(indexPair *config.IndexPair, indexes []string) ([]*config.IndexPair, error) {
+	sourceIndexes, err := expandIndexSpec(indexPair.SourceIndex, indexes)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if len(sourceIndexes) == 0 {
+		return nil, utils.NewCustomError(utils.NonIndexExisted, "no source index matches %s", indexPair.SourceIndex)
+	}
+
+	isTemplate := strings.Contains(indexPair.TargetIndex, targetIndexPlaceholder)
+	if len(sourceIndexes) > 1 && !isTemplate && indexPair.TargetIndex != "" && !indexPair.Merge {
+		return nil, utils.NewCustomError(utils.InvalidParams, "%s expands to %d indices which all map to %s, set merge to allow it",
+			indexPair.SourceIndex, len(sourceIndexes), indexPair.TargetIndex)
+	}
+
+	return lo.Map(sourceIndexes, func(sourceIndex string, _ int) *config.IndexPair {
+		targetIndex := indexPair.TargetIndex
+		if targetIndex == "" {
+			targetIndex = sourceIndex
+		} else if isTemplate {
+			targetIndex = strings.ReplaceAll(targetIndex, targetIndexPlaceholder, sourceIndex)
+		}
+		return &config.IndexPair{
+			SourceIndex: sourceIndex,
+			TargetIndex: targetIndex,
+			Merge:       indexPair.Merge,
+			SwapAlias:   strings.ReplaceAll(indexPair.SwapAlias, targetIndexPlaceholder, sourceIndex),
+		}
+	}), nil
+}
+
+// parseIndexList reads an index per line, `source=target` renames it on the target. Blank lines and the lines
+// starting with `#` are skipped.
+func parseIndexList(reader io.Reader) ([]*config.IndexPair, error) {
+	var indexPairs []*config.IndexPair
+	scanner := bufio.NewScanner(reader)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		sourceIndex, targetIndex, found := strings.Cut(line, "=")
+		sourceIndex, targetIndex = strings.TrimSpace(sourceIndex), strings.TrimSpace(targetIndex)
+		if !found {
+			targetIndex = sourceIndex
+		}
+		if sourceIndex == "" || targetIndex == "" {
+			return nil, utils.NewCustomError(utils.InvalidParams, "line %d %q is not an index or a source=target pair", lineNo, line)
+		}
+
+		indexPairs = append(indexPairs, &config.IndexPair{SourceIndex: sourceIndex, TargetIndex: targetIndex})
+	}
+	return indexPairs, errors.WithStack(scanner.Err())
+}
@@ -0,0 +1,199 @@
+package task
+
+import (
+	"context"
+	es2 "github.com/CharellKing/ela-lib/pkg/es"
+	"github.com/CharellKing/ela-lib/utils"
+	"github.com/pkg/errors"
+	"github.com/samber/lo"
+	"github.com/spf13/cast"
+	"sort"
+	"strings"
+)
+
+// SyncMapping pushes the fields the source index has and the target lacks without touching the docs. ES only
+// accepts additive mapping changes, a field whose type differs fails with BreakingMappingChange unless force,
+// which skips those fields and pushes the rest. A missing target is created from the source settings.
+func (m *Migrator) SyncMapping(force bool) error {
+	if m.err != nil {
+		return errors.WithStack(m.err)
+	}
+
+	ctx, err := m.buildIndexPairContext()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	existed, err := m.TargetES.IndexExisted(m.IndexPair.TargetIndex)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if !existed {
+		return errors.WithStack(m.copyIndexSettings(ctx, m.IndexPair.TargetIndex, false))
+	}
+
+	sourceMapping, err := m.SourceES.GetIndexMapping(m.IndexPair.SourceIndex)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	targetMapping, err := m.TargetES.GetIndexMapping(m.IndexPair.TargetIndex)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	delta, conflicts := mappingDelta("", mappingProperties(sourceMapping, m.IndexPair.SourceIndex),
+		mappingProperties(targetMapping, m.IndexPair.TargetIndex))
+	if len(conflicts) > 0 {
+		if !force {
+			return utils.NewCustomError(utils.BreakingMappingChange, "%s -> %s changes the type of %s",
+				m.IndexPair.SourceIndex, m.IndexPair.TargetIndex, strings.Join(conflicts, ", "))
+		}
+		utils.GetLogger(ctx).Warnf("skip the breaking mapping changes of %s", strings.Join(conflicts, ", "))
+	}
+
+	if len(delta) == 0 {
+		utils.GetLogger(ctx).Info("mapping is up to date")
+		return nil
+	}
+
+	if err := m.TargetES.PutMapping(m.IndexPair.TargetIndex, delta); err != nil {
+		return errors.WithStack(err)
+	}
+
+	utils.GetLogger(ctx).Infof("put %d mapping fields", len(delta))
+	return nil
+}
+
+// mappingProperties unwraps the field properties of a get mapping response, the properties of every type are
+// merged for the typed mappings before 7.x.
+func mappingProperties(indexMapping map[string]interface{}, index string) map[string]interface{} {
+	mappings := cast.ToStringMap(cast.ToStringMap(indexMapping[index])["mappings"])
+	if properties, ok := mappings["properties"]; ok {
+		return cast.ToStringMap(properties)
+	}
+
+	properties := make(map[string]interface{})
+	for _, typeMapping := range mappings {
+		properties = lo.Assign(properties, cast.ToStringMap(cast.ToStringMap(typeMapping)["properties"]))
+	}
+	return properties
+}
+
+// mappingDelta returns the source properties missing from the target and the paths of the fields whose type
+// differs. Objects and multi fields are compared recursively.
+func mappingDelta(prefix string, source map[string]interface{}, target map[string]interface{}) (map[string]interface{}, []string) {
+	delta := make(map[string]interface{})
+	var conflicts []string
+
+	for name, sourceField := range source {
+		path := prefix + name
+		targetField, ok := target[name]
+		if !ok {
+			delta[name] = sourceField
+			continue
+		}
+
+		sourceAttrs := cast.ToStringMap(sourceField)
+		targetAttrs := cast.ToStringMap(targetField)
+		if fieldType(sourceAttrs) != fieldType(targetAttrs) {
+			conflicts = append(conflicts, path)
+			continue
+		}
+
+		changed := false
+		fieldDelta := lo.Assign(sourceAttrs)
+		for _, key := range []string{"properties", "fields"} {
+			if _, ok := sourceAttrs[key]; !ok {
+				continue
+			}
+
+			subDelta, subConflicts := mappingDelta(path+".", cast.ToStringMap(sourceAttrs[key]),
+				cast.ToStringMap(targetAttrs[key]))
+			conflicts = append(conflicts, subConflicts...)
+			changed = changed || len(subDelta) > 0
+			// es merges the object properties, while new multi fields need the whole field definition
+			if key == "properties" {
+				fieldDelta[key] = subDelta
+			}
+		}
+
+		if changed {
+			delta[name] = fieldDelta
+		}
+	}
+
+	sort.Strings(conflicts)
+	return delta, conflicts
+}
+
+func fieldType(attrs map[string]interface{}) string {
+	if fieldType := cast.ToString(attrs["type"]); fieldType != "" {
+		return fieldType
+	}
+	return "object"
+}
+
+// checkSpecialFields looks for the fields a plain copy may break. A join field needs the source ids and routing, the
+// sync fails with auto generated ids and keeps the routing of every doc. A percolator query is parsed again when the
+// target indexes it, so a query the target version rejects fails its doc.
+func (m *Migrator) checkSpecialFields(ctx context.Context) error {
+	settings, ok := utils.GetCtxKeySourceIndexSetting(ctx).(es2.IESSettings)
+	if !ok || settings == nil {
+		return nil
+	}
+	properties := settings.GetFieldMap()
+
+	m.joinFields = fieldsOfType("", properties, "join")
+	if len(m.joinFields) > 0 {
+		if m.AutoGenerateIds {
+			return utils.NewCustomError(utils.InvalidParams, "join fields %s refer to the source doc ids, they can't be auto generated",
+				strings.Join(m.joinFields, ", "))
+		}
+		utils.GetLogger(ctx).Warnf("join fields %s, the docs keep their source routing and a child without one is routed to its parent",
+			strings.Join(m.joinFields, ", "))
+	}
+
+	if percolatorFields := fieldsOfType("", properties, "percolator"); len(percolatorFields) > 0 {
+		utils.GetLogger(ctx).Warnf("percolator fields %s, the target registers the queries again and fails the docs whose query it rejects",
+			strings.Join(percolatorFields, ", "))
+	}
+	return nil
+}
+
+// fieldsOfType returns the sorted paths of the fields of wantedType, objects and multi fields are searched recursively
+func fieldsOfType(prefix string, properties map[string]interface{}, wantedType string) []string {
+	var paths []string
+	for name, field := range properties {
+		attrs := cast.ToStringMap(field)
+		if fieldType(attrs) == wantedType {
+			paths = append(paths, prefix+name)
+		}
+		for _, key := range []string{"properties", "fields"} {
+			paths = append(paths, fieldsOfType(prefix+name+".", cast.ToStringMap(attrs[key]), wantedType)...)
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// keepJoinRouting routes a doc of a join index like its source doc, a child without routing to its parent. es
+// rejects a child without routing, and a child on another shard than its parent is never joined.
+func keepJoinRouting(doc *es2.Doc, routing string, joinFields []string) {
+	if doc.Routing != "" {
+		return
+	}
+	if routing != "" {
+		doc.Routing = routing
+		return
+	}
+
+	source := lo.Ternary(doc.Source != nil, doc.Source, doc.Upsert)
+	for _, field := range joinFields {
+		value, _ := utils.GetValueFromMapByPath(source, field)
+		if parent := cast.ToString(cast.ToStringMap(value)["parent"]); parent != "" {
+			doc.Routing = parent
+			return
+		}
+	}
+}
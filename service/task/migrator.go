@@ -9,12 +9,14 @@ import (
 	"github.com/CharellKing/ela-lib/config"
 	es2 "github.com/CharellKing/ela-lib/pkg/es"
 	"github.com/CharellKing/ela-lib/utils"
+	"github.com/alitto/pond"
 	"github.com/bytedance/gopkg/collection/skipmap"
 	"github.com/pkg/errors"
 	"github.com/samber/lo"
 	lop "github.com/samber/lo/parallel"
 	"github.com/spf13/cast"
 	"hash/fnv"
+	"io"
 	"os"
 	"regexp"
 	"strings"
@@ -34,6 +36,10 @@ const defaultSliceSize = 10
 const defaultBufferCount = 10000
 const defaultActionSize = 10 // MB
 const defaultActionParallelism = 20
+const maxAutoSliceSize = 32
+
+// maxTermsCount is the default index.max_terms_count, the most ids a terms query may have on es 7 and later
+const maxTermsCount = 65536
 
 type Migrator struct {
 	err error
@@ -64,6 +70,99 @@ type Migrator struct {
 	FileDir string
 
 	Ids []string
+
+	MaxDocs uint
+
+	AutoSlice bool
+
+	// ReadParallel bounds the slices scrolled at once, 0 scrolls all of them at once
+	ReadParallel uint
+
+	// MaxOpenScrolls bounds the scrolls open on the source at once, scrollSlots may be shared with other migrators
+	MaxOpenScrolls uint
+	scrollSlots    chan struct{}
+
+	// SortFields sorts the scrolls of the source, e.g. "timestamp:asc", the order holds within every slice
+	SortFields []string
+
+	BulkDedup bool
+
+	ConflictPolicy config.ConflictPolicy
+
+	TimestampField string
+
+	TargetType string
+
+	// MultiTypePolicy decides how the target of a multi-type source is created, it fails by default
+	MultiTypePolicy config.MultiTypePolicy
+
+	// ClosedIndexPolicy decides how a closed source index is synced, it fails by default
+	ClosedIndexPolicy config.ClosedIndexPolicy
+
+	// TemplateConflictPolicy decides what happens to an index template existing on the target, it is overwritten by
+	// default
+	TemplateConflictPolicy config.TemplateConflictPolicy
+
+	AutoGenerateIds bool
+
+	UnorderedArrayFields []string
+
+	CompareIgnoreFields []string
+
+	DeadLetter DeadLetterSink
+
+	// MaxInflightBulk bounds the bulk requests sent at once by the bulk workers, 0 is one per worker
+	MaxInflightBulk uint
+
+	bulkSlots chan struct{}
+
+	// BulkMetrics observes the durations and the docs of the bulk requests
+	BulkMetrics *BulkMetrics
+
+	// StreamBulk streams the bulk body to the target while the docs are encoded, instead of buffering the batch
+	StreamBulk bool
+
+	// WaitForActiveShards is the number of active shard copies the writes to the target wait for, e.g. "2" or "all"
+	WaitForActiveShards string
+
+	// RequireExistingTarget never creates or recreates the target index, a missing one fails the migration
+	RequireExistingTarget bool
+
+	// AllowSelf lets the source and the target be the same index of the same cluster
+	AllowSelf bool
+
+	// TrackTotalHits counts the exact total of the source scrolls on es 7 and later, true by default
+	TrackTotalHits bool
+
+	// VerifyCount compares the doc counts of the source and the target after a sync
+	VerifyCount bool
+
+	// BulkRefreshPolicy is the refresh of the bulk requests, none by default
+	BulkRefreshPolicy config.BulkRefreshPolicy
+
+	// DocTransformer rewrites the docs before they are bulked, e.g. into scripted updates
+	DocTransformer DocTransformer
+
+	// FieldCoercions converts the fields of the docs before they are bulked, by the dotted path of the field
+	FieldCoercions map[string]CoercionFunc
+
+	// Rollup writes a doc per time bucket of the source docs instead of the docs
+	Rollup *config.RollupCfg
+
+	// joinFields are the join fields of the source mapping found by the sync, their docs keep the source routing
+	joinFields []string
+
+	docProgress *docProgress
+
+	// pause holds the reads and the bulk writes while the bulk migrator is paused
+	pause *pauseGate
+
+	// compareCheckpoint makes the compare resumable, it records under compareCheckpointKey how far the compare of the
+	// index pair went
+	compareCheckpoint    *CompareCheckpoint
+	compareCheckpointKey string
+
+	stats *syncStats
 }
 
 func NewMigratorWithConfig(ctx context.Context, srcConfig *config.ESConfig, dstConfig *config.ESConfig) (*Migrator, error) {
@@ -83,10 +182,12 @@ func NewMigratorWithConfig(ctx context.Context, srcConfig *config.ESConfig, dstC
 func NewMigrator(ctx context.Context, srcES es2.ES, dstES es2.ES) *Migrator {
 	if lo.IsNotEmpty(srcES) {
 		ctx = utils.SetCtxKeySourceESVersion(ctx, srcES.GetClusterVersion())
+		ctx = utils.SetCtxKeySourceESName(ctx, srcES.GetClusterName())
 	}
 
 	if lo.IsNotEmpty(dstES) {
 		ctx = utils.SetCtxKeyTargetESVersion(ctx, dstES.GetClusterVersion())
+		ctx = utils.SetCtxKeyTargetESName(ctx, dstES.GetClusterName())
 	}
 
 	return &Migrator{
@@ -100,6 +201,7 @@ func NewMigrator(ctx context.Context, srcES es2.ES, dstES es2.ES) *Migrator {
 		BufferCount:       defaultBufferCount,
 		ActionParallelism: defaultActionParallelism,
 		ActionSize:        defaultActionSize,
+		TrackTotalHits:    true,
 	}
 }
 
@@ -107,6 +209,103 @@ func (m *Migrator) GetCtx() context.Context {
 	return m.ctx
 }
 
+// withCtx replaces the context the migrator runs with, e.g. to bound it with a deadline
+func (m *Migrator) withCtx(ctx context.Context) *Migrator {
+	newMigrator := m.clone()
+	newMigrator.ctx = ctx
+	return newMigrator
+}
+
+func (m *Migrator) clone() *Migrator {
+	return &Migrator{
+		err:                    m.err,
+		ctx:                    m.ctx,
+		SourceES:               m.SourceES,
+		TargetES:               m.TargetES,
+		IndexPair:              m.IndexPair,
+		ScrollSize:             m.ScrollSize,
+		ScrollTime:             m.ScrollTime,
+		SliceSize:              m.SliceSize,
+		BufferCount:            m.BufferCount,
+		ActionParallelism:      m.ActionParallelism,
+		ActionSize:             m.ActionSize,
+		IndexFilePair:          m.IndexFilePair,
+		IndexTemplate:          m.IndexTemplate,
+		FileDir:                m.FileDir,
+		Ids:                    m.Ids,
+		MaxDocs:                m.MaxDocs,
+		AutoSlice:              m.AutoSlice,
+		ReadParallel:           m.ReadParallel,
+		MaxOpenScrolls:         m.MaxOpenScrolls,
+		scrollSlots:            m.scrollSlots,
+		SortFields:             m.SortFields,
+		BulkDedup:              m.BulkDedup,
+		ConflictPolicy:         m.ConflictPolicy,
+		TimestampField:         m.TimestampField,
+		TargetType:             m.TargetType,
+		MultiTypePolicy:        m.MultiTypePolicy,
+		ClosedIndexPolicy:      m.ClosedIndexPolicy,
+		TemplateConflictPolicy: m.TemplateConflictPolicy,
+		AutoGenerateIds:        m.AutoGenerateIds,
+		UnorderedArrayFields:   m.UnorderedArrayFields,
+		CompareIgnoreFields:    m.CompareIgnoreFields,
+		DeadLetter:             m.DeadLetter,
+		MaxInflightBulk:        m.MaxInflightBulk,
+		bulkSlots:              m.bulkSlots,
+		BulkMetrics:            m.BulkMetrics,
+		StreamBulk:             m.StreamBulk,
+		WaitForActiveShards:    m.WaitForActiveShards,
+		RequireExistingTarget:  m.RequireExistingTarget,
+		AllowSelf:              m.AllowSelf,
+		TrackTotalHits:         m.TrackTotalHits,
+		VerifyCount:            m.VerifyCount,
+		BulkRefreshPolicy:      m.BulkRefreshPolicy,
+		DocTransformer:         m.DocTransformer,
+		FieldCoercions:         m.FieldCoercions,
+		Rollup:                 m.Rollup,
+		docProgress:            m.docProgress,
+		pause:                  m.pause,
+		compareCheckpoint:      m.compareCheckpoint,
+		compareCheckpointKey:   m.compareCheckpointKey,
+		stats:                  m.stats,
+	}
+}
+
+// withDocProgress adds the bulked docs to the progress shared by the index pairs of a bulk migrator
+func (m *Migrator) withDocProgress(progress *docProgress) *Migrator {
+	newMigrator := m.clone()
+	newMigrator.docProgress = progress
+	return newMigrator
+}
+
+// WithMaxOpenScrolls bounds the scrolls the migrator keeps open on the source, a slice waits for a free one before
+// its scroll is opened. The scrolls of the target, e.g. of a compare, are not bounded. 0 is unbounded.
+func (m *Migrator) WithMaxOpenScrolls(maxOpenScrolls uint) *Migrator {
+	if m.err != nil {
+		return m
+	}
+
+	var scrollSlots chan struct{}
+	if maxOpenScrolls > 0 {
+		scrollSlots = make(chan struct{}, maxOpenScrolls)
+	}
+	return m.withScrollSlots(maxOpenScrolls, scrollSlots)
+}
+
+// withScrollSlots shares the open scroll slots of a bulk run between its migrators
+func (m *Migrator) withScrollSlots(maxOpenScrolls uint, scrollSlots chan struct{}) *Migrator {
+	newMigrator := m.clone()
+	newMigrator.MaxOpenScrolls = maxOpenScrolls
+	newMigrator.scrollSlots = scrollSlots
+	return newMigrator
+}
+
+func (m *Migrator) withPause(pause *pauseGate) *Migrator {
+	newMigrator := m.clone()
+	newMigrator.pause = pause
+	return newMigrator
+}
+
 func (m *Migrator) addDateTimeFixFields(ctx context.Context, fieldMap map[string]interface{}) context.Context {
 	if !strings.HasPrefix(utils.GetCtxKeySourceESVersion(ctx), "5.") {
 		return ctx
@@ -158,44 +357,18 @@ func (m *Migrator) WithIndexPair(indexPair config.IndexPair) *Migrator {
 		return m
 	}
 
-	return &Migrator{
-		err:               m.err,
-		ctx:               m.ctx,
-		SourceES:          m.SourceES,
-		TargetES:          m.TargetES,
-		IndexPair:         &indexPair,
-		ScrollSize:        m.ScrollSize,
-		ScrollTime:        m.ScrollTime,
-		SliceSize:         m.SliceSize,
-		BufferCount:       m.BufferCount,
-		ActionParallelism: m.ActionParallelism,
-		ActionSize:        m.ActionSize,
-		Ids:               m.Ids,
-		IndexFilePair:     m.IndexFilePair,
-		IndexTemplate:     m.IndexTemplate,
-	}
+	newMigrator := m.clone()
+	newMigrator.IndexPair = &indexPair
+	return newMigrator
 }
 
 func (m *Migrator) WithIndexTemplate(indexTemplate config.IndexTemplate) *Migrator {
 	if m.err != nil {
 		return m
 	}
-	return &Migrator{
-		err:               m.err,
-		ctx:               m.ctx,
-		SourceES:          m.SourceES,
-		TargetES:          m.TargetES,
-		IndexPair:         m.IndexPair,
-		ScrollSize:        m.ScrollSize,
-		ScrollTime:        m.ScrollTime,
-		SliceSize:         m.SliceSize,
-		BufferCount:       m.BufferCount,
-		ActionParallelism: m.ActionParallelism,
-		ActionSize:        m.ActionSize,
-		Ids:               m.Ids,
-		IndexFilePair:     m.IndexFilePair,
-		IndexTemplate:     &indexTemplate,
-	}
+	newMigrator := m.clone()
+	newMigrator.IndexTemplate = &indexTemplate
+	return newMigrator
 }
 
 func (m *Migrator) WithScrollSize(scrollSize uint) *Migrator {
@@ -207,21 +380,9 @@ func (m *Migrator) WithScrollSize(scrollSize uint) *Migrator {
 		scrollSize = defaultScrollSize
 	}
 
-	return &Migrator{
-		ctx:               m.ctx,
-		SourceES:          m.SourceES,
-		TargetES:          m.TargetES,
-		IndexPair:         m.IndexPair,
-		ScrollSize:        scrollSize,
-		ScrollTime:        m.ScrollTime,
-		SliceSize:         m.SliceSize,
-		BufferCount:       m.BufferCount,
-		ActionParallelism: m.ActionParallelism,
-		ActionSize:        m.ActionSize,
-		Ids:               m.Ids,
-		IndexFilePair:     m.IndexFilePair,
-		IndexTemplate:     m.IndexTemplate,
-	}
+	newMigrator := m.clone()
+	newMigrator.ScrollSize = scrollSize
+	return newMigrator
 }
 
 func (m *Migrator) WithScrollTime(scrollTime uint) *Migrator {
@@ -233,21 +394,9 @@ func (m *Migrator) WithScrollTime(scrollTime uint) *Migrator {
 		scrollTime = defaultScrollTime
 	}
 
-	return &Migrator{
-		ctx:               m.ctx,
-		SourceES:          m.SourceES,
-		TargetES:          m.TargetES,
-		IndexPair:         m.IndexPair,
-		ScrollSize:        m.ScrollSize,
-		ScrollTime:        scrollTime,
-		SliceSize:         m.SliceSize,
-		BufferCount:       m.BufferCount,
-		ActionParallelism: m.ActionParallelism,
-		ActionSize:        m.ActionSize,
-		Ids:               m.Ids,
-		IndexFilePair:     m.IndexFilePair,
-		IndexTemplate:     m.IndexTemplate,
-	}
+	newMigrator := m.clone()
+	newMigrator.ScrollTime = scrollTime
+	return newMigrator
 }
 
 func (m *Migrator) WithSliceSize(sliceSize uint) *Migrator {
@@ -258,21 +407,9 @@ func (m *Migrator) WithSliceSize(sliceSize uint) *Migrator {
 	if sliceSize <= 0 {
 		sliceSize = defaultSliceSize
 	}
-	return &Migrator{
-		ctx:               m.ctx,
-		SourceES:          m.SourceES,
-		TargetES:          m.TargetES,
-		IndexPair:         m.IndexPair,
-		ScrollSize:        m.ScrollSize,
-		ScrollTime:        m.ScrollTime,
-		SliceSize:         sliceSize,
-		BufferCount:       m.BufferCount,
-		ActionParallelism: m.ActionParallelism,
-		ActionSize:        m.ActionSize,
-		Ids:               m.Ids,
-		IndexFilePair:     m.IndexFilePair,
-		IndexTemplate:     m.IndexTemplate,
-	}
+	newMigrator := m.clone()
+	newMigrator.SliceSize = sliceSize
+	return newMigrator
 }
 
 func (m *Migrator) WithBufferCount(sliceSize uint) *Migrator {
@@ -283,21 +420,9 @@ func (m *Migrator) WithBufferCount(sliceSize uint) *Migrator {
 	if sliceSize <= 0 {
 		sliceSize = defaultBufferCount
 	}
-	return &Migrator{
-		ctx:               m.ctx,
-		SourceES:          m.SourceES,
-		TargetES:          m.TargetES,
-		IndexPair:         m.IndexPair,
-		ScrollSize:        m.ScrollSize,
-		ScrollTime:        m.ScrollTime,
-		SliceSize:         m.SliceSize,
-		BufferCount:       sliceSize,
-		ActionParallelism: m.ActionParallelism,
-		ActionSize:        m.ActionSize,
-		Ids:               m.Ids,
-		IndexFilePair:     m.IndexFilePair,
-		IndexTemplate:     m.IndexTemplate,
-	}
+	newMigrator := m.clone()
+	newMigrator.BufferCount = sliceSize
+	return newMigrator
 }
 
 func (m *Migrator) WithActionParallelism(actionParallelism uint) *Migrator {
@@ -308,21 +433,9 @@ func (m *Migrator) WithActionParallelism(actionParallelism uint) *Migrator {
 	if actionParallelism <= 0 {
 		actionParallelism = defaultActionParallelism
 	}
-	return &Migrator{
-		ctx:               m.ctx,
-		SourceES:          m.SourceES,
-		TargetES:          m.TargetES,
-		IndexPair:         m.IndexPair,
-		ScrollSize:        m.ScrollSize,
-		ScrollTime:        m.ScrollTime,
-		SliceSize:         m.SliceSize,
-		BufferCount:       m.BufferCount,
-		ActionParallelism: actionParallelism,
-		ActionSize:        m.ActionSize,
-		Ids:               m.Ids,
-		IndexFilePair:     m.IndexFilePair,
-		IndexTemplate:     m.IndexTemplate,
-	}
+	newMigrator := m.clone()
+	newMigrator.ActionParallelism = actionParallelism
+	return newMigrator
 }
 
 func (m *Migrator) WithActionSize(actionSize uint) *Migrator {
@@ -334,21 +447,9 @@ func (m *Migrator) WithActionSize(actionSize uint) *Migrator {
 		actionSize = defaultActionSize
 	}
 
-	return &Migrator{
-		ctx:               m.ctx,
-		SourceES:          m.SourceES,
-		TargetES:          m.TargetES,
-		IndexPair:         m.IndexPair,
-		ScrollSize:        m.ScrollSize,
-		ScrollTime:        m.ScrollTime,
-		SliceSize:         m.SliceSize,
-		BufferCount:       m.BufferCount,
-		ActionParallelism: m.ActionParallelism,
-		ActionSize:        actionSize,
-		Ids:               m.Ids,
-		IndexFilePair:     m.IndexFilePair,
-		IndexTemplate:     m.IndexTemplate,
-	}
+	newMigrator := m.clone()
+	newMigrator.ActionSize = actionSize
+	return newMigrator
 }
 
 func (m *Migrator) WithIds(ids []string) *Migrator {
@@ -356,59 +457,373 @@ func (m *Migrator) WithIds(ids []string) *Migrator {
 		return m
 	}
 
-	return &Migrator{
-		ctx:               m.ctx,
-		SourceES:          m.SourceES,
-		TargetES:          m.TargetES,
-		IndexPair:         m.IndexPair,
-		ScrollSize:        m.ScrollSize,
-		ScrollTime:        m.ScrollTime,
-		SliceSize:         m.SliceSize,
-		BufferCount:       m.BufferCount,
-		ActionParallelism: m.ActionParallelism,
-		ActionSize:        m.ActionSize,
-		Ids:               ids,
-		IndexFilePair:     m.IndexFilePair,
-		IndexTemplate:     m.IndexTemplate,
+	newMigrator := m.clone()
+	newMigrator.Ids = ids
+	return newMigrator
+}
+
+// WithMaxDocs caps the number of documents synced per index, 0 means no limit.
+func (m *Migrator) WithMaxDocs(maxDocs uint) *Migrator {
+	if m.err != nil {
+		return m
 	}
+
+	newMigrator := m.clone()
+	newMigrator.MaxDocs = maxDocs
+	return newMigrator
 }
 
-func (m *Migrator) WithIndexFilePair(indexFilePair *config.IndexFilePair) *Migrator {
+// WithAutoSlice sets the scroll slice count to the number of primary shards of each searched index,
+// capped by maxAutoSliceSize. It relies on sliced scroll, which requires elasticsearch 5.0 or later.
+func (m *Migrator) WithAutoSlice(autoSlice bool) *Migrator {
 	if m.err != nil {
 		return m
 	}
 
-	return &Migrator{
-		ctx:               m.ctx,
-		SourceES:          m.SourceES,
-		TargetES:          m.TargetES,
-		IndexPair:         m.IndexPair,
-		ScrollSize:        m.ScrollSize,
-		ScrollTime:        m.ScrollTime,
-		SliceSize:         m.SliceSize,
-		BufferCount:       m.BufferCount,
-		ActionParallelism: m.ActionParallelism,
-		ActionSize:        m.ActionSize,
-		Ids:               m.Ids,
-		IndexFilePair:     indexFilePair,
-		IndexTemplate:     m.IndexTemplate,
+	newMigrator := m.clone()
+	newMigrator.AutoSlice = autoSlice
+	return newMigrator
+}
+
+// WithReadParallel bounds the sliced scrolls of an index running at once, the other slices wait for a free reader
+func (m *Migrator) WithReadParallel(readParallel uint) *Migrator {
+	if m.err != nil {
+		return m
+	}
+
+	newMigrator := m.clone()
+	newMigrator.ReadParallel = readParallel
+	return newMigrator
+}
+
+// WithSortFields scrolls the source docs in a deterministic order instead of the index order, e.g. "timestamp:asc"
+// or "_id". A sliced scroll keeps the order within every slice only, a slice size of 1 sorts the whole index.
+func (m *Migrator) WithSortFields(sortFields []string) *Migrator {
+	if m.err != nil {
+		return m
+	}
+
+	newMigrator := m.clone()
+	newMigrator.SortFields = sortFields
+	return newMigrator
+}
+
+// WithBulkDedup keeps only the last action for every doc id within one bulk request. It is opt-in
+// because dropping the earlier actions changes what the target observes.
+func (m *Migrator) WithBulkDedup(bulkDedup bool) *Migrator {
+	if m.err != nil {
+		return m
+	}
+
+	newMigrator := m.clone()
+	newMigrator.BulkDedup = bulkDedup
+	return newMigrator
+}
+
+// WithConflictPolicy decides what happens when a synced doc already exists in the target.
+// ConflictPolicySkipExisting writes with op_type=create so existing docs are kept. ConflictPolicyNewerWins
+// reads the target docs of every batch before writing and skips a doc whose target timestampField is newer,
+// which costs an extra search against the target per batch.
+func (m *Migrator) WithConflictPolicy(policy config.ConflictPolicy, timestampField string) *Migrator {
+	if m.err != nil {
+		return m
+	}
+
+	newMigrator := m.clone()
+	if policy == config.ConflictPolicyNewerWins && lo.IsEmpty(timestampField) {
+		newMigrator.err = utils.NewCustomError(utils.InvalidParams, "conflict policy %s requires a timestamp field", policy)
+	}
+	newMigrator.ConflictPolicy = policy
+	newMigrator.TimestampField = timestampField
+	return newMigrator
+}
+
+// WithMultiTypePolicy decides how the target of a multi-type 5.x index is created on 6.x or later, which allow one
+// mapping type. MultiTypePolicyFirst keeps the first type by name with a warning, the docs of the other types are
+// still written.
+func (m *Migrator) WithMultiTypePolicy(policy config.MultiTypePolicy) *Migrator {
+	if m.err != nil {
+		return m
 	}
+
+	newMigrator := m.clone()
+	newMigrator.MultiTypePolicy = policy
+	return newMigrator
 }
 
+// WithClosedIndexPolicy decides how a closed source index is synced instead of failing with an IndexClosed error,
+// ClosedIndexPolicySkip skips it with a warning and ClosedIndexPolicyOpen opens it for the sync and closes it after
+func (m *Migrator) WithClosedIndexPolicy(policy config.ClosedIndexPolicy) *Migrator {
+	if m.err != nil {
+		return m
+	}
+
+	newMigrator := m.clone()
+	newMigrator.ClosedIndexPolicy = policy
+	return newMigrator
+}
+
+// WithTemplateConflictPolicy decides what CreateTemplate does when the target has the template already
+func (m *Migrator) WithTemplateConflictPolicy(policy config.TemplateConflictPolicy) *Migrator {
+	if m.err != nil {
+		return m
+	}
+
+	newMigrator := m.clone()
+	newMigrator.TemplateConflictPolicy = policy
+	return newMigrator
+}
+
+// WithTargetType overrides the _type of the docs written to a typed target, typeless targets ignore it
+func (m *Migrator) WithTargetType(typeName string) *Migrator {
+	if m.err != nil {
+		return m
+	}
+
+	newMigrator := m.clone()
+	newMigrator.TargetType = typeName
+	return newMigrator
+}
+
+// WithAutoGenerateIds leaves out the source _id of the written docs so the target assigns new ones. The
+// writes are no longer idempotent: a re-run or a retried bulk duplicates the docs, and the target can not be
+// compared, repaired or synced by id against the source.
+func (m *Migrator) WithAutoGenerateIds(autoGenerateIds bool) *Migrator {
+	if m.err != nil {
+		return m
+	}
+
+	newMigrator := m.clone()
+	newMigrator.AutoGenerateIds = autoGenerateIds
+	return newMigrator
+}
+
+// WithUnorderedArrayFields compares the arrays of the dotted path fields regardless of their order, `*` for
+// every array
+func (m *Migrator) WithUnorderedArrayFields(fields []string) *Migrator {
+	if m.err != nil {
+		return m
+	}
+
+	newMigrator := m.clone()
+	newMigrator.UnorderedArrayFields = fields
+	return newMigrator
+}
+
+// WithCompareIgnoreFields strips the dotted path fields from both sides before they are compared, for fields
+// that legitimately differ like an ingest time timestamp
+func (m *Migrator) WithCompareIgnoreFields(fields []string) *Migrator {
+	if m.err != nil {
+		return m
+	}
+
+	newMigrator := m.clone()
+	newMigrator.CompareIgnoreFields = fields
+	return newMigrator
+}
+
+// WithDeadLetter writes the docs es rejects in a bulk request to sink, without one they are logged and dropped.
+// The caller closes the sink once the migration is done.
+func (m *Migrator) WithDeadLetter(sink DeadLetterSink) *Migrator {
+	if m.err != nil {
+		return m
+	}
+
+	newMigrator := m.clone()
+	newMigrator.DeadLetter = sink
+	return newMigrator
+}
+
+// WithMaxInflightBulk bounds the bulk requests the migrator has outstanding against the target, the bulk workers
+// wait for a free slot when more of them flush at once. 0 leaves it to ActionParallelism.
+func (m *Migrator) WithMaxInflightBulk(maxInflightBulk uint) *Migrator {
+	if m.err != nil {
+		return m
+	}
+
+	newMigrator := m.clone()
+	newMigrator.MaxInflightBulk = maxInflightBulk
+	newMigrator.bulkSlots = nil
+	if maxInflightBulk > 0 {
+		newMigrator.bulkSlots = make(chan struct{}, maxInflightBulk)
+	}
+	return newMigrator
+}
+
+// WithBulkMetrics observes the bulk requests to the target in metrics, e.g. shared by the migrators of a process
+// and exposed with BulkMetrics.Format
+func (m *Migrator) WithBulkMetrics(metrics *BulkMetrics) *Migrator {
+	if m.err != nil {
+		return m
+	}
+
+	newMigrator := m.clone()
+	newMigrator.BulkMetrics = metrics
+	return newMigrator
+}
+
+// WithStreamBulk streams every bulk body through a pipe, so a worker holds one doc instead of the whole batch. A
+// streamed request is not retried by the client, and BulkDedup keeps buffering because it needs the whole batch.
+func (m *Migrator) WithStreamBulk(streamBulk bool) *Migrator {
+	if m.err != nil {
+		return m
+	}
+
+	newMigrator := m.clone()
+	newMigrator.StreamBulk = streamBulk
+	return newMigrator
+}
+
+// WithWaitForActiveShards makes the bulk and create index requests wait for that many active shard copies, a
+// positive number or "all". Higher values trade throughput for durability while the target recovers, empty leaves
+// it to the index setting.
+func (m *Migrator) WithWaitForActiveShards(waitForActiveShards string) *Migrator {
+	if m.err != nil {
+		return m
+	}
+
+	newMigrator := m.clone()
+	if waitForActiveShards != "" && waitForActiveShards != "all" && cast.ToInt(waitForActiveShards) <= 0 {
+		newMigrator.err = utils.NewCustomError(utils.InvalidParams,
+			"wait_for_active_shards %s is neither a positive number nor all", waitForActiveShards)
+	}
+	newMigrator.WaitForActiveShards = waitForActiveShards
+	return newMigrator
+}
+
+// WithRequireExistingTarget leaves the creation of the target index to its owner, e.g. infrastructure as code. The
+// settings are never copied, force does not recreate the target and a missing target fails before any doc is
+// written, instead of being created with inherited or dynamic settings.
+func (m *Migrator) WithRequireExistingTarget(requireExistingTarget bool) *Migrator {
+	if m.err != nil {
+		return m
+	}
+
+	newMigrator := m.clone()
+	newMigrator.RequireExistingTarget = requireExistingTarget
+	return newMigrator
+}
+
+// WithAllowSelf lets the migrator read and rewrite the same index of the same cluster, which is rejected otherwise
+// as the writes are read again by the scroll and a recreated target deletes the source
+func (m *Migrator) WithAllowSelf(allowSelf bool) *Migrator {
+	if m.err != nil {
+		return m
+	}
+
+	newMigrator := m.clone()
+	newMigrator.AllowSelf = allowSelf
+	return newMigrator
+}
+
+// WithTrackTotalHits turns off the exact total of the source scrolls when false, es 7 and later then stop counting
+// the hits at 10000, which is cheaper on large indices but caps the scroll total of a count fallback
+func (m *Migrator) WithTrackTotalHits(trackTotalHits bool) *Migrator {
+	if m.err != nil {
+		return m
+	}
+
+	newMigrator := m.clone()
+	newMigrator.TrackTotalHits = trackTotalHits
+	return newMigrator
+}
+
+// WithVerifyCount fails a sync whose target counts fewer docs than the source. The last bulk of every bulk worker
+// is then sent with refresh=wait_for, so the count sees the written docs without an explicit refresh. It costs the
+// wait for the next refresh of the target, up to its refresh_interval, 1s by default, and the bulks are buffered
+// instead of streamed, since the last one is only known once the docs run out.
+func (m *Migrator) WithVerifyCount(verifyCount bool) *Migrator {
+	if m.err != nil {
+		return m
+	}
+
+	newMigrator := m.clone()
+	newMigrator.VerifyCount = verifyCount
+	return newMigrator
+}
+
+// WithBulkRefreshPolicy sets the refresh param of the bulk requests. The default none is the fastest for a bulk
+// load, the docs show up with the next refresh of the target. wait_for answers every bulk once its docs are visible,
+// e.g. for a sync verified right after, and holds each bulk worker up to a refresh_interval per request. true forces
+// a refresh after every bulk, the slowest as it makes many small segments.
+func (m *Migrator) WithBulkRefreshPolicy(policy config.BulkRefreshPolicy) *Migrator {
+	if m.err != nil {
+		return m
+	}
+
+	newMigrator := m.clone()
+	newMigrator.BulkRefreshPolicy = policy
+	return newMigrator
+}
+
+// WithDocTransformer rewrites every doc with transformer before it is bulked, e.g. ScriptedUpsert merges the
+// fields instead of overwriting the target doc
+func (m *Migrator) WithDocTransformer(transformer DocTransformer) *Migrator {
+	if m.err != nil {
+		return m
+	}
+
+	newMigrator := m.clone()
+	newMigrator.DocTransformer = transformer
+	return newMigrator
+}
+
+// WithFieldCoercions converts the fields of every doc with coercions before it is bulked, e.g. CoerceInteger for a
+// number the source keeps as text. A doc whose field fails to convert is not written, it goes to the dead letter sink.
+func (m *Migrator) WithFieldCoercions(coercions map[string]CoercionFunc) *Migrator {
+	if m.err != nil {
+		return m
+	}
+
+	newMigrator := m.clone()
+	newMigrator.FieldCoercions = coercions
+	return newMigrator
+}
+
+// WithRollup aggregates the source docs into a doc per time bucket and group of rollup, nil writes the docs as they
+// are. The bucket docs are written once the whole source is read, VerifyCount is skipped as the target has fewer
+// docs than the source.
+func (m *Migrator) WithRollup(rollup *config.RollupCfg) *Migrator {
+	if m.err != nil {
+		return m
+	}
+
+	newMigrator := m.clone()
+	if rollup != nil {
+		newMigrator.err = validateRollup(rollup)
+	}
+	newMigrator.Rollup = rollup
+	return newMigrator
+}
+
+func (m *Migrator) WithIndexFilePair(indexFilePair *config.IndexFilePair) *Migrator {
+	if m.err != nil {
+		return m
+	}
+
+	newMigrator := m.clone()
+	newMigrator.IndexFilePair = indexFilePair
+	return newMigrator
+}
+
+// CopyIndexSettings creates the target index with the source settings and mappings but copies no doc, an
+// existed target index is kept unless force recreates it
 func (m *Migrator) CopyIndexSettings(force bool) error {
 	if m.err != nil {
 		return errors.WithStack(m.err)
 	}
 
+	if err := m.checkSelfMigration(); err != nil {
+		return errors.WithStack(err)
+	}
+
 	ctx, err := m.buildIndexPairContext()
 	if err != nil {
 		return errors.WithStack(err)
 	}
 
-	if force {
-		if err := m.copyIndexSettings(ctx, m.IndexPair.TargetIndex, force); err != nil {
-			return errors.WithStack(err)
-		}
+	if err := m.copyIndexSettings(ctx, m.IndexPair.TargetIndex, force); err != nil {
+		return errors.WithStack(err)
 	}
 
 	return nil
@@ -454,6 +869,21 @@ func (m *Migrator) createTemplate() error {
 	}
 
 	templateSetting := m.GetTargetESTemplateSetting(sourceESSetting, m.IndexTemplate.Patterns, m.IndexTemplate.Order)
+	if m.TemplateConflictPolicy == config.TemplateConflictPolicySkip ||
+		m.TemplateConflictPolicy == config.TemplateConflictPolicyMerge {
+		existingSetting, err := m.TargetES.GetTemplate(m.ctx, m.IndexTemplate.Name)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		if existingSetting != nil && m.TemplateConflictPolicy == config.TemplateConflictPolicySkip {
+			utils.GetLogger(m.ctx).Warnf("skip the index template %s, it exists on the target", m.IndexTemplate.Name)
+			return nil
+		}
+		if existingSetting != nil {
+			templateSetting = mergeTemplateSetting(existingSetting, templateSetting)
+		}
+	}
+
 	if err := m.TargetES.CreateTemplate(m.ctx, m.IndexTemplate.Name, templateSetting); err != nil {
 		return errors.WithStack(err)
 	}
@@ -461,6 +891,27 @@ func (m *Migrator) createTemplate() error {
 	return nil
 }
 
+// mergeTemplateSetting lays setting over existing, the objects of both are merged and any other value of setting
+// replaces the existing one, e.g. the order and the patterns
+func mergeTemplateSetting(existing, setting map[string]interface{}) map[string]interface{} {
+	merged := make(map[string]interface{}, len(existing))
+	for key, value := range existing {
+		merged[key] = value
+	}
+	for key, value := range setting {
+		existingValue, ok1 := merged[key].(map[string]interface{})
+		valueMap, ok2 := value.(map[string]interface{})
+		if ok1 && ok2 {
+			merged[key] = mergeTemplateSetting(existingValue, valueMap)
+			continue
+		}
+		merged[key] = value
+	}
+	return merged
+}
+
+// CreateTemplate creates the index template on the target with the settings and mappings of the first source index
+// it matches and its configured order, an existing template is handled by TemplateConflictPolicy
 func (m *Migrator) CreateTemplate() error {
 	if m.err != nil {
 		return errors.WithStack(m.err)
@@ -487,10 +938,61 @@ func getQueryMap(docIds []string) map[string]interface{} {
 }
 
 func (m *Migrator) SyncDiff() (*DiffResult, error) {
+	return m.compareAndWrite(func(ctx context.Context, diffResult *DiffResult) error {
+		var errs utils.Errs
+		if _, err := m.syncDocs(ctx, diffResult.CreateDocs, es2.OperationCreate); err != nil {
+			errs.Add(errors.WithStack(err))
+		}
+		if _, err := m.syncDocs(ctx, diffResult.UpdateDocs, es2.OperationUpdate); err != nil {
+			errs.Add(errors.WithStack(err))
+		}
+		if _, err := m.syncDocs(ctx, diffResult.DeleteDocs, es2.OperationDelete); err != nil {
+			errs.Add(errors.WithStack(err))
+		}
+		return errs.Ret()
+	})
+}
+
+type RepairResult struct {
+	RepairedCount uint64
+	DeletedCount  uint64
+}
+
+// Repair compares the index pair and only rewrites the divergent documents: documents missing on the target or
+// whose content differs are re-copied from the source, documents only existing on the target are deleted. The
+// counts are the documents the target accepted.
+func (m *Migrator) Repair() (*RepairResult, error) {
+	var repairResult *RepairResult
+	_, err := m.compareAndWrite(func(ctx context.Context, diffResult *DiffResult) error {
+		repairResult = &RepairResult{}
+		var errs utils.Errs
+		repairStats, err := m.syncDocs(ctx, lo.Union(diffResult.CreateDocs, diffResult.UpdateDocs), es2.OperationCreate)
+		if err != nil {
+			errs.Add(errors.WithStack(err))
+		}
+		repairResult.RepairedCount = repairStats.DocsWritten
+
+		deleteStats, err := m.syncDocs(ctx, diffResult.DeleteDocs, es2.OperationDelete)
+		if err != nil {
+			errs.Add(errors.WithStack(err))
+		}
+		repairResult.DeletedCount = deleteStats.DocsWritten
+		return errs.Ret()
+	})
+	return repairResult, err
+}
+
+// compareAndWrite compares the index pair and hands the diff to write. A failed compare writes nothing, the docs it
+// did not reach would be taken for creates or deletes.
+func (m *Migrator) compareAndWrite(write func(ctx context.Context, diffResult *DiffResult) error) (*DiffResult, error) {
 	if m.err != nil {
 		return nil, errors.WithStack(m.err)
 	}
 
+	if err := m.checkSelfMigration(); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
 	existed, err := m.TargetES.IndexExisted(m.IndexPair.TargetIndex)
 	if err != nil {
 		return nil, errors.WithStack(err)
@@ -505,33 +1007,35 @@ func (m *Migrator) SyncDiff() (*DiffResult, error) {
 		return nil, errors.WithStack(err)
 	}
 
-	var errs utils.Errs
 	diffResult, err := m.compare()
 	if err != nil {
-		errs.Add(err)
+		return diffResult, errors.WithStack(err)
 	}
 
-	if len(diffResult.CreateDocs) > 0 {
-		utils.GetLogger(ctx).Debugf("sync with create docs: %+v", len(diffResult.CreateDocs))
-		if err := m.syncUpsert(ctx, getQueryMap(diffResult.CreateDocs), es2.OperationCreate); err != nil {
-			errs.Add(errors.WithStack(err))
-		}
+	if err := write(ctx, diffResult); err != nil {
+		return diffResult, errors.WithStack(err)
 	}
+	return diffResult, nil
+}
+
+// syncDocs copies the docs of ids to the target with the operation and returns the stats of the bulks. The ids
+// are searched maxTermsCount at a time, the limit of a terms query.
+func (m *Migrator) syncDocs(ctx context.Context, ids []string, operation es2.Operation) (*MigrationStats, error) {
+	newMigrator := m.clone()
+	newMigrator.stats = &syncStats{}
 
-	if len(diffResult.UpdateDocs) > 0 {
-		utils.GetLogger(ctx).Debugf("sync with update docs: %+v", len(diffResult.UpdateDocs))
-		if err := m.syncUpsert(ctx, getQueryMap(diffResult.UpdateDocs), es2.OperationUpdate); err != nil {
+	var errs utils.Errs
+	for _, batch := range lo.Chunk(ids, maxTermsCount) {
+		if err := ctx.Err(); err != nil {
 			errs.Add(errors.WithStack(err))
+			break
 		}
-	}
-
-	if len(diffResult.DeleteDocs) > 0 {
-		utils.GetLogger(ctx).Debugf("sync with delete docs: %+v", len(diffResult.DeleteDocs))
-		if err := m.syncUpsert(ctx, getQueryMap(diffResult.DeleteDocs), es2.OperationDelete); err != nil {
+		utils.GetLogger(ctx).Debugf("sync %d docs with %s", len(batch), m.getOperationTitle(operation))
+		if err := newMigrator.syncUpsert(ctx, getQueryMap(batch), 0, operation); err != nil {
 			errs.Add(errors.WithStack(err))
 		}
 	}
-	return diffResult, errs.Ret()
+	return newMigrator.stats.snapshot(0), errs.Ret()
 }
 
 func (m *Migrator) getESIndexFields(es es2.ES) (map[string]interface{}, error) {
@@ -569,7 +1073,8 @@ func (m *Migrator) getKeywordFields(ctx context.Context) ([]string, error) {
 
 func (m *Migrator) getDocHash(doc *es2.Doc) uint64 {
 	h := fnv.New64a()
-	jsonData, _ := json.Marshal(doc.Source)
+	normalizer := sourceNormalizer{UnorderedFields: m.UnorderedArrayFields, IgnoreFields: m.CompareIgnoreFields}
+	jsonData, _ := json.Marshal(normalizer.normalize(doc.Source))
 	_, _ = h.Write(jsonData)
 	return h.Sum64()
 }
@@ -593,12 +1098,46 @@ func (m *Migrator) handleMultipleErrors(errCh chan error) chan utils.Errs {
 	return errsCh
 }
 
+// compare keeps the ids of the diffs, the result of an index pair the checkpoint records as finished is used as is
 func (m *Migrator) compare() (*DiffResult, error) {
+	if diffResult, ok := m.compareCheckpoint.Get(m.compareCheckpointKey); ok {
+		utils.GetLogger(m.GetCtx()).Infof("compared before, resume %s", diffResult.toStr())
+		return diffResult, nil
+	}
+
+	diffResult, err := collectDiffDocs(m.compareStream)
+	if err != nil {
+		return diffResult, errors.WithStack(err)
+	}
+	if err := m.compareCheckpoint.Save(m.compareCheckpointKey, diffResult); err != nil {
+		utils.GetLogger(m.GetCtx()).Errorf("save compare checkpoint %+v", err)
+	}
+	return diffResult, nil
+}
+
+// collectDiffDocs runs a streaming compare and keeps the ids of every diff in the result
+func collectDiffDocs(compareStream func(callback func(diff DocDiff)) (*DiffResult, error)) (*DiffResult, error) {
+	var docs DiffResult
+	diffResult, err := compareStream(docs.appendDoc)
+	if diffResult != nil {
+		diffResult.CreateDocs, diffResult.UpdateDocs, diffResult.DeleteDocs = docs.CreateDocs, docs.UpdateDocs, docs.DeleteDocs
+	}
+	return diffResult, errors.WithStack(err)
+}
+
+// compareStream passes every diff to callback as soon as it is found, the result only counts them. Updates are
+// found while scrolling, creates and deletes once both sides are scrolled. callback is called by one goroutine
+// at a time.
+func (m *Migrator) compareStream(callback func(diff DocDiff)) (*DiffResult, error) {
 	ctx, err := m.buildIndexPairContext()
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
 
+	if m.compareCheckpoint != nil {
+		return m.compareFromPosition(ctx, callback)
+	}
+
 	keywordFields, err := m.getKeywordFields(ctx)
 	if err != nil {
 		return nil, errors.WithStack(err)
@@ -609,9 +1148,9 @@ func (m *Migrator) compare() (*DiffResult, error) {
 
 	queryMap := getQueryMap(m.Ids)
 
-	sourceDocCh, sourceTotal := m.search(ctx, m.SourceES, m.IndexPair.SourceIndex, queryMap, keywordFields, errCh, true)
+	sourceDocCh, sourceTotal := m.search(ctx, m.SourceES, m.IndexPair.SourceIndex, queryMap, keywordFields, 0, errCh, true)
 
-	targetDocCh, targetTotal := m.search(ctx, m.TargetES, m.IndexPair.TargetIndex, queryMap, keywordFields, errCh, true)
+	targetDocCh, targetTotal := m.search(ctx, m.TargetES, m.IndexPair.TargetIndex, queryMap, keywordFields, 0, errCh, true)
 
 	var (
 		sourceCount atomic.Uint64
@@ -622,6 +1161,17 @@ func (m *Migrator) compare() (*DiffResult, error) {
 	sourceDocHashMap := skipmap.NewString()
 	targetDocHashMap := skipmap.NewString()
 
+	var (
+		matchLock    sync.Mutex
+		callbackLock sync.Mutex
+	)
+	emit := func(diff DocDiff) {
+		diffResult.count(diff.Type)
+		callbackLock.Lock()
+		defer callbackLock.Unlock()
+		callback(diff)
+	}
+
 	lastPrintTime := time.Now()
 
 	var wg sync.WaitGroup
@@ -648,12 +1198,10 @@ func (m *Migrator) compare() (*DiffResult, error) {
 
 				if sourceResult != nil {
 					sourceCount.Add(1)
-					sourceDocHashMap.Store(sourceResult.ID, sourceResult.Hash)
 				}
 
 				if targetResult != nil {
 					targetCount.Add(1)
-					targetDocHashMap.Store(targetResult.ID, targetResult.Hash)
 				}
 
 				if time.Now().Sub(lastPrintTime) > everyLogTime {
@@ -668,33 +1216,36 @@ func (m *Migrator) compare() (*DiffResult, error) {
 					lastPrintTime = time.Now()
 				}
 
+				// a doc is paired by a single worker, the store and the lookup of its id on the other side are
+				// not split between two workers which would both find the pair
+				var updateIds []string
+				matchLock.Lock()
 				if sourceResult != nil {
-					targetHashValue, ok := targetDocHashMap.Load(sourceResult.ID)
-					if ok {
-						if sourceResult.Hash != targetHashValue {
-							diffResult.addUpdateDoc(sourceResult.ID)
-						} else {
-							diffResult.SameCount.Add(1)
-						}
-
-						targetDocHashMap.Delete(sourceResult.ID)
-						sourceDocHashMap.Delete(sourceResult.ID)
+					targetHashValue, ok := targetDocHashMap.LoadAndDelete(sourceResult.ID)
+					if !ok {
+						sourceDocHashMap.Store(sourceResult.ID, sourceResult.Hash)
+					} else if sourceResult.Hash != targetHashValue {
+						updateIds = append(updateIds, sourceResult.ID)
+					} else {
+						diffResult.SameCount.Add(1)
 					}
 				}
 
 				if targetResult != nil {
-					sourceHashValue, ok := sourceDocHashMap.Load(targetResult.ID)
-					if ok {
-						if targetResult.Hash != sourceHashValue {
-							diffResult.addUpdateDoc(targetResult.ID)
-						} else {
-							diffResult.SameCount.Add(1)
-						}
-
-						targetDocHashMap.Delete(targetResult.ID)
-						sourceDocHashMap.Delete(targetResult.ID)
+					sourceHashValue, ok := sourceDocHashMap.LoadAndDelete(targetResult.ID)
+					if !ok {
+						targetDocHashMap.Store(targetResult.ID, targetResult.Hash)
+					} else if targetResult.Hash != sourceHashValue {
+						updateIds = append(updateIds, targetResult.ID)
+					} else {
+						diffResult.SameCount.Add(1)
 					}
 				}
+				matchLock.Unlock()
+
+				for _, id := range updateIds {
+					emit(DocDiff{ID: id, Type: DiffTypeUpdate})
+				}
 			}
 		})
 	}
@@ -706,7 +1257,7 @@ func (m *Migrator) compare() (*DiffResult, error) {
 	utils.GoRecovery(m.ctx, func() {
 		defer wg.Done()
 		sourceDocHashMap.Range(func(key string, value interface{}) bool {
-			diffResult.addCreateDoc(key)
+			emit(DocDiff{ID: key, Type: DiffTypeCreate})
 			return true
 		})
 	})
@@ -715,7 +1266,7 @@ func (m *Migrator) compare() (*DiffResult, error) {
 	utils.GoRecovery(m.ctx, func() {
 		defer wg.Done()
 		targetDocHashMap.Range(func(key string, value interface{}) bool {
-			diffResult.addDeleteDoc(cast.ToString(key))
+			emit(DocDiff{ID: cast.ToString(key), Type: DiffTypeDelete})
 			return true
 		})
 	})
@@ -726,6 +1277,22 @@ func (m *Migrator) compare() (*DiffResult, error) {
 	return &diffResult, errors.WithStack(errs.Ret())
 }
 
+type DiffType string
+
+const (
+	// DiffTypeCreate is a doc missing on the target
+	DiffTypeCreate DiffType = "create"
+	// DiffTypeUpdate is a doc whose content differs
+	DiffTypeUpdate DiffType = "update"
+	// DiffTypeDelete is a doc only existing on the target
+	DiffTypeDelete DiffType = "delete"
+)
+
+type DocDiff struct {
+	ID   string
+	Type DiffType
+}
+
 type DiffResult struct {
 	SameCount   atomic.Uint64
 	CreateCount atomic.Uint64
@@ -738,6 +1305,12 @@ type DiffResult struct {
 	updateLock sync.Mutex
 
 	DeleteDocs []string
+
+	// SettingsDiff is the diff of the index settings and mappings, only compared when asked
+	SettingsDiff *SettingsDiffResult
+
+	// migrator compared the docs, ToBulkFile reads them again with it
+	migrator *Migrator
 }
 
 func (diffResult *DiffResult) toStr() string {
@@ -745,25 +1318,104 @@ func (diffResult *DiffResult) toStr() string {
 		diffResult.SameCount.Load(), diffResult.CreateCount.Load(), diffResult.UpdateCount.Load(),
 		diffResult.DeleteCount.Load(), diffResult.Total(), diffResult.Percent())
 }
-func (diffResult *DiffResult) addUpdateDoc(docId string) {
-	diffResult.UpdateCount.Add(1)
+func (diffResult *DiffResult) count(diffType DiffType) {
+	switch diffType {
+	case DiffTypeCreate:
+		diffResult.CreateCount.Add(1)
+	case DiffTypeUpdate:
+		diffResult.UpdateCount.Add(1)
+	case DiffTypeDelete:
+		diffResult.DeleteCount.Add(1)
+	}
+}
+
+func (diffResult *DiffResult) appendDoc(diff DocDiff) {
+	diffResult.updateLock.Lock()
+	defer diffResult.updateLock.Unlock()
+
+	switch diff.Type {
+	case DiffTypeCreate:
+		diffResult.CreateDocs = append(diffResult.CreateDocs, diff.ID)
+	case DiffTypeUpdate:
+		diffResult.UpdateDocs = append(diffResult.UpdateDocs, diff.ID)
+	case DiffTypeDelete:
+		diffResult.DeleteDocs = append(diffResult.DeleteDocs, diff.ID)
+	}
+}
+
+// Merge adds the counts and the docs of other, to sum the results of several runs
+func (diffResult *DiffResult) Merge(other *DiffResult) {
+	diffResult.SameCount.Add(other.SameCount.Load())
+	diffResult.CreateCount.Add(other.CreateCount.Load())
+	diffResult.UpdateCount.Add(other.UpdateCount.Load())
+	diffResult.DeleteCount.Add(other.DeleteCount.Load())
+
 	diffResult.updateLock.Lock()
 	defer diffResult.updateLock.Unlock()
-	diffResult.UpdateDocs = append(diffResult.UpdateDocs, docId)
+	diffResult.CreateDocs = append(diffResult.CreateDocs, other.CreateDocs...)
+	diffResult.UpdateDocs = append(diffResult.UpdateDocs, other.UpdateDocs...)
+	diffResult.DeleteDocs = append(diffResult.DeleteDocs, other.DeleteDocs...)
+	if other.SettingsDiff != nil {
+		diffResult.SettingsDiff = other.SettingsDiff
+	}
+}
+
+// counts copies the counts of the result without its docs
+func (diffResult *DiffResult) counts() *DiffResult {
+	counts := &DiffResult{}
+	counts.SameCount.Store(diffResult.SameCount.Load())
+	counts.CreateCount.Store(diffResult.CreateCount.Load())
+	counts.UpdateCount.Store(diffResult.UpdateCount.Load())
+	counts.DeleteCount.Store(diffResult.DeleteCount.Load())
+	return counts
 }
 
-func (diffResult *DiffResult) addCreateDoc(docId string) {
-	diffResult.CreateCount.Add(1)
-	diffResult.CreateDocs = append(diffResult.CreateDocs, docId)
+type diffResultJSON struct {
+	SameCount   uint64   `json:"same_count"`
+	CreateCount uint64   `json:"create_count"`
+	UpdateCount uint64   `json:"update_count"`
+	DeleteCount uint64   `json:"delete_count"`
+	CreateDocs  []string `json:"create_docs,omitempty"`
+	UpdateDocs  []string `json:"update_docs,omitempty"`
+	DeleteDocs  []string `json:"delete_docs,omitempty"`
+
+	SettingsDiff *SettingsDiffResult `json:"settings_diff,omitempty"`
 }
 
-func (diffResult *DiffResult) addDeleteDoc(docId string) {
-	diffResult.DeleteCount.Add(1)
-	diffResult.DeleteDocs = append(diffResult.DeleteDocs, docId)
+func (diffResult *DiffResult) MarshalJSON() ([]byte, error) {
+	return json.Marshal(&diffResultJSON{
+		SameCount:   diffResult.SameCount.Load(),
+		CreateCount: diffResult.CreateCount.Load(),
+		UpdateCount: diffResult.UpdateCount.Load(),
+		DeleteCount: diffResult.DeleteCount.Load(),
+		CreateDocs:  diffResult.CreateDocs,
+		UpdateDocs:  diffResult.UpdateDocs,
+		DeleteDocs:  diffResult.DeleteDocs,
+
+		SettingsDiff: diffResult.SettingsDiff,
+	})
+}
+
+func (diffResult *DiffResult) UnmarshalJSON(data []byte) error {
+	var value diffResultJSON
+	if err := json.Unmarshal(data, &value); err != nil {
+		return errors.WithStack(err)
+	}
+
+	diffResult.SameCount.Store(value.SameCount)
+	diffResult.CreateCount.Store(value.CreateCount)
+	diffResult.UpdateCount.Store(value.UpdateCount)
+	diffResult.DeleteCount.Store(value.DeleteCount)
+	diffResult.CreateDocs = value.CreateDocs
+	diffResult.UpdateDocs = value.UpdateDocs
+	diffResult.DeleteDocs = value.DeleteDocs
+	diffResult.SettingsDiff = value.SettingsDiff
+	return nil
 }
 
 func (diffResult *DiffResult) HasDiff() bool {
-	return diffResult.CreateCount.Load() > 0 || diffResult.UpdateCount.Load() > 0 || diffResult.DeleteCount.Load() > 0
+	return diffResult.CreateCount.Load() > 0 || diffResult.UpdateCount.Load() > 0 || diffResult.DeleteCount.Load() > 0 ||
+		diffResult.SettingsDiff.HasDiff()
 }
 
 func (diffResult *DiffResult) Total() uint64 {
@@ -775,6 +1427,16 @@ func (diffResult *DiffResult) Percent() float64 {
 }
 
 func (m *Migrator) Compare() (*DiffResult, error) {
+	diffResult, err := collectDiffDocs(m.CompareStream)
+	if diffResult != nil {
+		diffResult.migrator = m
+	}
+	return diffResult, err
+}
+
+// CompareStream passes every diff to callback as it is found instead of keeping the ids, the result only
+// counts them. callback is called by one goroutine at a time.
+func (m *Migrator) CompareStream(callback func(diff DocDiff)) (*DiffResult, error) {
 	if m.err != nil {
 		return nil, errors.WithStack(m.err)
 	}
@@ -788,15 +1450,33 @@ func (m *Migrator) Compare() (*DiffResult, error) {
 		return nil, utils.NewCustomError(utils.NonIndexExisted, "target index %s not existed", m.IndexPair.TargetIndex)
 	}
 
-	diffResult, err := m.compare()
+	diffResult, err := m.compareStream(callback)
 	return diffResult, errors.WithStack(err)
 }
 
 func (m *Migrator) Sync(force bool) error {
+	_, err := m.SyncWithStats(force)
+	return err
+}
+
+// SyncWithStats syncs like Sync and returns what was read and written, the stats are returned on error too
+func (m *Migrator) SyncWithStats(force bool) (*MigrationStats, error) {
+	startTime := time.Now()
+	newMigrator := m.clone()
+	newMigrator.stats = &syncStats{}
+	err := newMigrator.sync(force)
+	return newMigrator.stats.snapshot(time.Since(startTime)), err
+}
+
+func (m *Migrator) sync(force bool) error {
 	if m.err != nil {
 		return errors.WithStack(m.err)
 	}
 
+	if err := m.checkSelfMigration(); err != nil {
+		return errors.WithStack(err)
+	}
+
 	ctx, err := m.buildIndexPairContext()
 	if err != nil {
 		return errors.WithStack(err)
@@ -804,36 +1484,225 @@ func (m *Migrator) Sync(force bool) error {
 
 	utils.GetLogger(m.ctx).Debugf("sync with force: %+v", force)
 
+	if err := m.checkRequiredTarget(m.IndexPair.TargetIndex); err != nil {
+		return errors.WithStack(err)
+	}
+
+	if err := m.checkSpecialFields(ctx); err != nil {
+		return errors.WithStack(err)
+	}
+
+	closed, err := m.SourceES.IndexClosed(ctx, m.IndexPair.SourceIndex)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if closed {
+		switch m.ClosedIndexPolicy {
+		case config.ClosedIndexPolicySkip:
+			utils.GetLogger(ctx).Warnf("skip the closed source index %s", m.IndexPair.SourceIndex)
+			return nil
+		case config.ClosedIndexPolicyOpen:
+			if err := m.SourceES.OpenIndex(ctx, m.IndexPair.SourceIndex); err != nil {
+				return errors.WithStack(err)
+			}
+			utils.GetLogger(ctx).Infof("opened the closed source index %s, it is closed again after the sync",
+				m.IndexPair.SourceIndex)
+			defer func() {
+				// the source is closed again even when the sync timed out
+				if err := m.SourceES.CloseIndex(context.WithoutCancel(ctx), m.IndexPair.SourceIndex); err != nil {
+					utils.GetLogger(ctx).Errorf("close the source index %s again %+v", m.IndexPair.SourceIndex, err)
+				}
+			}()
+		default:
+			return utils.NewCustomError(utils.IndexClosed, "source index %s is closed, open it or set the closed index policy",
+				m.IndexPair.SourceIndex)
+		}
+	}
+
+	sourceCount, err := m.SourceES.Count(ctx, m.IndexPair.SourceIndex)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	// an empty source only needs the target index, there is nothing to scroll
+	if sourceCount == 0 {
+		if err := m.copyIndexSettings(ctx, m.IndexPair.TargetIndex, force); err != nil {
+			return errors.WithStack(err)
+		}
+		utils.GetLogger(ctx).Infof("source index %s is empty, synced 0 docs", m.IndexPair.SourceIndex)
+		return nil
+	}
+
 	if force {
 		if err := m.copyIndexSettings(ctx, m.IndexPair.TargetIndex, force); err != nil {
 			utils.GetLogger(m.GetCtx()).Errorf("copy index settings %+v", err)
 		}
 	}
-	if err := m.syncUpsert(ctx, getQueryMap(m.Ids), es2.OperationCreate); err != nil {
+	operation := es2.OperationCreate
+	if m.ConflictPolicy == config.ConflictPolicySkipExisting {
+		operation = es2.OperationCreateOnly
+	}
+	if err := m.syncUpsert(ctx, getQueryMap(m.Ids), m.MaxDocs, operation); err != nil {
+		return errors.WithStack(err)
+	}
+	if m.VerifyCount && m.Rollup == nil {
+		return m.verifyCount(ctx)
+	}
+	return nil
+}
+
+// verifyCount fails when the target counts fewer docs of the synced query than the source, capped by MaxDocs like
+// the sync. The target may hold more docs, e.g. ones written before the sync.
+func (m *Migrator) verifyCount(ctx context.Context) error {
+	query := getQueryMap(m.Ids)
+	sourceCount, err := m.count(ctx, m.SourceES, m.IndexPair.SourceIndex, query)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if m.MaxDocs > 0 {
+		sourceCount = min(sourceCount, uint64(m.MaxDocs))
+	}
+
+	targetCount, err := m.count(ctx, m.TargetES, m.IndexPair.TargetIndex, query)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if targetCount < sourceCount {
+		return utils.NewCustomError(utils.CountMismatch, "target index %s has %d docs, source index %s has %d",
+			m.IndexPair.TargetIndex, targetCount, m.IndexPair.SourceIndex, sourceCount)
+	}
+	utils.GetLogger(ctx).Infof("verified %d docs in target index %s", targetCount, m.IndexPair.TargetIndex)
+	return nil
+}
+
+// RefreshTarget makes the docs written to the target index visible to search and count
+func (m *Migrator) RefreshTarget() error {
+	if m.err != nil {
+		return errors.WithStack(m.err)
+	}
+
+	return errors.WithStack(m.TargetES.Refresh(m.GetCtx(), m.IndexPair.TargetIndex))
+}
+
+// ReindexInPlace copies the source index into the target index with the `_reindex` api of the cluster, which is far
+// cheaper than a sync scrolling the docs through the client. The source and target must be the same cluster, the ids
+// and the slice size of the migrator apply and it returns once the reindex task finished.
+func (m *Migrator) ReindexInPlace() (*MigrationStats, error) {
+	if m.err != nil {
+		return nil, errors.WithStack(m.err)
+	}
+
+	if err := m.checkSelfMigration(); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if len(m.sharedAddresses()) == 0 {
+		return nil, utils.NewCustomError(utils.InvalidParams,
+			"reindex %s in place requires the source and target on the same cluster", m.IndexPair.SourceIndex)
+	}
+
+	startTime := time.Now()
+	utils.GetLogger(m.GetCtx()).Infof("reindex %s into %s in place", m.IndexPair.SourceIndex, m.IndexPair.TargetIndex)
+	result, err := m.SourceES.Reindex(m.GetCtx(), m.IndexPair.SourceIndex, m.IndexPair.TargetIndex, &es2.ReindexOption{
+		Query:  getQueryMap(m.Ids),
+		Slices: m.SliceSize,
+	})
+	if result == nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return &MigrationStats{
+		DocsRead:    result.Total,
+		DocsWritten: result.Created + result.Updated,
+		DocsFailed:  uint64(len(result.Failures)),
+		Duration:    time.Since(startTime),
+	}, errors.WithStack(err)
+}
+
+// ForceMergeTarget merges the target index down to segments, it is expensive and meant to run off-peak after a sync
+func (m *Migrator) ForceMergeTarget(segments uint) error {
+	if m.err != nil {
+		return errors.WithStack(m.err)
+	}
+
+	utils.GetLogger(m.GetCtx()).Infof("force merge %s to %d segments", m.IndexPair.TargetIndex, segments)
+	if err := m.TargetES.ForceMerge(m.GetCtx(), m.IndexPair.TargetIndex, segments); err != nil {
 		return errors.WithStack(err)
 	}
+
+	// segments is per shard, the stats only tell whether the merge got rid of the deleted docs
+	stats, err := m.TargetES.GetIndexStats(m.GetCtx(), m.IndexPair.TargetIndex)
+	if err != nil {
+		utils.GetLogger(m.GetCtx()).Warnf("get the stats of %s: %+v", m.IndexPair.TargetIndex, err)
+		return nil
+	}
+	utils.GetLogger(m.GetCtx()).Infof("%s has %d segments and %d deleted docs after the force merge",
+		m.IndexPair.TargetIndex, stats.SegmentsCount, stats.DocsDeleted)
 	return nil
 }
 
+// SwapAlias moves the alias from the indices it points to onto the target index in one atomic `_aliases` request,
+// so the readers and writers of the alias switch at once. Sync never swaps, call it once the sync succeeded and the
+// target is verified, e.g. by Compare.
+func (m *Migrator) SwapAlias(aliasName string) error {
+	if m.err != nil {
+		return errors.WithStack(m.err)
+	}
+
+	if aliasName == "" {
+		return utils.NewCustomError(utils.InvalidParams, "alias name is empty")
+	}
+
+	existed, err := m.TargetES.IndexExisted(m.IndexPair.TargetIndex)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if !existed {
+		return utils.NewCustomError(utils.NonIndexExisted, "target index %s not existed", m.IndexPair.TargetIndex)
+	}
+
+	oldIndexes, err := m.TargetES.GetAliasIndexes(m.GetCtx(), aliasName)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	actions := lo.FilterMap(oldIndexes, func(index string, _ int) (es2.AliasAction, bool) {
+		return es2.AliasAction{Alias: aliasName, Index: index, Remove: true}, index != m.IndexPair.TargetIndex
+	})
+	actions = append(actions, es2.AliasAction{Alias: aliasName, Index: m.IndexPair.TargetIndex})
+
+	utils.GetLogger(m.GetCtx()).Infof("swap alias %s from %v to %s", aliasName, oldIndexes, m.IndexPair.TargetIndex)
+	return errors.WithStack(m.TargetES.UpdateAliases(m.GetCtx(), actions))
+}
+
 func (m *Migrator) searchSingleSlice(ctx context.Context, wg *sync.WaitGroup, es es2.ES,
 	index string, query map[string]interface{}, sortFields []string,
-	sliceId *uint, sliceSize *uint, docCh chan *es2.Doc, errCh chan error, needHash bool) {
+	sliceId *uint, sliceSize *uint, maxDocs uint, emitted *atomic.Uint64, docCh chan *es2.Doc, errCh chan error, needHash bool) {
 
 	utils.GoRecovery(m.GetCtx(), func() {
 		var (
-			scrollResult *es2.ScrollResult
-			err          error
+			scrollResult      *es2.ScrollResult
+			scrollId          string
+			err               error
+			releaseScrollSlot = func() {}
 		)
+		// the slice is done once its scroll is cleared, so that a finished search leaves no scroll open. The last id is
+		// kept, a scroll page failing on a cancelled context has none but its scroll is still open.
 		defer func() {
-			wg.Done()
-			if scrollResult != nil {
-				if err := es.ClearScroll(scrollResult.ScrollId); err != nil {
+			if scrollId != "" {
+				if err := es.ClearScroll(scrollId); err != nil {
 					utils.GetLogger(m.GetCtx()).Errorf("clear scroll %+v", err)
 				}
 			}
+			releaseScrollSlot()
+			wg.Done()
 		}()
 
 		func() {
+			if releaseScrollSlot, err = m.acquireScrollSlot(ctx, es); err != nil {
+				errCh <- errors.WithStack(err)
+				return
+			}
 			scrollResult, err = es.NewScroll(ctx, index, &es2.ScrollOption{
 				Query:      query,
 				SortFields: sortFields,
@@ -841,6 +1710,8 @@ func (m *Migrator) searchSingleSlice(ctx context.Context, wg *sync.WaitGroup, es
 				ScrollTime: m.ScrollTime,
 				SliceId:    sliceId,
 				SliceSize:  sliceSize,
+
+				TrackTotalHits: m.TrackTotalHits,
 			})
 
 			if err != nil {
@@ -851,12 +1722,12 @@ func (m *Migrator) searchSingleSlice(ctx context.Context, wg *sync.WaitGroup, es
 			if scrollResult == nil {
 				return
 			}
-
+			scrollId = scrollResult.ScrollId
 		}()
 
 		for {
 			if scrollResult == nil || len(scrollResult.Docs) <= 0 {
-				utils.GetLogger(m.GetCtx()).Infof("scroll slice %d exit", lo.Ternary(sliceId != nil, *sliceId, 0))
+				utils.GetLogger(m.GetCtx()).Infof("scroll slice %d exit", lo.FromPtr(sliceId))
 				break
 			}
 
@@ -872,24 +1743,92 @@ func (m *Migrator) searchSingleSlice(ctx context.Context, wg *sync.WaitGroup, es
 				return doc
 			})
 
+			reachMaxDocs := false
 			for _, doc := range scrollResult.Docs {
+				if maxDocs > 0 && emitted.Add(1) > uint64(maxDocs) {
+					reachMaxDocs = true
+					break
+				}
 				docCh <- doc
 			}
 
-			if scrollResult, err = es.NextScroll(ctx, scrollResult.ScrollId, m.ScrollTime); err != nil {
+			if reachMaxDocs {
+				utils.GetLogger(m.GetCtx()).Infof("scroll slice %d reach max docs %d", lo.FromPtr(sliceId), maxDocs)
+				break
+			}
+
+			// a paused slice holds its scroll, which expires once the pause outlasts the scroll time
+			m.pause.wait(ctx)
+			if scrollResult, err = es.NextScroll(ctx, scrollId, m.ScrollTime); err != nil {
 				utils.GetLogger(m.GetCtx()).Errorf("searchSingleSlice error: %+v", err)
 				errCh <- errors.WithStack(err)
 			}
+			if scrollResult != nil && scrollResult.ScrollId != "" {
+				scrollId = scrollResult.ScrollId
+			}
 		}
 	})
 }
 
+// count estimates the number of documents matched by query. If the cluster rejects a count with query,
+// it falls back to the total reported by the first scroll page.
+func (m *Migrator) count(ctx context.Context, es es2.ES, index string, query map[string]interface{}) (uint64, error) {
+	if len(query) <= 0 {
+		return es.Count(ctx, index)
+	}
+
+	total, err := es.CountByQuery(ctx, index, query)
+	if err == nil {
+		return total, nil
+	}
+	utils.GetLogger(ctx).Warnf("count by query %s failed, fallback to scroll total: %+v", index, err)
+
+	releaseScrollSlot, err := m.acquireScrollSlot(ctx, es)
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+	defer releaseScrollSlot()
+	scrollResult, err := es.NewScroll(ctx, index, &es2.ScrollOption{
+		Query:          query,
+		ScrollSize:     1,
+		ScrollTime:     m.ScrollTime,
+		TrackTotalHits: m.TrackTotalHits,
+	})
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+
+	if err := es.ClearScroll(scrollResult.ScrollId); err != nil {
+		utils.GetLogger(ctx).Errorf("clear scroll %+v", err)
+	}
+	return scrollResult.Total, nil
+}
+
+func (m *Migrator) getSliceSize(es es2.ES, index string) uint {
+	if !m.AutoSlice {
+		return m.SliceSize
+	}
+
+	settings, err := es.GetIndexSettings(index)
+	if err != nil {
+		utils.GetLogger(m.GetCtx()).Warnf("get index %s settings for auto slice %+v", index, err)
+		return m.SliceSize
+	}
+
+	shards := cast.ToUint(es2.GetIndexSettingValue(settings, index, "number_of_shards"))
+	if shards <= 0 {
+		return m.SliceSize
+	}
+	return min(shards, maxAutoSliceSize)
+}
+
 func (m *Migrator) search(ctx context.Context, es es2.ES, index string, query map[string]interface{},
-	sortFields []string, errCh chan error, needHash bool) (chan *es2.Doc, uint64) {
+	sortFields []string, maxDocs uint, errCh chan error, needHash bool) (chan *es2.Doc, uint64) {
 	docCh := make(chan *es2.Doc, m.BufferCount)
 	var wg sync.WaitGroup
+	var emitted atomic.Uint64
 
-	total, err := es.Count(m.GetCtx(), index)
+	total, err := m.count(m.GetCtx(), es, index, query)
 	if err != nil {
 		errCh <- errors.WithStack(err)
 		close(errCh)
@@ -897,18 +1836,36 @@ func (m *Migrator) search(ctx context.Context, es es2.ES, index string, query ma
 		return nil, 0
 	}
 
-	if m.SliceSize <= 1 {
+	if maxDocs > 0 && total > uint64(maxDocs) {
+		total = uint64(maxDocs)
+	}
+
+	sliceSize := m.getSliceSize(es, index)
+	var readers *pond.WorkerPool
+	if sliceSize <= 1 {
 		wg.Add(1)
-		m.searchSingleSlice(ctx, &wg, es, index, query, sortFields, nil, nil, docCh, errCh, needHash)
+		m.searchSingleSlice(ctx, &wg, es, index, query, sortFields, nil, nil, maxDocs, &emitted, docCh, errCh, needHash)
 	} else {
-		for i := uint(0); i < m.SliceSize; i++ {
+		readers = pond.New(cast.ToInt(lo.Ternary(m.ReadParallel > 0, min(m.ReadParallel, sliceSize), sliceSize)),
+			cast.ToInt(sliceSize))
+		for i := uint(0); i < sliceSize; i++ {
 			idx := i
 			wg.Add(1)
-			m.searchSingleSlice(ctx, &wg, es, index, query, sortFields, &idx, &m.SliceSize, docCh, errCh, needHash)
+			// the reader is busy until its slice is scrolled and cleared
+			readers.Submit(func() {
+				defer wg.Done()
+				var sliceWg sync.WaitGroup
+				sliceWg.Add(1)
+				m.searchSingleSlice(ctx, &sliceWg, es, index, query, sortFields, &idx, &sliceSize, maxDocs, &emitted, docCh, errCh, needHash)
+				sliceWg.Wait()
+			})
 		}
 	}
 	utils.GoRecovery(m.GetCtx(), func() {
 		wg.Wait()
+		if readers != nil {
+			readers.StopAndWait()
+		}
 		close(docCh)
 	})
 
@@ -916,61 +1873,217 @@ func (m *Migrator) search(ctx context.Context, es es2.ES, index string, query ma
 }
 
 func (m *Migrator) singleBulkWorker(docCh <-chan *es2.Doc, index string, total uint64, count *atomic.Uint64,
-	operation es2.Operation, errCh chan error) {
-	var buf bytes.Buffer
+	startTime time.Time, operation es2.Operation, errCh chan error) {
+	var batch bulkWriter = newBulkBatch(m.BulkDedup, m.DeadLetter != nil)
+	if m.StreamBulk && !m.BulkDedup && !m.VerifyCount {
+		batch = newBulkStream(m.streamBulk, m.DeadLetter != nil)
+	}
+	defer batch.release()
 
 	lastPrintTime := time.Now()
 	for {
+		if m.pause.isPaused() {
+			// a running stream request would idle through the pause, it is ended first
+			if _, ok := batch.(*bulkStream); ok && batch.Len() > 0 {
+				m.flushBulk(batch, false, errCh)
+			}
+			m.pause.wait(m.GetCtx())
+		}
 		v, ok := <-docCh
 		if !ok {
 			break
 		}
+		// a full batch is sent once the next doc comes, so the last bulk is the one sent after the loop
+		if batch.Len() >= cast.ToInt(m.ActionSize)*1024*1024 {
+			m.flushBulk(batch, false, errCh)
+		}
 		v.Op = operation
+		if m.TargetType != "" && !m.TargetES.ClusterVersionGte7() {
+			v.Type = m.TargetType
+		}
+		if m.AutoGenerateIds && (operation == es2.OperationCreate || operation == es2.OperationCreateOnly) {
+			v.ID = ""
+		}
 		count.Add(1)
+		m.stats.read()
+		if m.docProgress != nil {
+			m.docProgress.add(1)
+		}
 		percent := cast.ToFloat32(count.Load()) / cast.ToFloat32(total)
 
 		if time.Now().Sub(lastPrintTime) > everyLogTime {
-			utils.GetLogger(m.GetCtx()).Infof("bulk progress %.4f (%d, %d, %d)",
-				percent, count.Load(), total, len(docCh))
+			utils.GetLogger(m.GetCtx()).Infof("bulk progress %.4f (%d, %d, %d), eta %s",
+				percent, count.Load(), total, len(docCh), estimateRemaining(startTime, count.Load(), total))
 			lastPrintTime = time.Now()
 		}
-		switch operation {
-		case es2.OperationCreate:
-			if err := m.TargetES.BulkBody(index, &buf, v); err != nil {
-				errCh <- errors.WithStack(err)
+		routing := v.Routing
+		if len(m.FieldCoercions) > 0 && operation != es2.OperationDelete {
+			coercedDoc, err := coerceDoc(v, m.FieldCoercions)
+			if err != nil {
+				m.stats.failed(1)
+				m.deadLetterDoc(index, v, err, errCh)
+				continue
 			}
-		case es2.OperationUpdate:
-			if err := m.TargetES.BulkBody(index, &buf, v); err != nil {
-				errCh <- errors.WithStack(err)
+			v = coercedDoc
+		}
+		if m.DocTransformer != nil && operation != es2.OperationDelete {
+			if v = m.DocTransformer(v); v == nil {
+				continue
 			}
-		case es2.OperationDelete:
-			if err := m.TargetES.BulkBody(index, &buf, v); err != nil {
+		}
+		if len(m.joinFields) > 0 {
+			keepJoinRouting(v, routing, m.joinFields)
+		}
+		switch operation {
+		case es2.OperationCreate, es2.OperationCreateOnly, es2.OperationUpdate, es2.OperationDelete:
+			if err := batch.add(m.TargetES, index, v); err != nil {
+				m.stats.failed(1)
 				errCh <- errors.WithStack(err)
 			}
 		default:
 			utils.GetLogger(m.ctx).Error("unknown operation")
 		}
+	}
 
-		if buf.Len() >= cast.ToInt(m.ActionSize)*1024*1024 {
-			if err := m.TargetES.Bulk(&buf); err != nil {
-				errCh <- errors.WithStack(err)
-			}
-			buf.Reset()
-		}
+	if batch.Len() > 0 {
+		m.flushBulk(batch, true, errCh)
 	}
+}
 
-	if buf.Len() > 0 {
-		if err := m.TargetES.Bulk(&buf); err != nil {
+// flushBulk sends the batch to the target and resets it, last tells it is the last batch of the bulk worker
+func (m *Migrator) flushBulk(batch bulkWriter, last bool, errCh chan error) {
+	docs, size := batch.docCount(), batch.Len()
+	m.BulkMetrics.observeDocs(docs)
+	var err error
+	switch batch := batch.(type) {
+	case *bulkStream:
+		err = batch.close()
+	case *bulkBatch:
+		m.pause.wait(m.GetCtx())
+		err = m.bulk(batch.body(), last)
+	}
+
+	var bulkError *es2.BulkError
+	switch {
+	case errors.As(err, &bulkError):
+		m.stats.written(docs-len(bulkError.Items), size)
+		m.stats.failed(len(bulkError.Items))
+		m.deadLetter(batch, bulkError, errCh)
+	case err != nil:
+		m.stats.failed(docs)
+		errCh <- errors.WithStack(err)
+	default:
+		m.stats.written(docs, size)
+	}
+	batch.reset()
+}
+
+// bulk sends the body once a bulk slot is free, it protects the bulk thread pool of the target
+func (m *Migrator) bulk(body *bytes.Buffer, last bool) error {
+	defer m.acquireBulkSlot()()
+	defer m.BulkMetrics.startRequest()()
+	option := m.writeOption()
+	option.Refresh = m.bulkRefresh()
+	if last && m.VerifyCount && option.Refresh == "" {
+		// the verification counts once the docs of the last bulk are visible
+		option.Refresh = "wait_for"
+	}
+	return m.TargetES.Bulk(body, option)
+}
+
+// streamBulk sends the body of a bulkStream, the request holds its bulk slot until the stream is closed
+func (m *Migrator) streamBulk(body io.Reader) error {
+	defer m.acquireBulkSlot()()
+	defer m.BulkMetrics.startRequest()()
+	option := m.writeOption()
+	option.Refresh = m.bulkRefresh()
+	return m.TargetES.BulkStream(body, option)
+}
+
+// bulkRefresh is the refresh param of BulkRefreshPolicy, none sends no param
+func (m *Migrator) bulkRefresh() string {
+	if m.BulkRefreshPolicy == config.BulkRefreshPolicyNone {
+		return ""
+	}
+	return string(m.BulkRefreshPolicy)
+}
+
+func (m *Migrator) writeOption() *es2.WriteOption {
+	return &es2.WriteOption{WaitForActiveShards: m.WaitForActiveShards}
+}
+
+// acquireBulkSlot waits for a free bulk slot and returns the func giving it back
+func (m *Migrator) acquireBulkSlot() func() {
+	if m.bulkSlots == nil {
+		return func() {}
+	}
+	m.bulkSlots <- struct{}{}
+	return func() {
+		<-m.bulkSlots
+	}
+}
+
+// acquireScrollSlot waits for a free scroll slot before a scroll is opened on es and returns the func giving it
+// back once the scroll is cleared. Only the scrolls of the source are bounded.
+func (m *Migrator) acquireScrollSlot(ctx context.Context, es es2.ES) (func(), error) {
+	if m.scrollSlots == nil || es != m.SourceES {
+		return func() {}, nil
+	}
+	select {
+	case m.scrollSlots <- struct{}{}:
+		return func() {
+			<-m.scrollSlots
+		}, nil
+	case <-ctx.Done():
+		return func() {}, errors.WithStack(ctx.Err())
+	}
+}
+
+// deadLetter hands the rejected docs of a bulk request to the dead letter sink
+func (m *Migrator) deadLetter(batch bulkWriter, bulkError *es2.BulkError, errCh chan error) {
+	if m.DeadLetter == nil {
+		utils.GetLogger(m.GetCtx()).Warnf("drop the rejected docs, %s", bulkError.Error())
+		return
+	}
+
+	for _, item := range bulkError.Items {
+		doc := batch.sentDoc(item.Position)
+		if doc == nil {
+			continue
+		}
+		if err := m.DeadLetter.Write(item.Index, doc, item); err != nil {
 			errCh <- errors.WithStack(err)
+			return
 		}
-		buf.Reset()
 	}
 }
 
+// deadLetterDoc hands a doc which is not sent to the dead letter sink with the reason
+func (m *Migrator) deadLetterDoc(index string, doc *es2.Doc, reason error, errCh chan error) {
+	if m.DeadLetter == nil {
+		utils.GetLogger(m.GetCtx()).Warnf("drop the doc %s, %s", doc.ID, reason.Error())
+		return
+	}
+	if err := m.DeadLetter.Write(index, doc, reason); err != nil {
+		errCh <- errors.WithStack(err)
+	}
+}
+
+// estimateRemaining extrapolates the remaining time from the average rate since startTime.
+func estimateRemaining(startTime time.Time, done uint64, total uint64) time.Duration {
+	if done <= 0 || done >= total {
+		return 0
+	}
+	elapsed := time.Since(startTime)
+	return time.Duration(float64(elapsed) / float64(done) * float64(total-done)).Round(time.Second)
+}
+
 func (m *Migrator) getOperationTitle(operation es2.Operation) string {
 	switch operation {
 	case es2.OperationCreate:
 		return "create"
+	case es2.OperationCreateOnly:
+		return "create_only"
 	case es2.OperationUpdate:
 		return "update"
 	case es2.OperationDelete:
@@ -982,16 +2095,17 @@ func (m *Migrator) getOperationTitle(operation es2.Operation) string {
 func (m *Migrator) bulkWorker(docCh <-chan *es2.Doc, index string, total uint64, operation es2.Operation, errCh chan error) {
 	var wg sync.WaitGroup
 	var count atomic.Uint64
+	startTime := time.Now()
 
 	if m.ActionParallelism <= 1 {
-		m.singleBulkWorker(docCh, index, total, &count, operation, errCh)
+		m.singleBulkWorker(docCh, index, total, &count, startTime, operation, errCh)
 	}
 
 	wg.Add(cast.ToInt(m.ActionParallelism))
 	for i := 0; i < cast.ToInt(m.ActionParallelism); i++ {
 		utils.GoRecovery(m.ctx, func() {
 			defer wg.Done()
-			m.singleBulkWorker(docCh, index, total, &count, operation, errCh)
+			m.singleBulkWorker(docCh, index, total, &count, startTime, operation, errCh)
 		})
 	}
 
@@ -1071,7 +2185,7 @@ func (m *Migrator) bulkFileWorker(doc <-chan *es2.Doc, total uint64, files []str
 		percent, count.Load(), total, len(doc))
 }
 
-func (m *Migrator) syncUpsert(ctx context.Context, query map[string]interface{}, operation es2.Operation) error {
+func (m *Migrator) syncUpsert(ctx context.Context, query map[string]interface{}, maxDocs uint, operation es2.Operation) error {
 	errCh := make(chan error)
 	errsCh := m.handleMultipleErrors(errCh)
 
@@ -1080,9 +2194,18 @@ func (m *Migrator) syncUpsert(ctx context.Context, query map[string]interface{},
 		total uint64
 	)
 	if operation == es2.OperationDelete {
-		docCh, total = m.search(ctx, m.TargetES, m.IndexPair.SourceIndex, query, nil, errCh, false)
+		docCh, total = m.search(ctx, m.TargetES, m.IndexPair.TargetIndex, query, nil, maxDocs, errCh, false)
 	} else {
-		docCh, total = m.search(ctx, m.SourceES, m.IndexPair.SourceIndex, query, nil, errCh, false)
+		docCh, total = m.search(ctx, m.SourceES, m.IndexPair.SourceIndex, query, m.SortFields, maxDocs, errCh, false)
+		if docCh != nil && m.ConflictPolicy == config.ConflictPolicyNewerWins {
+			docCh = m.skipNewerTargetDocs(ctx, docCh, errCh)
+		}
+		if docCh != nil && m.Rollup != nil {
+			docCh = m.rollupDocs(ctx, docCh)
+		}
+	}
+	if m.docProgress != nil {
+		m.docProgress.addTotal(total)
 	}
 	m.bulkWorker(docCh, m.IndexPair.TargetIndex, total, operation, errCh)
 	close(errCh)
@@ -1090,7 +2213,56 @@ func (m *Migrator) syncUpsert(ctx context.Context, query map[string]interface{},
 	return errs.Ret()
 }
 
+// checkSelfMigration fails when the index pair reads and writes the same index of a cluster, the clusters are the
+// same when they share an address
+func (m *Migrator) checkSelfMigration() error {
+	if m.AllowSelf || m.IndexPair == nil || m.IndexPair.SourceIndex != m.IndexPair.TargetIndex {
+		return nil
+	}
+
+	sharedAddresses := m.sharedAddresses()
+	if len(sharedAddresses) == 0 {
+		return nil
+	}
+	return utils.NewCustomError(utils.SelfMigration,
+		"source and target are the same index %s of the cluster at %s, allow self to migrate it in place",
+		m.IndexPair.SourceIndex, sharedAddresses[0])
+}
+
+// sharedAddresses are the addresses of both the source and the target, the clusters are the same when there is one
+func (m *Migrator) sharedAddresses() []string {
+	normalize := func(address string, _ int) string {
+		return strings.ToLower(strings.TrimRight(strings.TrimSpace(address), "/"))
+	}
+	return lo.Intersect(lo.Map(m.SourceES.GetAddresses(), normalize), lo.Map(m.TargetES.GetAddresses(), normalize))
+}
+
+// checkRequiredTarget fails when the target index is required to exist and does not
+func (m *Migrator) checkRequiredTarget(targetIndex string) error {
+	if !m.RequireExistingTarget {
+		return nil
+	}
+
+	existed, err := m.TargetES.IndexExisted(targetIndex)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if !existed {
+		return utils.NewCustomError(utils.NonIndexExisted,
+			"target index %s not existed, it is required to be created before the migration", targetIndex)
+	}
+	return nil
+}
+
 func (m *Migrator) copyIndexSettings(ctx context.Context, targetIndex string, force bool) error {
+	if m.RequireExistingTarget {
+		return errors.WithStack(m.checkRequiredTarget(targetIndex))
+	}
+
+	if err := es2.ValidateIndexName(targetIndex); err != nil {
+		return errors.WithStack(err)
+	}
+
 	existed, err := m.TargetES.IndexExisted(targetIndex)
 	if err != nil {
 		return errors.WithStack(err)
@@ -1100,17 +2272,25 @@ func (m *Migrator) copyIndexSettings(ctx context.Context, targetIndex string, fo
 		return nil
 	}
 
+	sourceESSetting := utils.GetCtxKeySourceIndexSetting(ctx).(es2.IESSettings)
+	sourceESSetting, droppedTypes, err := es2.TranslateMappingTypes(sourceESSetting, m.TargetES.GetClusterVersion(),
+		m.TargetType, m.MultiTypePolicy)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if len(droppedTypes) > 0 {
+		utils.GetLogger(ctx).Warnf("create %s with the first mapping type, drop the types %v", targetIndex, droppedTypes)
+	}
+
 	if existed {
 		if err := m.TargetES.DeleteIndex(targetIndex); err != nil {
 			return errors.WithStack(err)
 		}
 	}
 
-	sourceESSetting := utils.GetCtxKeySourceIndexSetting(ctx).(es2.IESSettings)
-
 	targetESSetting := m.GetTargetESSetting(sourceESSetting, targetIndex)
 
-	if err := m.TargetES.CreateIndex(targetESSetting); err != nil {
+	if err := m.TargetES.CreateIndex(targetESSetting, m.writeOption()); err != nil {
 		return errors.WithStack(err)
 	}
 
@@ -1356,6 +2536,10 @@ func (m *Migrator) Import(force bool) error {
 		return errors.WithStack(err)
 	}
 
+	if err := m.checkRequiredTarget(m.IndexFilePair.Index); err != nil {
+		return errors.WithStack(err)
+	}
+
 	if force {
 		if err := m.copyIndexSettings(ctx, m.IndexFilePair.Index, force); err != nil {
 			utils.GetLogger(m.GetCtx()).Errorf("copy index settings %+v", err)
@@ -1401,7 +2585,7 @@ func LoadIndexFileSettingFromMap(settingMap map[string]interface{}) (*IndexFileS
 }
 
 func (m *Migrator) saveIndexFileSetting(ctx context.Context) (*IndexFileSetting, error) {
-	total, err := m.SourceES.Count(ctx, m.IndexFilePair.Index)
+	total, err := m.count(ctx, m.SourceES, m.IndexFilePair.Index, getQueryMap(m.Ids))
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
@@ -1456,7 +2640,7 @@ func (m *Migrator) export(ctx context.Context) error {
 	)
 
 	query := getQueryMap(m.Ids)
-	docCh, total = m.search(ctx, m.SourceES, m.IndexFilePair.Index, query, nil, errCh, false)
+	docCh, total = m.search(ctx, m.SourceES, m.IndexFilePair.Index, query, m.SortFields, 0, errCh, false)
 
 	m.bulkFileWorker(docCh, total, indexFileSetting.Files, errCh)
 	close(errCh)
@@ -0,0 +1,1654 @@
+package task
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/CharellKing/ela-lib/config"
+	es2 "github.com/CharellKing/ela-lib/pkg/es"
+	"github.com/CharellKing/ela-lib/utils"
+	"github.com/pkg/errors"
+	"github.com/samber/lo"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cast"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	utils.InitLogger(&config.Config{Level: "error"})
+	os.Exit(m.Run())
+}
+
+// fakeES overrides the methods a test needs; any other call panics on the nil embedded interface.
+type fakeES struct {
+	es2.ES
+
+	count        uint64
+	countErr     error
+	scrollTotal  uint64
+	clearedCount int
+	version      string
+	name         string
+	addresses    []string
+	shards       int
+	hidden       map[string]bool
+	sizes        map[string]uint64
+	blockScroll  map[string]bool
+	// blockNextScroll holds every scroll on its second page until the context is done, blockedScrolls counts them
+	blockNextScroll bool
+	blockedScrolls  int
+	scrollErrs      map[string]error
+	created         []string
+	createErrs      map[string]error
+	// nextScrollErr fails every scroll after its first page
+	nextScrollErr error
+	// hiddenRequests counts the GetHiddenIndexes calls
+	hiddenRequests int
+
+	mu       sync.Mutex
+	docs     map[string][]*es2.Doc
+	scrolls  map[string][]*es2.Doc
+	pageSize int
+	written  map[string]map[string]*es2.Doc
+	bulkIds  []string
+	bulkErr  error
+	// bulkBytes sums the bodies of the bulk requests
+	bulkBytes int
+	// mappings holds the field properties of every index
+	mappings map[string]map[string]interface{}
+	// rejects fails the bulk actions of the doc ids with the reason like a mapping conflict
+	rejects map[string]string
+	// openScrolls counts the scrolls not cleared yet, maxOpenScrolls is its peak
+	openScrolls    int
+	maxOpenScrolls int
+	clearedIds     []string
+	// bulkDelay holds every bulk request, inflightBulks counts them and maxInflightBulks is its peak
+	bulkDelay        time.Duration
+	inflightBulks    int
+	maxInflightBulks int
+	// streamedBulks counts the bulk requests sent by BulkStream
+	streamedBulks int
+	// sortFields are the sort of the last scroll, a numeric "field:asc|desc" sorts the scrolled docs
+	sortFields []string
+	// writeOption is the option of the last bulk or create index request, bulkRefreshes the refresh of every bulk
+	writeOption   *es2.WriteOption
+	bulkRefreshes []string
+	// searchResults are the json answers of SearchByQuery by index, the docs are searched when an index has none
+	searchResults map[string]string
+	// searchedIds are the ids of the searched docs by index, searchedDocs the ones returned with their source
+	searchedIds  map[string][]string
+	searchedDocs map[string][]string
+	// blockSearches holds the searches of an index after that many until the context is done
+	blockSearches map[string]int
+	// closed are the closed indices, which can't be scrolled, stateChanges records the open and close calls
+	closed       map[string]bool
+	stateChanges []string
+	// aliases are the indices of every alias, aliasActions the actions of the last `_aliases` request
+	aliases      map[string][]string
+	aliasActions []es2.AliasAction
+	// scrollFailures fails that many scrolls of every index, deleted are the deleted indices
+	scrollFailures map[string]int
+	deleted        []string
+	// templates are the bodies of the legacy index templates by name
+	templates map[string]map[string]interface{}
+	// reindexes are the `source>dest` of the reindex requests
+	reindexes []string
+}
+
+func newFakeES(docs map[string][]*es2.Doc) *fakeES {
+	return &fakeES{
+		docs:       docs,
+		scrolls:    make(map[string][]*es2.Doc),
+		scrollErrs: make(map[string]error),
+		written:    make(map[string]map[string]*es2.Doc),
+	}
+}
+
+func newFakeDocs(n int) []*es2.Doc {
+	docs := make([]*es2.Doc, 0, n)
+	for i := 0; i < n; i++ {
+		docs = append(docs, &es2.Doc{
+			ID:     fmt.Sprintf("%d", i),
+			Source: map[string]interface{}{"value": i},
+		})
+	}
+	return docs
+}
+
+func (f *fakeES) GetAddresses() []string {
+	return f.addresses
+}
+
+func (f *fakeES) GetClusterName() string {
+	return f.name
+}
+
+func (f *fakeES) GetClusterVersion() string {
+	return lo.Ternary(f.version == "", "7.10.2", f.version)
+}
+
+func (f *fakeES) ClusterVersionGte7() bool {
+	return !strings.HasPrefix(f.GetClusterVersion(), "5.") && !strings.HasPrefix(f.GetClusterVersion(), "6.")
+}
+
+func (f *fakeES) Count(ctx context.Context, index string) (uint64, error) {
+	if f.docs != nil {
+		return uint64(len(f.docs[index])), nil
+	}
+	return f.count, nil
+}
+
+func (f *fakeES) GetIndexes() ([]string, error) {
+	return lo.Keys(f.docs), nil
+}
+
+func (f *fakeES) GetIndexSizes() (map[string]uint64, error) {
+	return f.sizes, nil
+}
+
+func (f *fakeES) GetHiddenIndexes() (map[string]bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.hiddenRequests++
+	return f.hidden, nil
+}
+
+func (f *fakeES) GetIndexSettings(index string) (map[string]interface{}, error) {
+	return map[string]interface{}{
+		index: map[string]interface{}{
+			"settings": map[string]interface{}{
+				"index": map[string]interface{}{
+					"number_of_shards":   fmt.Sprintf("%d", f.shards),
+					"number_of_replicas": "1",
+				},
+			},
+		},
+	}, nil
+}
+
+func (f *fakeES) GetIndexMappingAndSetting(index string) (es2.IESSettings, error) {
+	mappings, _ := f.GetIndexMapping(index)
+	return es2.NewV7Settings(nil, mappings, nil, index), nil
+}
+
+func (f *fakeES) IndexExisted(index string) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, ok := f.docs[index]
+	_, mapped := f.mappings[index]
+	return ok || mapped || lo.Contains(f.created, index), nil
+}
+
+func (f *fakeES) IndexClosed(ctx context.Context, index string) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.closed[index], nil
+}
+
+func (f *fakeES) OpenIndex(ctx context.Context, index string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.closed[index] = false
+	f.stateChanges = append(f.stateChanges, "open "+index)
+	return nil
+}
+
+func (f *fakeES) CloseIndex(ctx context.Context, index string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.closed[index] = true
+	f.stateChanges = append(f.stateChanges, "close "+index)
+	return nil
+}
+
+func (f *fakeES) GetAliasIndexes(ctx context.Context, alias string) ([]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.aliases[alias], nil
+}
+
+func (f *fakeES) UpdateAliases(ctx context.Context, actions []es2.AliasAction) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.aliasActions = actions
+	for _, action := range actions {
+		f.aliases[action.Alias] = lo.Without(f.aliases[action.Alias], action.Index)
+		if !action.Remove {
+			f.aliases[action.Alias] = append(f.aliases[action.Alias], action.Index)
+		}
+	}
+	return nil
+}
+
+func (f *fakeES) Reindex(ctx context.Context, source, dest string, option *es2.ReindexOption) (*es2.ReindexResult, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.reindexes = append(f.reindexes, source+">"+dest)
+	return &es2.ReindexResult{Total: uint64(len(f.docs[source])), Created: uint64(len(f.docs[source]))}, nil
+}
+
+func (f *fakeES) GetTemplate(ctx context.Context, name string) (map[string]interface{}, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.templates[name], nil
+}
+
+func (f *fakeES) CreateTemplate(ctx context.Context, name string, body map[string]interface{}) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.templates == nil {
+		f.templates = make(map[string]map[string]interface{})
+	}
+	f.templates[name] = body
+	return nil
+}
+
+func (f *fakeES) GetIndexMapping(index string) (map[string]interface{}, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return map[string]interface{}{
+		index: map[string]interface{}{
+			"mappings": map[string]interface{}{"properties": lo.Assign(f.mappings[index])},
+		},
+	}, nil
+}
+
+func (f *fakeES) PutMapping(index string, properties map[string]interface{}) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.mappings == nil {
+		f.mappings = make(map[string]map[string]interface{})
+	}
+	if f.mappings[index] == nil {
+		f.mappings[index] = make(map[string]interface{})
+	}
+	mergeFakeMapping(f.mappings[index], properties)
+	return nil
+}
+
+// mergeFakeMapping merges the properties recursively like es does on put mapping
+func mergeFakeMapping(target map[string]interface{}, source map[string]interface{}) {
+	for key, value := range source {
+		targetValue, ok := target[key].(map[string]interface{})
+		sourceValue, isMap := value.(map[string]interface{})
+		if !ok || !isMap {
+			target[key] = value
+			continue
+		}
+		mergeFakeMapping(targetValue, sourceValue)
+	}
+}
+
+func (f *fakeES) CreateIndex(esSetting es2.IESSettings, option *es2.WriteOption) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.writeOption = option
+	if err := f.createErrs[esSetting.GetIndex()]; err != nil {
+		return err
+	}
+	f.created = append(f.created, esSetting.GetIndex())
+	return nil
+}
+
+func (f *fakeES) DeleteIndex(index string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.deleted = append(f.deleted, index)
+	f.created = lo.Without(f.created, index)
+	delete(f.written, index)
+	return nil
+}
+
+func (f *fakeES) SearchByQuery(ctx context.Context, index string, query map[string]interface{}) (map[string]interface{}, error) {
+	answer, ok := f.searchResults[index]
+	if !ok {
+		return f.searchDocs(ctx, index, query)
+	}
+	var result map[string]interface{}
+	if err := json.Unmarshal([]byte(answer), &result); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return result, nil
+}
+
+// searchDocs answers the docs of the ids of a terms query or the page after search_after in `_id` order
+func (f *fakeES) searchDocs(ctx context.Context, index string, query map[string]interface{}) (map[string]interface{}, error) {
+	f.mu.Lock()
+	if limit, ok := f.blockSearches[index]; ok {
+		if limit <= 0 {
+			f.mu.Unlock()
+			<-ctx.Done()
+			return nil, errors.WithStack(ctx.Err())
+		}
+		f.blockSearches[index] = limit - 1
+	}
+	defer f.mu.Unlock()
+
+	if f.searchedIds == nil {
+		f.searchedIds = make(map[string][]string)
+		f.searchedDocs = make(map[string][]string)
+	}
+	ids, _ := utils.GetValueFromMapByPath(query, "query.terms._id")
+	var after string
+	if searchAfter, ok := query["search_after"]; ok {
+		after = cast.ToString(cast.ToSlice(searchAfter)[0])
+	}
+	docs := slices.Clone(f.docs[index])
+	sort.Slice(docs, func(i, j int) bool {
+		return docs[i].ID < docs[j].ID
+	})
+
+	var hits []interface{}
+	for _, doc := range docs {
+		if len(hits) >= cast.ToInt(query["size"]) {
+			break
+		}
+		if (ids != nil && !lo.Contains(ids.([]string), doc.ID)) || (after != "" && doc.ID <= after) {
+			continue
+		}
+		hit := map[string]interface{}{"_id": doc.ID}
+		f.searchedIds[index] = append(f.searchedIds[index], doc.ID)
+		if withSource, ok := query["_source"].(bool); !ok || withSource {
+			hit["_source"] = doc.Source
+			f.searchedDocs[index] = append(f.searchedDocs[index], doc.ID)
+		}
+		hits = append(hits, hit)
+	}
+	return map[string]interface{}{
+		"hits": map[string]interface{}{"total": len(docs), "hits": hits},
+	}, nil
+}
+
+func (f *fakeES) CountByQuery(ctx context.Context, index string, query map[string]interface{}) (uint64, error) {
+	return f.count, f.countErr
+}
+
+func (f *fakeES) NewScroll(ctx context.Context, index string, option *es2.ScrollOption) (*es2.ScrollResult, error) {
+	if f.blockScroll[index] {
+		<-ctx.Done()
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		f.scrollErrs[index] = ctx.Err()
+		return nil, errors.WithStack(ctx.Err())
+	}
+
+	f.mu.Lock()
+	if f.scrollFailures[index] > 0 {
+		f.scrollFailures[index]--
+		f.mu.Unlock()
+		return nil, errors.New("search_phase_execution_exception")
+	}
+	f.mu.Unlock()
+
+	if f.docs == nil {
+		return &es2.ScrollResult{Total: f.scrollTotal, ScrollId: "scroll"}, nil
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	ids, _ := utils.GetValueFromMapByPath(option.Query, "query.terms._id")
+	var docs []*es2.Doc
+	for i, doc := range f.docs[index] {
+		if ids != nil && !lo.Contains(ids.([]string), doc.ID) {
+			continue
+		}
+		if option.SliceId == nil || uint(i)%*option.SliceSize == *option.SliceId {
+			docs = append(docs, doc)
+		}
+	}
+	f.sortFields = option.SortFields
+	if len(option.SortFields) > 0 {
+		field, order, _ := strings.Cut(option.SortFields[0], ":")
+		docs = slices.Clone(docs)
+		sort.SliceStable(docs, func(i, j int) bool {
+			left, right := cast.ToFloat64(docs[i].Source[field]), cast.ToFloat64(docs[j].Source[field])
+			return lo.Ternary(order == "desc", left > right, left < right)
+		})
+	}
+	f.pageSize = int(option.ScrollSize)
+	scrollId := fmt.Sprintf("%s-%d", index, len(f.scrolls))
+	f.scrolls[scrollId] = docs
+	f.openScrolls++
+	f.maxOpenScrolls = max(f.maxOpenScrolls, f.openScrolls)
+	return f.nextPage(scrollId, uint64(len(f.docs[index]))), nil
+}
+
+func (f *fakeES) NextScroll(ctx context.Context, scrollId string, scrollTime uint) (*es2.ScrollResult, error) {
+	if f.blockNextScroll {
+		f.mu.Lock()
+		f.blockedScrolls++
+		f.mu.Unlock()
+		<-ctx.Done()
+		return nil, errors.WithStack(ctx.Err())
+	}
+
+	if f.nextScrollErr != nil {
+		return nil, errors.WithStack(f.nextScrollErr)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.nextPage(scrollId, 0), nil
+}
+
+func (f *fakeES) nextPage(scrollId string, total uint64) *es2.ScrollResult {
+	docs := f.scrolls[scrollId]
+	size := min(f.pageSize, len(docs))
+	f.scrolls[scrollId] = docs[size:]
+	return &es2.ScrollResult{Total: total, Docs: docs[:size], ScrollId: scrollId}
+}
+
+func (f *fakeES) ClearScroll(scrollId string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.clearedCount++
+	f.clearedIds = append(f.clearedIds, scrollId)
+	if _, ok := f.scrolls[scrollId]; ok {
+		f.openScrolls--
+	}
+	return nil
+}
+
+func (f *fakeES) BulkBody(index string, buf *bytes.Buffer, doc *es2.Doc) error {
+	line, _ := json.Marshal(map[string]interface{}{"index": index, "doc": doc})
+	buf.Write(line)
+	buf.WriteByte('\n')
+	return nil
+}
+
+func (f *fakeES) BulkStream(body io.Reader, option *es2.WriteOption) error {
+	buf := &bytes.Buffer{}
+	if _, err := buf.ReadFrom(body); err != nil {
+		return errors.WithStack(err)
+	}
+	f.mu.Lock()
+	f.streamedBulks++
+	f.mu.Unlock()
+	return f.Bulk(buf, option)
+}
+
+func (f *fakeES) Bulk(buf *bytes.Buffer, option *es2.WriteOption) error {
+	f.mu.Lock()
+	f.writeOption = option
+	f.bulkRefreshes = append(f.bulkRefreshes, option.Refresh)
+	f.inflightBulks++
+	f.maxInflightBulks = max(f.maxInflightBulks, f.inflightBulks)
+	f.mu.Unlock()
+	time.Sleep(f.bulkDelay)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.inflightBulks--
+	if f.bulkErr != nil {
+		return f.bulkErr
+	}
+	f.bulkBytes += buf.Len()
+	bulkError := &es2.BulkError{}
+	for position, line := range bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n")) {
+		var action struct {
+			Index string   `json:"index"`
+			Doc   *es2.Doc `json:"doc"`
+		}
+		if err := json.Unmarshal(line, &action); err != nil {
+			return errors.WithStack(err)
+		}
+		if reason, ok := f.rejects[action.Doc.ID]; ok {
+			bulkError.Items = append(bulkError.Items, &es2.BulkItemError{
+				Position: position,
+				Index:    action.Index,
+				ID:       action.Doc.ID,
+				Status:   400,
+				Type:     "mapper_parsing_exception",
+				Reason:   reason,
+			})
+			continue
+		}
+		if f.written[action.Index] == nil {
+			f.written[action.Index] = make(map[string]*es2.Doc)
+		}
+		f.written[action.Index][action.Doc.ID] = action.Doc
+		f.bulkIds = append(f.bulkIds, action.Doc.ID)
+		f.applyDoc(action.Index, action.Doc)
+	}
+	if len(bulkError.Items) > 0 {
+		return bulkError
+	}
+	return nil
+}
+
+// applyDoc stores a bulk written doc so it can be searched afterward, create only keeps an existing doc and delete
+// removes it.
+func (f *fakeES) applyDoc(index string, doc *es2.Doc) {
+	if f.docs == nil {
+		f.docs = make(map[string][]*es2.Doc)
+	}
+	for i, existed := range f.docs[index] {
+		if existed.ID == doc.ID {
+			if doc.Op == es2.OperationDelete {
+				f.docs[index] = slices.Delete(f.docs[index], i, i+1)
+			} else if doc.Op != es2.OperationCreateOnly {
+				f.docs[index][i] = doc
+			}
+			return
+		}
+	}
+	if doc.Op != es2.OperationDelete {
+		f.docs[index] = append(f.docs[index], doc)
+	}
+}
+
+func (f *fakeES) writtenCount(index string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.written[index])
+}
+
+func TestMigratorCount(t *testing.T) {
+	ctx := context.Background()
+	query := getQueryMap([]string{"1", "2"})
+
+	es := &fakeES{count: 10, scrollTotal: 2}
+	m := NewMigrator(ctx, es, es)
+	if total, err := m.count(ctx, es, "idx", nil); err != nil || total != 10 {
+		t.Fatalf("count without query = %d, %v", total, err)
+	}
+	if total, err := m.count(ctx, es, "idx", query); err != nil || total != 10 {
+		t.Fatalf("count with query = %d, %v", total, err)
+	}
+
+	es.countErr = errors.New("unsupported")
+	if total, err := m.count(ctx, es, "idx", query); err != nil || total != 2 {
+		t.Fatalf("count fallback = %d, %v", total, err)
+	}
+	if es.clearedCount != 1 {
+		t.Fatalf("expected fallback scroll to be cleared, got %d", es.clearedCount)
+	}
+}
+
+func TestEstimateRemaining(t *testing.T) {
+	startTime := time.Now().Add(-10 * time.Second)
+	if eta := estimateRemaining(startTime, 0, 100); eta != 0 {
+		t.Fatalf("expected 0 eta without progress, got %s", eta)
+	}
+	if eta := estimateRemaining(startTime, 50, 100); eta < 9*time.Second || eta > 11*time.Second {
+		t.Fatalf("expected about 10s eta, got %s", eta)
+	}
+	if eta := estimateRemaining(startTime, 100, 100); eta != 0 {
+		t.Fatalf("expected 0 eta when done, got %s", eta)
+	}
+}
+
+func TestBulkMigratorWithMaxDocs(t *testing.T) {
+	sourceES := newFakeES(map[string][]*es2.Doc{
+		"large": newFakeDocs(95),
+		"small": newFakeDocs(3),
+	})
+	targetES := newFakeES(nil)
+
+	err := NewBulkMigratorWithES(context.Background(), sourceES, targetES).
+		WithIndexPairs(
+			&config.IndexPair{SourceIndex: "large", TargetIndex: "large"},
+			&config.IndexPair{SourceIndex: "small", TargetIndex: "small"}).
+		WithScrollSize(7).
+		WithSliceSize(4).
+		WithMaxDocs(10).
+		Sync(false)
+	if err != nil {
+		t.Fatalf("sync %+v", err)
+	}
+
+	if count := targetES.writtenCount("large"); count != 10 {
+		t.Fatalf("expected 10 docs written to large, got %d", count)
+	}
+	if count := targetES.writtenCount("small"); count != 3 {
+		t.Fatalf("expected 3 docs written to small, got %d", count)
+	}
+}
+
+func TestMigratorAutoSlice(t *testing.T) {
+	es := newFakeES(nil)
+	es.shards = 5
+	m := NewMigrator(context.Background(), es, es).WithSliceSize(3)
+
+	if sliceSize := m.getSliceSize(es, "idx"); sliceSize != 3 {
+		t.Fatalf("expected configured 3 slices without auto slice, got %d", sliceSize)
+	}
+
+	m = m.WithAutoSlice(true)
+	if sliceSize := m.getSliceSize(es, "idx"); sliceSize != 5 {
+		t.Fatalf("expected 5 slices for 5 shards, got %d", sliceSize)
+	}
+
+	es.shards = 100
+	if sliceSize := m.getSliceSize(es, "idx"); sliceSize != maxAutoSliceSize {
+		t.Fatalf("expected slices capped at %d, got %d", maxAutoSliceSize, sliceSize)
+	}
+}
+
+func TestMigratorWithTargetType(t *testing.T) {
+	for _, targetVersion := range []string{"6.8.23", "7.10.2"} {
+		docs := newFakeDocs(5)
+		for _, doc := range docs {
+			doc.Type = "event"
+		}
+		sourceES := newFakeES(map[string][]*es2.Doc{"idx": docs})
+		sourceES.version = "6.8.23"
+		targetES := newFakeES(nil)
+		targetES.version = targetVersion
+
+		err := NewMigrator(context.Background(), sourceES, targetES).
+			WithIndexPair(config.IndexPair{SourceIndex: "idx", TargetIndex: "idx"}).
+			WithTargetType("doc").
+			Sync(false)
+		if err != nil {
+			t.Fatalf("sync %+v", err)
+		}
+
+		expectedType := lo.Ternary(targetVersion == "6.8.23", "doc", "event")
+		if len(targetES.written["idx"]) != 5 {
+			t.Fatalf("%s written %d docs", targetVersion, len(targetES.written["idx"]))
+		}
+		for _, doc := range targetES.written["idx"] {
+			if doc.Type != expectedType {
+				t.Errorf("%s doc %s type %s, expected %s", targetVersion, doc.ID, doc.Type, expectedType)
+			}
+		}
+	}
+}
+
+func TestMigratorWithAutoGenerateIds(t *testing.T) {
+	for _, autoGenerateIds := range []bool{false, true} {
+		sourceES := newFakeES(map[string][]*es2.Doc{"idx": newFakeDocs(5)})
+		targetES := newFakeES(nil)
+
+		err := NewMigrator(context.Background(), sourceES, targetES).
+			WithIndexPair(config.IndexPair{SourceIndex: "idx", TargetIndex: "idx"}).
+			WithBulkDedup(true).
+			WithAutoGenerateIds(autoGenerateIds).
+			Sync(false)
+		if err != nil {
+			t.Fatalf("sync %+v", err)
+		}
+
+		if len(targetES.bulkIds) != 5 {
+			t.Fatalf("auto generate ids %v: expected 5 bulk docs, got %d", autoGenerateIds, len(targetES.bulkIds))
+		}
+		for _, id := range targetES.bulkIds {
+			if (id == "") != autoGenerateIds {
+				t.Errorf("auto generate ids %v: bulk doc id %q", autoGenerateIds, id)
+			}
+		}
+	}
+}
+
+func TestBulkMigratorWithIndexTimeout(t *testing.T) {
+	sourceES := newFakeES(map[string][]*es2.Doc{
+		"stuck":  newFakeDocs(10),
+		"normal": newFakeDocs(10),
+	})
+	sourceES.blockScroll = map[string]bool{"stuck": true}
+	targetES := newFakeES(nil)
+
+	var report *SyncReport
+	done := make(chan error)
+	go func() {
+		var err error
+		report, err = NewBulkMigratorWithES(context.Background(), sourceES, targetES).
+			WithIndexPairs(
+				&config.IndexPair{SourceIndex: "stuck", TargetIndex: "stuck"},
+				&config.IndexPair{SourceIndex: "normal", TargetIndex: "normal"}).
+			WithSliceSize(1).
+			WithParallelism(1).
+			WithIndexTimeout(100 * time.Millisecond).
+			SyncWithStats(false)
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("sync %+v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("the stuck index blocks the run")
+	}
+
+	if err := report.Failed["stuck:stuck"]; !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expect stuck:stuck reported with the deadline error, got %+v", err)
+	}
+	if err, ok := report.Failed["normal:normal"]; ok {
+		t.Errorf("expect normal:normal not failed, got %+v", err)
+	}
+	if stats := report.Indexes["normal:normal"]; stats == nil || stats.DocsWritten != 10 {
+		t.Errorf("unexpected stats of normal:normal %+v", stats)
+	}
+
+	sourceES.mu.Lock()
+	stuckErr := sourceES.scrollErrs["stuck"]
+	sourceES.mu.Unlock()
+	if !errors.Is(stuckErr, context.DeadlineExceeded) {
+		t.Errorf("stuck index is not timed out: %+v", stuckErr)
+	}
+	if count := targetES.writtenCount("normal"); count != 10 {
+		t.Errorf("expected 10 docs written to normal, got %d", count)
+	}
+	if count := targetES.writtenCount("stuck"); count != 0 {
+		t.Errorf("expected no doc written to stuck, got %d", count)
+	}
+}
+
+func TestMigratorSyncEmptySource(t *testing.T) {
+	sourceES := newFakeES(map[string][]*es2.Doc{"empty": {}})
+	targetES := newFakeES(nil)
+
+	err := NewMigrator(context.Background(), sourceES, targetES).
+		WithIndexPair(config.IndexPair{SourceIndex: "empty", TargetIndex: "empty-copy"}).
+		Sync(false)
+	if err != nil {
+		t.Fatalf("sync %+v", err)
+	}
+
+	if len(targetES.created) != 1 || targetES.created[0] != "empty-copy" {
+		t.Errorf("target index is not created: %v", targetES.created)
+	}
+	if len(sourceES.scrolls) != 0 {
+		t.Errorf("empty source is scrolled %d times", len(sourceES.scrolls))
+	}
+}
+
+func TestGetDocHashNormalize(t *testing.T) {
+	decode := func(body string, useNumber bool) *es2.Doc {
+		decoder := json.NewDecoder(strings.NewReader(body))
+		if useNumber {
+			decoder.UseNumber()
+		}
+		var source map[string]interface{}
+		if err := decoder.Decode(&source); err != nil {
+			t.Fatalf("%+v", err)
+		}
+		return &es2.Doc{ID: "1", Source: source}
+	}
+
+	cases := []struct {
+		source          string
+		target          string
+		unorderedFields []string
+		same            bool
+	}{
+		{`{"a":1,"b":{"c":[1,2],"d":"x"}}`, `{"b":{"d":"x","c":[1,2]},"a":1}`, nil, true},
+		{`{"a":1,"b":{"c":[1,2]}}`, `{"a":1.0,"b":{"c":[1.0,2.00]}}`, nil, true},
+		{`{"b":{"c":[1,2]}}`, `{"b":{"c":[2,1]}}`, nil, false},
+		{`{"b":{"c":[1,2]}}`, `{"b":{"c":[2,1]}}`, []string{"b.c"}, true},
+		{`{"b":{"c":[1,2]},"d":["x","y"]}`, `{"b":{"c":[2,1]},"d":["y","x"]}`, []string{"b.c"}, false},
+		{`{"b":{"c":[{"e":1},{"e":2}]},"d":["x","y"]}`, `{"b":{"c":[{"e":2},{"e":1.0}]},"d":["y","x"]}`, []string{"*"}, true},
+	}
+
+	for _, item := range cases {
+		m := NewMigrator(context.Background(), nil, nil).WithUnorderedArrayFields(item.unorderedFields)
+		for _, useNumber := range []bool{false, true} {
+			same := m.getDocHash(decode(item.source, useNumber)) == m.getDocHash(decode(item.target, useNumber))
+			if same != item.same {
+				t.Errorf("%s vs %s, unordered %v, use number %v: expected same %v", item.source, item.target,
+					item.unorderedFields, useNumber, item.same)
+			}
+		}
+	}
+}
+
+func TestMigratorCompareIgnoreFields(t *testing.T) {
+	newDocs := func(timestamp string) []*es2.Doc {
+		return lo.Times(3, func(i int) *es2.Doc {
+			return &es2.Doc{
+				ID: fmt.Sprintf("%d", i),
+				Source: map[string]interface{}{
+					"value":      i,
+					"@timestamp": timestamp,
+					"meta":       map[string]interface{}{"migrated_at": timestamp, "owner": "a"},
+				},
+			}
+		})
+	}
+	sourceES := newFakeES(map[string][]*es2.Doc{"idx": newDocs("2024-01-01")})
+	targetES := newFakeES(map[string][]*es2.Doc{"idx": newDocs("2024-06-01")})
+
+	cases := []struct {
+		ignoreFields []string
+		updateCount  uint64
+	}{
+		{nil, 3},
+		{[]string{"@timestamp"}, 3},
+		{[]string{"@timestamp", "meta.migrated_at"}, 0},
+	}
+	for _, item := range cases {
+		diffResult, err := NewMigrator(context.Background(), sourceES, targetES).
+			WithIndexPair(config.IndexPair{SourceIndex: "idx", TargetIndex: "idx"}).
+			WithCompareIgnoreFields(item.ignoreFields).
+			Compare()
+		if err != nil {
+			t.Fatalf("compare %+v", err)
+		}
+		if diffResult.UpdateCount.Load() != item.updateCount || diffResult.SameCount.Load() != 3-item.updateCount {
+			t.Errorf("ignore %v: expected %d updates, got %d updates and %d same", item.ignoreFields,
+				item.updateCount, diffResult.UpdateCount.Load(), diffResult.SameCount.Load())
+		}
+	}
+}
+
+func TestMigratorCompareStream(t *testing.T) {
+	targetDocs := newFakeDocs(20)
+	for _, doc := range targetDocs[:10] {
+		doc.Source = map[string]interface{}{"value": "updated"}
+	}
+	sourceES := newFakeES(map[string][]*es2.Doc{"idx": newFakeDocs(21)})
+	targetES := newFakeES(map[string][]*es2.Doc{"idx": append(targetDocs, &es2.Doc{ID: "extra"})})
+
+	firstDiff := make(chan struct{})
+	release := make(chan struct{})
+	diffs := make(map[DiffType][]string)
+	done := make(chan *DiffResult)
+	go func() {
+		diffResult, err := NewMigrator(context.Background(), sourceES, targetES).
+			WithIndexPair(config.IndexPair{SourceIndex: "idx", TargetIndex: "idx"}).
+			WithScrollSize(2).
+			CompareStream(func(diff DocDiff) {
+				if len(diffs) == 0 {
+					close(firstDiff)
+					<-release
+				}
+				diffs[diff.Type] = append(diffs[diff.Type], diff.ID)
+			})
+		if err != nil {
+			t.Errorf("compare %+v", err)
+		}
+		done <- diffResult
+	}()
+
+	// the compare is held by the callback of the first diff, so the diffs are streamed before it returns
+	select {
+	case <-firstDiff:
+	case <-done:
+		t.Fatalf("compare returned before the first diff was streamed")
+	}
+	close(release)
+
+	diffResult := <-done
+	if len(diffs[DiffTypeUpdate]) != 10 || len(diffs[DiffTypeCreate]) != 1 || len(diffs[DiffTypeDelete]) != 1 {
+		t.Errorf("unexpected streamed diffs %v", diffs)
+	}
+	if diffResult.UpdateCount.Load() != 10 || diffResult.SameCount.Load() != 10 || len(diffResult.UpdateDocs) != 0 {
+		t.Errorf("unexpected result %s, %d update docs kept", diffResult.toStr(), len(diffResult.UpdateDocs))
+	}
+}
+
+func TestMigratorRepair(t *testing.T) {
+	targetDocs := newFakeDocs(8)
+	for _, doc := range targetDocs[:3] {
+		doc.Source = map[string]interface{}{"value": "updated"}
+	}
+	sourceES := newFakeES(map[string][]*es2.Doc{"idx": newFakeDocs(10)})
+	targetES := newFakeES(map[string][]*es2.Doc{"idx": append(targetDocs, &es2.Doc{ID: "extra"})})
+	targetES.rejects = map[string]string{"9": "mapper_parsing_exception"}
+
+	repairResult, err := NewMigrator(context.Background(), sourceES, targetES).
+		WithIndexPair(config.IndexPair{SourceIndex: "idx", TargetIndex: "idx"}).
+		WithScrollSize(2).
+		Repair()
+	if err != nil {
+		t.Fatalf("repair %+v", err)
+	}
+	// 3 updates and 2 creates are rewritten but the target rejects one of them
+	if repairResult.RepairedCount != 4 || repairResult.DeletedCount != 1 {
+		t.Errorf("unexpected repair result %+v", repairResult)
+	}
+	if targetES.written["idx"]["9"] != nil || targetES.written["idx"]["8"] == nil || targetES.written["idx"]["extra"] == nil ||
+		targetES.written["idx"]["extra"].Op != es2.OperationDelete {
+		t.Errorf("unexpected written docs %v", lo.Keys(targetES.written["idx"]))
+	}
+	if count, _ := targetES.Count(context.Background(), "idx"); count != 9 {
+		t.Errorf("expect 9 docs on the target after repair, got %d", count)
+	}
+}
+
+func TestMigratorRepairFailedCompare(t *testing.T) {
+	sourceES := newFakeES(map[string][]*es2.Doc{"idx": newFakeDocs(10)})
+	sourceES.nextScrollErr = errors.New("search_context_missing_exception")
+	targetES := newFakeES(map[string][]*es2.Doc{"idx": newFakeDocs(10)})
+
+	// the source scroll fails after its first page, the target docs it did not reach are not deleted
+	repairResult, err := NewMigrator(context.Background(), sourceES, targetES).
+		WithIndexPair(config.IndexPair{SourceIndex: "idx", TargetIndex: "idx"}).
+		WithScrollSize(2).
+		WithSliceSize(1).
+		Repair()
+	if err == nil {
+		t.Fatalf("expect the compare error")
+	}
+	if repairResult != nil || len(targetES.written["idx"]) != 0 {
+		t.Errorf("expect nothing repaired, got %+v, written %v", repairResult, lo.Keys(targetES.written["idx"]))
+	}
+	if count, _ := targetES.Count(context.Background(), "idx"); count != 10 {
+		t.Errorf("expect the target to keep its 10 docs, got %d", count)
+	}
+}
+
+func TestMigratorInvalidTargetIndexName(t *testing.T) {
+	sourceES := newFakeES(map[string][]*es2.Doc{"a": newFakeDocs(1)})
+	targetES := newFakeES(nil)
+
+	err := NewMigrator(context.Background(), sourceES, targetES).
+		WithIndexPair(config.IndexPair{SourceIndex: "a", TargetIndex: "A_Copy"}).
+		CopyIndexSettings(false)
+	if !utils.IsCustomError(err, utils.InvalidParams) || !strings.Contains(err.Error(), "lowercase") {
+		t.Fatalf("expect an invalid index name error, got %v", err)
+	}
+	if len(targetES.created) != 0 {
+		t.Errorf("expect no index created, got %v", targetES.created)
+	}
+}
+
+func TestMigratorSyncMapping(t *testing.T) {
+	sourceUser := map[string]interface{}{
+		"id":    map[string]interface{}{"type": "keyword"},
+		"email": map[string]interface{}{"type": "keyword"},
+	}
+	sourceES := newFakeES(nil)
+	sourceES.mappings = map[string]map[string]interface{}{
+		"a": {
+			"name": map[string]interface{}{"type": "keyword"},
+			"age":  map[string]interface{}{"type": "long"},
+			"user": map[string]interface{}{"properties": sourceUser},
+		},
+	}
+	targetES := newFakeES(nil)
+	targetES.mappings = map[string]map[string]interface{}{
+		"b": {
+			"name": map[string]interface{}{"type": "keyword"},
+			"user": map[string]interface{}{"properties": map[string]interface{}{
+				"id": map[string]interface{}{"type": "keyword"},
+			}},
+		},
+	}
+
+	m := NewMigrator(context.Background(), sourceES, targetES).
+		WithIndexPair(config.IndexPair{SourceIndex: "a", TargetIndex: "b"})
+	if err := m.SyncMapping(false); err != nil {
+		t.Fatalf("sync mapping: %v", err)
+	}
+
+	targetMapping, _ := targetES.GetIndexMapping("b")
+	if properties := mappingProperties(targetMapping, "b"); !reflect.DeepEqual(properties, sourceES.mappings["a"]) {
+		t.Errorf("expect the target mapping %v, got %v", sourceES.mappings["a"], properties)
+	}
+	// a second run has nothing to push
+	if delta, conflicts := mappingDelta("", sourceES.mappings["a"], targetES.mappings["b"]); len(delta) != 0 || len(conflicts) != 0 {
+		t.Errorf("expect no delta, got %v %v", delta, conflicts)
+	}
+	if err := m.SyncMapping(false); err != nil {
+		t.Fatalf("sync mapping again: %v", err)
+	}
+
+	sourceUser["id"] = map[string]interface{}{"type": "long"}
+	err := m.SyncMapping(false)
+	if !utils.IsCustomError(err, utils.BreakingMappingChange) || !strings.Contains(err.Error(), "user.id") {
+		t.Fatalf("expect a breaking change on user.id, got %v", err)
+	}
+	if err := m.SyncMapping(true); err != nil {
+		t.Fatalf("force sync mapping: %v", err)
+	}
+}
+
+func TestMigratorWithDeadLetter(t *testing.T) {
+	sourceES := newFakeES(map[string][]*es2.Doc{"a": newFakeDocs(10)})
+	targetES := newFakeES(nil)
+	targetES.rejects = map[string]string{"3": "failed to parse field [value] of type [date]"}
+
+	deadLetterFile := filepath.Join(t.TempDir(), "dead_letter.ndjson")
+	sink, err := NewFileDeadLetterSink(deadLetterFile)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	stats, err := NewMigrator(context.Background(), sourceES, targetES).
+		WithIndexPair(config.IndexPair{SourceIndex: "a", TargetIndex: "b"}).
+		WithActionParallelism(1).
+		WithDeadLetter(sink).
+		SyncWithStats(false)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if stats.DocsWritten != 9 || stats.DocsFailed != 1 || targetES.writtenCount("b") != 9 {
+		t.Errorf("unexpected stats %+v, %d written", stats, targetES.writtenCount("b"))
+	}
+
+	content, err := os.ReadFile(deadLetterFile)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("expect one dead letter, got %q", content)
+	}
+	var deadLetter map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[0]), &deadLetter); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if deadLetter["_index"] != "b" || deadLetter["_id"] != "3" ||
+		!strings.Contains(cast.ToString(deadLetter["error"]), "failed to parse field [value]") ||
+		cast.ToStringMap(deadLetter["_source"])["value"] != float64(3) {
+		t.Errorf("unexpected dead letter %v", deadLetter)
+	}
+}
+
+func TestMigratorWithReadParallel(t *testing.T) {
+	sourceES := newFakeES(map[string][]*es2.Doc{"a": newFakeDocs(100)})
+	targetES := newFakeES(nil)
+
+	stats, err := NewMigrator(context.Background(), sourceES, targetES).
+		WithIndexPair(config.IndexPair{SourceIndex: "a", TargetIndex: "b"}).
+		WithScrollSize(7).
+		WithSliceSize(3).
+		WithReadParallel(2).
+		SyncWithStats(false)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	if stats.DocsRead != 100 || stats.DocsWritten != 100 || targetES.writtenCount("b") != 100 {
+		t.Errorf("unexpected stats %+v, %d written", stats, targetES.writtenCount("b"))
+	}
+	if ids := lo.FindDuplicates(targetES.bulkIds); len(targetES.bulkIds) != 100 || len(ids) != 0 {
+		t.Errorf("expect every doc written once, got %d writes with duplicates %v", len(targetES.bulkIds), ids)
+	}
+	if sourceES.maxOpenScrolls > 2 || sourceES.openScrolls != 0 {
+		t.Errorf("expect 2 scrolls at most and all cleared, got %d at most and %d open",
+			sourceES.maxOpenScrolls, sourceES.openScrolls)
+	}
+	if cleared := lo.Uniq(sourceES.clearedIds); len(cleared) != 3 {
+		t.Errorf("expect the 3 slice scrolls cleared, got %v", sourceES.clearedIds)
+	}
+}
+
+func TestMigratorWithSortFields(t *testing.T) {
+	sourceES := newFakeES(map[string][]*es2.Doc{"a": newFakeDocs(20)})
+	targetES := newFakeES(nil)
+
+	m := NewMigrator(context.Background(), sourceES, targetES).
+		WithIndexPair(config.IndexPair{SourceIndex: "a", TargetIndex: "b"}).
+		WithScrollSize(3).
+		WithSliceSize(1).
+		WithActionParallelism(1)
+	if err := m.WithSortFields([]string{"value:desc"}).Sync(false); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if !reflect.DeepEqual(sourceES.sortFields, []string{"value:desc"}) {
+		t.Fatalf("expect the sort sent with the scroll, got %v", sourceES.sortFields)
+	}
+	expected := make([]string, 0, 20)
+	for i := 19; i >= 0; i-- {
+		expected = append(expected, fmt.Sprintf("%d", i))
+	}
+	if !reflect.DeepEqual(targetES.bulkIds, expected) {
+		t.Errorf("expect the docs written in descending order, got %v", targetES.bulkIds)
+	}
+}
+
+func TestMigratorWithWaitForActiveShards(t *testing.T) {
+	sourceES := newFakeES(map[string][]*es2.Doc{"a": newFakeDocs(10)})
+	targetES := newFakeES(nil)
+
+	m := NewMigrator(context.Background(), sourceES, targetES).
+		WithIndexPair(config.IndexPair{SourceIndex: "a", TargetIndex: "b"})
+	if err := m.WithWaitForActiveShards("all").Sync(false); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if targetES.writeOption == nil || targetES.writeOption.WaitForActiveShards != "all" {
+		t.Errorf("expect the bulk to wait for all shards, got %+v", targetES.writeOption)
+	}
+
+	for _, invalid := range []string{"0", "some"} {
+		if err := m.WithWaitForActiveShards(invalid).Sync(false); !utils.IsCustomError(err, utils.InvalidParams) {
+			t.Errorf("expect %s to be rejected, got %v", invalid, err)
+		}
+	}
+}
+
+func TestMigratorRejectsSelfMigration(t *testing.T) {
+	sourceES := newFakeES(map[string][]*es2.Doc{"a": newFakeDocs(10)})
+	sourceES.addresses = []string{"http://es-1:9200", "http://es-2:9200"}
+	targetES := newFakeES(nil)
+	targetES.addresses = []string{"HTTP://es-2:9200/"}
+
+	m := NewMigrator(context.Background(), sourceES, targetES).
+		WithIndexPair(config.IndexPair{SourceIndex: "a", TargetIndex: "a"})
+	for _, run := range []func() error{
+		func() error { return m.Sync(false) },
+		func() error { return m.CopyIndexSettings(true) },
+		func() error { _, err := m.SyncDiff(); return err },
+	} {
+		if err := run(); !utils.IsCustomError(err, utils.SelfMigration) {
+			t.Errorf("expect a self migration error, got %v", err)
+		}
+	}
+	if len(targetES.created) != 0 || targetES.writtenCount("a") != 0 {
+		t.Fatalf("expect the target untouched, got created %v with %d docs", targetES.created, targetES.writtenCount("a"))
+	}
+
+	// another index of the cluster is no self migration
+	if err := m.WithIndexPair(config.IndexPair{SourceIndex: "a", TargetIndex: "b"}).Sync(false); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if err := m.WithAllowSelf(true).Sync(false); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if targetES.writtenCount("a") != 10 {
+		t.Errorf("expect the allowed self migration written, got %d docs", targetES.writtenCount("a"))
+	}
+}
+
+func TestMigratorReindexInPlace(t *testing.T) {
+	sourceES := newFakeES(map[string][]*es2.Doc{"a": newFakeDocs(10)})
+	sourceES.addresses = []string{"http://es:9200"}
+	otherES := newFakeES(nil)
+	otherES.addresses = []string{"http://other:9200"}
+
+	m := NewMigrator(context.Background(), sourceES, otherES).
+		WithIndexPair(config.IndexPair{SourceIndex: "a", TargetIndex: "a-v2"})
+	if _, err := m.ReindexInPlace(); !utils.IsCustomError(err, utils.InvalidParams) || len(sourceES.reindexes) != 0 {
+		t.Fatalf("expect a reindex across clusters rejected, got %v", err)
+	}
+
+	sameES := newFakeES(nil)
+	sameES.addresses = []string{"http://ES:9200/"}
+	stats, err := NewMigrator(context.Background(), sourceES, sameES).
+		WithIndexPair(config.IndexPair{SourceIndex: "a", TargetIndex: "a-v2"}).
+		ReindexInPlace()
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if !reflect.DeepEqual(sourceES.reindexes, []string{"a>a-v2"}) || stats.DocsRead != 10 || stats.DocsWritten != 10 {
+		t.Errorf("expect a reindex of 10 docs, got %v with %+v", sourceES.reindexes, stats)
+	}
+}
+
+func TestMigratorClearsScrollsOnCancel(t *testing.T) {
+	sourceES := newFakeES(map[string][]*es2.Doc{"a": newFakeDocs(10)})
+	sourceES.blockNextScroll = true
+	targetES := newFakeES(nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- NewMigrator(ctx, sourceES, targetES).
+			WithIndexPair(config.IndexPair{SourceIndex: "a", TargetIndex: "a"}).
+			WithScrollSize(2).
+			WithSliceSize(2).
+			Sync(false)
+	}()
+
+	// both slices hold their scroll on the second page, as an interrupt finds them
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(time.Millisecond) {
+		sourceES.mu.Lock()
+		blockedScrolls := sourceES.blockedScrolls
+		sourceES.mu.Unlock()
+		if blockedScrolls == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expect 2 blocked scrolls, got %d", blockedScrolls)
+		}
+	}
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("the cancelled sync does not return")
+	}
+	sourceES.mu.Lock()
+	defer sourceES.mu.Unlock()
+	if sourceES.openScrolls != 0 || len(sourceES.clearedIds) != 2 {
+		t.Errorf("expect the scrolls cleared on cancel, %d open and cleared %v", sourceES.openScrolls, sourceES.clearedIds)
+	}
+}
+
+func TestMigratorWithRequireExistingTarget(t *testing.T) {
+	sourceES := newFakeES(map[string][]*es2.Doc{"a": newFakeDocs(10)})
+	targetES := newFakeES(nil)
+
+	m := NewMigrator(context.Background(), sourceES, targetES).
+		WithIndexPair(config.IndexPair{SourceIndex: "a", TargetIndex: "b"}).
+		WithRequireExistingTarget(true)
+	for _, run := range []func() error{
+		func() error { return m.Sync(false) },
+		func() error { return m.Sync(true) },
+		func() error { return m.CopyIndexSettings(false) },
+	} {
+		if err := run(); !utils.IsCustomError(err, utils.NonIndexExisted) {
+			t.Errorf("expect a missing target error, got %v", err)
+		}
+	}
+	if len(targetES.created) != 0 || targetES.writtenCount("b") != 0 {
+		t.Fatalf("expect the target untouched, got created %v with %d docs", targetES.created, targetES.writtenCount("b"))
+	}
+
+	// an existing target is written but never recreated, the fake has no delete
+	targetES.mappings = map[string]map[string]interface{}{"b": {}}
+	if err := m.Sync(true); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if len(targetES.created) != 0 || targetES.writtenCount("b") != 10 {
+		t.Errorf("expect the docs written to the existing target, got created %v with %d docs",
+			targetES.created, targetES.writtenCount("b"))
+	}
+}
+
+func TestMigratorValidateQueries(t *testing.T) {
+	sourceES := newFakeES(map[string][]*es2.Doc{"a": newFakeDocs(10)})
+	targetES := newFakeES(map[string][]*es2.Doc{"b": newFakeDocs(10)})
+	// a 6.x source answers a number as hits.total, a 7.x target an object
+	sourceES.searchResults = map[string]string{"a": `{"took":3,"timed_out":false,"_shards":{"total":5},
+		"hits":{"total":10,"max_score":0,"hits":[]},
+		"aggregations":{"tags":{"buckets":[{"key":"go","doc_count":6},{"key":"es","doc_count":4}]}}}`}
+	targetES.searchResults = map[string]string{"b": `{"took":1,"timed_out":false,"_shards":{"total":1},
+		"hits":{"total":{"value":10,"relation":"eq"},"max_score":null,"hits":[]},
+		"aggregations":{"tags":{"buckets":[{"key":"go","doc_count":6},{"key":"es","doc_count":3}]}}}`}
+
+	m := NewMigrator(context.Background(), sourceES, targetES).
+		WithIndexPair(config.IndexPair{SourceIndex: "a", TargetIndex: "b"})
+	query := ValidationQuery{Name: "tags", Query: map[string]interface{}{
+		"size": 0,
+		"aggs": map[string]interface{}{"tags": map[string]interface{}{"terms": map[string]interface{}{"field": "tag"}}},
+	}}
+	reports, err := m.ValidateQueries([]ValidationQuery{query})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if len(reports) != 1 || reports[0].Equal || !reflect.DeepEqual(reports[0].Diffs, []string{"aggregations.tags.buckets.1.doc_count"}) {
+		t.Fatalf("expect the es bucket to differ, got %+v", reports[0])
+	}
+
+	targetES.searchResults["b"] = strings.Replace(targetES.searchResults["b"], `"doc_count":3`, `"doc_count":4`, 1)
+	reports, err = m.ValidateQueries([]ValidationQuery{query})
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if !reports[0].Equal {
+		t.Errorf("expect the answers equal across versions, got %v", reports[0].Diffs)
+	}
+}
+
+func TestMigratorWithStreamBulk(t *testing.T) {
+	sourceES := newFakeES(map[string][]*es2.Doc{"a": newFakeDocs(64)})
+	targetES := newFakeES(nil)
+
+	m := NewMigrator(context.Background(), sourceES, targetES).
+		WithIndexPair(config.IndexPair{SourceIndex: "a", TargetIndex: "b"}).
+		WithActionParallelism(4).
+		WithMaxInflightBulk(2)
+	if err := m.WithStreamBulk(true).Sync(false); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if targetES.streamedBulks == 0 || targetES.writtenCount("b") != 64 {
+		t.Errorf("expect the 64 docs streamed, got %d streamed bulks with %d docs written",
+			targetES.streamedBulks, targetES.writtenCount("b"))
+	}
+
+	// dedup needs the whole batch, so it keeps buffering
+	targetES.streamedBulks = 0
+	if err := m.WithStreamBulk(true).WithBulkDedup(true).Sync(false); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if targetES.streamedBulks != 0 {
+		t.Errorf("expect no streamed bulk with dedup, got %d", targetES.streamedBulks)
+	}
+}
+
+func TestMigratorWithMaxInflightBulk(t *testing.T) {
+	sourceES := newFakeES(map[string][]*es2.Doc{"a": newFakeDocs(64)})
+	targetES := newFakeES(nil)
+	targetES.bulkDelay = 20 * time.Millisecond
+
+	m := NewMigrator(context.Background(), sourceES, targetES).
+		WithIndexPair(config.IndexPair{SourceIndex: "a", TargetIndex: "b"}).
+		WithActionParallelism(8)
+	if err := m.WithMaxInflightBulk(2).Sync(false); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if targetES.maxInflightBulks != 2 || targetES.writtenCount("b") != 64 {
+		t.Errorf("expect 2 bulks in flight at most, got %d with %d docs written",
+			targetES.maxInflightBulks, targetES.writtenCount("b"))
+	}
+
+	// the bulk workers flush at once without a bound
+	targetES.maxInflightBulks = 0
+	if err := m.Sync(false); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if targetES.maxInflightBulks <= 2 {
+		t.Errorf("expect more than 2 bulks in flight without a bound, got %d", targetES.maxInflightBulks)
+	}
+}
+
+func TestMigratorClosedSourceIndex(t *testing.T) {
+	sourceES := newFakeES(map[string][]*es2.Doc{"a": newFakeDocs(3)})
+	sourceES.closed = map[string]bool{"a": true}
+	targetES := newFakeES(nil)
+
+	m := NewMigrator(context.Background(), sourceES, targetES).
+		WithIndexPair(config.IndexPair{SourceIndex: "a", TargetIndex: "b"})
+	if err := m.Sync(false); !utils.IsCustomError(err, utils.IndexClosed) {
+		t.Fatalf("expect an index closed error, got %v", err)
+	}
+	if len(sourceES.scrolls) != 0 || len(targetES.created) != 0 {
+		t.Errorf("expect the closed index neither scrolled nor created, scrolled %d, created %v",
+			len(sourceES.scrolls), targetES.created)
+	}
+
+	if err := m.WithClosedIndexPolicy(config.ClosedIndexPolicySkip).Sync(false); err != nil {
+		t.Fatalf("expect the closed index skipped, got %+v", err)
+	}
+	if targetES.writtenCount("b") != 0 {
+		t.Errorf("expect nothing written for a skipped index, got %d", targetES.writtenCount("b"))
+	}
+
+	if err := m.WithClosedIndexPolicy(config.ClosedIndexPolicyOpen).Sync(false); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if targetES.writtenCount("b") != 3 || !sourceES.closed["a"] ||
+		!reflect.DeepEqual(sourceES.stateChanges, []string{"open a", "close a"}) {
+		t.Errorf("expect the source opened, synced and closed again, written %d, state changes %v",
+			targetES.writtenCount("b"), sourceES.stateChanges)
+	}
+}
+
+func TestMigratorSwapAlias(t *testing.T) {
+	sourceES := newFakeES(map[string][]*es2.Doc{"a": newFakeDocs(10)})
+	targetES := newFakeES(nil)
+	targetES.aliases = map[string][]string{"writer": {"old"}}
+
+	m := NewMigrator(context.Background(), sourceES, targetES).
+		WithIndexPair(config.IndexPair{SourceIndex: "a", TargetIndex: "b"})
+	if err := m.SwapAlias("writer"); !utils.IsCustomError(err, utils.NonIndexExisted) {
+		t.Fatalf("expect a missing target error before the sync, got %v", err)
+	}
+	if err := m.Sync(false); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if targetES.aliasActions != nil {
+		t.Fatalf("expect the sync to leave the alias, got %+v", targetES.aliasActions)
+	}
+
+	if err := m.SwapAlias("writer"); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	expected := []es2.AliasAction{{Alias: "writer", Index: "old", Remove: true}, {Alias: "writer", Index: "b"}}
+	if !reflect.DeepEqual(targetES.aliasActions, expected) || !reflect.DeepEqual(targetES.aliases["writer"], []string{"b"}) {
+		t.Errorf("expect the alias swapped by %+v, got %+v pointing to %v", expected, targetES.aliasActions,
+			targetES.aliases["writer"])
+	}
+
+	// swapping again keeps the alias on the target
+	if err := m.SwapAlias("writer"); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if !reflect.DeepEqual(targetES.aliasActions, []es2.AliasAction{{Alias: "writer", Index: "b"}}) {
+		t.Errorf("expect only the add action, got %+v", targetES.aliasActions)
+	}
+}
+
+func TestMigratorWithVerifyCount(t *testing.T) {
+	// a doc over the 1MB action size flushes a bulk request of its own
+	docs := newFakeDocs(4)
+	for _, doc := range docs {
+		doc.Source["padding"] = strings.Repeat("x", 1024*1024)
+	}
+
+	for _, testCase := range []struct {
+		verifyCount       bool
+		streamBulk        bool
+		rejects           map[string]string
+		expectedRefreshes []string
+		expectedErr       bool
+	}{
+		{false, false, nil, []string{"", "", "", ""}, false},
+		{true, false, nil, []string{"", "", "", "wait_for"}, false},
+		{true, true, nil, []string{"", "", "", "wait_for"}, false},
+		// the rejected doc is dropped without a dead letter sink, only the count tells
+		{true, false, map[string]string{"2": "mapping conflict"}, []string{"", "", "", "wait_for"}, true},
+	} {
+		sourceES := newFakeES(map[string][]*es2.Doc{"a": docs})
+		targetES := newFakeES(nil)
+		targetES.rejects = testCase.rejects
+
+		err := NewMigrator(context.Background(), sourceES, targetES).
+			WithIndexPair(config.IndexPair{SourceIndex: "a", TargetIndex: "b"}).
+			WithActionParallelism(1).
+			WithActionSize(1).
+			WithStreamBulk(testCase.streamBulk).
+			WithVerifyCount(testCase.verifyCount).
+			Sync(false)
+		if testCase.expectedErr != utils.IsCustomError(err, utils.CountMismatch) || (!testCase.expectedErr && err != nil) {
+			t.Errorf("%+v: unexpected error %v", testCase, err)
+		}
+		if !reflect.DeepEqual(targetES.bulkRefreshes, testCase.expectedRefreshes) || targetES.streamedBulks != 0 &&
+			testCase.verifyCount {
+			t.Errorf("%+v: expect the bulk refreshes %q, got %q with %d streamed", testCase,
+				testCase.expectedRefreshes, targetES.bulkRefreshes, targetES.streamedBulks)
+		}
+	}
+}
+
+func TestMigratorWithBulkRefreshPolicy(t *testing.T) {
+	for _, testCase := range []struct {
+		policy            config.BulkRefreshPolicy
+		streamBulk        bool
+		verifyCount       bool
+		expectedRefreshes []string
+	}{
+		{"", false, false, []string{""}},
+		{config.BulkRefreshPolicyNone, false, false, []string{""}},
+		{config.BulkRefreshPolicyWaitFor, false, false, []string{"wait_for"}},
+		{config.BulkRefreshPolicyTrue, true, false, []string{"true"}},
+		// the last bulk, here the only one, of a verified sync waits for its docs unless they are refreshed already
+		{config.BulkRefreshPolicyNone, false, true, []string{"wait_for"}},
+		{config.BulkRefreshPolicyTrue, false, true, []string{"true"}},
+	} {
+		sourceES := newFakeES(map[string][]*es2.Doc{"a": newFakeDocs(3)})
+		targetES := newFakeES(nil)
+
+		err := NewMigrator(context.Background(), sourceES, targetES).
+			WithIndexPair(config.IndexPair{SourceIndex: "a", TargetIndex: "b"}).
+			WithActionParallelism(1).
+			WithStreamBulk(testCase.streamBulk).
+			WithVerifyCount(testCase.verifyCount).
+			WithBulkRefreshPolicy(testCase.policy).
+			Sync(false)
+		if err != nil {
+			t.Fatalf("%+v: %+v", testCase, err)
+		}
+		if !reflect.DeepEqual(targetES.bulkRefreshes, testCase.expectedRefreshes) {
+			t.Errorf("%+v: expect the bulk refreshes %q, got %q", testCase, testCase.expectedRefreshes, targetES.bulkRefreshes)
+		}
+	}
+}
+
+func TestMigratorLogsClusterNames(t *testing.T) {
+	var buf bytes.Buffer
+	logger := utils.GetLogger(context.Background()).Logger
+	out, level := logger.Out, logger.GetLevel()
+	logger.SetOutput(&buf)
+	logger.SetLevel(logrus.InfoLevel)
+	t.Cleanup(func() {
+		logger.SetOutput(out)
+		logger.SetLevel(level)
+	})
+
+	sourceES := newFakeES(map[string][]*es2.Doc{"a": newFakeDocs(10)})
+	sourceES.name = "prod-eu"
+	targetES := newFakeES(nil)
+	targetES.name = "prod-us"
+	err := NewMigrator(context.Background(), sourceES, targetES).
+		WithIndexPair(config.IndexPair{SourceIndex: "a", TargetIndex: "b"}).
+		Sync(false)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	for _, expected := range []string{`"sourceEsName":"prod-eu"`, `"targetEsName":"prod-us"`} {
+		if !strings.Contains(buf.String(), expected) {
+			t.Errorf("expect %s in the logs %s", expected, buf.String())
+		}
+	}
+}
+
+func TestMigratorWithScriptedUpsert(t *testing.T) {
+	sourceES := newFakeES(map[string][]*es2.Doc{"a": newFakeDocs(3)})
+	targetES := newFakeES(nil)
+
+	err := NewMigrator(context.Background(), sourceES, targetES).
+		WithIndexPair(config.IndexPair{SourceIndex: "a", TargetIndex: "b"}).
+		WithDocTransformer(ScriptedUpsert("")).
+		Sync(false)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	for _, doc := range sourceES.docs["a"] {
+		written := targetES.written["b"][doc.ID]
+		expectedScript := map[string]interface{}{
+			"source": mergeFieldsScript,
+			"lang":   "painless",
+			// the fake decodes the numbers of the bulk body as float64
+			"params": map[string]interface{}{"doc": map[string]interface{}{"value": cast.ToFloat64(doc.Source["value"])}},
+		}
+		if written == nil || written.Op != es2.OperationUpdate || !reflect.DeepEqual(written.Script, expectedScript) ||
+			!reflect.DeepEqual(written.Upsert, expectedScript["params"].(map[string]interface{})["doc"]) {
+			t.Errorf("expect doc %s written as a scripted upsert, got %+v", doc.ID, written)
+		}
+		if doc.Op == es2.OperationUpdate || doc.Script != nil {
+			t.Errorf("expect the source doc %s untouched, got %+v", doc.ID, doc)
+		}
+	}
+}
+
+func TestMigratorJoinFieldRouting(t *testing.T) {
+	relation := func(parent string) map[string]interface{} {
+		return map[string]interface{}{"relation": map[string]interface{}{"name": "answer", "parent": parent}}
+	}
+	sourceES := newFakeES(map[string][]*es2.Doc{"qa": {
+		{ID: "1", Source: map[string]interface{}{"relation": "question"}},
+		{ID: "2", Routing: "1", Source: relation("1")},
+		{ID: "3", Source: relation("1")},
+	}})
+	sourceES.mappings = map[string]map[string]interface{}{"qa": {
+		"relation": map[string]interface{}{"type": "join", "relations": map[string]interface{}{"question": "answer"}},
+	}}
+	targetES := newFakeES(nil)
+
+	// a transformer which drops the routing does not move the children off the shard of their parent
+	err := NewMigrator(context.Background(), sourceES, targetES).
+		WithIndexPair(config.IndexPair{SourceIndex: "qa", TargetIndex: "qa"}).
+		WithDocTransformer(func(doc *es2.Doc) *es2.Doc {
+			newDoc := *doc
+			newDoc.Routing = ""
+			return &newDoc
+		}).
+		Sync(false)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	for id, routing := range map[string]string{"1": "", "2": "1", "3": "1"} {
+		if doc := targetES.written["qa"][id]; doc == nil || doc.Routing != routing {
+			t.Errorf("expect doc %s routed by %q, got %+v", id, routing, doc)
+		}
+	}
+
+	err = NewMigrator(context.Background(), sourceES, newFakeES(nil)).
+		WithIndexPair(config.IndexPair{SourceIndex: "qa", TargetIndex: "qa"}).
+		WithAutoGenerateIds(true).
+		Sync(false)
+	if !utils.IsCustomError(err, utils.InvalidParams) {
+		t.Errorf("expect auto generated ids refused for a join field, got %v", err)
+	}
+}
+
+func TestFieldsOfType(t *testing.T) {
+	properties := map[string]interface{}{
+		"query":  map[string]interface{}{"type": "percolator"},
+		"family": map[string]interface{}{"type": "join"},
+		"nested": map[string]interface{}{"properties": map[string]interface{}{
+			"rule": map[string]interface{}{"type": "percolator"},
+		}},
+	}
+	if paths := fieldsOfType("", properties, "percolator"); !reflect.DeepEqual(paths, []string{"nested.rule", "query"}) {
+		t.Errorf("unexpected percolator fields %v", paths)
+	}
+	if paths := fieldsOfType("", properties, "join"); !reflect.DeepEqual(paths, []string{"family"}) {
+		t.Errorf("unexpected join fields %v", paths)
+	}
+}
+
+func TestBulkMigratorCreateTemplatesKeepsOrder(t *testing.T) {
+	sourceES := newFakeES(map[string][]*es2.Doc{"logs-app-1": newFakeDocs(1)})
+	targetES := newFakeES(nil)
+	targetES.templates = map[string]map[string]interface{}{
+		"logs-app": {
+			"order":          5,
+			"index_patterns": []string{"logs-app-old-*"},
+			"aliases":        map[string]interface{}{"app": map[string]interface{}{}},
+		},
+	}
+
+	// the overlapping templates apply to logs-app-* in their order, the more specific one last
+	indexTemplates := []*config.IndexTemplate{
+		{Name: "logs", Patterns: []string{"logs-*"}, Order: 0},
+		{Name: "logs-app", Patterns: []string{"logs-app-*"}, Order: 1},
+	}
+	bulkMigrator := NewBulkMigratorWithES(context.Background(), sourceES, targetES).
+		WithIndexTemplates(indexTemplates...)
+
+	assertTemplate := func(name string, order int, patterns []string) {
+		template := targetES.templates[name]
+		if template["order"] != order || !reflect.DeepEqual(template["index_patterns"], patterns) {
+			t.Errorf("expect the template %s of order %d and patterns %v, got %v", name, order, patterns, template)
+		}
+	}
+
+	if err := bulkMigrator.WithTemplateConflictPolicy(config.TemplateConflictPolicySkip).CreateTemplates(); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	assertTemplate("logs", 0, []string{"logs-*"})
+	assertTemplate("logs-app", 5, []string{"logs-app-old-*"})
+
+	if err := bulkMigrator.WithTemplateConflictPolicy(config.TemplateConflictPolicyMerge).CreateTemplates(); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	assertTemplate("logs-app", 1, []string{"logs-app-*"})
+	if _, ok := cast.ToStringMap(targetES.templates["logs-app"]["aliases"])["app"]; !ok {
+		t.Errorf("expect the merge to keep the alias of the existing template, got %v", targetES.templates["logs-app"])
+	}
+
+	if err := bulkMigrator.CreateTemplates(); err != nil {
+		t.Fatalf("%+v", err)
+	}
+	assertTemplate("logs", 0, []string{"logs-*"})
+	assertTemplate("logs-app", 1, []string{"logs-app-*"})
+	if _, ok := cast.ToStringMap(targetES.templates["logs-app"]["aliases"])["app"]; ok {
+		t.Errorf("expect the overwrite to replace the existing template, got %v", targetES.templates["logs-app"])
+	}
+}
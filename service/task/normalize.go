@@ -0,0 +1,71 @@
+package task
+
+import (
+	"encoding/json"
+	"github.com/samber/lo"
+	"github.com/spf13/cast"
+	"sort"
+)
+
+const allFields = "*"
+
+// sourceNormalizer canonicalizes a doc source before it is hashed so insignificant differences do not count
+// as diffs: every number becomes a float64, so 1 and 1.0 are equal, the arrays under UnorderedFields
+// (dotted paths, `*` for all) are sorted and the IgnoreFields (dotted paths) are stripped. json.Marshal
+// sorts the keys. The source itself is not modified.
+type sourceNormalizer struct {
+	UnorderedFields []string
+	IgnoreFields    []string
+}
+
+func (n sourceNormalizer) normalize(source map[string]interface{}) map[string]interface{} {
+	normalized, _ := n.normalizeValue(source, "").(map[string]interface{})
+	return normalized
+}
+
+func (n sourceNormalizer) normalizeValue(value interface{}, path string) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		normalized := make(map[string]interface{}, len(v))
+		for key, child := range v {
+			childPath := joinFieldPath(path, key)
+			if lo.Contains(n.IgnoreFields, childPath) {
+				continue
+			}
+			normalized[key] = n.normalizeValue(child, childPath)
+		}
+		return normalized
+	case []interface{}:
+		// an array keeps the path of its field, like the es mapping does
+		normalized := lo.Map(v, func(item interface{}, _ int) interface{} {
+			return n.normalizeValue(item, path)
+		})
+		if lo.Contains(n.UnorderedFields, allFields) || lo.Contains(n.UnorderedFields, path) {
+			sort.Slice(normalized, func(i, j int) bool {
+				return jsonString(normalized[i]) < jsonString(normalized[j])
+			})
+		}
+		return normalized
+	case json.Number:
+		if f, err := v.Float64(); err == nil {
+			return f
+		}
+		return v.String()
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32:
+		return cast.ToFloat64(v)
+	default:
+		return v
+	}
+}
+
+func joinFieldPath(path string, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func jsonString(value interface{}) string {
+	valueBytes, _ := json.Marshal(value)
+	return string(valueBytes)
+}
@@ -0,0 +1,64 @@
+package task
+
+import (
+	"context"
+	"sync"
+)
+
+// pauseGate holds the readers and the bulk writers of a run while it is paused, the clones of a migrator share it.
+// A nil gate is never paused.
+type pauseGate struct {
+	mutex sync.Mutex
+	// resumed is closed on resume, it is nil unless paused
+	resumed chan struct{}
+}
+
+func newPauseGate() *pauseGate {
+	return &pauseGate{}
+}
+
+func (g *pauseGate) pause() {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if g.resumed == nil {
+		g.resumed = make(chan struct{})
+	}
+}
+
+func (g *pauseGate) resume() {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	if g.resumed != nil {
+		close(g.resumed)
+		g.resumed = nil
+	}
+}
+
+func (g *pauseGate) isPaused() bool {
+	if g == nil {
+		return false
+	}
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	return g.resumed != nil
+}
+
+// wait blocks while the gate is paused or until ctx is done
+func (g *pauseGate) wait(ctx context.Context) {
+	if g == nil {
+		return
+	}
+	g.mutex.Lock()
+	resumed := g.resumed
+	g.mutex.Unlock()
+	if resumed == nil {
+		return
+	}
+
+	select {
+	case <-resumed:
+	case <-ctx.Done():
+	}
+}
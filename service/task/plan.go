@@ -0,0 +1,246 @@
+package task
+
+import (
+	"fmt"
+	"github.com/CharellKing/ela-lib/config"
+	"github.com/CharellKing/ela-lib/utils"
+	"github.com/alitto/pond"
+	"github.com/pkg/errors"
+	"github.com/samber/lo"
+	"github.com/spf13/cast"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type PlanStepKind string
+
+const (
+	PlanStepCreateTemplate PlanStepKind = "create_template"
+	PlanStepCreateIndex    PlanStepKind = "create_index"
+	PlanStepSync           PlanStepKind = "sync"
+	PlanStepSwapAlias      PlanStepKind = "swap_alias"
+)
+
+// PlanStep is a step of SyncAll, it runs once all the steps of DependsOn succeeded and is skipped when one failed
+type PlanStep struct {
+	ID   string       `json:"id"`
+	Kind PlanStepKind `json:"kind"`
+	// Target is the template name, the index pair `source:target` or the alias the step writes
+	Target    string   `json:"target"`
+	DependsOn []string `json:"depends_on,omitempty"`
+
+	indexTemplate *config.IndexTemplate
+	indexPair     *config.IndexPair
+}
+
+// Plan lists the steps of SyncAll in the order they run, every step comes after its dependencies
+type Plan struct {
+	Steps []*PlanStep `json:"steps"`
+}
+
+// String renders a step per line with its dependencies, e.g. `3. sync a:a-copy <- index:a:a-copy`
+func (plan *Plan) String() string {
+	var builder strings.Builder
+	for i, step := range plan.Steps {
+		_, _ = fmt.Fprintf(&builder, "%d. %s %s", i+1, step.Kind, step.Target)
+		if len(step.DependsOn) > 0 {
+			_, _ = fmt.Fprintf(&builder, " <- %s", strings.Join(step.DependsOn, ", "))
+		}
+		builder.WriteString("\n")
+	}
+	return builder.String()
+}
+
+// Plan returns the steps SyncAll runs without running any: the index templates, then the target index of every
+// index pair after the templates matching it, the sync of every pair and the swap of its alias.
+func (m *BulkMigrator) Plan() (*Plan, error) {
+	newBulkMigrator := m.getIndexPairsFromPattern()
+	if newBulkMigrator.Error != nil {
+		return nil, errors.WithStack(newBulkMigrator.Error)
+	}
+	return newBulkMigrator.buildPlan()
+}
+
+func (m *BulkMigrator) buildPlan() (*Plan, error) {
+	plan := &Plan{}
+
+	indexTemplates := lo.Values(m.IndexTemplates)
+	sort.Slice(indexTemplates, func(i, j int) bool { return indexTemplates[i].Name < indexTemplates[j].Name })
+	templatePatterns := make(map[string][]*regexp.Regexp)
+	for _, indexTemplate := range indexTemplates {
+		stepId := "template:" + indexTemplate.Name
+		for _, pattern := range indexTemplate.Patterns {
+			re, err := wildcardToRegexp(pattern)
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+			templatePatterns[stepId] = append(templatePatterns[stepId], re)
+		}
+		plan.Steps = append(plan.Steps, &PlanStep{
+			ID:            stepId,
+			Kind:          PlanStepCreateTemplate,
+			Target:        indexTemplate.Name,
+			indexTemplate: indexTemplate,
+		})
+	}
+
+	// the order of the run, by key unless ordered by size
+	indexPairs := m.orderedIndexPairs()
+	if !m.OrderBySize {
+		sort.Slice(indexPairs, func(i, j int) bool {
+			return m.getIndexPairKey(indexPairs[i]) < m.getIndexPairKey(indexPairs[j])
+		})
+	}
+
+	var syncSteps, aliasSteps []*PlanStep
+	for _, indexPair := range indexPairs {
+		key := m.getIndexPairKey(indexPair)
+		var templateSteps []string
+		for _, indexTemplate := range indexTemplates {
+			stepId := "template:" + indexTemplate.Name
+			if lo.SomeBy(templatePatterns[stepId], func(re *regexp.Regexp) bool { return re.MatchString(indexPair.TargetIndex) }) {
+				templateSteps = append(templateSteps, stepId)
+			}
+		}
+		plan.Steps = append(plan.Steps, &PlanStep{
+			ID:        "index:" + key,
+			Kind:      PlanStepCreateIndex,
+			Target:    key,
+			DependsOn: templateSteps,
+			indexPair: indexPair,
+		})
+		syncSteps = append(syncSteps, &PlanStep{
+			ID:        "sync:" + key,
+			Kind:      PlanStepSync,
+			Target:    key,
+			DependsOn: []string{"index:" + key},
+			indexPair: indexPair,
+		})
+		if indexPair.SwapAlias != "" {
+			aliasSteps = append(aliasSteps, &PlanStep{
+				ID:        "alias:" + key,
+				Kind:      PlanStepSwapAlias,
+				Target:    indexPair.SwapAlias,
+				DependsOn: []string{"sync:" + key},
+				indexPair: indexPair,
+			})
+		}
+	}
+	plan.Steps = append(plan.Steps, syncSteps...)
+	plan.Steps = append(plan.Steps, aliasSteps...)
+	return plan, nil
+}
+
+// SyncAll runs the steps of Plan, the steps of a kind run in parallel and a step whose dependency failed is
+// skipped. force recreates the existing target indices, the errors of the failed steps are returned together.
+func (m *BulkMigrator) SyncAll(force bool) (*SyncReport, error) {
+	startTime := time.Now()
+	newBulkMigrator := m.getIndexPairsFromPattern()
+	if newBulkMigrator.Error != nil {
+		return nil, errors.WithStack(newBulkMigrator.Error)
+	}
+
+	plan, err := newBulkMigrator.buildPlan()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	report, err := newBulkMigrator.runPlan(plan, force)
+	report.Total.Duration = time.Since(startTime)
+	return report, err
+}
+
+func (m *BulkMigrator) runPlan(plan *Plan, force bool) (*SyncReport, error) {
+	var (
+		mutex    sync.Mutex
+		reported bool
+		failed   = make(map[string]error)
+		errs     utils.Errs
+		report   = &SyncReport{Indexes: make(map[string]*MigrationStats), Failed: make(map[string]error)}
+	)
+	// a step which finishes after its index timeout is left out of the returned report and errors, a failed sync
+	// step is reported under its index pair key like in Sync
+	fail := func(step *PlanStep, err error) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if !reported {
+			failed[step.ID] = err
+			errs.Add(errors.Wrapf(err, "%s", step.ID))
+			if step.Kind == PlanStepSync {
+				report.Failed[step.Target] = err
+			}
+		}
+	}
+
+	// the steps of a kind follow each other in the plan
+	for _, steps := range lo.PartitionBy(plan.Steps, func(step *PlanStep) PlanStepKind { return step.Kind }) {
+		pool := pond.New(cast.ToInt(m.Parallelism), len(steps))
+		for _, step := range steps {
+			mutex.Lock()
+			failedStepId, skipped := lo.Find(step.DependsOn, func(stepId string) bool { return failed[stepId] != nil })
+			if skipped {
+				// the index pair of a skipped sync step failed with the step it depends on
+				failed[step.ID] = errors.Wrapf(failed[failedStepId], "%s", failedStepId)
+				if step.Kind == PlanStepSync {
+					report.Failed[step.Target] = failed[step.ID]
+				}
+			}
+			mutex.Unlock()
+			if skipped {
+				utils.GetLogger(m.ctx).Warnf("skip %s, a step it depends on failed", step.ID)
+				continue
+			}
+
+			pool.Submit(func() {
+				migrator := lo.TernaryF(step.indexTemplate != nil,
+					func() *Migrator { return m.newIndexTemplateMigrator(step.indexTemplate) },
+					func() *Migrator { return m.newIndexPairMigrator(step.indexPair) })
+				err := m.runWithIndexTimeout(migrator, func(migrator *Migrator) {
+					stats, err := m.runPlanStep(migrator, step, force)
+					mutex.Lock()
+					if stats != nil && !reported {
+						report.Indexes[step.Target] = stats
+						report.Total.Add(stats)
+					}
+					mutex.Unlock()
+					if err != nil {
+						utils.GetLogger(migrator.GetCtx()).Errorf("%s %+v", step.ID, err)
+						fail(step, err)
+					}
+				})
+				if err != nil {
+					fail(step, err)
+				}
+			})
+		}
+		pool.StopAndWait()
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	reported = true
+	return report, errs.Ret()
+}
+
+func (m *BulkMigrator) runPlanStep(migrator *Migrator, step *PlanStep, force bool) (*MigrationStats, error) {
+	switch step.Kind {
+	case PlanStepCreateTemplate:
+		return nil, errors.WithStack(migrator.CreateTemplate())
+	case PlanStepCreateIndex:
+		return nil, errors.WithStack(migrator.CopyIndexSettings(force))
+	case PlanStepSync:
+		// the index step created the target already
+		stats, err := m.syncWithRetry(migrator, false)
+		if err == nil && m.ForceMergeSegments > 0 {
+			if err := migrator.ForceMergeTarget(m.ForceMergeSegments); err != nil {
+				utils.GetLogger(migrator.GetCtx()).Errorf("force merge %+v", err)
+			}
+		}
+		return stats, errors.WithStack(err)
+	case PlanStepSwapAlias:
+		return nil, errors.WithStack(migrator.SwapAlias(step.Target))
+	}
+	return nil, utils.NewCustomError(utils.InvalidParams, "unknown plan step %s", step.Kind)
+}
@@ -0,0 +1,101 @@
+package task
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/CharellKing/ela-lib/config"
+	es2 "github.com/CharellKing/ela-lib/pkg/es"
+	"github.com/pkg/errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBulkMigratorPlan(t *testing.T) {
+	sourceES := newFakeES(map[string][]*es2.Doc{"a": newFakeDocs(3), "logs-1": newFakeDocs(3)})
+
+	plan, err := NewBulkMigratorWithES(context.Background(), sourceES, newFakeES(nil)).
+		WithIndexTemplates(
+			&config.IndexTemplate{Name: "metrics", Patterns: []string{"metrics-*"}},
+			&config.IndexTemplate{Name: "logs", Patterns: []string{"logs-*"}}).
+		WithIndexPairs(
+			&config.IndexPair{SourceIndex: "logs-1", TargetIndex: "logs-1"},
+			&config.IndexPair{SourceIndex: "a", TargetIndex: "a-v2", SwapAlias: "a-alias"}).
+		Plan()
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	expected := strings.Join([]string{
+		"1. create_template logs",
+		"2. create_template metrics",
+		"3. create_index a:a-v2",
+		"4. create_index logs-1:logs-1 <- template:logs",
+		"5. sync a:a-v2 <- index:a:a-v2",
+		"6. sync logs-1:logs-1 <- index:logs-1:logs-1",
+		"7. swap_alias a-alias <- sync:a:a-v2",
+	}, "\n") + "\n"
+	if plan.String() != expected {
+		t.Errorf("expect the plan\n%s\ngot\n%s", expected, plan)
+	}
+
+	planJson, _ := json.Marshal(plan.Steps[len(plan.Steps)-1])
+	if string(planJson) != `{"id":"alias:a:a-v2","kind":"swap_alias","target":"a-alias","depends_on":["sync:a:a-v2"]}` {
+		t.Errorf("unexpected json of the alias step %s", planJson)
+	}
+}
+
+func TestBulkMigratorSyncAll(t *testing.T) {
+	sourceES := newFakeES(map[string][]*es2.Doc{"a": newFakeDocs(3), "b": newFakeDocs(3)})
+	targetES := newFakeES(nil)
+	targetES.aliases = map[string][]string{"a-alias": {"a-v1"}, "b-alias": {"b-v1"}}
+	targetES.createErrs = map[string]error{"b-v2": errors.New("mapper_parsing_exception")}
+
+	report, err := NewBulkMigratorWithES(context.Background(), sourceES, targetES).
+		WithIndexPairs(
+			&config.IndexPair{SourceIndex: "a", TargetIndex: "a-v2", SwapAlias: "a-alias"},
+			&config.IndexPair{SourceIndex: "b", TargetIndex: "b-v2", SwapAlias: "b-alias"}).
+		SyncAll(false)
+	if err == nil || !strings.Contains(err.Error(), "index:b:b-v2") {
+		t.Fatalf("expect the error of the b index step, got %v", err)
+	}
+
+	if stats := report.Indexes["a:a-v2"]; stats == nil || stats.DocsWritten != 3 {
+		t.Errorf("expect a synced, got %+v", stats)
+	}
+	// the steps after the failed index step are skipped
+	if _, ok := report.Indexes["b:b-v2"]; ok || len(targetES.written["b-v2"]) != 0 {
+		t.Errorf("expect b not synced, written %d", len(targetES.written["b-v2"]))
+	}
+	if err := report.Failed["b:b-v2"]; err == nil || !strings.Contains(err.Error(), "mapper_parsing_exception") {
+		t.Errorf("expect b reported failed with the index step error, got %v", err)
+	}
+	if err, ok := report.Failed["a:a-v2"]; ok {
+		t.Errorf("expect a not failed, got %+v", err)
+	}
+	if !reflect.DeepEqual(targetES.aliases, map[string][]string{"a-alias": {"a-v2"}, "b-alias": {"b-v1"}}) {
+		t.Errorf("expect only the alias of a swapped, got %v", targetES.aliases)
+	}
+}
+
+func TestBulkMigratorSyncAllReportsFailedSync(t *testing.T) {
+	sourceES := newFakeES(map[string][]*es2.Doc{"a": newFakeDocs(3), "b": newFakeDocs(3)})
+	sourceES.scrollFailures = map[string]int{"a": 1}
+	targetES := newFakeES(nil)
+
+	report, err := NewBulkMigratorWithES(context.Background(), sourceES, targetES).
+		WithIndexPairs(
+			&config.IndexPair{SourceIndex: "a", TargetIndex: "a-v2"},
+			&config.IndexPair{SourceIndex: "b", TargetIndex: "b-v2"}).
+		WithSliceSize(1).
+		SyncAll(false)
+	if err == nil || !strings.Contains(err.Error(), "sync:a:a-v2") {
+		t.Fatalf("expect the error of the a sync step, got %v", err)
+	}
+	if err := report.Failed["a:a-v2"]; err == nil || !strings.Contains(err.Error(), "search_phase_execution_exception") {
+		t.Errorf("expect a reported failed with the scroll error, got %v", err)
+	}
+	if err, ok := report.Failed["b:b-v2"]; ok {
+		t.Errorf("expect b not failed, got %+v", err)
+	}
+}
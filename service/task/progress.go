@@ -0,0 +1,102 @@
+package task
+
+import (
+	"context"
+	"github.com/CharellKing/ela-lib/utils"
+	"sync"
+	"time"
+)
+
+// progressLogger counts every finished task but logs the progress at most once per interval, the last task
+// is always logged. A 0 interval logs every task.
+type progressLogger struct {
+	mutex     sync.Mutex
+	total     int
+	finished  int
+	interval  time.Duration
+	lastLogAt time.Time
+	logf      func(format string, args ...interface{})
+}
+
+func newProgressLogger(ctx context.Context, total int, interval time.Duration) *progressLogger {
+	return &progressLogger{
+		total:     total,
+		interval:  interval,
+		lastLogAt: time.Now(),
+		logf:      utils.GetLogger(ctx).Infof,
+	}
+}
+
+func (p *progressLogger) done() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.finished++
+	now := time.Now()
+	if p.finished < p.total && now.Sub(p.lastLogAt) < p.interval {
+		return
+	}
+	p.lastLogAt = now
+
+	progress := float64(0)
+	if p.total > 0 {
+		progress = float64(p.finished) / float64(p.total)
+	}
+	p.logf("task progress %0.4f (%d, %d)", progress, p.finished, p.total)
+}
+
+// docProgress sums the docs bulked by all the index pairs against their total, it logs at most once per
+// everyLogTime and when the total is reached. The total is either pre-counted or grows with the count of every
+// index pair as its sync starts.
+type docProgress struct {
+	mutex      sync.Mutex
+	total      uint64
+	preCounted bool
+	done       uint64
+	lastLogAt  time.Time
+	logf       func(format string, args ...interface{})
+}
+
+func newDocProgress(ctx context.Context, total uint64) *docProgress {
+	return &docProgress{
+		total:      total,
+		preCounted: true,
+		lastLogAt:  time.Now(),
+		logf:       utils.GetLogger(ctx).Infof,
+	}
+}
+
+func newGrowingDocProgress(ctx context.Context) *docProgress {
+	return &docProgress{
+		lastLogAt: time.Now(),
+		logf:      utils.GetLogger(ctx).Infof,
+	}
+}
+
+// addTotal adds the counted docs of an index pair to the total unless it is pre-counted
+func (p *docProgress) addTotal(count uint64) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if !p.preCounted {
+		p.total += count
+	}
+}
+
+func (p *docProgress) add(count uint64) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.done += count
+	now := time.Now()
+	if p.done < p.total && now.Sub(p.lastLogAt) < everyLogTime {
+		return
+	}
+	p.lastLogAt = now
+
+	progress := float64(0)
+	if p.total > 0 {
+		progress = float64(p.done) / float64(p.total)
+	}
+	p.logf("total progress %0.4f (%d, %d)", progress, p.done, p.total)
+}
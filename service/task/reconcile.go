@@ -0,0 +1,77 @@
+package task
+
+import (
+	"bytes"
+	"context"
+	es2 "github.com/CharellKing/ela-lib/pkg/es"
+	"github.com/CharellKing/ela-lib/utils"
+	"github.com/pkg/errors"
+	"github.com/samber/lo"
+	"io"
+)
+
+// ToBulkFile writes the NDJSON bulk body which brings the target in line with the source, an index action with the
+// source doc for every create and update diff and a delete action for every delete diff. The docs are read again
+// from both clusters, so only a result of Compare can be written, not one resumed from a checkpoint file.
+func (diffResult *DiffResult) ToBulkFile(w io.Writer) error {
+	if diffResult.migrator == nil {
+		return utils.NewCustomError(utils.InvalidParams, "the diff result is not compared by a migrator, its docs can't be read")
+	}
+	return diffResult.migrator.writeReconciliation(diffResult, w)
+}
+
+func (m *Migrator) writeReconciliation(diffResult *DiffResult, w io.Writer) error {
+	ctx, err := m.buildIndexPairContext()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if err := m.writeReconciliationActions(ctx, m.SourceES, m.IndexPair.SourceIndex,
+		lo.Union(diffResult.CreateDocs, diffResult.UpdateDocs), es2.OperationCreate, w); err != nil {
+		return errors.WithStack(err)
+	}
+	// the deleted docs are read from the target for their type and routing
+	return m.writeReconciliationActions(ctx, m.TargetES, m.IndexPair.TargetIndex, diffResult.DeleteDocs,
+		es2.OperationDelete, w)
+}
+
+// writeReconciliationActions writes an action of operation on the target index for every doc of ids in index
+func (m *Migrator) writeReconciliationActions(ctx context.Context, es es2.ES, index string, ids []string,
+	operation es2.Operation, w io.Writer) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	errCh := make(chan error)
+	errsCh := m.handleMultipleErrors(errCh)
+	docCh, _ := m.search(ctx, es, index, getQueryMap(ids), nil, 0, errCh, false)
+	if docCh == nil {
+		// search closed errCh with the error of its count
+		errs := <-errsCh
+		return errs.Ret()
+	}
+
+	var (
+		buf      bytes.Buffer
+		writeErr error
+	)
+	// the scroll is drained on a write error, so that it is cleared
+	for doc := range docCh {
+		if writeErr != nil {
+			continue
+		}
+		doc.Op = operation
+		if m.TargetType != "" && !m.TargetES.ClusterVersionGte7() {
+			doc.Type = m.TargetType
+		}
+		buf.Reset()
+		if writeErr = m.TargetES.BulkBody(m.IndexPair.TargetIndex, &buf, doc); writeErr != nil {
+			continue
+		}
+		_, writeErr = w.Write(buf.Bytes())
+	}
+	close(errCh)
+	errs := <-errsCh
+	errs.Add(errors.WithStack(writeErr))
+	return errs.Ret()
+}
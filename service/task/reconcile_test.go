@@ -0,0 +1,70 @@
+package task
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/CharellKing/ela-lib/config"
+	es2 "github.com/CharellKing/ela-lib/pkg/es"
+	"github.com/CharellKing/ela-lib/utils"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestDiffResultToBulkFile(t *testing.T) {
+	// source 0-4, the target lacks 3 and 4, has 2 changed and an extra doc
+	targetDocs := newFakeDocs(3)
+	targetDocs[2].Source = map[string]interface{}{"value": "changed"}
+	sourceES := newFakeES(map[string][]*es2.Doc{"a": newFakeDocs(5)})
+	targetES := newFakeES(map[string][]*es2.Doc{"b": append(targetDocs, &es2.Doc{ID: "extra", Source: map[string]interface{}{}})})
+
+	diffResult, err := NewMigrator(context.Background(), sourceES, targetES).
+		WithIndexPair(config.IndexPair{SourceIndex: "a", TargetIndex: "b"}).
+		Compare()
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := diffResult.ToBulkFile(&buf); err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	actions := make(map[es2.Operation][]string)
+	scanner := bufio.NewScanner(&buf)
+	for scanner.Scan() {
+		var action struct {
+			Index string   `json:"index"`
+			Doc   *es2.Doc `json:"doc"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &action); err != nil {
+			t.Fatalf("%+v", err)
+		}
+		if action.Index != "b" {
+			t.Errorf("expect the actions on the target index, got %s", action.Index)
+		}
+		if action.Doc.Op == es2.OperationCreate && action.Doc.Source["value"] == nil {
+			t.Errorf("expect the source doc in the index action of %s", action.Doc.ID)
+		}
+		actions[action.Doc.Op] = append(actions[action.Doc.Op], action.Doc.ID)
+	}
+	for _, ids := range actions {
+		sort.Strings(ids)
+	}
+
+	expected := map[es2.Operation][]string{
+		es2.OperationCreate: {"2", "3", "4"},
+		es2.OperationDelete: {"extra"},
+	}
+	if !reflect.DeepEqual(actions, expected) {
+		t.Errorf("expect the actions %v, got %v", expected, actions)
+	}
+
+	// a result resumed from a checkpoint has no migrator to read the docs
+	var resumed DiffResult
+	if err := resumed.ToBulkFile(&buf); !utils.IsCustomError(err, utils.InvalidParams) {
+		t.Errorf("expect an invalid params error, got %v", err)
+	}
+}
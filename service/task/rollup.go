@@ -0,0 +1,207 @@
+package task
+
+import (
+	"context"
+	"github.com/CharellKing/ela-lib/config"
+	es2 "github.com/CharellKing/ela-lib/pkg/es"
+	"github.com/CharellKing/ela-lib/utils"
+	"github.com/pkg/errors"
+	"github.com/samber/lo"
+	"github.com/spf13/cast"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// rollupDocCountField counts the source docs of a bucket doc
+const rollupDocCountField = "doc_count"
+
+// rollupBucket aggregates the source docs of a time bucket and group
+type rollupBucket struct {
+	start  time.Time
+	group  []interface{}
+	count  uint64
+	sums   map[string]float64
+	mins   map[string]float64
+	maxs   map[string]float64
+	values map[string]uint64
+}
+
+func validateRollup(rollup *config.RollupCfg) error {
+	if lo.IsEmpty(rollup.TimeField) {
+		return utils.NewCustomError(utils.InvalidParams, "rollup requires a time field")
+	}
+	if !lo.Contains([]config.RollupInterval{config.RollupIntervalHour, config.RollupIntervalDay,
+		config.RollupIntervalMonth, config.RollupIntervalYear}, rollup.Interval) {
+		return utils.NewCustomError(utils.InvalidParams, "unknown rollup interval %s", rollup.Interval)
+	}
+	for field, metrics := range rollup.Metrics {
+		for _, metric := range metrics {
+			if !lo.Contains([]config.RollupMetric{config.RollupMetricSum, config.RollupMetricMin,
+				config.RollupMetricMax, config.RollupMetricAvg}, metric) {
+				return utils.NewCustomError(utils.InvalidParams, "unknown rollup metric %s of %s", metric, field)
+			}
+		}
+	}
+	return nil
+}
+
+// rollupBucketStart truncates t to the start of its interval in UTC
+func rollupBucketStart(t time.Time, interval config.RollupInterval) time.Time {
+	t = t.UTC()
+	switch interval {
+	case config.RollupIntervalHour:
+		return t.Truncate(time.Hour)
+	case config.RollupIntervalDay:
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	case config.RollupIntervalMonth:
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+	default:
+		return time.Date(t.Year(), 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+}
+
+// rollupBucketLabel names the bucket in the ids of the bucket docs, e.g. `2024-03` for a month
+func rollupBucketLabel(start time.Time, interval config.RollupInterval) string {
+	switch interval {
+	case config.RollupIntervalHour:
+		return start.Format("2006-01-02T15")
+	case config.RollupIntervalDay:
+		return start.Format("2006-01-02")
+	case config.RollupIntervalMonth:
+		return start.Format("2006-01")
+	default:
+		return start.Format("2006")
+	}
+}
+
+// parseRollupTime reads a date the way es does by default, a number is epoch millis
+func parseRollupTime(value interface{}) (time.Time, error) {
+	switch value := value.(type) {
+	case nil:
+		return time.Time{}, errors.New("no time")
+	case string:
+		if millis, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return time.UnixMilli(millis), nil
+		}
+		t, err := cast.ToTimeE(value)
+		return t, errors.WithStack(err)
+	default:
+		millis, err := cast.ToInt64E(value)
+		return time.UnixMilli(millis), errors.WithStack(err)
+	}
+}
+
+func (bucket *rollupBucket) add(rollup *config.RollupCfg, source map[string]interface{}) {
+	bucket.count++
+	for field := range rollup.Metrics {
+		value, ok := utils.GetValueFromMapByPath(source, field)
+		if !ok {
+			continue
+		}
+		number, err := cast.ToFloat64E(value)
+		if err != nil {
+			continue
+		}
+
+		if bucket.values[field] == 0 {
+			bucket.mins[field], bucket.maxs[field] = number, number
+		}
+		bucket.mins[field] = min(bucket.mins[field], number)
+		bucket.maxs[field] = max(bucket.maxs[field], number)
+		bucket.sums[field] += number
+		bucket.values[field]++
+	}
+}
+
+func (bucket *rollupBucket) doc(rollup *config.RollupCfg, id string) *es2.Doc {
+	source := map[string]interface{}{rollupDocCountField: bucket.count}
+	utils.SetValueFromMapByPath(source, rollup.TimeField, bucket.start.Format(time.RFC3339))
+	for i, field := range rollup.GroupBy {
+		utils.SetValueFromMapByPath(source, field, bucket.group[i])
+	}
+	for field, metrics := range rollup.Metrics {
+		// a field without a number in the bucket has no metric
+		if bucket.values[field] == 0 {
+			continue
+		}
+		for _, metric := range metrics {
+			var value float64
+			switch metric {
+			case config.RollupMetricSum:
+				value = bucket.sums[field]
+			case config.RollupMetricMin:
+				value = bucket.mins[field]
+			case config.RollupMetricMax:
+				value = bucket.maxs[field]
+			case config.RollupMetricAvg:
+				value = bucket.sums[field] / float64(bucket.values[field])
+			}
+			utils.SetValueFromMapByPath(source, field+"_"+string(metric), value)
+		}
+	}
+	return &es2.Doc{ID: id, Source: source}
+}
+
+// rollupDocs aggregates all the docs of docCh into a doc per time bucket and group, written once the source is read.
+// The id of a bucket doc is its bucket and group, so a re-run overwrites the same docs. The docs without a time are
+// dropped with a warning.
+func (m *Migrator) rollupDocs(ctx context.Context, docCh chan *es2.Doc) chan *es2.Doc {
+	outCh := make(chan *es2.Doc, m.BufferCount)
+
+	utils.GoRecovery(m.GetCtx(), func() {
+		defer close(outCh)
+
+		var skipCount int
+		buckets := make(map[string]*rollupBucket)
+		for doc := range docCh {
+			value, _ := utils.GetValueFromMapByPath(doc.Source, m.Rollup.TimeField)
+			t, err := parseRollupTime(value)
+			if err != nil {
+				skipCount++
+				continue
+			}
+
+			start := rollupBucketStart(t, m.Rollup.Interval)
+			group := lo.Map(m.Rollup.GroupBy, func(field string, _ int) interface{} {
+				value, _ := utils.GetValueFromMapByPath(doc.Source, field)
+				return value
+			})
+			keys := append([]string{rollupBucketLabel(start, m.Rollup.Interval)},
+				lo.Map(group, func(value interface{}, _ int) string {
+					if s, ok := value.(string); ok {
+						return s
+					}
+					return jsonString(value)
+				})...)
+			key := strings.Join(keys, "|")
+
+			bucket, ok := buckets[key]
+			if !ok {
+				bucket = &rollupBucket{
+					start:  start,
+					group:  group,
+					sums:   make(map[string]float64),
+					mins:   make(map[string]float64),
+					maxs:   make(map[string]float64),
+					values: make(map[string]uint64),
+				}
+				buckets[key] = bucket
+			}
+			bucket.add(m.Rollup, doc.Source)
+		}
+
+		if skipCount > 0 {
+			utils.GetLogger(ctx).Warnf("rollup skipped %d docs without a time in %s", skipCount, m.Rollup.TimeField)
+		}
+		keys := lo.Keys(buckets)
+		sort.Strings(keys)
+		utils.GetLogger(ctx).Infof("rollup wrote %d buckets", len(keys))
+		for _, key := range keys {
+			outCh <- buckets[key].doc(m.Rollup, key)
+		}
+	})
+
+	return outCh
+}
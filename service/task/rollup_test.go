@@ -0,0 +1,66 @@
+package task
+
+import (
+	"context"
+	"github.com/CharellKing/ela-lib/config"
+	es2 "github.com/CharellKing/ela-lib/pkg/es"
+	"github.com/CharellKing/ela-lib/utils"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMigratorRollup(t *testing.T) {
+	// the daily counts of two hosts over two months, one day of march is epoch millis and one doc has no day
+	dailyCount := func(id string, day interface{}, host string, count int) *es2.Doc {
+		return &es2.Doc{ID: id, Source: map[string]interface{}{"day": day, "host": host, "count": float64(count)}}
+	}
+	sourceES := newFakeES(map[string][]*es2.Doc{"counts-daily": {
+		dailyCount("1", "2024-02-01", "a", 1),
+		dailyCount("2", "2024-02-29", "a", 2),
+		dailyCount("3", "2024-02-10", "b", 4),
+		dailyCount("4", "2024-03-01T00:00:00Z", "a", 8),
+		dailyCount("5", float64(time.Date(2024, 3, 31, 12, 0, 0, 0, time.UTC).UnixMilli()), "a", 16),
+		{ID: "6", Source: map[string]interface{}{"host": "a", "count": float64(32)}},
+	}})
+	targetES := newFakeES(nil)
+
+	err := NewMigrator(context.Background(), sourceES, targetES).
+		WithIndexPair(config.IndexPair{SourceIndex: "counts-daily", TargetIndex: "counts-monthly"}).
+		WithRollup(&config.RollupCfg{
+			TimeField: "day",
+			Interval:  config.RollupIntervalMonth,
+			GroupBy:   []string{"host"},
+			Metrics:   map[string][]config.RollupMetric{"count": {config.RollupMetricSum, config.RollupMetricMax}},
+		}).
+		Sync(false)
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+
+	monthly := func(month string, host string, docCount float64, sum float64, max float64) map[string]interface{} {
+		return map[string]interface{}{"day": month, "host": host, "doc_count": docCount, "count_sum": sum, "count_max": max}
+	}
+	expected := map[string]map[string]interface{}{
+		"2024-02|a": monthly("2024-02-01T00:00:00Z", "a", 2, 3, 2),
+		"2024-02|b": monthly("2024-02-01T00:00:00Z", "b", 1, 4, 4),
+		"2024-03|a": monthly("2024-03-01T00:00:00Z", "a", 2, 24, 16),
+	}
+	written := targetES.written["counts-monthly"]
+	if len(written) != len(expected) {
+		t.Fatalf("expect %d monthly docs, got %d", len(expected), len(written))
+	}
+	for id, source := range expected {
+		if doc := written[id]; doc == nil || !reflect.DeepEqual(doc.Source, source) {
+			t.Errorf("expect doc %s %v, got %+v", id, source, doc)
+		}
+	}
+
+	err = NewMigrator(context.Background(), sourceES, targetES).
+		WithIndexPair(config.IndexPair{SourceIndex: "counts-daily", TargetIndex: "counts-monthly"}).
+		WithRollup(&config.RollupCfg{TimeField: "day", Interval: "week"}).
+		Sync(false)
+	if !utils.IsCustomError(err, utils.InvalidParams) {
+		t.Errorf("expect an unknown interval refused, got %v", err)
+	}
+}
@@ -0,0 +1,118 @@
+package task
+
+import (
+	"github.com/CharellKing/ela-lib/utils"
+	"github.com/pkg/errors"
+	"github.com/samber/lo"
+	"github.com/spf13/cast"
+	"sort"
+	"strings"
+)
+
+// ignoredSettings are set by es itself for every index, they always differ between the source and the target
+var ignoredSettings = []string{
+	"index.provided_name",
+	"index.creation_date",
+	"index.uuid",
+	"index.version",
+	"index.routing.allocation.initial_recovery",
+	"index.resize",
+	"index.shrink",
+}
+
+// SettingDiff is a setting or a mapping path whose value differs, the side lacking it has nil
+type SettingDiff struct {
+	Path   string      `json:"path"`
+	Source interface{} `json:"source"`
+	Target interface{} `json:"target"`
+}
+
+// SettingsDiffResult lists the differing paths of the index settings, e.g. `index.number_of_shards`, and of the
+// mappings, e.g. `properties.title.type`, sorted by path
+type SettingsDiffResult struct {
+	Settings []SettingDiff `json:"settings,omitempty"`
+	Mappings []SettingDiff `json:"mappings,omitempty"`
+}
+
+func (settingsDiff *SettingsDiffResult) HasDiff() bool {
+	return settingsDiff != nil && (len(settingsDiff.Settings) > 0 || len(settingsDiff.Mappings) > 0)
+}
+
+// CompareSettings diffs the index settings and mappings of the source and the target, the settings es sets itself
+// like the uuid and the creation date are left out. The mappings are compared field by field whatever the version,
+// the types of a typed mapping are merged like a sync does.
+func (m *Migrator) CompareSettings() (*SettingsDiffResult, error) {
+	if m.err != nil {
+		return nil, errors.WithStack(m.err)
+	}
+
+	existed, err := m.TargetES.IndexExisted(m.IndexPair.TargetIndex)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if !existed {
+		return nil, utils.NewCustomError(utils.NonIndexExisted, "target index %s not existed", m.IndexPair.TargetIndex)
+	}
+
+	sourceSettings, err := m.SourceES.GetIndexMappingAndSetting(m.IndexPair.SourceIndex)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	targetSettings, err := m.TargetES.GetIndexMappingAndSetting(m.IndexPair.TargetIndex)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	indexSettings := func(settings map[string]interface{}, index string) map[string]interface{} {
+		flattened := flattenSettings("index", cast.ToStringMap(cast.ToStringMap(
+			cast.ToStringMap(settings[index])["settings"])["index"]))
+		return lo.OmitBy(flattened, func(path string, _ interface{}) bool {
+			return lo.SomeBy(ignoredSettings, func(ignored string) bool {
+				return path == ignored || strings.HasPrefix(path, ignored+".")
+			})
+		})
+	}
+	return &SettingsDiffResult{
+		Settings: diffSettings(indexSettings(sourceSettings.GetSettings(), m.IndexPair.SourceIndex),
+			indexSettings(targetSettings.GetSettings(), m.IndexPair.TargetIndex)),
+		Mappings: diffSettings(flattenSettings("", sourceSettings.GetProperties()),
+			flattenSettings("", targetSettings.GetProperties())),
+	}, nil
+}
+
+// flattenSettings maps the dotted path of every leaf of settings to its value, the settings es answers flat or nested
+// are flattened alike
+func flattenSettings(prefix string, settings map[string]interface{}) map[string]interface{} {
+	flattened := make(map[string]interface{})
+	for key, value := range settings {
+		path := joinFieldPath(prefix, key)
+		if valueMap, ok := value.(map[string]interface{}); ok && len(valueMap) > 0 {
+			flattened = lo.Assign(flattened, flattenSettings(path, valueMap))
+			continue
+		}
+		flattened[path] = value
+	}
+	return flattened
+}
+
+// diffSettings compares the values by their text, es answers the numbers of the settings as strings
+func diffSettings(source map[string]interface{}, target map[string]interface{}) []SettingDiff {
+	settingString := func(value interface{}) string {
+		if s, ok := value.(string); ok {
+			return s
+		}
+		return jsonString(value)
+	}
+
+	var diffs []SettingDiff
+	for _, path := range lo.Union(lo.Keys(source), lo.Keys(target)) {
+		sourceValue, sourceOk := source[path]
+		targetValue, targetOk := target[path]
+		if sourceOk == targetOk && settingString(sourceValue) == settingString(targetValue) {
+			continue
+		}
+		diffs = append(diffs, SettingDiff{Path: path, Source: sourceValue, Target: targetValue})
+	}
+	sort.Slice(diffs, func(i, j int) bool { return diffs[i].Path < diffs[j].Path })
+	return diffs
+}
@@ -0,0 +1,69 @@
+package task
+
+import (
+	"context"
+	"github.com/CharellKing/ela-lib/config"
+	es2 "github.com/CharellKing/ela-lib/pkg/es"
+	"reflect"
+	"testing"
+)
+
+func TestMigratorCompareSettings(t *testing.T) {
+	mapping := func(countType string) map[string]interface{} {
+		return map[string]interface{}{
+			"title": map[string]interface{}{"type": "text", "fields": map[string]interface{}{
+				"raw": map[string]interface{}{"type": "keyword"},
+			}},
+			"count": map[string]interface{}{"type": countType},
+		}
+	}
+	sourceES := newFakeES(map[string][]*es2.Doc{"a": newFakeDocs(3)})
+	sourceES.mappings = map[string]map[string]interface{}{"a": mapping("long")}
+	targetES := newFakeES(map[string][]*es2.Doc{"a": newFakeDocs(3)})
+	targetES.mappings = map[string]map[string]interface{}{"a": mapping("integer")}
+
+	settingsDiff, err := NewMigrator(context.Background(), sourceES, targetES).
+		WithIndexPair(config.IndexPair{SourceIndex: "a", TargetIndex: "a"}).
+		CompareSettings()
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	expected := []SettingDiff{{Path: "properties.count.type", Source: "long", Target: "integer"}}
+	if !reflect.DeepEqual(settingsDiff.Mappings, expected) || len(settingsDiff.Settings) != 0 {
+		t.Errorf("expect the mapping diff %+v, got %+v", expected, settingsDiff)
+	}
+
+	// the docs are the same, the index pair is reported for its mapping alone
+	result, err := NewBulkMigratorWithES(context.Background(), sourceES, targetES).
+		WithIndexPairs(&config.IndexPair{SourceIndex: "a", TargetIndex: "a"}).
+		WithCompareSettings(true).
+		Compare()
+	if err != nil {
+		t.Fatalf("%+v", err)
+	}
+	if diffResult := result["a:a"]; diffResult == nil || diffResult.SameCount.Load() != 3 ||
+		!reflect.DeepEqual(diffResult.SettingsDiff.Mappings, expected) {
+		t.Errorf("expect the settings diff in the compare result, got %+v", diffResult)
+	}
+}
+
+func TestDiffSettings(t *testing.T) {
+	source := flattenSettings("index", map[string]interface{}{
+		"number_of_shards": "1",
+		"refresh_interval": "1s",
+		"analysis":         map[string]interface{}{"analyzer": map[string]interface{}{"default": map[string]interface{}{"type": "standard"}}},
+	})
+	// es answers numbers as strings, a number of the other side is the same setting
+	target := flattenSettings("", map[string]interface{}{
+		"index.number_of_shards": 1,
+		"index.analysis":         map[string]interface{}{"analyzer.default.type": "simple"},
+	})
+
+	expected := []SettingDiff{
+		{Path: "index.analysis.analyzer.default.type", Source: "standard", Target: "simple"},
+		{Path: "index.refresh_interval", Source: "1s", Target: nil},
+	}
+	if diffs := diffSettings(source, target); !reflect.DeepEqual(diffs, expected) {
+		t.Errorf("expect %+v, got %+v", expected, diffs)
+	}
+}
@@ -0,0 +1,72 @@
+package task
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// MigrationStats counts what a sync read and wrote
+type MigrationStats struct {
+	DocsRead    uint64
+	DocsWritten uint64
+	// DocsFailed are the docs es rejected or whose bulk request failed
+	DocsFailed   uint64
+	BytesWritten uint64
+	Duration     time.Duration
+	// Retries counts the resent bulk requests, the migrator does not resend a failed one so far
+	Retries uint64
+}
+
+// Add sums other into stats, the durations of parallel syncs overlap so Duration is kept as is
+func (stats *MigrationStats) Add(other *MigrationStats) {
+	stats.DocsRead += other.DocsRead
+	stats.DocsWritten += other.DocsWritten
+	stats.DocsFailed += other.DocsFailed
+	stats.BytesWritten += other.BytesWritten
+	stats.Retries += other.Retries
+}
+
+// SyncReport is the stats of every index pair and their total
+type SyncReport struct {
+	Total   MigrationStats
+	Indexes map[string]*MigrationStats
+	// Failed are the errors of the index pairs whose sync failed or ran out of the index timeout
+	Failed map[string]error
+}
+
+// syncStats collects the stats of the bulk workers, a nil syncStats collects nothing
+type syncStats struct {
+	docsRead     atomic.Uint64
+	docsWritten  atomic.Uint64
+	docsFailed   atomic.Uint64
+	bytesWritten atomic.Uint64
+}
+
+func (stats *syncStats) read() {
+	if stats != nil {
+		stats.docsRead.Add(1)
+	}
+}
+
+func (stats *syncStats) written(docs int, bytes int) {
+	if stats != nil {
+		stats.docsWritten.Add(uint64(docs))
+		stats.bytesWritten.Add(uint64(bytes))
+	}
+}
+
+func (stats *syncStats) failed(docs int) {
+	if stats != nil {
+		stats.docsFailed.Add(uint64(docs))
+	}
+}
+
+func (stats *syncStats) snapshot(duration time.Duration) *MigrationStats {
+	return &MigrationStats{
+		DocsRead:     stats.docsRead.Load(),
+		DocsWritten:  stats.docsWritten.Load(),
+		DocsFailed:   stats.docsFailed.Load(),
+		BytesWritten: stats.bytesWritten.Load(),
+		Duration:     duration,
+	}
+}
@@ -9,13 +9,19 @@ import (
 	"github.com/google/uuid"
 	"github.com/pkg/errors"
 	"github.com/samber/lo"
+	"os"
+	"os/signal"
 	"strings"
+	"syscall"
+	"time"
 )
 
 type Task struct {
 	bulkMigrator *BulkMigrator
 	force        bool
 	showProgress bool
+	// cancelOnSignal cancels Run on SIGINT or SIGTERM
+	cancelOnSignal bool
 }
 
 func NewTaskWithES(ctx context.Context, taskCfg *config.TaskCfg, sourceES, targetES es.ES) *Task {
@@ -23,10 +29,12 @@ func NewTaskWithES(ctx context.Context, taskCfg *config.TaskCfg, sourceES, targe
 
 	if lo.IsNotEmpty(sourceES) {
 		ctx = utils.SetCtxKeySourceESVersion(ctx, sourceES.GetClusterVersion())
+		ctx = utils.SetCtxKeySourceESName(ctx, sourceES.GetClusterName())
 	}
 
 	if lo.IsNotEmpty(targetES) {
 		ctx = utils.SetCtxKeyTargetESVersion(ctx, targetES.GetClusterVersion())
+		ctx = utils.SetCtxKeyTargetESName(ctx, targetES.GetClusterName())
 	}
 
 	ctx = utils.SetCtxKeyTaskName(ctx, taskCfg.Name)
@@ -35,6 +43,7 @@ func NewTaskWithES(ctx context.Context, taskCfg *config.TaskCfg, sourceES, targe
 
 	bulkMigrator := NewBulkMigratorWithES(ctx, sourceES, targetES)
 	bulkMigrator = bulkMigrator.WithIndexPairs(taskCfg.IndexPairs...).
+		WithIndexListFile(taskCfg.IndexListFile).
 		WithParallelism(taskCfg.Parallelism).
 		WithScrollSize(taskCfg.ScrollSize).
 		WithScrollTime(taskCfg.ScrollTime).
@@ -45,14 +54,55 @@ func NewTaskWithES(ctx context.Context, taskCfg *config.TaskCfg, sourceES, targe
 		WithIds(taskCfg.Ids).
 		WithIndexFilePairs(taskCfg.IndexFilePairs...).
 		WithIndexFileRoot(taskCfg.IndexFileRoot).
-		WithIndexTemplates(taskCfg.IndexTemplates...)
+		WithIndexTemplates(taskCfg.IndexTemplates...).
+		WithMaxDocs(taskCfg.MaxDocs).
+		WithAutoSlice(taskCfg.AutoSlice).
+		WithReadParallel(taskCfg.ReadParallel).
+		WithMaxOpenScrolls(taskCfg.MaxOpenScrolls).
+		WithMaxInflightBulk(taskCfg.MaxInflightBulk).
+		WithStreamBulk(taskCfg.StreamBulk).
+		WithSortFields(taskCfg.SortFields).
+		WithMultiTypePolicy(taskCfg.MultiTypePolicy).
+		WithClosedIndexPolicy(taskCfg.ClosedIndexPolicy).
+		WithTemplateConflictPolicy(taskCfg.TemplateConflictPolicy).
+		WithWaitForActiveShards(taskCfg.WaitForActiveShards).
+		WithRequireExistingTarget(taskCfg.RequireExistingTarget).
+		WithVerifyCount(taskCfg.VerifyCount).
+		WithBulkRefreshPolicy(taskCfg.BulkRefreshPolicy).
+		WithIndexRetry(taskCfg.IndexRetry).
+		WithIndexRetryCleanup(taskCfg.IndexRetryCleanup).
+		WithRollup(taskCfg.Rollup).
+		WithBulkDedup(taskCfg.BulkDedup).
+		WithConflictPolicy(taskCfg.ConflictPolicy, taskCfg.TimestampField).
+		WithForceMergeSegments(taskCfg.ForceMergeSegments).
+		WithTargetType(taskCfg.TargetType).
+		WithStrictCompatibility(taskCfg.StrictCompatibility).
+		WithIndexTimeout(time.Duration(taskCfg.IndexTimeout) * time.Second).
+		WithProgressLogInterval(time.Duration(taskCfg.ProgressLogInterval) * time.Second).
+		WithAutoGenerateIds(taskCfg.AutoGenerateIds).
+		WithUnorderedArrayFields(taskCfg.UnorderedArrayFields).
+		WithCompareIgnoreFields(taskCfg.CompareIgnoreFields).
+		WithCompareCheckpoint(taskCfg.CompareCheckpointFile).
+		WithCompareSettings(taskCfg.CompareSettings).
+		WithAllowSelf(taskCfg.AllowSelf).
+		WithProgressTotalDocs(taskCfg.ProgressTotalDocs).
+		WithStartStagger(time.Duration(taskCfg.StartStagger) * time.Millisecond)
+	if taskCfg.TrackTotalHits != nil {
+		bulkMigrator = bulkMigrator.WithTrackTotalHits(*taskCfg.TrackTotalHits)
+	}
 	if taskCfg.IndexPattern != nil {
 		bulkMigrator = bulkMigrator.WithPatternIndexes(*taskCfg.IndexPattern)
 	}
+	bulkMigrator = bulkMigrator.WithSizeFilter(taskCfg.MinIndexBytes, taskCfg.MaxIndexBytes)
+	if taskCfg.SizeOrdering != "" {
+		bulkMigrator = bulkMigrator.WithSizeOrdering(strings.EqualFold(taskCfg.SizeOrdering, "desc"))
+	}
+	bulkMigrator = bulkMigrator.WithDenyIndexes(taskCfg.DenyIndexes)
 
 	return &Task{
-		bulkMigrator: bulkMigrator,
-		force:        taskCfg.Force,
+		bulkMigrator:   bulkMigrator,
+		force:          taskCfg.Force,
+		cancelOnSignal: taskCfg.CancelOnSignal,
 	}
 }
 
@@ -88,6 +138,10 @@ func (t *Task) SyncDiff() (map[string]*DiffResult, error) {
 	return t.bulkMigrator.SyncDiff()
 }
 
+func (t *Task) Repair() (map[string]*RepairResult, error) {
+	return t.bulkMigrator.Repair()
+}
+
 func (t *Task) Sync() error {
 	return t.bulkMigrator.Sync(t.force)
 }
@@ -108,7 +162,28 @@ func (t *Task) CreateTemplate() error {
 	return t.bulkMigrator.CreateTemplates()
 }
 
+// Pause holds the running migration of the task until Resume, see BulkMigrator.Pause
+func (t *Task) Pause() {
+	t.bulkMigrator.Pause()
+}
+
+func (t *Task) Resume() {
+	t.bulkMigrator.Resume()
+}
+
 func (t *Task) Run() error {
+	if t.cancelOnSignal {
+		// the scroll slices see the cancelled context and clear their scrolls before Run returns, so an interrupted
+		// task leaves no scroll open on the source
+		ctx, stop := signal.NotifyContext(t.GetCtx(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		task := *t
+		task.cancelOnSignal = false
+		task.bulkMigrator = t.bulkMigrator.withCtx(ctx)
+		return task.Run()
+	}
+
 	ctx := t.GetCtx()
 	taskAction := config.TaskAction(utils.GetCtxKeyTaskAction(ctx))
 	switch taskAction {
@@ -156,12 +231,36 @@ func (t *Task) Run() error {
 				WithField("deleteDocs", diffResult.DeleteDocs).
 				Info("difference")
 		}
+	case config.TaskActionRepair:
+		repairResultMap, err := t.Repair()
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		for indexes, repairResult := range repairResultMap {
+			indexArray := strings.Split(indexes, ":")
+			utils.GetLogger(t.GetCtx()).
+				WithField("sourceIndex", indexArray[0]).
+				WithField("targetIndex", indexArray[1]).
+				WithField("repaired", repairResult.RepairedCount).
+				WithField("deleted", repairResult.DeletedCount).
+				Info("repair")
+		}
 	case config.TaskActionImport:
 		return t.Import()
 	case config.TaskActionExport:
 		return t.Export()
 	case config.TaskActionTemplate:
 		return t.CreateTemplate()
+	case config.TaskActionPlan:
+		plan, err := t.bulkMigrator.Plan()
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		utils.GetLogger(ctx).Infof("plan:\n%s", plan)
+	case config.TaskActionSyncAll:
+		_, err := t.bulkMigrator.SyncAll(t.force)
+		return errors.WithStack(err)
 	default:
 		taskName := utils.GetCtxKeyTaskName(ctx)
 		return fmt.Errorf("%s invalid task action %s", taskName, taskAction)
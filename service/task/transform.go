@@ -0,0 +1,33 @@
+package task
+
+import (
+	es2 "github.com/CharellKing/ela-lib/pkg/es"
+)
+
+// mergeFieldsScript overwrites the fields of the target doc with the ones of the source doc, the other target
+// fields are kept
+const mergeFieldsScript = "ctx._source.putAll(params.doc)"
+
+// DocTransformer rewrites a doc before it is bulked to the target, a nil doc is dropped. The bulk workers call it
+// concurrently, it must not modify the doc it is given.
+type DocTransformer func(doc *es2.Doc) *es2.Doc
+
+// ScriptedUpsert turns every doc into an update running the painless script with the source doc as params.doc, a
+// missing target doc is created from the source. An empty script merges the source fields into the target doc
+// instead of replacing it.
+func ScriptedUpsert(script string) DocTransformer {
+	if script == "" {
+		script = mergeFieldsScript
+	}
+	return func(doc *es2.Doc) *es2.Doc {
+		newDoc := *doc
+		newDoc.Op = es2.OperationUpdate
+		newDoc.Script = map[string]interface{}{
+			"source": script,
+			"lang":   "painless",
+			"params": map[string]interface{}{"doc": doc.Source},
+		}
+		newDoc.Upsert = doc.Source
+		return &newDoc
+	}
+}
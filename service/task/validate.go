@@ -0,0 +1,140 @@
+package task
+
+import (
+	es2 "github.com/CharellKing/ela-lib/pkg/es"
+	"github.com/CharellKing/ela-lib/utils"
+	"github.com/pkg/errors"
+	"github.com/samber/lo"
+	"github.com/spf13/cast"
+	"reflect"
+	"sort"
+	"strconv"
+)
+
+// ValidationQuery is a representative search body, usually with aggregations, run on both indices of the pair
+type ValidationQuery struct {
+	Name  string
+	Query map[string]interface{}
+}
+
+// ValidationReport compares the answers of the source and the target to a ValidationQuery
+type ValidationReport struct {
+	Name  string
+	Equal bool
+	// Diffs are the dotted paths where the answers differ, array items are addressed by their position
+	Diffs []string
+	// Source and Target are the normalized answers that were compared
+	Source map[string]interface{}
+	Target map[string]interface{}
+}
+
+// ValidateQueries runs every query on the source and the target index and compares the answers, which catches the
+// analyzer and mapping drift a doc count misses. The hits totals are compared as numbers whatever the versions,
+// took, timed_out, _shards and where the hits live and how they scored are left out.
+func (m *Migrator) ValidateQueries(queries []ValidationQuery) ([]*ValidationReport, error) {
+	if m.err != nil {
+		return nil, errors.WithStack(m.err)
+	}
+
+	existed, err := m.TargetES.IndexExisted(m.IndexPair.TargetIndex)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if !existed {
+		return nil, utils.NewCustomError(utils.NonIndexExisted, "target index %s not existed", m.IndexPair.TargetIndex)
+	}
+
+	ctx := m.GetCtx()
+	reports := make([]*ValidationReport, 0, len(queries))
+	for _, query := range queries {
+		sourceResult, err := m.SourceES.SearchByQuery(ctx, m.IndexPair.SourceIndex, query.Query)
+		if err != nil {
+			return nil, errors.Wrapf(err, "validation query %s on the source", query.Name)
+		}
+		targetResult, err := m.TargetES.SearchByQuery(ctx, m.IndexPair.TargetIndex, query.Query)
+		if err != nil {
+			return nil, errors.Wrapf(err, "validation query %s on the target", query.Name)
+		}
+
+		report := &ValidationReport{
+			Name:   query.Name,
+			Source: normalizeSearchResult(sourceResult),
+			Target: normalizeSearchResult(targetResult),
+		}
+		report.Diffs = diffPaths("", report.Source, report.Target)
+		report.Equal = len(report.Diffs) == 0
+		if !report.Equal {
+			utils.GetLogger(ctx).Warnf("validation query %s differs at %v", query.Name, report.Diffs)
+		}
+		reports = append(reports, report)
+	}
+	return reports, nil
+}
+
+// normalizeSearchResult keeps what the docs of the index decide in a search answer, it modifies result
+func normalizeSearchResult(result map[string]interface{}) map[string]interface{} {
+	es2.TranslateHitsTotal(result, false)
+	for _, key := range []string{"took", "timed_out", "_shards"} {
+		delete(result, key)
+	}
+	stripHitLocations(result)
+	return sourceNormalizer{}.normalize(result)
+}
+
+// stripHitLocations drops the index, type and score of the hits, at the top and in the top_hits aggregations
+func stripHitLocations(node interface{}) {
+	switch value := node.(type) {
+	case map[string]interface{}:
+		if hits, ok := value["hits"].(map[string]interface{}); ok {
+			delete(hits, "max_score")
+			for _, hit := range cast.ToSlice(hits["hits"]) {
+				if hit, ok := hit.(map[string]interface{}); ok {
+					delete(hit, "_index")
+					delete(hit, "_type")
+					delete(hit, "_score")
+				}
+			}
+		}
+		for key, child := range value {
+			// hits carry the documents, their sources are compared as is
+			if key != "hits" {
+				stripHitLocations(child)
+			}
+		}
+	case []interface{}:
+		for _, child := range value {
+			stripHitLocations(child)
+		}
+	}
+}
+
+// diffPaths returns the dotted paths where two normalized values differ
+func diffPaths(path string, source interface{}, target interface{}) []string {
+	sourceMap, sourceIsMap := source.(map[string]interface{})
+	targetMap, targetIsMap := target.(map[string]interface{})
+	if sourceIsMap && targetIsMap {
+		keys := lo.Uniq(append(lo.Keys(sourceMap), lo.Keys(targetMap)...))
+		sort.Strings(keys)
+
+		var diffs []string
+		for _, key := range keys {
+			diffs = append(diffs, diffPaths(joinFieldPath(path, key), sourceMap[key], targetMap[key])...)
+		}
+		return diffs
+	}
+
+	sourceArray, sourceIsArray := source.([]interface{})
+	targetArray, targetIsArray := target.([]interface{})
+	if sourceIsArray && targetIsArray && len(sourceArray) == len(targetArray) {
+		var diffs []string
+		for idx := range sourceArray {
+			diffs = append(diffs, diffPaths(joinFieldPath(path, strconv.Itoa(idx)), sourceArray[idx], targetArray[idx])...)
+		}
+		return diffs
+	}
+
+	if reflect.DeepEqual(source, target) {
+		return nil
+	}
+	return []string{path}
+}
@@ -0,0 +1,121 @@
+package task
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"hash/fnv"
+	"io"
+	"slices"
+	"sync"
+)
+
+// VerifiedIDs is the set of the doc ids a compare verified, kept as 64-bit hashes of the ids. It is persisted as
+// the sorted hashes delta encoded in varints and deflated, a few bytes per id however long the ids are. A hash
+// collision counts an unverified id as verified, rare enough for billions of ids. A nil VerifiedIDs is empty.
+type VerifiedIDs struct {
+	mutex  sync.Mutex
+	hashes map[uint64]struct{}
+}
+
+func NewVerifiedIDs() *VerifiedIDs {
+	return &VerifiedIDs{hashes: make(map[uint64]struct{})}
+}
+
+func hashID(id string) uint64 {
+	hash := fnv.New64a()
+	_, _ = hash.Write([]byte(id))
+	return hash.Sum64()
+}
+
+func (ids *VerifiedIDs) Add(id string) {
+	if ids == nil {
+		return
+	}
+
+	ids.mutex.Lock()
+	defer ids.mutex.Unlock()
+	ids.hashes[hashID(id)] = struct{}{}
+}
+
+func (ids *VerifiedIDs) Contains(id string) bool {
+	if ids == nil {
+		return false
+	}
+
+	ids.mutex.Lock()
+	defer ids.mutex.Unlock()
+	_, ok := ids.hashes[hashID(id)]
+	return ok
+}
+
+func (ids *VerifiedIDs) Len() int {
+	if ids == nil {
+		return 0
+	}
+
+	ids.mutex.Lock()
+	defer ids.mutex.Unlock()
+	return len(ids.hashes)
+}
+
+// MarshalJSON writes the compact encoding as a base64 string
+func (ids *VerifiedIDs) MarshalJSON() ([]byte, error) {
+	ids.mutex.Lock()
+	hashes := make([]uint64, 0, len(ids.hashes))
+	for hash := range ids.hashes {
+		hashes = append(hashes, hash)
+	}
+	ids.mutex.Unlock()
+	slices.Sort(hashes)
+
+	var buf bytes.Buffer
+	writer, err := flate.NewWriter(&buf, flate.BestCompression)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	varint := make([]byte, binary.MaxVarintLen64)
+	var previous uint64
+	for _, hash := range hashes {
+		if _, err := writer.Write(varint[:binary.PutUvarint(varint, hash-previous)]); err != nil {
+			return nil, errors.WithStack(err)
+		}
+		previous = hash
+	}
+	if err := writer.Close(); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return json.Marshal(buf.Bytes())
+}
+
+func (ids *VerifiedIDs) UnmarshalJSON(data []byte) error {
+	var content []byte
+	if err := json.Unmarshal(data, &content); err != nil {
+		return errors.WithStack(err)
+	}
+
+	hashes := make(map[uint64]struct{})
+	reader := flate.NewReader(bytes.NewReader(content))
+	defer func() { _ = reader.Close() }()
+	byteReader := bufio.NewReader(reader)
+	var previous uint64
+	for {
+		delta, err := binary.ReadUvarint(byteReader)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return errors.Wrap(err, "verified ids")
+		}
+		previous += delta
+		hashes[previous] = struct{}{}
+	}
+
+	ids.mutex.Lock()
+	defer ids.mutex.Unlock()
+	ids.hashes = hashes
+	return nil
+}
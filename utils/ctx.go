@@ -2,7 +2,6 @@ package utils
 
 import (
 	"context"
-	"github.com/spf13/cast"
 )
 
 type CtxKey string
@@ -10,6 +9,8 @@ type CtxKey string
 const (
 	CtxKeySourceESVersion CtxKey = "sourceEsVersion"
 	CtxKeyTargetESVersion CtxKey = "targetEsVersion"
+	CtxKeySourceESName    CtxKey = "sourceEsName"
+	CtxKeyTargetESName    CtxKey = "targetEsName"
 	CtxKeySourceObject    CtxKey = "sourceObject"
 	CtxKeyTargetObject    CtxKey = "targetObject"
 	CtxKeyTaskName        CtxKey = "taskName"
@@ -27,106 +28,223 @@ const (
 	CtxKeyIgnoreSystemIndex CtxKey = "ignoreSystemIndex"
 )
 
+// ctxKeyOptions holds the Options of a context, the CtxKey getters and setters read and write its fields
+const ctxKeyOptions CtxKey = "options"
+
+// Options are the settings a context carries through a migration, in place of a context value per CtxKey
+type Options struct {
+	SourceESVersion string
+	TargetESVersion string
+	SourceESName    string
+	TargetESName    string
+	SourceObject    string
+	TargetObject    string
+	TaskName        string
+	TaskID          string
+	TaskAction      string
+
+	SourceIndexSetting interface{}
+	TargetIndexSetting interface{}
+
+	SourceFieldMap map[string]interface{}
+	TargetFieldMap map[string]interface{}
+
+	DateTimeFormatFixFields map[string]string
+
+	IgnoreSystemIndex bool
+}
+
+// value returns the field of key, false for a key which is not an option
+func (options *Options) value(key CtxKey) (interface{}, bool) {
+	switch key {
+	case CtxKeySourceESVersion:
+		return options.SourceESVersion, true
+	case CtxKeyTargetESVersion:
+		return options.TargetESVersion, true
+	case CtxKeySourceESName:
+		return options.SourceESName, true
+	case CtxKeyTargetESName:
+		return options.TargetESName, true
+	case CtxKeySourceObject:
+		return options.SourceObject, true
+	case CtxKeyTargetObject:
+		return options.TargetObject, true
+	case CtxKeyTaskName:
+		return options.TaskName, true
+	case CtxKeyTaskID:
+		return options.TaskID, true
+	case CtxKeyTaskAction:
+		return options.TaskAction, true
+	case CtxKeySourceIndexSetting:
+		return options.SourceIndexSetting, true
+	case CtxKeyTargetIndexSetting:
+		return options.TargetIndexSetting, true
+	case CtxKeySourceFieldMap:
+		return options.SourceFieldMap, true
+	case CtxKeyTargetFieldMap:
+		return options.TargetFieldMap, true
+	case CtxKeyDateTimeFormatFixFields:
+		return options.DateTimeFormatFixFields, true
+	case CtxKeyIgnoreSystemIndex:
+		return options.IgnoreSystemIndex, true
+	}
+	return nil, false
+}
+
+// optionsCtx answers the value of every CtxKey from its options, so reading a key directly keeps working
+type optionsCtx struct {
+	context.Context
+	options *Options
+}
+
+func (ctx *optionsCtx) Value(key interface{}) interface{} {
+	if key == ctxKeyOptions {
+		return ctx.options
+	}
+	if ctxKey, ok := key.(CtxKey); ok {
+		if value, ok := ctx.options.value(ctxKey); ok {
+			return value
+		}
+	}
+	return ctx.Context.Value(key)
+}
+
+// GetOptions returns a copy of the options of ctx, the zero Options when none are set
+func GetOptions(ctx context.Context) Options {
+	if options, ok := ctx.Value(ctxKeyOptions).(*Options); ok {
+		return *options
+	}
+	return Options{}
+}
+
+// WithOptions returns a context carrying options, they replace all the options of ctx
+func WithOptions(ctx context.Context, options Options) context.Context {
+	return &optionsCtx{Context: ctx, options: &options}
+}
+
+// updateOptions returns a context with the options of ctx changed by update, ctx itself is left as it is
+func updateOptions(ctx context.Context, update func(options *Options)) context.Context {
+	options := GetOptions(ctx)
+	update(&options)
+	return WithOptions(ctx, options)
+}
+
 func GetCtxKeySourceESVersion(ctx context.Context) string {
-	return cast.ToString(ctx.Value(CtxKeySourceESVersion))
+	return GetOptions(ctx).SourceESVersion
 }
 
 func GetCtxKeyTargetESVersion(ctx context.Context) string {
-	return cast.ToString(ctx.Value(CtxKeyTargetESVersion))
+	return GetOptions(ctx).TargetESVersion
 }
 
 func SetCtxKeySourceESVersion(ctx context.Context, version string) context.Context {
-	return context.WithValue(ctx, CtxKeySourceESVersion, version)
+	return updateOptions(ctx, func(options *Options) { options.SourceESVersion = version })
 }
 
 func SetCtxKeyTargetESVersion(ctx context.Context, version string) context.Context {
-	return context.WithValue(ctx, CtxKeyTargetESVersion, version)
+	return updateOptions(ctx, func(options *Options) { options.TargetESVersion = version })
+}
+
+func GetCtxKeySourceESName(ctx context.Context) string {
+	return GetOptions(ctx).SourceESName
+}
+
+func GetCtxKeyTargetESName(ctx context.Context) string {
+	return GetOptions(ctx).TargetESName
+}
+
+func SetCtxKeySourceESName(ctx context.Context, name string) context.Context {
+	return updateOptions(ctx, func(options *Options) { options.SourceESName = name })
+}
+
+func SetCtxKeyTargetESName(ctx context.Context, name string) context.Context {
+	return updateOptions(ctx, func(options *Options) { options.TargetESName = name })
 }
 
 func GetCtxKeySourceObject(ctx context.Context) string {
-	return cast.ToString(ctx.Value(CtxKeySourceObject))
+	return GetOptions(ctx).SourceObject
 }
 
 func GetCtxKeyTargetObject(ctx context.Context) string {
-	return cast.ToString(ctx.Value(CtxKeyTargetObject))
+	return GetOptions(ctx).TargetObject
 }
 
 func SetCtxKeySourceObject(ctx context.Context, obj string) context.Context {
-	return context.WithValue(ctx, CtxKeySourceObject, obj)
+	return updateOptions(ctx, func(options *Options) { options.SourceObject = obj })
 }
 
 func SetCtxKeyTargetObject(ctx context.Context, index string) context.Context {
-	return context.WithValue(ctx, CtxKeyTargetObject, index)
+	return updateOptions(ctx, func(options *Options) { options.TargetObject = index })
 }
 
 func GetCtxKeyTaskName(ctx context.Context) string {
-	return cast.ToString(ctx.Value(CtxKeyTaskName))
+	return GetOptions(ctx).TaskName
 }
 
 func GetCtxKeyTaskID(ctx context.Context) string {
-	return cast.ToString(ctx.Value(CtxKeyTaskID))
+	return GetOptions(ctx).TaskID
 }
 
 func SetCtxKeyTaskName(ctx context.Context, name string) context.Context {
-	return context.WithValue(ctx, CtxKeyTaskName, name)
+	return updateOptions(ctx, func(options *Options) { options.TaskName = name })
 }
 
 func SetCtxKeyTaskID(ctx context.Context, id string) context.Context {
-	return context.WithValue(ctx, CtxKeyTaskID, id)
+	return updateOptions(ctx, func(options *Options) { options.TaskID = id })
 }
 
 func GetCtxKeyTaskAction(ctx context.Context) string {
-	return cast.ToString(ctx.Value(CtxKeyTaskAction))
+	return GetOptions(ctx).TaskAction
 }
 
 func SetCtxKeyTaskAction(ctx context.Context, action string) context.Context {
-	return context.WithValue(ctx, CtxKeyTaskAction, action)
+	return updateOptions(ctx, func(options *Options) { options.TaskAction = action })
 }
 
 func GetCtxKeySourceIndexSetting(ctx context.Context) interface{} {
-	return ctx.Value(CtxKeySourceIndexSetting)
+	return GetOptions(ctx).SourceIndexSetting
 }
 
 func SetCtxKeySourceIndexSetting(ctx context.Context, setting interface{}) context.Context {
-	return context.WithValue(ctx, CtxKeySourceIndexSetting, setting)
+	return updateOptions(ctx, func(options *Options) { options.SourceIndexSetting = setting })
 }
 
 func GetCtxKeyTargetIndexSetting(ctx context.Context) interface{} {
-	return ctx.Value(CtxKeyTargetIndexSetting)
+	return GetOptions(ctx).TargetIndexSetting
 }
 
 func SetCtxKeyTargetIndexSetting(ctx context.Context, setting interface{}) context.Context {
-	return context.WithValue(ctx, CtxKeyTargetIndexSetting, setting)
+	return updateOptions(ctx, func(options *Options) { options.TargetIndexSetting = setting })
 }
 
 func GetCtxKeySourceFieldMap(ctx context.Context) map[string]interface{} {
-	return ctx.Value(CtxKeySourceFieldMap).(map[string]interface{})
+	return GetOptions(ctx).SourceFieldMap
 }
 
 func SetCtxKeySourceFieldMap(ctx context.Context, fieldMap map[string]interface{}) context.Context {
-	return context.WithValue(ctx, CtxKeySourceFieldMap, fieldMap)
+	return updateOptions(ctx, func(options *Options) { options.SourceFieldMap = fieldMap })
 }
 
 func GetCtxKeyTargetFieldMap(ctx context.Context) map[string]interface{} {
-	return ctx.Value(CtxKeyTargetFieldMap).(map[string]interface{})
+	return GetOptions(ctx).TargetFieldMap
 }
 
 func SetCtxKeyTargetFieldMap(ctx context.Context, fieldMap map[string]interface{}) context.Context {
-	return context.WithValue(ctx, CtxKeyTargetFieldMap, fieldMap)
+	return updateOptions(ctx, func(options *Options) { options.TargetFieldMap = fieldMap })
 }
 
 func GetCtxKeyDateTimeFormatFixFields(ctx context.Context) map[string]string {
-	return ctx.Value(CtxKeyDateTimeFormatFixFields).(map[string]string)
+	return GetOptions(ctx).DateTimeFormatFixFields
 }
 
 func SetCtxKeyDateTimeFormatFixFields(ctx context.Context, fields map[string]string) context.Context {
-	return context.WithValue(ctx, CtxKeyDateTimeFormatFixFields, fields)
+	return updateOptions(ctx, func(options *Options) { options.DateTimeFormatFixFields = fields })
 }
 
 func GetCtxKeyIgnoreSystemIndex(ctx context.Context) bool {
-	return cast.ToBool(ctx.Value(CtxKeyIgnoreSystemIndex))
+	return GetOptions(ctx).IgnoreSystemIndex
 }
 
 func SetCtxKeyIgnoreSystemIndex(ctx context.Context, ignoreSystemIndex bool) context.Context {
-	return context.WithValue(ctx, CtxKeyIgnoreSystemIndex, ignoreSystemIndex)
+	return updateOptions(ctx, func(options *Options) { options.IgnoreSystemIndex = ignoreSystemIndex })
 }
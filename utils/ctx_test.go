@@ -0,0 +1,90 @@
+package utils
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestOptionsParity(t *testing.T) {
+	options := Options{
+		SourceESVersion:         "7.10.2",
+		TargetESVersion:         "8.12.2",
+		SourceESName:            "source",
+		TargetESName:            "target",
+		SourceObject:            "a",
+		TargetObject:            "a-copy",
+		TaskName:                "task",
+		TaskID:                  "1",
+		TaskAction:              "sync",
+		SourceIndexSetting:      "source setting",
+		TargetIndexSetting:      "target setting",
+		SourceFieldMap:          map[string]interface{}{"field": "text"},
+		TargetFieldMap:          map[string]interface{}{"field": "keyword"},
+		DateTimeFormatFixFields: map[string]string{"date": "yyyy-MM-dd"},
+		IgnoreSystemIndex:       true,
+	}
+
+	// the old setters fill the options
+	ctx := context.Background()
+	ctx = SetCtxKeySourceESVersion(ctx, options.SourceESVersion)
+	ctx = SetCtxKeyTargetESVersion(ctx, options.TargetESVersion)
+	ctx = SetCtxKeySourceESName(ctx, options.SourceESName)
+	ctx = SetCtxKeyTargetESName(ctx, options.TargetESName)
+	ctx = SetCtxKeySourceObject(ctx, options.SourceObject)
+	ctx = SetCtxKeyTargetObject(ctx, options.TargetObject)
+	ctx = SetCtxKeyTaskName(ctx, options.TaskName)
+	ctx = SetCtxKeyTaskID(ctx, options.TaskID)
+	ctx = SetCtxKeyTaskAction(ctx, options.TaskAction)
+	ctx = SetCtxKeySourceIndexSetting(ctx, options.SourceIndexSetting)
+	ctx = SetCtxKeyTargetIndexSetting(ctx, options.TargetIndexSetting)
+	ctx = SetCtxKeySourceFieldMap(ctx, options.SourceFieldMap)
+	ctx = SetCtxKeyTargetFieldMap(ctx, options.TargetFieldMap)
+	ctx = SetCtxKeyDateTimeFormatFixFields(ctx, options.DateTimeFormatFixFields)
+	ctx = SetCtxKeyIgnoreSystemIndex(ctx, options.IgnoreSystemIndex)
+	if got := GetOptions(ctx); !reflect.DeepEqual(got, options) {
+		t.Errorf("expect the options %+v, got %+v", options, got)
+	}
+
+	// the old getters and the keys read the options
+	ctx = WithOptions(context.Background(), options)
+	for key, expected := range map[CtxKey]interface{}{
+		CtxKeySourceESVersion:         GetCtxKeySourceESVersion(ctx),
+		CtxKeyTargetESVersion:         GetCtxKeyTargetESVersion(ctx),
+		CtxKeySourceESName:            GetCtxKeySourceESName(ctx),
+		CtxKeyTargetESName:            GetCtxKeyTargetESName(ctx),
+		CtxKeySourceObject:            GetCtxKeySourceObject(ctx),
+		CtxKeyTargetObject:            GetCtxKeyTargetObject(ctx),
+		CtxKeyTaskName:                GetCtxKeyTaskName(ctx),
+		CtxKeyTaskID:                  GetCtxKeyTaskID(ctx),
+		CtxKeyTaskAction:              GetCtxKeyTaskAction(ctx),
+		CtxKeySourceIndexSetting:      GetCtxKeySourceIndexSetting(ctx),
+		CtxKeyTargetIndexSetting:      GetCtxKeyTargetIndexSetting(ctx),
+		CtxKeySourceFieldMap:          GetCtxKeySourceFieldMap(ctx),
+		CtxKeyTargetFieldMap:          GetCtxKeyTargetFieldMap(ctx),
+		CtxKeyDateTimeFormatFixFields: GetCtxKeyDateTimeFormatFixFields(ctx),
+		CtxKeyIgnoreSystemIndex:       GetCtxKeyIgnoreSystemIndex(ctx),
+	} {
+		value, _ := options.value(key)
+		if !reflect.DeepEqual(expected, value) || !reflect.DeepEqual(ctx.Value(key), value) {
+			t.Errorf("%s: expect %v, got %v from the getter and %v from the key", key, value, expected, ctx.Value(key))
+		}
+	}
+}
+
+func TestOptionsCopyOnSet(t *testing.T) {
+	type otherKey string
+	parent := context.WithValue(SetCtxKeyTaskName(context.Background(), "parent"), otherKey("other"), "value")
+	child := SetCtxKeyTaskID(parent, "1")
+
+	if GetCtxKeyTaskID(parent) != "" || GetCtxKeyTaskName(child) != "parent" || GetCtxKeyTaskID(child) != "1" {
+		t.Errorf("expect the child to add to the options of the parent only, got %+v and %+v",
+			GetOptions(parent), GetOptions(child))
+	}
+	if child.Value(otherKey("other")) != "value" {
+		t.Errorf("expect the other values of the parent kept")
+	}
+	if GetCtxKeySourceFieldMap(context.Background()) != nil {
+		t.Errorf("expect no field map without options")
+	}
+}
@@ -52,7 +52,7 @@ func (e *Errs) Is(target error) bool {
 
 func (e *Errs) As(target interface{}) bool {
 	for _, candidate := range e.errors {
-		if errors.As(candidate, &target) {
+		if errors.As(candidate, target) {
 			return true
 		}
 	}
@@ -73,6 +73,23 @@ func (e *CustomError) Error() string {
 
 const (
 	NonIndexExisted ErrCode = 1000
+	InvalidParams   ErrCode = 1001
+	// ConnectionFailed means the cluster address can't be resolved or connected
+	ConnectionFailed ErrCode = 1002
+	// AuthFailed means the cluster rejected the credentials with 401 or 403
+	AuthFailed ErrCode = 1003
+	// IncompatibleVersion means the source and target versions are an unsupported jump
+	IncompatibleVersion ErrCode = 1004
+	// BreakingMappingChange means a field changed its type, which es can't apply in place
+	BreakingMappingChange ErrCode = 1005
+	// MultipleMappingTypes means a multi-type index can't be created on a version allowing one type
+	MultipleMappingTypes ErrCode = 1006
+	// IndexClosed means the source index is closed, so it can't be searched
+	IndexClosed ErrCode = 1007
+	// CountMismatch means the target index holds fewer docs than the source after a sync
+	CountMismatch ErrCode = 1008
+	// SelfMigration means the source and target are the same index of the same cluster
+	SelfMigration ErrCode = 1009
 )
 
 // NewCustomError creates a new CustomError with the given code and message.
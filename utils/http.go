@@ -0,0 +1,17 @@
+package utils
+
+import "io"
+
+// maxDrainBytes caps what DrainAndClose reads of a body, dropping the connection is cheaper than reading a larger rest
+const maxDrainBytes = 1 << 20
+
+// DrainAndClose reads the rest of a response body, up to maxDrainBytes, and closes it. The transport only reuses
+// the connection of a body read to its end, a body closed after an error answer or a partial decode drops it, recent
+// go versions read a small rest on close themselves.
+func DrainAndClose(body io.ReadCloser) {
+	if body == nil {
+		return
+	}
+	_, _ = io.CopyN(io.Discard, body, maxDrainBytes)
+	_ = body.Close()
+}
@@ -38,6 +38,8 @@ func GetLogger(ctx context.Context) *log.Entry {
 	ctxKeyMap := map[CtxKey]func(ctx context.Context) string{
 		CtxKeySourceESVersion: GetCtxKeySourceESVersion,
 		CtxKeyTargetESVersion: GetCtxKeyTargetESVersion,
+		CtxKeySourceESName:    GetCtxKeySourceESName,
+		CtxKeyTargetESName:    GetCtxKeyTargetESName,
 		CtxKeySourceObject:    GetCtxKeySourceObject,
 		CtxKeyTargetObject:    GetCtxKeyTargetObject,
 		CtxKeyTaskName:        GetCtxKeyTaskName,
@@ -11,13 +11,16 @@ func GetValueFromMapByPath(data map[string]interface{}, path string) (interface{
 		return nil, false
 	}
 	keys := strings.Split(path, ".")
-	value := data
+	var value interface{} = data
 	for _, key := range keys {
-		v, ok := value[key]
+		valueMap, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		value, ok = valueMap[key]
 		if !ok {
 			return nil, false
 		}
-		value = v.(map[string]interface{})
 	}
 	return value, true
 }
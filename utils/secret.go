@@ -0,0 +1,53 @@
+package utils
+
+import (
+	"github.com/pkg/errors"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// secretRefPattern matches `${ENV:NAME}` and `${FILE:/path/to/secret}`
+var secretRefPattern = regexp.MustCompile(`^\$\{(ENV|FILE):(.+)}$`)
+
+// ResolveSecret returns the value a secret reference points to, a value which is not a reference is
+// returned as is
+func ResolveSecret(value string) (string, error) {
+	matches := secretRefPattern.FindStringSubmatch(value)
+	if matches == nil {
+		return value, nil
+	}
+
+	switch matches[1] {
+	case "ENV":
+		secret, ok := os.LookupEnv(matches[2])
+		if !ok {
+			return "", NewCustomError(InvalidParams, "secret env %s is not set", matches[2])
+		}
+		return secret, nil
+	default:
+		return ReadSecretFile(matches[2])
+	}
+}
+
+// ReadSecretFile reads a secret kept in a file, the trailing line break editors add is dropped
+func ReadSecretFile(file string) (string, error) {
+	secret, err := os.ReadFile(file)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	return strings.TrimRight(string(secret), "\r\n"), nil
+}
+
+// ResolveSecrets resolves every value with ResolveSecret
+func ResolveSecrets(values []string) ([]string, error) {
+	secrets := make([]string, 0, len(values))
+	for _, value := range values {
+		secret, err := ResolveSecret(value)
+		if err != nil {
+			return nil, err
+		}
+		secrets = append(secrets, secret)
+	}
+	return secrets, nil
+}